@@ -42,7 +42,7 @@ func chatGPT(c *gin.Context) {
 	}
 
 	msg := arg["msg"].(string)
-	ret.Data = model.ChatGPT(msg)
+	ret.Data = model.ChatGPTWithContext(model.GetWorkspaceContext(c), msg)
 }
 
 func chatGPTWithAction(c *gin.Context) {
@@ -60,7 +60,7 @@ func chatGPTWithAction(c *gin.Context) {
 		ids = append(ids, id.(string))
 	}
 	action := arg["action"].(string)
-	ret.Data = model.ChatGPTWithAction(ids, action)
+	ret.Data = model.ChatGPTWithActionWithContext(model.GetWorkspaceContext(c), ids, action)
 }
 
 func chat(c *gin.Context) {
@@ -222,6 +222,110 @@ func chatStream(c *gin.Context) {
 	c.Writer.Flush()
 }
 
+// chatAgent 智能体模式聊天，模型可以调用工具检索和编辑笔记
+func chatAgent(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	messagesArg, ok := arg["messages"].([]interface{})
+	if !ok {
+		ret.Code = -1
+		ret.Msg = "messages parameter is missing or invalid"
+		return
+	}
+
+	var messages []openai.ChatCompletionMessage
+	for _, msg := range messagesArg {
+		msgMap, ok := msg.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		role, _ := msgMap["role"].(string)
+		content, _ := msgMap["content"].(string)
+
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    role,
+			Content: content,
+		})
+	}
+
+	opts := &model.AgentOptions{}
+	if dryRun, ok := arg["dryRun"].(bool); ok {
+		opts.DryRun = dryRun
+	}
+	if maxSteps, ok := arg["maxSteps"].(float64); ok {
+		opts.MaxSteps = int(maxSteps)
+	}
+	if tools, ok := arg["tools"].([]interface{}); ok {
+		for _, t := range tools {
+			if s, ok := t.(string); ok {
+				opts.Tools = append(opts.Tools, s)
+			}
+		}
+	}
+
+	ctx := model.GetWorkspaceContext(c)
+	if ctx == nil {
+		ret.Code = -1
+		ret.Msg = "用户未登录或上下文不存在"
+		return
+	}
+
+	result, err := model.ChatWithAgent(ctx, messages, opts)
+	if err != nil {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = result
+}
+
+// applyAgentProposals 执行用户确认后的智能体写操作
+func applyAgentProposals(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	param, err := gulu.JSON.MarshalJSON(arg["proposals"])
+	if err != nil {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+
+	var proposals []*model.AgentProposal
+	if err = gulu.JSON.UnmarshalJSON(param, &proposals); err != nil {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+
+	ctx := model.GetWorkspaceContext(c)
+	transactions, err := model.ApplyAgentProposals(ctx, proposals)
+	if err != nil {
+		ret.Code = -1
+		ret.Msg = err.Error()
+	}
+	ret.Data = transactions
+}
+
+func getAgentTools(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	ret.Data = model.GetAgentTools()
+}
+
 // 新增向量化和AI文档分析API
 
 func vectorizeBlock(c *gin.Context) {
@@ -290,7 +394,7 @@ func semanticSearch(c *gin.Context) {
 		limit = int(l)
 	}
 
-	results, err := model.SemanticSearch(query, notebookID, limit)
+	results, err := model.SemanticSearchWithContext(model.GetWorkspaceContext(c), query, notebookID, limit)
 	if err != nil {
 		ret.Code = -1
 		ret.Msg = err.Error()
@@ -392,6 +496,9 @@ func setEmbeddingConfig(c *gin.Context) {
 		return
 	}
 
+	// 记录旧向量所属的模型，切换模型后在重新向量化完成前仍使用旧模型检索
+	model.EnsureEmbeddingIndex()
+
 	// 更新配置
 	if model.Conf.AI.Embedding == nil {
 		model.Conf.AI.Embedding = &conf.Embedding{}
@@ -422,6 +529,7 @@ func setEmbeddingConfig(c *gin.Context) {
 	}
 
 	model.Conf.Save()
+	model.SwitchEmptyEmbeddingIndex(util.DataDir)
 
 	ret.Data = map[string]interface{}{
 		"success": true,
@@ -474,7 +582,7 @@ func testEmbeddingConnection(c *gin.Context) {
 	ret := gulu.Ret.NewResult()
 	defer c.JSON(http.StatusOK, ret)
 
-	embeddingService := model.NewEmbeddingService()
+	embeddingService := model.NewEmbeddingServiceWithContext(model.GetWorkspaceContext(c))
 	if embeddingService == nil {
 		ret.Code = -1
 		ret.Msg = "向量化服务未配置"
@@ -695,9 +803,48 @@ func getVectorizeProgress(c *gin.Context) {
 	ret.Data = progress
 }
 
-// ===== OCR API (PaddleOCR) =====
+// startReembedJob 使用当前配置的向量化模型在后台重新生成所有向量
+func startReembedJob(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	if err := model.StartReembedJob(util.DataDir); nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = model.GetReembedProgress()
+}
+
+// cancelReembedJob 取消重新向量化任务
+func cancelReembedJob(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	model.CancelReembedJob()
+}
+
+// getReembedProgress 获取重新向量化任务进度
+func getReembedProgress(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	ret.Data = model.GetReembedProgress()
+}
 
-// ocrAsset 对资源文件进行 OCR 识别
+// ===== OCR API =====
+
+// resolveOCRAssetPath 把请求中的资源路径转换为工作空间中的绝对路径
+func resolveOCRAssetPath(ctx *model.WorkspaceContext, assetPath string) string {
+	if strings.HasPrefix(assetPath, "assets/") {
+		return filepath.Join(ctx.GetDataDir(), assetPath)
+	} else if strings.HasPrefix(assetPath, "/") {
+		return assetPath
+	}
+	return filepath.Join(ctx.GetDataDir(), "assets", assetPath)
+}
+
+// ocrAsset 使用当前的 OCR 引擎立即识别资源文件
 func ocrAsset(c *gin.Context) {
 	ret := gulu.Ret.NewResult()
 	defer c.JSON(http.StatusOK, ret)
@@ -714,18 +861,8 @@ func ocrAsset(c *gin.Context) {
 		return
 	}
 
-	// 处理相对路径
-	var fullPath string
-	if strings.HasPrefix(assetPath, "assets/") {
-		fullPath = filepath.Join(util.DataDir, assetPath)
-	} else if strings.HasPrefix(assetPath, "/") {
-		fullPath = assetPath
-	} else {
-		fullPath = filepath.Join(util.DataDir, "assets", assetPath)
-	}
-
-	// 执行 OCR
-	result, err := model.OCRAsset(fullPath)
+	ctx := model.GetWorkspaceContext(c)
+	result, err := model.OCRAssetWithContext(ctx, resolveOCRAssetPath(ctx, assetPath))
 	if err != nil {
 		ret.Code = -1
 		ret.Msg = fmt.Sprintf("OCR 识别失败: %v", err)
@@ -738,20 +875,20 @@ func ocrAsset(c *gin.Context) {
 		"assetPath": result.AssetPath,
 		"fileName":  result.FileName,
 		"fileType":  result.FileType,
+		"engine":    result.Engine,
 		"fullText":  result.FullText,
 		"pageCount": result.PageCount,
-		"ocrFile":   fullPath + ".ocr.json",
 		"updatedAt": result.UpdatedAt,
 		"message":   fmt.Sprintf("OCR 识别完成: %s, 共 %d 页", result.FileName, result.PageCount),
 	}
 }
 
-// ocrHealthCheck 检查 OCR 服务状态
+// ocrHealthCheck 检查当前 OCR 引擎状态
 func ocrHealthCheck(c *gin.Context) {
 	ret := gulu.Ret.NewResult()
 	defer c.JSON(http.StatusOK, ret)
 
-	healthy, msg := model.PaddleOCRHealthCheck()
+	healthy, msg := model.OCRHealthCheckWithContext(model.GetWorkspaceContext(c))
 
 	ret.Data = map[string]interface{}{
 		"healthy": healthy,
@@ -776,34 +913,105 @@ func getOCRResult(c *gin.Context) {
 		return
 	}
 
-	// 处理相对路径
-	var fullPath string
-	if strings.HasPrefix(assetPath, "assets/") {
-		fullPath = filepath.Join(util.DataDir, assetPath)
-	} else if strings.HasPrefix(assetPath, "/") {
-		fullPath = assetPath
-	} else {
-		fullPath = filepath.Join(util.DataDir, "assets", assetPath)
-	}
-
-	// 检查是否有 OCR 结果
-	if !model.HasOCRResult(fullPath) {
+	text, err := model.GetOCRText(resolveOCRAssetPath(model.GetWorkspaceContext(c), assetPath))
+	if err != nil {
 		ret.Code = -1
 		ret.Msg = "该资源文件没有 OCR 结果"
 		return
 	}
 
-	// 获取 OCR 文本
-	text, err := model.GetOCRText(fullPath)
-	if err != nil {
+	ret.Data = map[string]interface{}{
+		"assetPath": assetPath,
+		"fullText":  text,
+	}
+}
+
+// enqueueOCR 把资源文件加入文字识别队列
+func enqueueOCR(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	var assetPaths []string
+	if paths, ok := arg["assetPaths"].([]interface{}); ok {
+		for _, p := range paths {
+			assetPaths = append(assetPaths, p.(string))
+		}
+	}
+	if assetPath, ok := arg["assetPath"].(string); ok && "" != assetPath {
+		assetPaths = append(assetPaths, assetPath)
+	}
+	priority := 0
+	if p, ok := arg["priority"].(float64); ok {
+		priority = int(p)
+	}
+
+	jobs, err := model.EnqueueOCRAssetsWithContext(model.GetWorkspaceContext(c), assetPaths, priority)
+	if nil != err {
 		ret.Code = -1
-		ret.Msg = fmt.Sprintf("获取 OCR 结果失败: %v", err)
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = jobs
+}
+
+// retryOCRJob 重试失败的文字识别任务，不指定资源路径时重试所有失败的任务
+func retryOCRJob(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
 		return
 	}
 
+	var assetPaths []string
+	if paths, ok := arg["assetPaths"].([]interface{}); ok {
+		for _, p := range paths {
+			assetPaths = append(assetPaths, p.(string))
+		}
+	}
+	if assetPath, ok := arg["assetPath"].(string); ok && "" != assetPath {
+		assetPaths = append(assetPaths, assetPath)
+	}
+
 	ret.Data = map[string]interface{}{
-		"assetPath": assetPath,
-		"fullText":  text,
-		"ocrFile":   fullPath + ".ocr.json",
+		"count": model.RetryOCRJobsWithContext(model.GetWorkspaceContext(c), assetPaths),
+	}
+}
+
+// setOCRJobPriority 调整排队中的文字识别任务的优先级
+func setOCRJobPriority(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	assetPath, _ := arg["assetPath"].(string)
+	priority, _ := arg["priority"].(float64)
+	if err := model.SetOCRJobPriorityWithContext(model.GetWorkspaceContext(c), assetPath, int(priority)); nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
 	}
 }
+
+// getOCRQueueStatus 获取文字识别队列状态
+func getOCRQueueStatus(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	status, _ := arg["status"].(string)
+	ret.Data = model.GetOCRQueueStatusWithContext(model.GetWorkspaceContext(c), status)
+}
@@ -0,0 +1,202 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/88250/gulu"
+	"github.com/gin-gonic/gin"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+	"github.com/siyuan-note/siyuan/kernel/model"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+func getAIProviders(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	// 返回的密钥都经过脱敏，保存时提交脱敏的密钥会保留原有密钥
+	ctx := model.GetWorkspaceContext(c)
+	routes := map[string]*model.AIEndpoint{}
+	for _, task := range conf.AITasks {
+		if ep := model.ResolveAIEndpoint(ctx, task); nil != ep {
+			masked := *ep
+			masked.Provider = ep.Provider.Masked()
+			routes[task] = &masked
+		}
+	}
+
+	ret.Data = map[string]interface{}{
+		"global":   model.Conf.AI.Providers.Masked(),
+		"user":     model.GetUserAIProviders(ctx).Masked(),
+		"resolved": routes,
+		"tasks":    conf.AITasks,
+		"types":    conf.AIProviderTypes,
+		"owner":    model.IsWorkspaceOwnerContext(c),
+	}
+}
+
+func setAIProviders(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	providers, ok := bindAIProviders(c, ret)
+	if !ok {
+		return
+	}
+
+	providers.RestoreMaskedAPIKeys(model.Conf.AI.Providers)
+	if err := model.SetAIProviders(providers); nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = model.Conf.AI.Providers.Masked()
+}
+
+func setUserAIProviders(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	providers, ok := bindAIProviders(c, ret)
+	if !ok {
+		return
+	}
+
+	ctx := model.GetWorkspaceContext(c)
+	providers.RestoreMaskedAPIKeys(model.GetUserAIProviders(ctx))
+	if err := model.SetUserAIProviders(ctx, providers); nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = providers.Masked()
+}
+
+func validateAIProviders(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	providers, ok := bindAIProviders(c, ret)
+	if !ok {
+		return
+	}
+
+	// 用户路由可以引用全局服务商
+	var fallback *conf.AIProviders
+	if "user" == c.Query("scope") {
+		fallback = model.Conf.AI.Providers
+	}
+	problems := providers.Validate(fallback)
+	if nil == problems {
+		problems = []string{}
+	}
+	ret.Data = map[string]interface{}{
+		"valid":    0 == len(problems),
+		"problems": problems,
+	}
+}
+
+func testAIProvider(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	task, _ := arg["task"].(string)
+	if !gulu.Str.Contains(task, conf.AITasks) {
+		ret.Code = -1
+		ret.Msg = "invalid task [" + task + "]"
+		return
+	}
+
+	// 传入 provider 时测试未保存的服务商，否则测试当前路由解析到的服务商
+	var ep *model.AIEndpoint
+	if providerArg, ok := arg["provider"].(map[string]interface{}); ok {
+		provider := &conf.AIProvider{}
+		data, _ := gulu.JSON.MarshalJSON(providerArg)
+		if err := gulu.JSON.UnmarshalJSON(data, provider); nil != err {
+			ret.Code = -1
+			ret.Msg = err.Error()
+			return
+		}
+		// 测试已保存的服务商时前端提交的是脱敏的密钥
+		saved := conf.NewAIProviders()
+		saved.Providers = append(saved.Providers, model.GetUserAIProviders(model.GetWorkspaceContext(c)).Providers...)
+		if nil == saved.GetProvider(provider.Name) && nil != model.Conf.AI.Providers.GetProvider(provider.Name) {
+			// 全局服务商的密钥只有工作空间所有者可以使用
+			if !model.IsWorkspaceOwnerContext(c) {
+				ret.Code = -1
+				ret.Msg = "only the workspace owner can test global provider [" + provider.Name + "]"
+				return
+			}
+			saved.Providers = append(saved.Providers, model.Conf.AI.Providers.Providers...)
+		}
+		tested := &conf.AIProviders{Providers: []*conf.AIProvider{provider}}
+		tested.RestoreMaskedAPIKeys(saved)
+
+		modelName, _ := arg["model"].(string)
+		if "" == modelName && 0 < len(provider.Models) {
+			modelName = provider.Models[0]
+		}
+		ep = &model.AIEndpoint{Task: task, Provider: provider, Model: modelName}
+	} else {
+		ep = model.ResolveAIEndpoint(model.GetWorkspaceContext(c), task)
+	}
+	if nil == ep {
+		ret.Code = -1
+		ret.Msg = "no provider is routed for task [" + task + "]"
+		return
+	}
+
+	elapsed, err := model.TestAIEndpoint(ep)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		ret.Data = map[string]interface{}{"provider": ep.Provider.Name, "model": ep.Model}
+		return
+	}
+	ret.Data = map[string]interface{}{"provider": ep.Provider.Name, "model": ep.Model, "elapsed": elapsed.Milliseconds()}
+}
+
+func bindAIProviders(c *gin.Context, ret *gulu.Result) (providers *conf.AIProviders, ok bool) {
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	data, err := gulu.JSON.MarshalJSON(arg)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return nil, false
+	}
+	providers = conf.NewAIProviders()
+	if err = gulu.JSON.UnmarshalJSON(data, providers); nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return nil, false
+	}
+	if nil == providers.Routes {
+		providers.Routes = map[string]*conf.AIRoute{}
+	}
+	return providers, true
+}
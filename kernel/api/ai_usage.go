@@ -0,0 +1,107 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/gin-gonic/gin"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+	"github.com/siyuan-note/siyuan/kernel/model"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+func getAIUsageReport(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	// 默认统计最近 30 天，按天聚合
+	to := time.Now()
+	from := to.AddDate(0, 0, -29)
+	var err error
+	if fromArg, _ := arg["from"].(string); "" != fromArg {
+		if from, err = time.ParseInLocation("2006-01-02", fromArg, time.Local); nil != err {
+			ret.Code = -1
+			ret.Msg = "invalid from [" + fromArg + "]"
+			return
+		}
+	}
+	if toArg, _ := arg["to"].(string); "" != toArg {
+		if to, err = time.ParseInLocation("2006-01-02", toArg, time.Local); nil != err {
+			ret.Code = -1
+			ret.Msg = "invalid to [" + toArg + "]"
+			return
+		}
+	}
+
+	groupBy := []string{"day"}
+	if groupByArg, ok := arg["groupBy"].([]interface{}); ok {
+		groupBy = nil
+		for _, g := range groupByArg {
+			dim, _ := g.(string)
+			if !gulu.Str.Contains(dim, []string{"day", "model", "feature", "provider", "user"}) {
+				ret.Code = -1
+				ret.Msg = "invalid groupBy [" + dim + "]"
+				return
+			}
+			groupBy = append(groupBy, dim)
+		}
+	}
+
+	ctx := model.GetWorkspaceContext(c)
+	ret.Data = map[string]interface{}{
+		"from":    from.Format("2006-01-02"),
+		"to":      to.Format("2006-01-02"),
+		"groupBy": groupBy,
+		"rows":    model.GetAIUsageReport(ctx, from, to, groupBy, model.IsWorkspaceOwnerContext(c)),
+	}
+}
+
+func getAIUsageStatus(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	ret.Data = model.GetAIUsageStatus(model.GetWorkspaceContext(c))
+}
+
+func setAIBudget(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	username, _ := arg["username"].(string)
+	budget := &conf.AIBudget{}
+	if daily, ok := arg["daily"].(float64); ok {
+		budget.Daily = int64(daily)
+	}
+	if monthly, ok := arg["monthly"].(float64); ok {
+		budget.Monthly = int64(monthly)
+	}
+	model.SetAIBudget(username, budget)
+	ret.Data = model.Conf.AI.Usage
+}
@@ -17,7 +17,11 @@
 package api
 
 import (
+	"errors"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"time"
 
 	"github.com/88250/gulu"
 	"github.com/gin-gonic/gin"
@@ -27,6 +31,59 @@ import (
 	"github.com/siyuan-note/siyuan/kernel/util"
 )
 
+func importAttributeViewCSV(c *gin.Context) {
+	importAttributeView(c, model.ImportAttributeViewCSVWithContext)
+}
+
+func importAttributeViewXLSX(c *gin.Context) {
+	importAttributeView(c, model.ImportAttributeViewXLSXWithContext)
+}
+
+// importAttributeView 处理 CSV/XLSX 导入请求，表单字段 file 为导入文件，options 为 JSON 格式的 model.AttrViewImportOptions。
+func importAttributeView(c *gin.Context, importFunc func(ctx *model.WorkspaceContext, reader io.Reader, fileName string, opts *model.AttrViewImportOptions) (*model.AttrViewImportResult, error)) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	form, err := c.MultipartForm()
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+
+	files := form.File["file"]
+	if 1 > len(files) {
+		ret.Code = -1
+		ret.Msg = "no file found"
+		return
+	}
+
+	opts := &model.AttrViewImportOptions{}
+	if optionsVal := form.Value["options"]; 0 < len(optionsVal) && "" != optionsVal[0] {
+		if err = gulu.JSON.UnmarshalJSON([]byte(optionsVal[0]), opts); nil != err {
+			ret.Code = -1
+			ret.Msg = err.Error()
+			return
+		}
+	}
+	result, err := importAttributeViewFile(model.GetWorkspaceContext(c), files[0], opts, importFunc)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = result
+}
+
+func importAttributeViewFile(ctx *model.WorkspaceContext, file *multipart.FileHeader, opts *model.AttrViewImportOptions, importFunc func(ctx *model.WorkspaceContext, reader io.Reader, fileName string, opts *model.AttrViewImportOptions) (*model.AttrViewImportResult, error)) (ret *model.AttrViewImportResult, err error) {
+	reader, err := file.Open()
+	if nil != err {
+		return
+	}
+	defer reader.Close()
+	return importFunc(ctx, reader, file.Filename, opts)
+}
+
 func getAttributeViewItemIDsByBoundIDs(c *gin.Context) {
 	ret := gulu.Ret.NewResult()
 	defer c.JSON(http.StatusOK, ret)
@@ -861,6 +918,140 @@ func renderAttrView(blockID, avID, viewID, query string, page, pageSize int, gro
 	return
 }
 
+func renderAttributeViewCalendar(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	id, blockID, viewID, query := parseRenderAttrViewArg(arg)
+	rangeStart, rangeEnd, err := parseAttrViewDateRange(arg, 366)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+
+	calendar, attrView, err := model.RenderAttributeViewCalendar(blockID, id, viewID, query, rangeStart, rangeEnd)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+
+	ret.Data = map[string]interface{}{
+		"name":     attrView.Name,
+		"id":       attrView.ID,
+		"viewType": calendar.GetType(),
+		"viewID":   calendar.GetID(),
+		"view":     calendar,
+		"isMirror": av.IsMirror(attrView.ID),
+	}
+}
+
+func renderAttributeViewTimeline(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	id, blockID, viewID, query := parseRenderAttrViewArg(arg)
+	rangeStart, rangeEnd, err := parseAttrViewDateRange(arg, 366*5)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+
+	timeline, attrView, err := model.RenderAttributeViewTimeline(blockID, id, viewID, query, rangeStart, rangeEnd)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+
+	ret.Data = map[string]interface{}{
+		"name":     attrView.Name,
+		"id":       attrView.ID,
+		"viewType": timeline.GetType(),
+		"viewID":   timeline.GetID(),
+		"view":     timeline,
+		"isMirror": av.IsMirror(attrView.ID),
+	}
+}
+
+func renderAttributeViewChart(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	id, blockID, viewID, query := parseRenderAttrViewArg(arg)
+	chart, attrView, err := model.RenderAttributeViewChart(blockID, id, viewID, query)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+
+	ret.Data = map[string]interface{}{
+		"name":     attrView.Name,
+		"id":       attrView.ID,
+		"viewType": chart.GetType(),
+		"viewID":   chart.GetID(),
+		"view":     chart,
+		"isMirror": av.IsMirror(attrView.ID),
+	}
+}
+
+func parseRenderAttrViewArg(arg map[string]interface{}) (id, blockID, viewID, query string) {
+	id = arg["id"].(string)
+	if blockIDArg := arg["blockID"]; nil != blockIDArg {
+		blockID = blockIDArg.(string)
+	}
+	if viewIDArg := arg["viewID"]; nil != viewIDArg {
+		viewID = viewIDArg.(string)
+	}
+	if queryArg := arg["query"]; nil != queryArg {
+		query = queryArg.(string)
+	}
+	return
+}
+
+// parseAttrViewDateRange 解析渲染范围 start 和 end（格式为 yyyy-MM-dd，包含 end 当天），默认为当月。
+func parseAttrViewDateRange(arg map[string]interface{}, maxDays int) (rangeStart, rangeEnd time.Time, err error) {
+	now := time.Now()
+	rangeStart = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	rangeEnd = rangeStart.AddDate(0, 1, -1)
+	if startArg := arg["start"]; nil != startArg {
+		if rangeStart, err = time.ParseInLocation("2006-01-02", startArg.(string), time.Local); nil != err {
+			return
+		}
+	}
+	if endArg := arg["end"]; nil != endArg {
+		if rangeEnd, err = time.ParseInLocation("2006-01-02", endArg.(string), time.Local); nil != err {
+			return
+		}
+	}
+	if rangeEnd.Before(rangeStart) {
+		err = errors.New("end date is before start date")
+		return
+	}
+	if float64(maxDays) < rangeEnd.Sub(rangeStart).Hours()/24 {
+		err = errors.New("date range is too large")
+	}
+	return
+}
+
 func getCurrentAttrViewImages(c *gin.Context) {
 	ret := gulu.Ret.NewResult()
 	defer c.JSON(http.StatusOK, ret)
@@ -960,3 +1151,267 @@ func batchSetAttributeViewBlockAttrs(c *gin.Context) {
 
 	model.ReloadAttrView(avID)
 }
+
+func extractAttributeViewCells(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	viewID, _ := arg["viewID"].(string)
+	var itemIDs, keyIDs []string
+	if itemIDsArg, ok := arg["itemIDs"].([]interface{}); ok {
+		for _, itemID := range itemIDsArg {
+			itemIDs = append(itemIDs, itemID.(string))
+		}
+	}
+	if keyIDsArg, ok := arg["keyIDs"].([]interface{}); ok {
+		for _, keyID := range keyIDsArg {
+			keyIDs = append(keyIDs, keyID.(string))
+		}
+	}
+	overwrite, _ := arg["overwrite"].(bool)
+
+	result, err := model.ExtractAttributeViewCells(model.GetWorkspaceContext(c), avID, viewID, itemIDs, keyIDs, overwrite)
+	if err != nil {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = result
+}
+
+func applyAttributeViewExtractedCells(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	minConfidence, _ := arg["minConfidence"].(float64)
+	var cells []*model.AttrViewExtractedCell
+	data, err := gulu.JSON.MarshalJSON(arg["cells"])
+	if err == nil {
+		err = gulu.JSON.UnmarshalJSON(data, &cells)
+	}
+	if err != nil {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+
+	applied, err := model.ApplyAttributeViewExtractedCells(model.GetWorkspaceContext(c), avID, cells, minConfidence)
+	if err != nil {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = map[string]interface{}{
+		"applied": applied,
+	}
+
+	model.ReloadAttrView(avID)
+}
+
+func getAttributeViewAutomations(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	automations, err := model.GetAttributeViewAutomations(avID)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = map[string]interface{}{
+		"automations": automations,
+	}
+}
+
+func setAttributeViewAutomations(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	var automations []*av.Automation
+	data, err := gulu.JSON.MarshalJSON(arg["automations"])
+	if nil == err {
+		err = gulu.JSON.UnmarshalJSON(data, &automations)
+	}
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+
+	if err = model.SetAttributeViewAutomations(avID, automations); nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = map[string]interface{}{
+		"automations": automations,
+	}
+}
+
+func getAttributeViewAutomationLogs(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	ret.Data = map[string]interface{}{
+		"logs": model.GetAttributeViewAutomationLogs(avID),
+	}
+}
+
+func getAttributeViewConstraintViolations(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	violations, err := model.GetAttributeViewConstraintViolations(avID)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = map[string]interface{}{
+		"violations": violations,
+	}
+}
+
+func getAttributeViewCalendar(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	binding, err := model.GetAttributeViewCalendar(avID)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = map[string]interface{}{
+		"calendar": binding,
+	}
+}
+
+func bindAttributeViewCalendar(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	calendarPath := arg["calendarPath"].(string)
+	dateKeyID := arg["dateKeyID"].(string)
+	var notesKeyID string
+	if notesKeyIDArg := arg["notesKeyID"]; nil != notesKeyIDArg {
+		notesKeyID = notesKeyIDArg.(string)
+	}
+
+	if err := model.BindAttributeViewCalendar(avID, calendarPath, dateKeyID, notesKeyID); nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	model.ReloadAttrView(avID)
+}
+
+func unbindAttributeViewCalendar(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	if err := model.UnbindAttributeViewCalendar(avID); nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+}
+
+func getAttributeViewItemHistories(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	itemID := arg["itemID"].(string)
+	var keyID string
+	if keyIDArg := arg["keyID"]; nil != keyIDArg {
+		keyID = keyIDArg.(string)
+	}
+
+	ret.Data = map[string]interface{}{
+		"histories": model.GetAttributeViewItemHistories(avID, itemID, keyID),
+	}
+}
+
+func restoreAttributeViewItem(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	avID := arg["avID"].(string)
+	itemID := arg["itemID"].(string)
+	var keyID string
+	if keyIDArg := arg["keyID"]; nil != keyIDArg {
+		keyID = keyIDArg.(string)
+	}
+	before := int64(arg["before"].(float64))
+
+	if err := model.RestoreAttributeViewItem(avID, itemID, keyID, before); nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+}
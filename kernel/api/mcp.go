@@ -0,0 +1,62 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/88250/lute/ast"
+	"github.com/gin-gonic/gin"
+	"github.com/siyuan-note/siyuan/kernel/model"
+)
+
+// mcp 处理 MCP Streamable HTTP 传输，每个 POST 请求携带一条或一批 JSON-RPC 消息
+func mcp(c *gin.Context) {
+	if http.MethodPost != c.Request.Method {
+		// 服务端不会主动推送消息，不提供 SSE 流
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := model.GetWorkspaceContext(c)
+	if nil == ctx {
+		c.JSON(http.StatusUnauthorized, map[string]interface{}{
+			"code": -1,
+			"msg":  "用户未登录或上下文不存在",
+		})
+		return
+	}
+
+	data, err := io.ReadAll(c.Request.Body)
+	if nil != err {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	if "" == c.GetHeader("Mcp-Session-Id") && model.IsMCPInitializeMessage(data) {
+		c.Header("Mcp-Session-Id", ast.NewNodeID())
+	}
+
+	resp := model.HandleMCPMessage(ctx, data)
+	if 1 > len(resp) {
+		// 只包含通知或者响应的消息
+		c.Status(http.StatusAccepted)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", resp)
+}
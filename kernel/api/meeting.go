@@ -1,10 +1,16 @@
 package api
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
+	"sync"
 
+	"github.com/88250/gulu"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/siyuan-note/logging"
 	"github.com/siyuan-note/siyuan/kernel/model"
 )
 
@@ -25,7 +31,7 @@ func TranscribeAudio(c *gin.Context) {
 	}
 
 	// 调用服务层进行转录和摘要生成的处理
-	result, err := model.Meeting.TranscribeAudio(audioData)
+	result, err := model.Meeting.TranscribeAudio(model.GetWorkspaceContext(c), audioData)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "msg": err.Error()})
 		return
@@ -38,3 +44,110 @@ func TranscribeAudio(c *gin.Context) {
 		"data": result,
 	})
 }
+
+var meetingStreamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  64 * 1024,
+	WriteBufferSize: 4 * 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// TranscribeStream 流式转录。客户端持续发送二进制音频帧，发送 {"type": "stop"} 结束录音；
+// 服务端推送 partial、final、summary 事件，最后推送带完整结果的 done 事件后关闭连接
+func TranscribeStream(c *gin.Context) {
+	conn, err := meetingStreamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logging.LogErrorf("upgrade meeting stream failed: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	writeLock := sync.Mutex{}
+	send := func(evt *model.MeetingStreamEvent) {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		if err := conn.WriteJSON(evt); err != nil {
+			logging.LogDebugf("push meeting stream event failed: %s", err)
+		}
+	}
+
+	sampleRate, _ := strconv.Atoi(c.Query("sampleRate"))
+	opts := &model.MeetingStreamOptions{DocID: c.Query("docID"), Format: c.Query("format"), SampleRate: sampleRate}
+	stream, err := model.Meeting.StartStream(model.GetWorkspaceContext(c), opts, send)
+	if err != nil {
+		send(&model.MeetingStreamEvent{Type: model.MeetingStreamEventError, Msg: err.Error()})
+		return
+	}
+
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			// 客户端断开时仍然保留已经识别的内容
+			break
+		}
+		if websocket.BinaryMessage == msgType {
+			if err = stream.Write(data); err != nil {
+				send(&model.MeetingStreamEvent{Type: model.MeetingStreamEventError, Msg: err.Error()})
+				break
+			}
+			continue
+		}
+
+		var cmd struct {
+			Type string `json:"type"`
+		}
+		if err = json.Unmarshal(data, &cmd); err == nil && "stop" == cmd.Type {
+			break
+		}
+	}
+
+	result := stream.Stop()
+	send(&model.MeetingStreamEvent{Type: model.MeetingStreamEventDone, Result: result})
+	writeLock.Lock()
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	writeLock.Unlock()
+}
+
+// FinalizeMeeting 把会议整理为文档。使用 multipart 表单提交，audio 为可选的录音文件，
+// segments 为流式转录得到的片段 JSON 数组
+func FinalizeMeeting(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	opts := &model.MeetingFinalizeOptions{
+		BoxID:         c.PostForm("notebook"),
+		HPath:         c.PostForm("path"),
+		Title:         c.PostForm("title"),
+		Template:      c.PostForm("template"),
+		Transcription: c.PostForm("transcription"),
+		Summary:       c.PostForm("summary"),
+		AvID:          c.PostForm("avID"),
+		OwnerKeyID:    c.PostForm("ownerKeyID"),
+		DueKeyID:      c.PostForm("dueKeyID"),
+		StatusKeyID:   c.PostForm("statusKeyID"),
+	}
+	if segments := c.PostForm("segments"); "" != segments {
+		if err := gulu.JSON.UnmarshalJSON([]byte(segments), &opts.Segments); nil != err {
+			ret.Code = -1
+			ret.Msg = "invalid segments: " + err.Error()
+			return
+		}
+	}
+	if file, header, err := c.Request.FormFile("audio"); nil == err {
+		opts.Audio, err = io.ReadAll(file)
+		file.Close()
+		if nil != err {
+			ret.Code = -1
+			ret.Msg = "failed to read audio data"
+			return
+		}
+		opts.AudioName = header.Filename
+	}
+
+	result, err := model.Meeting.Finalize(model.GetWorkspaceContext(c), opts)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = result
+}
@@ -0,0 +1,175 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/model"
+)
+
+// OpenAI 兼容接口的错误按 OpenAI 的格式返回，以便客户端正确解析
+
+func openAICompatError(c *gin.Context, status int, errType, msg string) {
+	c.JSON(status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": msg,
+			"type":    errType,
+			"code":    nil,
+		},
+	})
+}
+
+func openAICompatUpstreamError(c *gin.Context, err error) {
+	if errors.Is(err, model.ErrOpenAICompatModelNotFound) {
+		openAICompatError(c, http.StatusNotFound, "invalid_request_error", err.Error())
+		return
+	}
+	if errors.Is(err, model.ErrAIBudgetExceeded) {
+		openAICompatError(c, http.StatusTooManyRequests, "insufficient_quota", err.Error())
+		return
+	}
+
+	apiErr := &openai.APIError{}
+	if errors.As(err, &apiErr) && 0 < apiErr.HTTPStatusCode {
+		openAICompatError(c, apiErr.HTTPStatusCode, "upstream_error", apiErr.Message)
+		return
+	}
+	openAICompatError(c, http.StatusBadGateway, "upstream_error", err.Error())
+}
+
+func openAICompatModels(c *gin.Context) {
+	ctx := model.GetWorkspaceContext(c)
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data":   model.ListOpenAICompatModels(ctx),
+	})
+}
+
+func openAICompatChatCompletions(c *gin.Context) {
+	req := openai.ChatCompletionRequest{}
+	if err := c.ShouldBindJSON(&req); nil != err {
+		openAICompatError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if 1 > len(req.Messages) {
+		openAICompatError(c, http.StatusBadRequest, "invalid_request_error", "messages is required")
+		return
+	}
+
+	ctx := model.GetWorkspaceContext(c)
+	if !req.Stream {
+		resp, err := model.OpenAICompatChatCompletion(ctx, req)
+		if nil != err {
+			openAICompatUpstreamError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	headerWritten := false
+	err := model.OpenAICompatChatCompletionStream(ctx, req, func(chunk *openai.ChatCompletionStreamResponse) error {
+		if !headerWritten {
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("Connection", "keep-alive")
+			c.Status(http.StatusOK)
+			headerWritten = true
+		}
+
+		data, err := json.Marshal(chunk)
+		if nil != err {
+			return err
+		}
+		if _, err = c.Writer.Write([]byte("data: " + string(data) + "\n\n")); nil != err {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if nil != err {
+		if !headerWritten {
+			openAICompatUpstreamError(c, err)
+			return
+		}
+
+		// 流已经开始，只能在流中返回错误
+		logging.LogErrorf("OpenAI compatible chat stream failed: %s", err)
+		data, _ := json.Marshal(map[string]interface{}{"error": map[string]interface{}{"message": err.Error(), "type": "upstream_error"}})
+		c.Writer.Write([]byte("data: " + string(data) + "\n\n"))
+	}
+	if !headerWritten {
+		c.Header("Content-Type", "text/event-stream")
+		c.Status(http.StatusOK)
+	}
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	c.Writer.Flush()
+}
+
+func openAICompatEmbeddings(c *gin.Context) {
+	var req struct {
+		Model string      `json:"model"`
+		Input interface{} `json:"input"`
+	}
+	if err := c.ShouldBindJSON(&req); nil != err {
+		openAICompatError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	var inputs []string
+	switch input := req.Input.(type) {
+	case string:
+		inputs = append(inputs, input)
+	case []interface{}:
+		for _, item := range input {
+			s, ok := item.(string)
+			if !ok {
+				openAICompatError(c, http.StatusBadRequest, "invalid_request_error", "input must be a string or an array of strings")
+				return
+			}
+			inputs = append(inputs, s)
+		}
+	}
+	if 1 > len(inputs) {
+		openAICompatError(c, http.StatusBadRequest, "invalid_request_error", "input is required")
+		return
+	}
+
+	data, err := model.OpenAICompatEmbeddings(model.GetWorkspaceContext(c), inputs)
+	if nil != err {
+		openAICompatUpstreamError(c, err)
+		return
+	}
+
+	// 向量化服务不返回用量，这里按字符数估算
+	promptTokens := 0
+	for _, input := range inputs {
+		promptTokens += len([]rune(input))
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  req.Model,
+		"usage":  map[string]interface{}{"prompt_tokens": promptTokens, "total_tokens": promptTokens},
+	})
+}
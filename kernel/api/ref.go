@@ -124,7 +124,8 @@ func getBacklink2(c *gin.Context) {
 	if val, ok := arg["containChildren"]; ok {
 		containChildren = val.(bool)
 	}
-	boxID, backlinks, backmentions, linkRefsCount, mentionsCount := model.GetBacklink2(id, keyword, mentionKeyword, sort, mentionSort, containChildren)
+	withRelated, _ := arg["related"].(bool)
+	boxID, backlinks, backmentions, related, linkRefsCount, mentionsCount := model.GetBacklink2(id, keyword, mentionKeyword, sort, mentionSort, containChildren, withRelated)
 	ret.Data = map[string]interface{}{
 		"backlinks":     backlinks,
 		"linkRefsCount": linkRefsCount,
@@ -134,6 +135,9 @@ func getBacklink2(c *gin.Context) {
 		"mk":            mentionKeyword,
 		"box":           boxID,
 	}
+	if withRelated {
+		ret.Data.(map[string]interface{})["related"] = related
+	}
 }
 
 func getBacklink(c *gin.Context) {
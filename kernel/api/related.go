@@ -0,0 +1,91 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/88250/gulu"
+	"github.com/gin-gonic/gin"
+	"github.com/siyuan-note/siyuan/kernel/model"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+func getRelatedDocs(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	id, _ := arg["id"].(string)
+	boxIDs, allBoxes := semanticScopeArg(arg)
+	limit := 0
+	if l, ok := arg["limit"].(float64); ok {
+		limit = int(l)
+	}
+	threshold, _ := arg["threshold"].(float64)
+
+	docs, err := model.GetRelatedDocs(model.GetWorkspaceContext(c), id, boxIDs, allBoxes, limit, threshold)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = docs
+}
+
+func startDuplicateBlocksJob(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	boxIDs, allBoxes := semanticScopeArg(arg)
+	threshold, _ := arg["threshold"].(float64)
+	if err := model.StartDuplicateBlocksJob(model.GetWorkspaceContext(c), boxIDs, allBoxes, threshold); nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	ret.Data = model.GetDuplicateBlocksJob(model.GetWorkspaceContext(c))
+}
+
+func getDuplicateBlocksJob(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	ret.Data = model.GetDuplicateBlocksJob(model.GetWorkspaceContext(c))
+}
+
+// semanticScopeArg 解析笔记本范围参数：notebooks 为笔记本 ID 列表，allNotebooks 为 true 时使用所有打开的笔记本
+func semanticScopeArg(arg map[string]interface{}) (boxIDs []string, allBoxes bool) {
+	if notebooks, ok := arg["notebooks"].([]interface{}); ok {
+		for _, notebook := range notebooks {
+			if boxID, ok := notebook.(string); ok {
+				boxIDs = append(boxIDs, boxID)
+			}
+		}
+	}
+	allBoxes, _ = arg["allNotebooks"].(bool)
+	return
+}
@@ -449,3 +449,42 @@ func deckData(deck *riff.Deck) map[string]interface{} {
 		"updated": time.UnixMilli(deck.Updated).Format("2006-01-02 15:04:05"),
 	}
 }
+
+func generateRiffCards(c *gin.Context) {
+	ret := gulu.Ret.NewResult()
+	defer c.JSON(http.StatusOK, ret)
+
+	arg, ok := util.JsonArg(c, ret)
+	if !ok {
+		return
+	}
+
+	id, _ := arg["id"].(string)
+	deckID, _ := arg["deckID"].(string)
+	parentID, _ := arg["parentID"].(string)
+	preview, _ := arg["preview"].(bool)
+	limit := 0
+	if limitArg, ok := arg["limit"].(float64); ok {
+		limit = int(limitArg)
+	}
+
+	ctx := model.GetWorkspaceContext(c)
+	cards, err := model.GenerateFlashcards(ctx, id, deckID, parentID, limit, preview)
+	if nil != err {
+		ret.Code = -1
+		ret.Msg = err.Error()
+		return
+	}
+	if nil == cards {
+		cards = []*model.GeneratedFlashcard{}
+	}
+
+	data := map[string]interface{}{
+		"cards":   cards,
+		"preview": preview,
+	}
+	if !preview {
+		data["deck"] = deckData(model.Decks[deckID])
+	}
+	ret.Data = data
+}
@@ -464,6 +464,7 @@ func ServeAPI(ginServer *gin.Engine) {
 	ginServer.Handle("POST", "/api/riff/resetRiffCards", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, resetRiffCards)
 	ginServer.Handle("POST", "/api/riff/batchSetRiffCardsDueTime", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, batchSetRiffCardsDueTime)
 	ginServer.Handle("POST", "/api/riff/getRiffCardsByBlockIDs", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, getRiffCardsByBlockIDs)
+	ginServer.Handle("POST", "/api/riff/generateCards", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, generateRiffCards)
 
 	ginServer.Handle("POST", "/api/notification/pushMsg", model.CheckWebAuth, model.CheckAdminRole, pushMsg)
 	ginServer.Handle("POST", "/api/notification/pushErrMsg", model.CheckWebAuth, model.CheckAdminRole, pushErrMsg)
@@ -473,6 +474,9 @@ func ServeAPI(ginServer *gin.Engine) {
 	ginServer.Handle("POST", "/api/snippet/removeSnippet", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, removeSnippet)
 
 	ginServer.Handle("POST", "/api/av/renderAttributeView", model.CheckAuth, renderAttributeView)
+	ginServer.Handle("POST", "/api/av/renderAttributeViewCalendar", model.CheckAuth, renderAttributeViewCalendar)
+	ginServer.Handle("POST", "/api/av/renderAttributeViewTimeline", model.CheckAuth, renderAttributeViewTimeline)
+	ginServer.Handle("POST", "/api/av/renderAttributeViewChart", model.CheckAuth, renderAttributeViewChart)
 	ginServer.Handle("POST", "/api/av/renderHistoryAttributeView", model.CheckAuth, model.CheckAdminRole, renderHistoryAttributeView)
 	ginServer.Handle("POST", "/api/av/renderSnapshotAttributeView", model.CheckAuth, model.CheckAdminRole, renderSnapshotAttributeView)
 	ginServer.Handle("POST", "/api/av/getAttributeViewKeys", model.CheckAuth, getAttributeViewKeys)
@@ -504,16 +508,55 @@ func ServeAPI(ginServer *gin.Engine) {
 	ginServer.Handle("POST", "/api/av/getAttributeViewAddingBlockDefaultValues", model.CheckAuth, getAttributeViewAddingBlockDefaultValues)
 	ginServer.Handle("POST", "/api/av/getAttributeViewBoundBlockIDsByItemIDs", model.CheckAuth, getAttributeViewBoundBlockIDsByItemIDs)
 	ginServer.Handle("POST", "/api/av/getAttributeViewItemIDsByBoundIDs", model.CheckAuth, getAttributeViewItemIDsByBoundIDs)
+	ginServer.Handle("POST", "/api/av/extractAttributeViewCells", model.CheckAuth, model.CheckAdminRole, model.CheckReadonly, extractAttributeViewCells)
+	ginServer.Handle("POST", "/api/av/applyAttributeViewExtractedCells", model.CheckAuth, model.CheckAdminRole, model.CheckReadonly, applyAttributeViewExtractedCells)
+	ginServer.Handle("POST", "/api/av/importCSV", model.CheckAuth, model.CheckAdminRole, model.CheckReadonly, importAttributeViewCSV)
+	ginServer.Handle("POST", "/api/av/importXLSX", model.CheckAuth, model.CheckAdminRole, model.CheckReadonly, importAttributeViewXLSX)
+	ginServer.Handle("POST", "/api/av/getAttributeViewAutomations", model.CheckAuth, getAttributeViewAutomations)
+	ginServer.Handle("POST", "/api/av/setAttributeViewAutomations", model.CheckAuth, model.CheckAdminRole, model.CheckReadonly, setAttributeViewAutomations)
+	ginServer.Handle("POST", "/api/av/getAttributeViewAutomationLogs", model.CheckAuth, getAttributeViewAutomationLogs)
+	ginServer.Handle("POST", "/api/av/getAttributeViewConstraintViolations", model.CheckAuth, getAttributeViewConstraintViolations)
+	ginServer.Handle("POST", "/api/av/getAttributeViewCalendar", model.CheckAuth, getAttributeViewCalendar)
+	ginServer.Handle("POST", "/api/av/bindAttributeViewCalendar", model.CheckAuth, model.CheckAdminRole, model.CheckReadonly, bindAttributeViewCalendar)
+	ginServer.Handle("POST", "/api/av/unbindAttributeViewCalendar", model.CheckAuth, model.CheckAdminRole, model.CheckReadonly, unbindAttributeViewCalendar)
+	ginServer.Handle("POST", "/api/av/getAttributeViewItemHistories", model.CheckAuth, getAttributeViewItemHistories)
+	ginServer.Handle("POST", "/api/av/restoreAttributeViewItem", model.CheckAuth, model.CheckAdminRole, model.CheckReadonly, restoreAttributeViewItem)
 
 	ginServer.Handle("POST", "/api/ai/chatGPT", model.CheckWebAuth, model.CheckAdminRole, chatGPT)
 	ginServer.Handle("POST", "/api/ai/chatGPTWithAction", model.CheckWebAuth, model.CheckAdminRole, chatGPTWithAction)
+	ginServer.Handle("POST", "/api/ai/getAIActions", model.CheckWebAuth, getAIActions)
+	ginServer.Handle("POST", "/api/ai/setAIActions", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, setAIActions)
+	ginServer.Handle("POST", "/api/ai/runAIAction", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, runAIAction)
+	ginServer.Handle("POST", "/api/ai/clearAIActionContext", model.CheckWebAuth, clearAIActionContext)
 	ginServer.Handle("POST", "/api/ai/chat", model.CheckWebAuth, model.CheckAdminRole, chat)
 	ginServer.Handle("POST", "/api/ai/chatStream", model.CheckWebAuth, model.CheckAdminRole, chatStream)
+	ginServer.Handle("POST", "/api/ai/chatAgent", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, chatAgent)
+	ginServer.Handle("POST", "/api/ai/applyAgentProposals", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, applyAgentProposals)
+	ginServer.Handle("POST", "/api/ai/getAgentTools", model.CheckWebAuth, model.CheckAdminRole, getAgentTools)
+	ginServer.Handle("POST", "/api/ai/getAIProviders", model.CheckWebAuth, model.CheckAdminRole, getAIProviders)
+	ginServer.Handle("POST", "/api/ai/setAIProviders", model.CheckWebAuth, model.CheckWorkspaceOwner, model.CheckReadonly, setAIProviders)
+	ginServer.Handle("POST", "/api/ai/setUserAIProviders", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, setUserAIProviders)
+	ginServer.Handle("POST", "/api/ai/validateAIProviders", model.CheckWebAuth, model.CheckAdminRole, validateAIProviders)
+	ginServer.Handle("POST", "/api/ai/testAIProvider", model.CheckWebAuth, model.CheckAdminRole, testAIProvider)
+	ginServer.Handle("POST", "/api/ai/getAIUsageReport", model.CheckWebAuth, model.CheckAdminRole, getAIUsageReport)
+	ginServer.Handle("POST", "/api/ai/getAIUsageStatus", model.CheckWebAuth, getAIUsageStatus)
+	ginServer.Handle("POST", "/api/ai/setAIBudget", model.CheckWebAuth, model.CheckWorkspaceOwner, model.CheckReadonly, setAIBudget)
+
+	ginServer.Handle("POST", "/mcp", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, mcp)
+	ginServer.Handle("GET", "/mcp", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, mcp)
+
+	// OpenAI 兼容接口
+	ginServer.Handle("GET", "/v1/models", model.CheckWebAuth, model.CheckAdminRole, openAICompatModels)
+	ginServer.Handle("POST", "/v1/chat/completions", model.CheckWebAuth, model.CheckAdminRole, openAICompatChatCompletions)
+	ginServer.Handle("POST", "/v1/embeddings", model.CheckWebAuth, model.CheckAdminRole, openAICompatEmbeddings)
 
 	// 新增向量化和AI文档分析API
 	ginServer.Handle("POST", "/api/ai/vectorizeBlock", model.CheckWebAuth, vectorizeBlock)
 	ginServer.Handle("POST", "/api/ai/batchVectorizeNotebook", model.CheckWebAuth, batchVectorizeNotebook)
 	ginServer.Handle("POST", "/api/ai/semanticSearch", model.CheckWebAuth, semanticSearch)
+	ginServer.Handle("POST", "/api/ai/getRelatedDocs", model.CheckWebAuth, getRelatedDocs)
+	ginServer.Handle("POST", "/api/ai/startDuplicateBlocksJob", model.CheckWebAuth, model.CheckAdminRole, startDuplicateBlocksJob)
+	ginServer.Handle("POST", "/api/ai/getDuplicateBlocksJob", model.CheckWebAuth, model.CheckAdminRole, getDuplicateBlocksJob)
 	ginServer.Handle("POST", "/api/ai/generateNotebookSummary", model.CheckWebAuth, generateNotebookSummary)
 	ginServer.Handle("POST", "/api/ai/getNotebookSummary", model.CheckWebAuth, getNotebookSummary)
 
@@ -532,15 +575,22 @@ func ServeAPI(ginServer *gin.Engine) {
 	ginServer.Handle("POST", "/api/ai/getVectorizedAssets", model.CheckWebAuth, getVectorizedAssets)
 	ginServer.Handle("POST", "/api/ai/batchVectorizeAllAssets", model.CheckWebAuth, model.CheckAdminRole, batchVectorizeAllAssets)
 	ginServer.Handle("GET", "/api/ai/getVectorizeProgress", model.CheckWebAuth, getVectorizeProgress)
+	ginServer.Handle("POST", "/api/ai/startReembedJob", model.CheckWebAuth, model.CheckWorkspaceOwner, startReembedJob)
+	ginServer.Handle("POST", "/api/ai/cancelReembedJob", model.CheckWebAuth, model.CheckWorkspaceOwner, cancelReembedJob)
+	ginServer.Handle("GET", "/api/ai/getReembedProgress", model.CheckWebAuth, getReembedProgress)
 	
 	// 内部 API（仅 localhost 可访问，无需认证）
 	ginServer.Handle("POST", "/api/internal/batchVectorizeAllAssets", model.CheckLocalhost, batchVectorizeAllAssets)
 	ginServer.Handle("GET", "/api/internal/getVectorizeProgress", model.CheckLocalhost, getVectorizeProgress)
 
-	// OCR API (PaddleOCR)
+	// OCR API
 	ginServer.Handle("POST", "/api/ai/ocrAsset", model.CheckWebAuth, ocrAsset)
 	ginServer.Handle("POST", "/api/ai/ocrHealthCheck", model.CheckWebAuth, ocrHealthCheck)
 	ginServer.Handle("POST", "/api/ai/getOCRResult", model.CheckWebAuth, getOCRResult)
+	ginServer.Handle("POST", "/api/ai/enqueueOCR", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, enqueueOCR)
+	ginServer.Handle("POST", "/api/ai/retryOCRJob", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, retryOCRJob)
+	ginServer.Handle("POST", "/api/ai/setOCRJobPriority", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, setOCRJobPriority)
+	ginServer.Handle("POST", "/api/ai/getOCRQueueStatus", model.CheckWebAuth, getOCRQueueStatus)
 
 	ginServer.Handle("POST", "/api/petal/loadPetals", model.CheckWebAuth, loadPetals)
 	ginServer.Handle("POST", "/api/petal/setPetalEnabled", model.CheckWebAuth, model.CheckAdminRole, model.CheckReadonly, setPetalEnabled)
@@ -589,4 +639,6 @@ func ServeAPI(ginServer *gin.Engine) {
 
 	meetingAPI := ginServer.Group("/api/meeting", model.CheckWebAuth)
 	meetingAPI.POST("/transcribe", TranscribeAudio)
+	meetingAPI.POST("/finalize", model.CheckReadonly, FinalizeMeeting)
+	ginServer.Handle("GET", "/ws/meeting/transcribe", model.CheckWebAuth, model.CheckReadonly, TranscribeStream)
 }
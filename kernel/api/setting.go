@@ -205,6 +205,16 @@ func setAI(c *gin.Context) {
 		ai.OpenAI.APIMaxContexts = 7
 	}
 
+	if nil == ai.Agent {
+		ai.Agent = model.Conf.AI.Agent
+	}
+	if nil == ai.Providers {
+		ai.Providers = model.Conf.AI.Providers
+	}
+	if nil == ai.Usage {
+		ai.Usage = model.Conf.AI.Usage
+	}
+
 	model.Conf.AI = ai
 	model.Conf.Save()
 
@@ -0,0 +1,112 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package av
+
+import (
+	"strings"
+)
+
+// AutomationTriggerType 描述了自动化规则的触发类型。
+type AutomationTriggerType string
+
+const (
+	AutomationTriggerItemAdded   AutomationTriggerType = "itemAdded"   // 添加项目
+	AutomationTriggerCellChanged AutomationTriggerType = "cellChanged" // 单元格修改为指定值
+	AutomationTriggerDateReached AutomationTriggerType = "dateReached" // 到达日期
+)
+
+// AutomationActionType 描述了自动化规则的动作类型。
+type AutomationActionType string
+
+const (
+	AutomationActionSetCell      AutomationActionType = "setCell"      // 设置当前项目的单元格
+	AutomationActionAddToAv      AutomationActionType = "addToAv"      // 添加到另一个数据库
+	AutomationActionRemoveFromAv AutomationActionType = "removeFromAv" // 从另一个数据库中移除
+	AutomationActionCreateDoc    AutomationActionType = "createDoc"    // 使用模板创建文档
+	AutomationActionNotify       AutomationActionType = "notify"       // 推送通知
+	AutomationActionWebhook      AutomationActionType = "webhook"      // 调用外部 Webhook
+)
+
+// Automation 描述了数据库自动化规则的结构。
+//
+// 规则保存在数据库中，添加项目和修改单元格的事务提交后求值，到达日期由定时任务扫描。
+// 规则动作的修改不会再次触发规则，避免规则之间循环触发。
+type Automation struct {
+	ID      string              `json:"id"`              // 规则 ID
+	Name    string              `json:"name"`            // 规则名称
+	Enabled bool                `json:"enabled"`         // 是否启用
+	Trigger *AutomationTrigger  `json:"trigger"`         // 触发条件
+	Actions []*AutomationAction `json:"actions"`         // 动作，按顺序执行
+	Created int64               `json:"created"`         // 创建时间
+	Updated int64               `json:"updated"`         // 更新时间，到达日期规则只处理更新时间之后到达的日期
+	Fired   map[string]int64    `json:"fired,omitempty"` // 到达日期规则已经触发过的项目 ID 和对应的日期，日期变化后会再次触发
+}
+
+// AutomationTrigger 描述了自动化规则触发条件的结构。
+type AutomationTrigger struct {
+	Type   AutomationTriggerType `json:"type"`             // 触发类型
+	KeyID  string                `json:"keyID,omitempty"`  // 单元格修改和到达日期时使用的字段 ID
+	Value  string                `json:"value,omitempty"`  // 单元格修改后的值，为空时任意修改都会触发
+	Offset int64                 `json:"offset,omitempty"` // 到达日期的偏移分钟数，负数表示提前
+}
+
+// AutomationAction 描述了自动化规则动作的结构。
+type AutomationAction struct {
+	Type     AutomationActionType `json:"type"`               // 动作类型
+	KeyID    string               `json:"keyID,omitempty"`    // 设置单元格时的字段 ID
+	Value    interface{}          `json:"value,omitempty"`    // 设置单元格时的值，格式和 updateAttrViewCell 一致
+	AvID     string               `json:"avID,omitempty"`     // 添加到或者移除出的数据库 ID
+	BoxID    string               `json:"boxID,omitempty"`    // 创建文档的笔记本 ID
+	HPath    string               `json:"hPath,omitempty"`    // 创建文档的父路径
+	Template string               `json:"template,omitempty"` // 创建文档使用的模板路径，相对于 data/templates/
+	Message  string               `json:"message,omitempty"`  // 通知内容，{{title}} 会被替换为项目标题
+	URL      string               `json:"url,omitempty"`      // Webhook 地址
+}
+
+// IsAutomationActionType 判断动作类型是否合法。
+func IsAutomationActionType(typ AutomationActionType) bool {
+	switch typ {
+	case AutomationActionSetCell, AutomationActionAddToAv, AutomationActionRemoveFromAv, AutomationActionCreateDoc, AutomationActionNotify, AutomationActionWebhook:
+		return true
+	}
+	return false
+}
+
+// MatchAutomationValue 判断单元格值是否满足单元格修改触发条件中的值。
+func MatchAutomationValue(value *Value, expected string) bool {
+	expected = strings.TrimSpace(expected)
+	if "" == expected {
+		return true
+	}
+	if nil == value {
+		return false
+	}
+
+	switch value.Type {
+	case KeyTypeCheckbox:
+		checked := nil != value.Checkbox && value.Checkbox.Checked
+		return (checked && "true" == expected) || (!checked && "false" == expected)
+	case KeyTypeSelect, KeyTypeMSelect:
+		for _, opt := range value.MSelect {
+			if opt.Content == expected {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.TrimSpace(value.String(false)) == expected
+}
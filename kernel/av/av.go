@@ -43,6 +43,9 @@ type AttributeView struct {
 	ViewID    string       `json:"viewID"`    // 当前视图 ID
 	Views     []*View      `json:"views"`     // 视图
 
+	Automations []*Automation    `json:"automations,omitempty"` // 自动化规则
+	Calendar    *CalendarBinding `json:"calendar,omitempty"`    // 绑定的 CalDAV 日历
+
 	RenderedViewables map[string]Viewable `json:"-"` // 已经渲染好的视图
 }
 
@@ -117,6 +120,7 @@ const (
 	KeyTypeRelation   KeyType = "relation"   // 关联
 	KeyTypeRollup     KeyType = "rollup"     // 汇总
 	KeyTypeLineNumber KeyType = "lineNumber" // 行号
+	KeyTypeFormula    KeyType = "formula"    // 公式
 )
 
 // Key 描述了属性视图属性字段的基础结构。
@@ -152,6 +156,12 @@ type Key struct {
 
 	// 更新时间
 	Updated *Updated `json:"updated,omitempty"` // 更新时间设置
+
+	// 公式
+	Formula *Formula `json:"formula,omitempty"` // 公式设置
+
+	// 校验规则
+	Constraint *KeyConstraint `json:"constraint,omitempty"` // 字段校验规则
 }
 
 func NewKey(id, name, icon string, keyType KeyType) *Key {
@@ -211,19 +221,22 @@ type SelectOption struct {
 
 // View 描述了视图的结构。
 type View struct {
-	ID               string         `json:"id"`                // 视图 ID
-	Icon             string         `json:"icon"`              // 视图图标
-	Name             string         `json:"name"`              // 视图名称
-	HideAttrViewName bool           `json:"hideAttrViewName"`  // 是否隐藏属性视图名称
-	Desc             string         `json:"desc"`              // 视图描述
-	Filters          []*ViewFilter  `json:"filters,omitempty"` // 过滤规则
-	Sorts            []*ViewSort    `json:"sorts,omitempty"`   // 排序规则
-	PageSize         int            `json:"pageSize"`          // 每页条目数
-	LayoutType       LayoutType     `json:"type"`              // 当前布局类型
-	Table            *LayoutTable   `json:"table,omitempty"`   // 表格布局
-	Gallery          *LayoutGallery `json:"gallery,omitempty"` // 卡片布局
-	Kanban           *LayoutKanban  `json:"kanban,omitempty"`  // 看板布局
-	ItemIDs          []string       `json:"itemIds,omitempty"` // 项目 ID 列表，用于维护所有项目
+	ID               string          `json:"id"`                 // 视图 ID
+	Icon             string          `json:"icon"`               // 视图图标
+	Name             string          `json:"name"`               // 视图名称
+	HideAttrViewName bool            `json:"hideAttrViewName"`   // 是否隐藏属性视图名称
+	Desc             string          `json:"desc"`               // 视图描述
+	Filters          []*ViewFilter   `json:"filters,omitempty"`  // 过滤规则
+	Sorts            []*ViewSort     `json:"sorts,omitempty"`    // 排序规则
+	PageSize         int             `json:"pageSize"`           // 每页条目数
+	LayoutType       LayoutType      `json:"type"`               // 当前布局类型
+	Table            *LayoutTable    `json:"table,omitempty"`    // 表格布局
+	Gallery          *LayoutGallery  `json:"gallery,omitempty"`  // 卡片布局
+	Kanban           *LayoutKanban   `json:"kanban,omitempty"`   // 看板布局
+	Calendar         *LayoutCalendar `json:"calendar,omitempty"` // 日历布局
+	Timeline         *LayoutTimeline `json:"timeline,omitempty"` // 时间线布局
+	Chart            *LayoutChart    `json:"chart,omitempty"`    // 图表布局
+	ItemIDs          []string        `json:"itemIds,omitempty"`  // 项目 ID 列表，用于维护所有项目
 
 	Group        *ViewGroup `json:"group,omitempty"`     // 分组规则
 	GroupCreated int64      `json:"groupCreated"`        // 分组生成时间戳
@@ -313,9 +326,12 @@ type GroupCalc struct {
 type LayoutType string
 
 const (
-	LayoutTypeTable   LayoutType = "table"   // 属性视图类型 - 表格
-	LayoutTypeGallery LayoutType = "gallery" // 属性视图类型 - 卡片
-	LayoutTypeKanban  LayoutType = "kanban"  // 属性视图类型 - 看板
+	LayoutTypeTable    LayoutType = "table"    // 属性视图类型 - 表格
+	LayoutTypeGallery  LayoutType = "gallery"  // 属性视图类型 - 卡片
+	LayoutTypeKanban   LayoutType = "kanban"   // 属性视图类型 - 看板
+	LayoutTypeCalendar LayoutType = "calendar" // 属性视图类型 - 日历
+	LayoutTypeTimeline LayoutType = "timeline" // 属性视图类型 - 时间线
+	LayoutTypeChart    LayoutType = "chart"    // 属性视图类型 - 图表
 )
 
 const (
@@ -377,6 +393,42 @@ func NewKanbanView() (ret *View) {
 	}
 }
 
+func NewCalendarView() (ret *View) {
+	return &View{
+		ID:         ast.NewNodeID(),
+		Name:       GetAttributeViewI18n("calendar"),
+		Filters:    []*ViewFilter{},
+		Sorts:      []*ViewSort{},
+		PageSize:   ViewDefaultPageSize,
+		LayoutType: LayoutTypeCalendar,
+		Calendar:   NewLayoutCalendar(),
+	}
+}
+
+func NewTimelineView() (ret *View) {
+	return &View{
+		ID:         ast.NewNodeID(),
+		Name:       GetAttributeViewI18n("timeline"),
+		Filters:    []*ViewFilter{},
+		Sorts:      []*ViewSort{},
+		PageSize:   ViewDefaultPageSize,
+		LayoutType: LayoutTypeTimeline,
+		Timeline:   NewLayoutTimeline(),
+	}
+}
+
+func NewChartView() (ret *View) {
+	return &View{
+		ID:         ast.NewNodeID(),
+		Name:       GetAttributeViewI18n("chart"),
+		Filters:    []*ViewFilter{},
+		Sorts:      []*ViewSort{},
+		PageSize:   ViewDefaultPageSize,
+		LayoutType: LayoutTypeChart,
+		Chart:      NewLayoutChart(),
+	}
+}
+
 // Viewable 描述了视图的接口。
 type Viewable interface {
 
@@ -759,9 +811,9 @@ func (av *AttributeView) Clone() (ret *AttributeView) {
 	for _, view := range ret.Views {
 		view.ID = ast.NewNodeID()
 
-		for _, f := range view.Filters {
+		WalkFilters(view.Filters, func(f *ViewFilter) {
 			f.Column = keyIDMap[f.Column]
-		}
+		})
 		for _, s := range view.Sorts {
 			s.Column = keyIDMap[s.Column]
 		}
@@ -786,11 +838,49 @@ func (av *AttributeView) Clone() (ret *AttributeView) {
 			for _, field := range view.Kanban.Fields {
 				field.ID = keyIDMap[field.ID]
 			}
+		case LayoutTypeCalendar:
+			view.Calendar.ID = ast.NewNodeID()
+			view.Calendar.StartKeyID = keyIDMap[view.Calendar.StartKeyID]
+			view.Calendar.EndKeyID = keyIDMap[view.Calendar.EndKeyID]
+			view.Calendar.TitleKeyID = keyIDMap[view.Calendar.TitleKeyID]
+			for _, field := range view.Calendar.Fields {
+				field.ID = keyIDMap[field.ID]
+			}
+		case LayoutTypeTimeline:
+			view.Timeline.ID = ast.NewNodeID()
+			view.Timeline.StartKeyID = keyIDMap[view.Timeline.StartKeyID]
+			view.Timeline.EndKeyID = keyIDMap[view.Timeline.EndKeyID]
+			view.Timeline.TitleKeyID = keyIDMap[view.Timeline.TitleKeyID]
+			view.Timeline.DependencyKeyID = keyIDMap[view.Timeline.DependencyKeyID]
+			for _, field := range view.Timeline.Fields {
+				field.ID = keyIDMap[field.ID]
+			}
+		case LayoutTypeChart:
+			view.Chart.ID = ast.NewNodeID()
+			view.Chart.SeriesKeyID = keyIDMap[view.Chart.SeriesKeyID]
+			if nil != view.Chart.Aggregate {
+				view.Chart.Aggregate.KeyID = keyIDMap[view.Chart.Aggregate.KeyID]
+			}
+			for _, field := range view.Chart.Fields {
+				field.ID = keyIDMap[field.ID]
+			}
 		}
 		view.ItemIDs = []string{}
 	}
 	ret.ViewID = ret.Views[0].ID
 
+	for _, automation := range ret.Automations {
+		automation.ID = ast.NewNodeID()
+		automation.Fired = nil
+		if nil != automation.Trigger {
+			automation.Trigger.KeyID = keyIDMap[automation.Trigger.KeyID]
+		}
+		for _, action := range automation.Actions {
+			action.KeyID = keyIDMap[action.KeyID]
+		}
+	}
+	ret.Calendar = nil // 同一个日历只能绑定一个数据库
+
 	ret.KeyIDs = nil
 	for _, oldKeyID := range oldKeyIDs {
 		newKeyID := keyIDMap[oldKeyID]
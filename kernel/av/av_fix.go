@@ -115,13 +115,10 @@ func upgradeSpec2(av *AttributeView) {
 		}
 
 		// 清理过滤和排序规则中不存在的键
-		tmpFilters := []*ViewFilter{}
-		for _, f := range view.Filters {
-			if k, _ := av.GetKey(f.Column); nil != k {
-				tmpFilters = append(tmpFilters, f)
-			}
-		}
-		view.Filters = tmpFilters
+		view.Filters, _ = RemoveFilters(view.Filters, func(f *ViewFilter) bool {
+			k, _ := av.GetKey(f.Column)
+			return nil == k
+		})
 
 		tmpSorts := []*ViewSort{}
 		for _, s := range view.Sorts {
@@ -128,9 +128,63 @@ func calcField(collection Collection, field Field, fieldIndex int, attrView *Att
 		calcFieldRelation(collection, field, fieldIndex)
 	case KeyTypeRollup:
 		calcFieldRollup(collection, field, fieldIndex)
+	case KeyTypeFormula:
+		calcFieldFormula(collection, field, fieldIndex, attrView)
 	}
 }
 
+// calcFieldFormula 将公式字段的值转换为结果类型对应的值后按结果类型计算，结果类型不一致的值视为空值。
+func calcFieldFormula(collection Collection, field Field, fieldIndex int, attrView *AttributeView) {
+	var resultType KeyType
+	for _, item := range collection.GetItems() {
+		if result := item.GetValues()[fieldIndex].GetFormulaResult(); nil != result {
+			resultType = result.Type
+			break
+		}
+	}
+
+	resultCollection := &formulaResultCollection{Collection: collection}
+	for _, item := range collection.GetItems() {
+		values := append([]*Value{}, item.GetValues()...)
+		if result := values[fieldIndex].GetFormulaResult(); nil != result && resultType == result.Type {
+			values[fieldIndex] = result
+		} else {
+			values[fieldIndex] = nil
+		}
+		resultCollection.items = append(resultCollection.items, &formulaResultItem{Item: item, values: values})
+	}
+
+	switch resultType {
+	case KeyTypeNumber:
+		calcFieldNumber(resultCollection, field, fieldIndex)
+	case KeyTypeText:
+		calcFieldText(resultCollection, field, fieldIndex)
+	case KeyTypeDate:
+		calcFieldDate(resultCollection, field, fieldIndex)
+	case KeyTypeCheckbox:
+		calcFieldCheckbox(resultCollection, field, fieldIndex)
+	}
+}
+
+// formulaResultCollection 用于公式字段计算，项目中公式字段的值被替换为结果类型对应的值。
+type formulaResultCollection struct {
+	Collection
+	items []Item
+}
+
+func (collection *formulaResultCollection) GetItems() []Item {
+	return collection.items
+}
+
+type formulaResultItem struct {
+	Item
+	values []*Value
+}
+
+func (item *formulaResultItem) GetValues() []*Value {
+	return item.values
+}
+
 func calcFieldTemplate(collection Collection, field Field, fieldIndex int) {
 	calc := field.GetCalc()
 	switch calc.Operator {
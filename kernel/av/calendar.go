@@ -0,0 +1,61 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package av
+
+// CalendarBinding 描述了数据库和 CalDAV 日历的绑定。
+//
+// 绑定后每个日期不为空的项目对应日历中的一个事件：标题为主键，时间为日期字段，备注为文本字段。
+// 数据库导出的事件 UID 即项目 ID，日历客户端创建的事件使用 UIDs 映射到新建的项目。
+// 只有导出过或者映射过的事件才会在项目删除后从日历中删除，其他事件（比如导入失败的事件）保持不变。
+type CalendarBinding struct {
+	CalendarPath string            `json:"calendarPath"`           // 日历路径
+	DateKeyID    string            `json:"dateKeyID"`              // 日期字段 ID
+	NotesKeyID   string            `json:"notesKeyID,omitempty"`   // 备注文本字段 ID，为空时不同步备注
+	UIDs         map[string]string `json:"uids,omitempty"`         // 日历客户端创建的事件 UID 和项目 ID 的映射
+	ExportedUIDs []string          `json:"exportedUIDs,omitempty"` // 上次导出的事件 UID
+}
+
+// GetItemID 返回事件 UID 对应的项目 ID，没有映射时 UID 即项目 ID。
+func (binding *CalendarBinding) GetItemID(uid string) string {
+	if itemID := binding.UIDs[uid]; "" != itemID {
+		return itemID
+	}
+	return uid
+}
+
+// IsManagedUID 判断事件是否由绑定管理，即导出过或者映射到了项目。
+func (binding *CalendarBinding) IsManagedUID(uid string) bool {
+	if _, ok := binding.UIDs[uid]; ok {
+		return true
+	}
+	for _, exportedUID := range binding.ExportedUIDs {
+		if exportedUID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUID 返回项目对应的事件 UID。
+func (binding *CalendarBinding) GetUID(itemID string) string {
+	for uid, id := range binding.UIDs {
+		if id == itemID {
+			return uid
+		}
+	}
+	return itemID
+}
@@ -0,0 +1,277 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package av
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// KeyConstraint 描述了字段的校验规则，修改单元格时校验，不满足时拒绝修改。
+type KeyConstraint struct {
+	Required      bool     `json:"required,omitempty"`      // 必填
+	Unique        bool     `json:"unique,omitempty"`        // 在数据库内唯一，空值不参与比较
+	Min           *float64 `json:"min,omitempty"`           // 数字的最小值，日期时为毫秒时间戳
+	Max           *float64 `json:"max,omitempty"`           // 数字的最大值，日期时为毫秒时间戳
+	Pattern       string   `json:"pattern,omitempty"`       // 文本、链接、邮箱和电话需要匹配的正则表达式
+	Format        bool     `json:"format,omitempty"`        // 校验链接、邮箱和电话的格式
+	MaxSelections int      `json:"maxSelections,omitempty"` // 多选最多可以选择的选项数
+}
+
+// ConstraintRule 描述了字段校验规则的类型。
+type ConstraintRule string
+
+const (
+	ConstraintRuleRequired      ConstraintRule = "required"
+	ConstraintRuleUnique        ConstraintRule = "unique"
+	ConstraintRuleMin           ConstraintRule = "min"
+	ConstraintRuleMax           ConstraintRule = "max"
+	ConstraintRulePattern       ConstraintRule = "pattern"
+	ConstraintRuleMaxSelections ConstraintRule = "maxSelections"
+	ConstraintRuleFormat        ConstraintRule = "format"
+)
+
+// ConstraintViolation 描述了不满足字段校验规则的值。
+type ConstraintViolation struct {
+	ItemID  string         `json:"itemID"`  // 项目 ID
+	KeyID   string         `json:"keyID"`   // 字段 ID
+	KeyName string         `json:"keyName"` // 字段名
+	Rule    ConstraintRule `json:"rule"`    // 不满足的规则
+	Value   string         `json:"value"`   // 值
+}
+
+func (violation *ConstraintViolation) Error() string {
+	switch violation.Rule {
+	case ConstraintRuleRequired:
+		return fmt.Sprintf("field [%s] is required", violation.KeyName)
+	case ConstraintRuleUnique:
+		return fmt.Sprintf("field [%s] value [%s] already exists", violation.KeyName, violation.Value)
+	case ConstraintRuleFormat:
+		return fmt.Sprintf("field [%s] value [%s] is malformed", violation.KeyName, violation.Value)
+	}
+	return fmt.Sprintf("field [%s] value [%s] violates rule [%s]", violation.KeyName, violation.Value, violation.Rule)
+}
+
+// IsEmpty 判断是否没有设置任何规则。
+func (constraint *KeyConstraint) IsEmpty() bool {
+	return !constraint.Required && !constraint.Unique && nil == constraint.Min && nil == constraint.Max && "" == constraint.Pattern && !constraint.Format && 1 > constraint.MaxSelections
+}
+
+// CheckKeyConstraint 检查规则是否适用于字段类型以及规则本身是否合法。
+func CheckKeyConstraint(key *Key, constraint *KeyConstraint) error {
+	switch key.Type {
+	case KeyTypeCreated, KeyTypeUpdated, KeyTypeLineNumber, KeyTypeRollup, KeyTypeFormula, KeyTypeTemplate:
+		return fmt.Errorf("field [%s] does not support constraints", key.Name)
+	}
+
+	if constraint.Unique {
+		switch key.Type {
+		case KeyTypeBlock, KeyTypeText, KeyTypeNumber, KeyTypeDate, KeyTypeURL, KeyTypeEmail, KeyTypePhone, KeyTypeSelect:
+		default:
+			return fmt.Errorf("field [%s] does not support unique constraint", key.Name)
+		}
+	}
+	if nil != constraint.Min || nil != constraint.Max {
+		if KeyTypeNumber != key.Type && KeyTypeDate != key.Type {
+			return fmt.Errorf("field [%s] does not support range constraint", key.Name)
+		}
+		if nil != constraint.Min && nil != constraint.Max && *constraint.Min > *constraint.Max {
+			return fmt.Errorf("field [%s] min is greater than max", key.Name)
+		}
+	}
+	if "" != constraint.Pattern {
+		switch key.Type {
+		case KeyTypeText, KeyTypeURL, KeyTypeEmail, KeyTypePhone:
+		default:
+			return fmt.Errorf("field [%s] does not support pattern constraint", key.Name)
+		}
+		if _, err := compileConstraintPattern(constraint.Pattern); nil != err {
+			return fmt.Errorf("field [%s] pattern is invalid: %s", key.Name, err)
+		}
+	}
+	if constraint.Format && KeyTypeURL != key.Type && KeyTypeEmail != key.Type && KeyTypePhone != key.Type {
+		return fmt.Errorf("field [%s] does not support format constraint", key.Name)
+	}
+	if 0 < constraint.MaxSelections && KeyTypeMSelect != key.Type {
+		return fmt.Errorf("field [%s] does not support max selections constraint", key.Name)
+	}
+	return nil
+}
+
+// CheckValueConstraint 校验值是否满足字段的规则，唯一约束需要结合数据库中的其他值判断。
+func CheckValueConstraint(attrView *AttributeView, key *Key, itemID string, value *Value) (ret *ConstraintViolation) {
+	constraint := key.Constraint
+	if nil == constraint {
+		return
+	}
+
+	newViolation := func(rule ConstraintRule) *ConstraintViolation {
+		ret := &ConstraintViolation{ItemID: itemID, KeyID: key.ID, KeyName: key.Name, Rule: rule}
+		if nil != value {
+			ret.Value = value.String(false)
+		}
+		return ret
+	}
+
+	if isConstraintValueBlank(value) {
+		if constraint.Required {
+			return newViolation(ConstraintRuleRequired)
+		}
+		return
+	}
+
+	switch key.Type {
+	case KeyTypeNumber:
+		if nil != value.Number && value.Number.IsNotEmpty {
+			if nil != constraint.Min && value.Number.Content < *constraint.Min {
+				return newViolation(ConstraintRuleMin)
+			}
+			if nil != constraint.Max && value.Number.Content > *constraint.Max {
+				return newViolation(ConstraintRuleMax)
+			}
+		}
+	case KeyTypeDate:
+		if nil != value.Date && value.Date.IsNotEmpty {
+			dates := []int64{value.Date.Content}
+			if value.Date.HasEndDate && value.Date.IsNotEmpty2 {
+				dates = append(dates, value.Date.Content2)
+			}
+			for _, date := range dates {
+				if nil != constraint.Min && float64(date) < *constraint.Min {
+					return newViolation(ConstraintRuleMin)
+				}
+				if nil != constraint.Max && float64(date) > *constraint.Max {
+					return newViolation(ConstraintRuleMax)
+				}
+			}
+		}
+	case KeyTypeText, KeyTypeURL, KeyTypeEmail, KeyTypePhone:
+		if constraint.Format && !isValidValueFormat(value) {
+			return newViolation(ConstraintRuleFormat)
+		}
+		if "" != constraint.Pattern {
+			if re, err := compileConstraintPattern(constraint.Pattern); nil == err && !re.MatchString(value.String(false)) {
+				return newViolation(ConstraintRulePattern)
+			}
+		}
+	case KeyTypeMSelect:
+		if 0 < constraint.MaxSelections && constraint.MaxSelections < len(value.MSelect) {
+			return newViolation(ConstraintRuleMaxSelections)
+		}
+	}
+
+	if constraint.Unique {
+		content := strings.TrimSpace(value.String(false))
+		keyValues, _ := attrView.GetKeyValues(key.ID)
+		if nil != keyValues {
+			for _, v := range keyValues.Values {
+				if v.BlockID == itemID || isConstraintValueBlank(v) {
+					continue
+				}
+				if content == strings.TrimSpace(v.String(false)) {
+					return newViolation(ConstraintRuleUnique)
+				}
+			}
+		}
+	}
+	return
+}
+
+// isConstraintValueBlank 判断值是否为空，绑定块的主键使用动态锚文本时内容可能为空，此时不认为是空值。
+func isConstraintValueBlank(value *Value) bool {
+	if nil == value {
+		return true
+	}
+	if KeyTypeBlock == value.Type && !value.IsDetached && nil != value.Block && "" != value.Block.ID {
+		return false
+	}
+	return value.IsBlank()
+}
+
+var (
+	emailFormatPattern = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@.]+$`)
+	phoneFormatPattern = regexp.MustCompile(`^\+?[0-9][0-9 ()\-.]*[0-9]$`)
+)
+
+// isValidValueFormat 校验链接、邮箱和电话的格式，其他类型的值总是合法。
+func isValidValueFormat(value *Value) bool {
+	switch value.Type {
+	case KeyTypeURL:
+		if nil == value.URL {
+			return true
+		}
+		content := strings.TrimSpace(value.URL.Content)
+		if strings.ContainsAny(content, " \t\r\n") {
+			return false
+		}
+		u, err := url.Parse(content)
+		if nil != err {
+			return false
+		}
+		if "" == u.Scheme {
+			// 没有协议头时按照 https 处理，例如 www.example.com
+			if u, err = url.Parse("https://" + content); nil != err {
+				return false
+			}
+			return strings.Contains(u.Hostname(), ".")
+		}
+		return "" != u.Host || "" != u.Opaque || "" != u.Path
+	case KeyTypeEmail:
+		if nil == value.Email {
+			return true
+		}
+		return emailFormatPattern.MatchString(strings.TrimSpace(value.Email.Content))
+	case KeyTypePhone:
+		if nil == value.Phone {
+			return true
+		}
+		content := strings.TrimSpace(value.Phone.Content)
+		if !phoneFormatPattern.MatchString(content) {
+			return false
+		}
+		digits := 0
+		for _, r := range content {
+			if '0' <= r && r <= '9' {
+				digits++
+			}
+		}
+		return 3 <= digits && digits <= 20
+	}
+	return true
+}
+
+var (
+	constraintPatterns     = map[string]*regexp.Regexp{}
+	constraintPatternsLock = sync.Mutex{}
+)
+
+func compileConstraintPattern(pattern string) (ret *regexp.Regexp, err error) {
+	constraintPatternsLock.Lock()
+	defer constraintPatternsLock.Unlock()
+
+	if ret = constraintPatterns[pattern]; nil != ret {
+		return
+	}
+	if ret, err = regexp.Compile(pattern); nil != err {
+		return
+	}
+	constraintPatterns[pattern] = ret
+	return
+}
@@ -17,6 +17,8 @@
 package av
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
 	"strings"
 	"time"
@@ -26,6 +28,8 @@ import (
 )
 
 // ViewFilter 描述了视图过滤规则的结构。
+// Conjunction 不为空时表示过滤组，过滤组包含的过滤规则或者子过滤组按 Conjunction 组合，此时 Column 等其他字段不使用。
+// 视图上的顶层过滤规则按 AND 组合，旧版本会忽略过滤组（没有字段和过滤值的过滤规则不过滤）。
 type ViewFilter struct {
 	Column        string           `json:"column"`                  // 字段（列）ID
 	Qualifier     FilterQuantifier `json:"quantifier,omitempty"`    // 量词
@@ -33,6 +37,122 @@ type ViewFilter struct {
 	Value         *Value           `json:"value"`                   // 过滤值
 	RelativeDate  *RelativeDate    `json:"relativeDate,omitempty"`  // 相对时间
 	RelativeDate2 *RelativeDate    `json:"relativeDate2,omitempty"` // 第二个相对时间，用于某些操作符，比如 FilterOperatorIsBetween
+
+	Conjunction FilterConjunction `json:"conjunction,omitempty"` // 过滤组的逻辑连接词
+	Filters     []*ViewFilter     `json:"filters,omitempty"`     // 过滤组包含的过滤规则或者子过滤组
+}
+
+// FilterConjunction 描述了过滤组中过滤规则的组合方式。
+type FilterConjunction string
+
+const (
+	FilterConjunctionAnd FilterConjunction = "and" // 满足所有过滤规则
+	FilterConjunctionOr  FilterConjunction = "or"  // 满足任意一个过滤规则
+)
+
+// IsGroup 判断是否是过滤组。
+func (filter *ViewFilter) IsGroup() bool {
+	return "" != filter.Conjunction
+}
+
+// WalkFilters 遍历过滤规则树中的所有过滤规则（不包括过滤组）。
+func WalkFilters(filters []*ViewFilter, fn func(filter *ViewFilter)) {
+	for _, filter := range filters {
+		if filter.IsGroup() {
+			WalkFilters(filter.Filters, fn)
+			continue
+		}
+		fn(filter)
+	}
+}
+
+// RemoveFilters 从过滤规则树中删除 remove 返回 true 的过滤规则，删除后变为空的过滤组也会一并删除。
+func RemoveFilters(filters []*ViewFilter, remove func(filter *ViewFilter) bool) (ret []*ViewFilter, removed bool) {
+	ret = []*ViewFilter{}
+	for _, filter := range filters {
+		if filter.IsGroup() {
+			if 1 > len(filter.Filters) {
+				ret = append(ret, filter)
+				continue
+			}
+
+			var subRemoved bool
+			filter.Filters, subRemoved = RemoveFilters(filter.Filters, remove)
+			removed = removed || subRemoved
+			if 1 > len(filter.Filters) {
+				continue
+			}
+			ret = append(ret, filter)
+			continue
+		}
+
+		if remove(filter) {
+			removed = true
+			continue
+		}
+		ret = append(ret, filter)
+	}
+	return
+}
+
+// GetRequiredFilters 返回项目必须满足的过滤规则，即顶层和 AND 过滤组中的过滤规则，OR 过滤组中的过滤规则不一定需要满足。
+func GetRequiredFilters(filters []*ViewFilter) (ret []*ViewFilter) {
+	for _, filter := range filters {
+		if !filter.IsGroup() {
+			ret = append(ret, filter)
+			continue
+		}
+
+		if FilterConjunctionAnd == filter.Conjunction || 1 == len(filter.Filters) {
+			ret = append(ret, GetRequiredFilters(filter.Filters)...)
+		}
+	}
+	return
+}
+
+// CloneFilters 复制过滤规则树，过滤值不深拷贝。
+func CloneFilters(filters []*ViewFilter) (ret []*ViewFilter) {
+	ret = []*ViewFilter{}
+	for _, filter := range filters {
+		clone := &ViewFilter{
+			Column:        filter.Column,
+			Qualifier:     filter.Qualifier,
+			Operator:      filter.Operator,
+			Value:         filter.Value,
+			RelativeDate:  filter.RelativeDate,
+			RelativeDate2: filter.RelativeDate2,
+			Conjunction:   filter.Conjunction,
+		}
+		if filter.IsGroup() {
+			clone.Filters = CloneFilters(filter.Filters)
+		}
+		ret = append(ret, clone)
+	}
+	return
+}
+
+// ValidateFilters 检查过滤规则树的结构是否合法。
+func ValidateFilters(filters []*ViewFilter) error {
+	for _, filter := range filters {
+		if nil == filter {
+			return errors.New("invalid filter")
+		}
+
+		if !filter.IsGroup() {
+			if 0 < len(filter.Filters) {
+				return errors.New("filter group requires a conjunction")
+			}
+			continue
+		}
+
+		if FilterConjunctionAnd != filter.Conjunction && FilterConjunctionOr != filter.Conjunction {
+			return fmt.Errorf("invalid filter conjunction [%s]", filter.Conjunction)
+		}
+		if err := ValidateFilters(filter.Filters); nil != err {
+			return err
+		}
+	}
+	return nil
 }
 
 type RelativeDateUnit int
@@ -94,47 +214,53 @@ func Filter(viewable Viewable, attrView *AttributeView, rollupFurtherCollections
 		return
 	}
 
-	var colIndexes []int
-	for _, f := range filters {
-		for i, c := range collection.GetFields() {
-			if c.GetID() == f.Column {
-				colIndexes = append(colIndexes, i)
-				break
-			}
-		}
+	fieldIndexes := map[string]int{}
+	for i, field := range collection.GetFields() {
+		fieldIndexes[field.GetID()] = i
 	}
 
 	var items []Item
 	for _, item := range collection.GetItems() {
-		pass := true
-		values := item.GetValues()
-		for j, index := range colIndexes {
-			operator := filters[j].Operator
-
-			if nil == values[index] {
-				if FilterOperatorIsNotEmpty == operator {
-					pass = false
-				} else if FilterOperatorIsEmpty == operator {
-					pass = true
-					break
-				}
+		if pass, _ := filterItem(item, filters, FilterConjunctionAnd, fieldIndexes, attrView, rollupFurtherCollections, cachedAttrViews); pass {
+			items = append(items, item)
+		}
+	}
+	collection.SetItems(items)
+}
 
-				if KeyTypeText != values[index].Type {
-					pass = false
-				}
-				break
+// filterItem 判断项目是否满足按 conjunction 组合的过滤规则。字段不存在的过滤规则和空的过滤组不参与过滤，
+// 没有参与过滤的规则时 applied 为 false，此时 pass 总是为 true。
+func filterItem(item Item, filters []*ViewFilter, conjunction FilterConjunction, fieldIndexes map[string]int, attrView *AttributeView, rollupFurtherCollections map[string]Collection, cachedAttrViews map[string]*AttributeView) (pass, applied bool) {
+	for _, filter := range filters {
+		var filterPass bool
+		if filter.IsGroup() {
+			var groupApplied bool
+			if filterPass, groupApplied = filterItem(item, filter.Filters, filter.Conjunction, fieldIndexes, attrView, rollupFurtherCollections, cachedAttrViews); !groupApplied {
+				continue
+			}
+		} else {
+			index, ok := fieldIndexes[filter.Column]
+			if !ok {
+				continue
 			}
 
-			if !values[index].Filter(filters[j], attrView, item.GetID(), rollupFurtherCollections, cachedAttrViews) {
-				pass = false
-				break
+			if value := item.GetValues()[index]; nil == value {
+				filterPass = FilterOperatorIsEmpty == filter.Operator
+			} else {
+				filterPass = value.Filter(filter, attrView, item.GetID(), rollupFurtherCollections, cachedAttrViews)
 			}
 		}
-		if pass {
-			items = append(items, item)
+
+		applied = true
+		if FilterConjunctionOr == conjunction {
+			if filterPass {
+				return true, true
+			}
+		} else if !filterPass {
+			return false, true
 		}
 	}
-	collection.SetItems(items)
+	return FilterConjunctionOr != conjunction || !applied, applied
 }
 
 func (value *Value) Filter(filter *ViewFilter, attrView *AttributeView, itemID string, rollupFurtherCollections map[string]Collection, cachedAttrViews map[string]*AttributeView) bool {
@@ -669,6 +795,25 @@ func (value *Value) filter(other *Value, relativeDate, relativeDate2 *RelativeDa
 				return !contains
 			}
 		}
+	case KeyTypeFormula:
+		if nil != value.Formula && nil != other && nil != other.Formula {
+			if value.Formula.Type != other.Formula.Type {
+				// 公式的结果类型被用户修改过导致和过滤规则值类型不匹配，该情况下不过滤
+				return true
+			}
+
+			// 表达式为空的公式字段没有结果，过滤规则值没有结果时不过滤，单元格没有结果时按空值处理
+			result, otherResult := value.GetFormulaResult(), other.GetFormulaResult()
+			if nil == otherResult {
+				return true
+			}
+			if nil == result {
+				return FilterOperatorIsNotEqual == operator || FilterOperatorDoesNotContain == operator
+			}
+
+			// 按结果类型过滤
+			return result.filter(otherResult, relativeDate, relativeDate2, operator)
+		}
 	case KeyTypeTemplate:
 		if nil != value.Template && nil != other && nil != other.Template {
 			switch operator {
@@ -1007,7 +1152,7 @@ func calcRelativeTimeRegion(count int, unit RelativeDateUnit, direction Relative
 
 func (filter *ViewFilter) GetAffectValue(key *Key, addingBlockID string) (ret *Value) {
 	if nil != filter.Value {
-		if KeyTypeRelation == filter.Value.Type || KeyTypeTemplate == filter.Value.Type || KeyTypeRollup == filter.Value.Type || KeyTypeUpdated == filter.Value.Type || KeyTypeCreated == filter.Value.Type {
+		if KeyTypeRelation == filter.Value.Type || KeyTypeTemplate == filter.Value.Type || KeyTypeFormula == filter.Value.Type || KeyTypeRollup == filter.Value.Type || KeyTypeUpdated == filter.Value.Type || KeyTypeCreated == filter.Value.Type {
 			// 所有生成的数据都不设置默认值
 			return nil
 		}
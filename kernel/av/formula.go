@@ -0,0 +1,981 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package av
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// FormulaResultType 描述了公式字段计算结果的类型。
+type FormulaResultType string
+
+const (
+	FormulaResultTypeNumber   FormulaResultType = "number"   // 数字
+	FormulaResultTypeText     FormulaResultType = "text"     // 文本
+	FormulaResultTypeDate     FormulaResultType = "date"     // 日期
+	FormulaResultTypeCheckbox FormulaResultType = "checkbox" // 复选框
+
+	formulaTypeAny FormulaResultType = "" // 仅用于函数参数，表示任意类型
+)
+
+// Formula 描述了公式字段的设置。
+type Formula struct {
+	Expr       string            `json:"expr"`       // 公式表达式，使用 prop("字段名") 引用其他字段
+	ResultType FormulaResultType `json:"resultType"` // 结果类型，设置公式时根据表达式推断
+}
+
+// ValueFormula 描述了公式字段的值，根据结果类型只会填充 Number、Text、Date 和 Checkbox 中的一个。
+type ValueFormula struct {
+	Type     FormulaResultType `json:"type"`               // 结果类型
+	Number   *ValueNumber      `json:"number,omitempty"`   // 数字结果
+	Text     *ValueText        `json:"text,omitempty"`     // 文本结果
+	Date     *ValueDate        `json:"date,omitempty"`     // 日期结果
+	Checkbox *ValueCheckbox    `json:"checkbox,omitempty"` // 复选框结果
+	Error    string            `json:"error,omitempty"`    // 计算出错时的错误信息
+}
+
+// GetFormulaResult 将公式字段的值转换为结果类型对应的值，这样过滤、排序和计算就可以复用对应类型的逻辑。
+func (value *Value) GetFormulaResult() (ret *Value) {
+	if nil == value || nil == value.Formula {
+		return nil
+	}
+
+	ret = &Value{ID: value.ID, KeyID: value.KeyID, BlockID: value.BlockID, CreatedAt: value.CreatedAt, UpdatedAt: value.UpdatedAt}
+	switch value.Formula.Type {
+	case FormulaResultTypeNumber:
+		ret.Type, ret.Number = KeyTypeNumber, value.Formula.Number
+		if nil == ret.Number {
+			ret.Number = &ValueNumber{}
+		}
+	case FormulaResultTypeText:
+		ret.Type, ret.Text = KeyTypeText, value.Formula.Text
+		if nil == ret.Text {
+			ret.Text = &ValueText{}
+		}
+	case FormulaResultTypeDate:
+		ret.Type, ret.Date = KeyTypeDate, value.Formula.Date
+		if nil == ret.Date {
+			ret.Date = &ValueDate{}
+		}
+	case FormulaResultTypeCheckbox:
+		ret.Type, ret.Checkbox = KeyTypeCheckbox, value.Formula.Checkbox
+		if nil == ret.Checkbox {
+			ret.Checkbox = &ValueCheckbox{}
+		}
+	default:
+		return nil
+	}
+	return
+}
+
+// CompiledFormula 描述了编译后的公式字段。
+type CompiledFormula struct {
+	Key          *Key              // 公式字段
+	ResultType   FormulaResultType // 结果类型
+	DependKeyIDs []string          // 直接引用的字段 ID
+
+	root *formulaNode
+}
+
+// CompileFormulas 编译属性视图中的所有公式字段，ret 按依赖关系排序，被引用的公式字段排在前面。
+// 表达式为空的公式字段会被忽略；解析失败、引用了不存在的字段、类型不匹配、存在循环引用或者引用了这些公式字段的
+// 公式字段不会出现在 ret 中，错误记录在 errs 中，键为字段 ID。
+func CompileFormulas(attrView *AttributeView) (ret []*CompiledFormula, errs map[string]error) {
+	errs = map[string]error{}
+	parsed := map[string]*CompiledFormula{}
+	var order []string
+	for _, kv := range attrView.KeyValues {
+		key := kv.Key
+		if KeyTypeFormula != key.Type || nil == key.Formula || "" == strings.TrimSpace(key.Formula.Expr) {
+			continue
+		}
+
+		root, err := parseFormula(key.Formula.Expr)
+		if nil != err {
+			errs[key.ID] = err
+			continue
+		}
+
+		compiled := &CompiledFormula{Key: key, root: root}
+		if err = compiled.resolveProps(attrView, root); nil != err {
+			errs[key.ID] = err
+			continue
+		}
+		parsed[key.ID] = compiled
+		order = append(order, key.ID)
+	}
+
+	// 按照引用关系拓扑排序，剩下没法排序的公式字段处于循环引用中或者依赖于循环引用
+	dependents := map[string][]string{}
+	inDegree := map[string]int{}
+	for _, id := range order {
+		for _, depID := range parsed[id].DependKeyIDs {
+			if nil != parsed[depID] {
+				dependents[depID] = append(dependents[depID], id)
+				inDegree[id]++
+			}
+		}
+	}
+	var queue, sorted []string
+	for _, id := range order {
+		if 0 == inDegree[id] {
+			queue = append(queue, id)
+		}
+	}
+	for 0 < len(queue) {
+		id := queue[0]
+		queue = queue[1:]
+		sorted = append(sorted, id)
+		for _, dependentID := range dependents[id] {
+			if inDegree[dependentID]--; 0 == inDegree[dependentID] {
+				queue = append(queue, dependentID)
+			}
+		}
+	}
+	for _, id := range order {
+		if 0 < inDegree[id] {
+			errs[id] = fmt.Errorf("circular reference in formula field [%s]", parsed[id].Key.Name)
+		}
+	}
+
+	compiled := map[string]*CompiledFormula{}
+	for _, id := range sorted {
+		f := parsed[id]
+		if err := f.check(attrView, f.root, compiled, errs); nil != err {
+			errs[id] = err
+			continue
+		}
+		f.ResultType = f.root.typ
+		compiled[id] = f
+		ret = append(ret, f)
+	}
+	return
+}
+
+// CompileFormula 编译属性视图中的某个公式字段。
+func CompileFormula(attrView *AttributeView, keyID string) (ret *CompiledFormula, err error) {
+	formulas, errs := CompileFormulas(attrView)
+	if err = errs[keyID]; nil != err {
+		return
+	}
+	for _, f := range formulas {
+		if f.Key.ID == keyID {
+			return f, nil
+		}
+	}
+	return nil, errors.New("empty formula")
+}
+
+func (f *CompiledFormula) resolveProps(attrView *AttributeView, node *formulaNode) error {
+	if formulaNodeProp == node.kind {
+		var key *Key
+		for _, kv := range attrView.KeyValues {
+			if kv.Key.Name == node.name {
+				key = kv.Key
+				break
+			}
+		}
+		if nil == key {
+			return fmt.Errorf("field [%s] not found", node.name)
+		}
+		if key.ID == f.Key.ID {
+			return fmt.Errorf("circular reference in formula field [%s]", f.Key.Name)
+		}
+
+		node.keyID = key.ID
+		for _, id := range f.DependKeyIDs {
+			if id == key.ID {
+				return nil
+			}
+		}
+		f.DependKeyIDs = append(f.DependKeyIDs, key.ID)
+		return nil
+	}
+
+	for _, arg := range node.args {
+		if err := f.resolveProps(attrView, arg); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// formulaPropType 返回字段被公式引用时的类型。
+func formulaPropType(key *Key) FormulaResultType {
+	switch key.Type {
+	case KeyTypeNumber:
+		return FormulaResultTypeNumber
+	case KeyTypeDate, KeyTypeCreated, KeyTypeUpdated:
+		return FormulaResultTypeDate
+	case KeyTypeCheckbox:
+		return FormulaResultTypeCheckbox
+	case KeyTypeRollup:
+		if nil == key.Rollup || nil == key.Rollup.Calc {
+			return FormulaResultTypeText
+		}
+		switch key.Rollup.Calc.Operator {
+		case CalcOperatorCountAll, CalcOperatorCountValues, CalcOperatorCountUniqueValues, CalcOperatorCountEmpty, CalcOperatorCountNotEmpty,
+			CalcOperatorPercentEmpty, CalcOperatorPercentNotEmpty, CalcOperatorPercentUniqueValues, CalcOperatorSum, CalcOperatorAverage,
+			CalcOperatorMedian, CalcOperatorMin, CalcOperatorMax, CalcOperatorRange, CalcOperatorChecked, CalcOperatorUnchecked,
+			CalcOperatorPercentChecked, CalcOperatorPercentUnchecked:
+			return FormulaResultTypeNumber
+		case CalcOperatorEarliest, CalcOperatorLatest:
+			return FormulaResultTypeDate
+		}
+	}
+	return FormulaResultTypeText
+}
+
+// formulaFunc 描述了公式内置函数的签名和实现。
+type formulaFunc struct {
+	args     []FormulaResultType // 参数类型
+	optional int                 // 末尾可选参数的个数
+	variadic bool                // 最后一个参数是否可以重复
+	ret      FormulaResultType   // 返回值类型
+	call     func(args []formulaValue, now time.Time) (formulaValue, error)
+}
+
+func (f *CompiledFormula) check(attrView *AttributeView, node *formulaNode, compiled map[string]*CompiledFormula, errs map[string]error) (err error) {
+	for _, arg := range node.args {
+		if err = f.check(attrView, arg, compiled, errs); nil != err {
+			return
+		}
+	}
+
+	switch node.kind {
+	case formulaNodeLiteral:
+		node.typ = node.lit.typ
+	case formulaNodeProp:
+		key, _ := attrView.GetKey(node.keyID)
+		if KeyTypeFormula == key.Type {
+			dep := compiled[key.ID]
+			if nil == dep {
+				if _, invalid := errs[key.ID]; invalid || (nil != key.Formula && "" != strings.TrimSpace(key.Formula.Expr)) {
+					return fmt.Errorf("formula field [%s] is invalid", key.Name)
+				}
+				node.typ = FormulaResultTypeText // 表达式为空的公式字段
+				return
+			}
+			node.typ = dep.ResultType
+			return
+		}
+		node.typ = formulaPropType(key)
+	case formulaNodeUnary:
+		operand := node.args[0].typ
+		switch node.op {
+		case "-":
+			if FormulaResultTypeNumber != operand {
+				return fmt.Errorf("operator [-] requires a number at %d", node.pos)
+			}
+			node.typ = FormulaResultTypeNumber
+		case "!":
+			if FormulaResultTypeCheckbox != operand {
+				return fmt.Errorf("operator [not] requires a checkbox at %d", node.pos)
+			}
+			node.typ = FormulaResultTypeCheckbox
+		}
+	case formulaNodeBinary:
+		left, right := node.args[0].typ, node.args[1].typ
+		switch node.op {
+		case "+":
+			if FormulaResultTypeNumber == left && FormulaResultTypeNumber == right {
+				node.typ = FormulaResultTypeNumber
+			} else if FormulaResultTypeText == left || FormulaResultTypeText == right {
+				node.typ = FormulaResultTypeText
+			} else {
+				return fmt.Errorf("operator [+] can not be applied to %s and %s at %d", left, right, node.pos)
+			}
+		case "-", "*", "/", "%":
+			if FormulaResultTypeNumber != left || FormulaResultTypeNumber != right {
+				return fmt.Errorf("operator [%s] requires numbers at %d", node.op, node.pos)
+			}
+			node.typ = FormulaResultTypeNumber
+		case "==", "!=":
+			if left != right {
+				return fmt.Errorf("can not compare %s with %s at %d", left, right, node.pos)
+			}
+			node.typ = FormulaResultTypeCheckbox
+		case "<", "<=", ">", ">=":
+			if left != right || FormulaResultTypeCheckbox == left {
+				return fmt.Errorf("can not compare %s with %s at %d", left, right, node.pos)
+			}
+			node.typ = FormulaResultTypeCheckbox
+		case "&&", "||":
+			if FormulaResultTypeCheckbox != left || FormulaResultTypeCheckbox != right {
+				return fmt.Errorf("operator [%s] requires checkboxes at %d", node.op, node.pos)
+			}
+			node.typ = FormulaResultTypeCheckbox
+		}
+	case formulaNodeCall:
+		if "if" == node.name {
+			if 3 != len(node.args) {
+				return fmt.Errorf("function [if] requires 3 arguments at %d", node.pos)
+			}
+			if FormulaResultTypeCheckbox != node.args[0].typ {
+				return fmt.Errorf("the condition of function [if] must be a checkbox at %d", node.pos)
+			}
+			if node.args[1].typ != node.args[2].typ {
+				return fmt.Errorf("the branches of function [if] must have the same type at %d", node.pos)
+			}
+			node.typ = node.args[1].typ
+			return
+		}
+
+		fn := formulaFuncs[node.name]
+		if nil == fn {
+			return fmt.Errorf("unknown function [%s] at %d", node.name, node.pos)
+		}
+		if len(node.args) < len(fn.args)-fn.optional || (!fn.variadic && len(node.args) > len(fn.args)) {
+			return fmt.Errorf("wrong number of arguments for function [%s] at %d", node.name, node.pos)
+		}
+		for i, arg := range node.args {
+			want := fn.args[min(i, len(fn.args)-1)]
+			if formulaTypeAny != want && want != arg.typ {
+				return fmt.Errorf("argument %d of function [%s] must be %s at %d", i+1, node.name, want, node.pos)
+			}
+		}
+		node.typ = fn.ret
+	}
+	return
+}
+
+// formulaValue 描述了公式计算过程中的值。
+type formulaValue struct {
+	typ       FormulaResultType
+	empty     bool    // 数字和日期是否为空，文本为空时 str 为空字符串，复选框不会为空
+	num       float64 // 数字
+	str       string  // 文本
+	date      int64   // 日期毫秒时间戳
+	date2     int64   // 结束日期毫秒时间戳
+	hasEnd    bool    // 是否有结束日期
+	isNotTime bool    // 日期是否不包含时间
+	b         bool    // 复选框
+}
+
+func (v formulaValue) isEmpty() bool {
+	switch v.typ {
+	case FormulaResultTypeText:
+		return "" == v.str
+	case FormulaResultTypeCheckbox:
+		return false
+	}
+	return v.empty
+}
+
+func (v formulaValue) String() string {
+	if v.isEmpty() {
+		return ""
+	}
+
+	switch v.typ {
+	case FormulaResultTypeNumber:
+		return strconv.FormatFloat(v.num, 'f', -1, 64)
+	case FormulaResultTypeDate:
+		return NewFormattedValueDate(v.date, v.date2, DateFormatNone, v.isNotTime, v.hasEnd).FormattedContent
+	case FormulaResultTypeCheckbox:
+		return strconv.FormatBool(v.b)
+	}
+	return v.str
+}
+
+func formulaEmpty(typ FormulaResultType) formulaValue {
+	return formulaValue{typ: typ, empty: true}
+}
+
+func formulaNumber(n float64) (formulaValue, error) {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return formulaEmpty(FormulaResultTypeNumber), errors.New("number out of range")
+	}
+	return formulaValue{typ: FormulaResultTypeNumber, num: n}, nil
+}
+
+func formulaDate(t time.Time, isNotTime bool) formulaValue {
+	return formulaValue{typ: FormulaResultTypeDate, date: t.UnixMilli(), isNotTime: isNotTime}
+}
+
+// Eval 计算公式在某个项目上的结果，getValue 返回该项目指定字段的值，公式字段的值需要已经计算过。
+func (f *CompiledFormula) Eval(getValue func(keyID string) *Value) (ret *ValueFormula) {
+	ret = &ValueFormula{Type: f.ResultType}
+	result, err := f.eval(f.root, getValue, time.Now())
+	if nil != err {
+		ret.Error = err.Error()
+		result = formulaEmpty(f.ResultType)
+	}
+
+	switch f.ResultType {
+	case FormulaResultTypeNumber:
+		ret.Number = &ValueNumber{}
+		if !result.isEmpty() {
+			ret.Number = NewFormattedValueNumber(result.num, f.Key.NumberFormat)
+		}
+	case FormulaResultTypeText:
+		ret.Text = &ValueText{Content: result.str}
+	case FormulaResultTypeDate:
+		ret.Date = &ValueDate{IsNotTime: result.isNotTime}
+		if !result.isEmpty() {
+			ret.Date = NewFormattedValueDate(result.date, result.date2, DateFormatNone, result.isNotTime, result.hasEnd)
+		}
+	case FormulaResultTypeCheckbox:
+		ret.Checkbox = &ValueCheckbox{Checked: result.b}
+	}
+	return
+}
+
+func (f *CompiledFormula) eval(node *formulaNode, getValue func(keyID string) *Value, now time.Time) (ret formulaValue, err error) {
+	switch node.kind {
+	case formulaNodeLiteral:
+		return node.lit, nil
+	case formulaNodeProp:
+		return formulaPropValue(getValue(node.keyID), node.typ), nil
+	case formulaNodeUnary:
+		operand, operandErr := f.eval(node.args[0], getValue, now)
+		if nil != operandErr {
+			return operand, operandErr
+		}
+		if "!" == node.op {
+			return formulaValue{typ: FormulaResultTypeCheckbox, b: !operand.b}, nil
+		}
+		if operand.isEmpty() {
+			return operand, nil
+		}
+		return formulaNumber(-operand.num)
+	case formulaNodeBinary:
+		left, leftErr := f.eval(node.args[0], getValue, now)
+		if nil != leftErr {
+			return left, leftErr
+		}
+
+		// 逻辑运算短路求值
+		switch node.op {
+		case "&&":
+			if !left.b {
+				return left, nil
+			}
+			return f.eval(node.args[1], getValue, now)
+		case "||":
+			if left.b {
+				return left, nil
+			}
+			return f.eval(node.args[1], getValue, now)
+		}
+
+		right, rightErr := f.eval(node.args[1], getValue, now)
+		if nil != rightErr {
+			return right, rightErr
+		}
+		return evalFormulaBinary(node, left, right)
+	case formulaNodeCall:
+		if "if" == node.name {
+			cond, condErr := f.eval(node.args[0], getValue, now)
+			if nil != condErr {
+				return cond, condErr
+			}
+			if cond.b {
+				return f.eval(node.args[1], getValue, now)
+			}
+			return f.eval(node.args[2], getValue, now)
+		}
+
+		var args []formulaValue
+		for _, argNode := range node.args {
+			arg, argErr := f.eval(argNode, getValue, now)
+			if nil != argErr {
+				return arg, argErr
+			}
+			args = append(args, arg)
+		}
+		ret, err = formulaFuncs[node.name].call(args, now)
+		if nil != err {
+			err = fmt.Errorf("function [%s]: %s", node.name, err)
+		}
+		return
+	}
+	return formulaEmpty(node.typ), nil
+}
+
+func evalFormulaBinary(node *formulaNode, left, right formulaValue) (formulaValue, error) {
+	switch node.op {
+	case "+":
+		if FormulaResultTypeText == node.typ {
+			return formulaValue{typ: FormulaResultTypeText, str: left.String() + right.String()}, nil
+		}
+		if left.isEmpty() || right.isEmpty() {
+			return formulaEmpty(FormulaResultTypeNumber), nil
+		}
+		return formulaNumber(left.num + right.num)
+	case "-", "*", "/", "%":
+		if left.isEmpty() || right.isEmpty() {
+			return formulaEmpty(FormulaResultTypeNumber), nil
+		}
+		switch node.op {
+		case "-":
+			return formulaNumber(left.num - right.num)
+		case "*":
+			return formulaNumber(left.num * right.num)
+		}
+		if 0 == right.num {
+			return formulaEmpty(FormulaResultTypeNumber), errors.New("division by zero")
+		}
+		if "/" == node.op {
+			return formulaNumber(left.num / right.num)
+		}
+		return formulaNumber(math.Mod(left.num, right.num))
+	}
+
+	// 比较运算，空值只和空值相等，和其他值比较大小总是不成立
+	ret := formulaValue{typ: FormulaResultTypeCheckbox}
+	if left.isEmpty() || right.isEmpty() {
+		switch node.op {
+		case "==":
+			ret.b = left.isEmpty() && right.isEmpty()
+		case "!=":
+			ret.b = left.isEmpty() != right.isEmpty()
+		}
+		return ret, nil
+	}
+
+	var cmp int
+	switch left.typ {
+	case FormulaResultTypeNumber:
+		cmp = compareFormulaOrdered(left.num, right.num)
+	case FormulaResultTypeText:
+		cmp = strings.Compare(left.str, right.str)
+	case FormulaResultTypeDate:
+		cmp = compareFormulaOrdered(left.date, right.date)
+	case FormulaResultTypeCheckbox:
+		if left.b != right.b {
+			cmp = 1
+		}
+	}
+	switch node.op {
+	case "==":
+		ret.b = 0 == cmp
+	case "!=":
+		ret.b = 0 != cmp
+	case "<":
+		ret.b = 0 > cmp
+	case "<=":
+		ret.b = 0 >= cmp
+	case ">":
+		ret.b = 0 < cmp
+	case ">=":
+		ret.b = 0 <= cmp
+	}
+	return ret, nil
+}
+
+func compareFormulaOrdered[T int64 | float64](a, b T) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// formulaPropValue 将字段值转换为公式中的值，typ 为字段被引用时的类型。
+func formulaPropValue(value *Value, typ FormulaResultType) (ret formulaValue) {
+	ret = formulaEmpty(typ)
+	if nil == value {
+		return
+	}
+
+	if KeyTypeFormula == value.Type {
+		value = value.GetFormulaResult()
+		if nil == value {
+			return
+		}
+	}
+
+	if KeyTypeRollup == value.Type {
+		if nil == value.Rollup || 1 > len(value.Rollup.Contents) {
+			return
+		}
+		if FormulaResultTypeText != typ {
+			// 汇总计算结果只有一个值
+			return formulaPropValue(value.Rollup.Contents[0], typ)
+		}
+		ret.str = value.String(true)
+		return
+	}
+
+	switch typ {
+	case FormulaResultTypeNumber:
+		if nil != value.Number && value.Number.IsNotEmpty {
+			ret.num, ret.empty = value.Number.Content, false
+		} else if KeyTypeNumber != value.Type {
+			if n, ok := util.Convert2Float(value.String(false)); ok {
+				ret.num, ret.empty = n, false
+			}
+		}
+	case FormulaResultTypeDate:
+		switch {
+		case nil != value.Date && value.Date.IsNotEmpty:
+			ret.date, ret.isNotTime, ret.empty = value.Date.Content, value.Date.IsNotTime, false
+			if value.Date.HasEndDate && value.Date.IsNotEmpty2 {
+				ret.date2, ret.hasEnd = value.Date.Content2, true
+			}
+		case nil != value.Created && 0 < value.Created.Content:
+			ret.date, ret.empty = value.Created.Content, false
+		case nil != value.Updated && 0 < value.Updated.Content:
+			ret.date, ret.empty = value.Updated.Content, false
+		}
+	case FormulaResultTypeCheckbox:
+		ret.b = nil != value.Checkbox && value.Checkbox.Checked
+	default:
+		if KeyTypeCheckbox == value.Type {
+			ret.str = strconv.FormatBool(nil != value.Checkbox && value.Checkbox.Checked)
+			return
+		}
+		ret.str = value.String(true)
+	}
+	return
+}
+
+var formulaFuncs map[string]*formulaFunc
+
+func init() {
+	num, text, date, checkbox, anyType := FormulaResultTypeNumber, FormulaResultTypeText, FormulaResultTypeDate, FormulaResultTypeCheckbox, formulaTypeAny
+
+	// 数字参数为空时结果也为空
+	unaryNumber := func(fn func(float64) float64) *formulaFunc {
+		return &formulaFunc{args: []FormulaResultType{num}, ret: num, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			if args[0].isEmpty() {
+				return args[0], nil
+			}
+			return formulaNumber(fn(args[0].num))
+		}}
+	}
+	dateNumber := func(fn func(time.Time) float64) *formulaFunc {
+		return &formulaFunc{args: []FormulaResultType{date}, ret: num, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			if args[0].isEmpty() {
+				return formulaEmpty(FormulaResultTypeNumber), nil
+			}
+			return formulaNumber(fn(time.UnixMilli(args[0].date)))
+		}}
+	}
+	textText := func(fn func(string) string) *formulaFunc {
+		return &formulaFunc{args: []FormulaResultType{text}, ret: text, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			return formulaValue{typ: FormulaResultTypeText, str: fn(args[0].str)}, nil
+		}}
+	}
+	textCheckbox := func(fn func(s, sub string) bool) *formulaFunc {
+		return &formulaFunc{args: []FormulaResultType{text, text}, ret: checkbox, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			return formulaValue{typ: FormulaResultTypeCheckbox, b: fn(args[0].str, args[1].str)}, nil
+		}}
+	}
+	extremum := func(less func(a, b float64) bool) *formulaFunc {
+		return &formulaFunc{args: []FormulaResultType{num}, variadic: true, ret: num, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			ret := formulaEmpty(FormulaResultTypeNumber)
+			for _, arg := range args {
+				if !arg.isEmpty() && (ret.isEmpty() || less(arg.num, ret.num)) {
+					ret = arg
+				}
+			}
+			return ret, nil
+		}}
+	}
+	shiftDate := func(sign int) *formulaFunc {
+		return &formulaFunc{args: []FormulaResultType{date, num, text}, ret: date, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			if args[0].isEmpty() || args[1].isEmpty() {
+				return formulaEmpty(FormulaResultTypeDate), nil
+			}
+			ret := args[0]
+			var err error
+			if ret.date, err = addFormulaDate(ret.date, float64(sign)*args[1].num, args[2].str); nil != err {
+				return formulaEmpty(FormulaResultTypeDate), err
+			}
+			if ret.hasEnd {
+				if ret.date2, err = addFormulaDate(ret.date2, float64(sign)*args[1].num, args[2].str); nil != err {
+					return formulaEmpty(FormulaResultTypeDate), err
+				}
+			}
+			return ret, nil
+		}}
+	}
+
+	formulaFuncs = map[string]*formulaFunc{
+		// 数字
+		"abs":   unaryNumber(math.Abs),
+		"floor": unaryNumber(math.Floor),
+		"ceil":  unaryNumber(math.Ceil),
+		"sqrt": {args: []FormulaResultType{num}, ret: num, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			if args[0].isEmpty() {
+				return args[0], nil
+			}
+			if 0 > args[0].num {
+				return formulaEmpty(FormulaResultTypeNumber), errors.New("square root of a negative number")
+			}
+			return formulaNumber(math.Sqrt(args[0].num))
+		}},
+		"round": {args: []FormulaResultType{num, num}, optional: 1, ret: num, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			if args[0].isEmpty() {
+				return args[0], nil
+			}
+			precision := 0
+			if 1 < len(args) && !args[1].isEmpty() {
+				precision = int(args[1].num)
+			}
+			return formulaNumber(Round(args[0].num, precision))
+		}},
+		"pow": {args: []FormulaResultType{num, num}, ret: num, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			if args[0].isEmpty() || args[1].isEmpty() {
+				return formulaEmpty(FormulaResultTypeNumber), nil
+			}
+			return formulaNumber(math.Pow(args[0].num, args[1].num))
+		}},
+		"min": extremum(func(a, b float64) bool { return a < b }),
+		"max": extremum(func(a, b float64) bool { return a > b }),
+		"toNumber": {args: []FormulaResultType{anyType}, ret: num, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			arg := args[0]
+			switch arg.typ {
+			case FormulaResultTypeNumber:
+				return arg, nil
+			case FormulaResultTypeCheckbox:
+				if arg.b {
+					return formulaNumber(1)
+				}
+				return formulaNumber(0)
+			case FormulaResultTypeDate:
+				if arg.isEmpty() {
+					return formulaEmpty(FormulaResultTypeNumber), nil
+				}
+				return formulaNumber(float64(arg.date))
+			}
+			if n, err := strconv.ParseFloat(strings.TrimSpace(arg.str), 64); nil == err {
+				return formulaNumber(n)
+			}
+			return formulaEmpty(FormulaResultTypeNumber), nil
+		}},
+
+		// 文本
+		"concat": {args: []FormulaResultType{anyType}, variadic: true, ret: text, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			buf := strings.Builder{}
+			for _, arg := range args {
+				buf.WriteString(arg.String())
+			}
+			return formulaValue{typ: FormulaResultTypeText, str: buf.String()}, nil
+		}},
+		"format": {args: []FormulaResultType{anyType}, ret: text, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			return formulaValue{typ: FormulaResultTypeText, str: args[0].String()}, nil
+		}},
+		"length": {args: []FormulaResultType{text}, ret: num, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			return formulaNumber(float64(utf8.RuneCountInString(args[0].str)))
+		}},
+		"lower":      textText(strings.ToLower),
+		"upper":      textText(strings.ToUpper),
+		"trim":       textText(strings.TrimSpace),
+		"contains":   textCheckbox(strings.Contains),
+		"startsWith": textCheckbox(strings.HasPrefix),
+		"endsWith":   textCheckbox(strings.HasSuffix),
+		"replace": {args: []FormulaResultType{text, text, text}, ret: text, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			if "" == args[1].str {
+				return args[0], nil
+			}
+			return formulaValue{typ: FormulaResultTypeText, str: strings.ReplaceAll(args[0].str, args[1].str, args[2].str)}, nil
+		}},
+		"slice": {args: []FormulaResultType{text, num, num}, optional: 1, ret: text, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			runes := []rune(args[0].str)
+			start, end := 0, len(runes)
+			if !args[1].isEmpty() {
+				start = int(args[1].num)
+			}
+			if 2 < len(args) && !args[2].isEmpty() {
+				end = int(args[2].num)
+			}
+			start, end = max(0, min(start, len(runes))), max(0, min(end, len(runes)))
+			if start >= end {
+				return formulaValue{typ: FormulaResultTypeText}, nil
+			}
+			return formulaValue{typ: FormulaResultTypeText, str: string(runes[start:end])}, nil
+		}},
+
+		// 逻辑
+		"empty": {args: []FormulaResultType{anyType}, ret: checkbox, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			return formulaValue{typ: FormulaResultTypeCheckbox, b: args[0].isEmpty()}, nil
+		}},
+
+		// 日期
+		"now": {ret: date, call: func(_ []formulaValue, now time.Time) (formulaValue, error) {
+			return formulaDate(now, false), nil
+		}},
+		"today": {ret: date, call: func(_ []formulaValue, now time.Time) (formulaValue, error) {
+			return formulaDate(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local), true), nil
+		}},
+		"dateAdd":      shiftDate(1),
+		"dateSubtract": shiftDate(-1),
+		"dateBetween": {args: []FormulaResultType{date, date, text}, ret: num, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			if args[0].isEmpty() || args[1].isEmpty() {
+				return formulaEmpty(FormulaResultTypeNumber), nil
+			}
+			n, err := formulaDateBetween(args[0], args[1], args[2].str)
+			if nil != err {
+				return formulaEmpty(FormulaResultTypeNumber), err
+			}
+			return formulaNumber(n)
+		}},
+		"dateStart": {args: []FormulaResultType{date}, ret: date, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			ret := args[0]
+			ret.date2, ret.hasEnd = 0, false
+			return ret, nil
+		}},
+		"dateEnd": {args: []FormulaResultType{date}, ret: date, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			ret := args[0]
+			if ret.hasEnd {
+				ret.date = ret.date2
+			}
+			ret.date2, ret.hasEnd = 0, false
+			return ret, nil
+		}},
+		"formatDate": {args: []FormulaResultType{date, text}, ret: text, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			if args[0].isEmpty() {
+				return formulaValue{typ: FormulaResultTypeText}, nil
+			}
+			return formulaValue{typ: FormulaResultTypeText, str: time.UnixMilli(args[0].date).Format(formulaDateLayout(args[1].str))}, nil
+		}},
+		"fromTimestamp": {args: []FormulaResultType{num}, ret: date, call: func(args []formulaValue, _ time.Time) (formulaValue, error) {
+			if args[0].isEmpty() {
+				return formulaEmpty(FormulaResultTypeDate), nil
+			}
+			if !isFormulaDateInRange(args[0].num) {
+				return formulaEmpty(FormulaResultTypeDate), errors.New("timestamp out of range")
+			}
+			return formulaDate(time.UnixMilli(int64(args[0].num)), false), nil
+		}},
+		"timestamp": dateNumber(func(t time.Time) float64 { return float64(t.UnixMilli()) }),
+		"year":      dateNumber(func(t time.Time) float64 { return float64(t.Year()) }),
+		"month":     dateNumber(func(t time.Time) float64 { return float64(t.Month()) }),
+		"day":       dateNumber(func(t time.Time) float64 { return float64(t.Day()) }),
+		"weekday":   dateNumber(func(t time.Time) float64 { return float64(t.Weekday()) }),
+		"hour":      dateNumber(func(t time.Time) float64 { return float64(t.Hour()) }),
+		"minute":    dateNumber(func(t time.Time) float64 { return float64(t.Minute()) }),
+	}
+}
+
+const (
+	formulaMinDate = -62135596800000 // 0001-01-01T00:00:00Z 的毫秒时间戳
+	formulaMaxDate = 253402300799999 // 9999-12-31T23:59:59.999Z 的毫秒时间戳
+
+	formulaMaxDateOffset = 10000 * 366 // 按日历偏移时的数量上限，超过时结果必然超出日期范围，同时避免转换为 int 时溢出
+)
+
+// isFormulaDateInRange 判断毫秒时间戳是否在公式支持的日期范围（1 年到 9999 年）内。
+func isFormulaDateInRange(mills float64) bool {
+	return formulaMinDate <= mills && mills <= formulaMaxDate
+}
+
+// addFormulaDate 将毫秒时间戳按单位偏移，年、月、周和天按日历计算，不足一个单位的部分会被舍去。
+func addFormulaDate(mills int64, n float64, unit string) (int64, error) {
+	errOutOfRange := errors.New("date out of range")
+	t := time.UnixMilli(mills)
+	switch unit {
+	case "years", "quarters", "months", "weeks", "days":
+		if formulaMaxDateOffset < math.Abs(n) {
+			return mills, errOutOfRange
+		}
+		switch unit {
+		case "years":
+			t = t.AddDate(int(n), 0, 0)
+		case "quarters":
+			t = t.AddDate(0, int(n)*3, 0)
+		case "months":
+			t = t.AddDate(0, int(n), 0)
+		case "weeks":
+			t = t.AddDate(0, 0, int(n)*7)
+		case "days":
+			t = t.AddDate(0, 0, int(n))
+		}
+	case "hours", "minutes", "seconds":
+		unitMills := float64(time.Second / time.Millisecond)
+		switch unit {
+		case "hours":
+			unitMills = float64(time.Hour / time.Millisecond)
+		case "minutes":
+			unitMills = float64(time.Minute / time.Millisecond)
+		}
+		ret := float64(mills) + math.Trunc(n*unitMills)
+		if !isFormulaDateInRange(ret) {
+			return mills, errOutOfRange
+		}
+		return int64(ret), nil
+	default:
+		return mills, fmt.Errorf("invalid unit [%s]", unit)
+	}
+	if ret := t.UnixMilli(); isFormulaDateInRange(float64(ret)) {
+		return ret, nil
+	}
+	return mills, errOutOfRange
+}
+
+// formulaDateBetween 返回 a - b 按单位计算的差值，不足一个单位的部分向零取整。
+func formulaDateBetween(a, b formulaValue, unit string) (float64, error) {
+	ta, tb := time.UnixMilli(a.date), time.UnixMilli(b.date)
+	switch unit {
+	case "years", "quarters", "months":
+		months := (ta.Year()-tb.Year())*12 + int(ta.Month()) - int(tb.Month())
+		// 还没到对应的日期时不足一个月
+		if shifted := tb.AddDate(0, months, 0); 0 < months && shifted.After(ta) {
+			months--
+		} else if 0 > months && shifted.Before(ta) {
+			months++
+		}
+		switch unit {
+		case "years":
+			return float64(months / 12), nil
+		case "quarters":
+			return float64(months / 3), nil
+		}
+		return float64(months), nil
+	case "weeks", "days":
+		var days float64
+		if a.isNotTime && b.isNotTime {
+			days = timelineDays(tb, ta) // 按日历日计算以避免夏令时切换带来的误差
+		} else {
+			days = ta.Sub(tb).Hours() / 24
+		}
+		if "weeks" == unit {
+			days /= 7
+		}
+		return math.Trunc(days), nil
+	case "hours":
+		return math.Trunc(ta.Sub(tb).Hours()), nil
+	case "minutes":
+		return math.Trunc(ta.Sub(tb).Minutes()), nil
+	case "seconds":
+		return math.Trunc(ta.Sub(tb).Seconds()), nil
+	}
+	return 0, fmt.Errorf("invalid unit [%s]", unit)
+}
+
+var formulaDateLayoutReplacer = strings.NewReplacer("YYYY", "2006", "YY", "06", "MM", "01", "DD", "02", "HH", "15", "mm", "04", "ss", "05")
+
+// formulaDateLayout 将 YYYY-MM-DD HH:mm:ss 形式的格式转换为 Go 的时间格式。
+func formulaDateLayout(layout string) string {
+	if "" == layout {
+		return "2006-01-02 15:04"
+	}
+	return formulaDateLayoutReplacer.Replace(layout)
+}
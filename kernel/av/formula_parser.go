@@ -0,0 +1,356 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package av
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type formulaTokenKind int
+
+const (
+	formulaTokenEOF formulaTokenKind = iota
+	formulaTokenNumber
+	formulaTokenString
+	formulaTokenIdent
+	formulaTokenOperator
+)
+
+type formulaToken struct {
+	kind       formulaTokenKind
+	text       string // 字符串字面量为去掉引号和转义后的内容
+	start, end int    // 在表达式中的字节位置
+}
+
+// tokenizeFormula 将公式表达式拆分为词法单元，最后一个总是 formulaTokenEOF。
+func tokenizeFormula(expr string) (ret []*formulaToken, err error) {
+	for i := 0; i < len(expr); {
+		r, size := utf8.DecodeRuneInString(expr[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case '0' <= r && '9' >= r || ('.' == r && i+1 < len(expr) && '0' <= expr[i+1] && '9' >= expr[i+1]):
+			start := i
+			for i < len(expr) && ('0' <= expr[i] && '9' >= expr[i] || '.' == expr[i]) {
+				i++
+			}
+			if i < len(expr) && ('e' == expr[i] || 'E' == expr[i]) {
+				j := i + 1
+				if j < len(expr) && ('+' == expr[j] || '-' == expr[j]) {
+					j++
+				}
+				if j < len(expr) && '0' <= expr[j] && '9' >= expr[j] {
+					for i = j; i < len(expr) && '0' <= expr[i] && '9' >= expr[i]; i++ {
+					}
+				}
+			}
+			ret = append(ret, &formulaToken{kind: formulaTokenNumber, text: expr[start:i], start: start, end: i})
+		case '"' == r || '\'' == r:
+			start := i
+			buf := strings.Builder{}
+			closed := false
+			for i++; i < len(expr); {
+				c, s := utf8.DecodeRuneInString(expr[i:])
+				if c == r {
+					i += s
+					closed = true
+					break
+				}
+				if '\\' == c && i+1 < len(expr) {
+					i++
+					switch expr[i] {
+					case 'n':
+						buf.WriteByte('\n')
+					case 't':
+						buf.WriteByte('\t')
+					default:
+						buf.WriteByte(expr[i])
+					}
+					i++
+					continue
+				}
+				buf.WriteRune(c)
+				i += s
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			ret = append(ret, &formulaToken{kind: formulaTokenString, text: buf.String(), start: start, end: i})
+		case '_' == r || unicode.IsLetter(r):
+			start := i
+			for i < len(expr) {
+				c, s := utf8.DecodeRuneInString(expr[i:])
+				if '_' != c && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+					break
+				}
+				i += s
+			}
+			ret = append(ret, &formulaToken{kind: formulaTokenIdent, text: expr[start:i], start: start, end: i})
+		default:
+			start := i
+			op := ""
+			if i+1 < len(expr) {
+				switch expr[i : i+2] {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = expr[i : i+2]
+				}
+			}
+			if "" == op {
+				switch r {
+				case '+', '-', '*', '/', '%', '(', ')', ',', '<', '>', '!':
+					op = string(r)
+				case '=':
+					op = "=="
+				default:
+					return nil, fmt.Errorf("unexpected character [%c] at %d", r, i)
+				}
+				i += size
+			} else {
+				i += 2
+			}
+			ret = append(ret, &formulaToken{kind: formulaTokenOperator, text: op, start: start, end: i})
+		}
+	}
+	ret = append(ret, &formulaToken{kind: formulaTokenEOF, start: len(expr), end: len(expr)})
+	return
+}
+
+type formulaNodeKind int
+
+const (
+	formulaNodeLiteral formulaNodeKind = iota
+	formulaNodeProp
+	formulaNodeUnary
+	formulaNodeBinary
+	formulaNodeCall
+)
+
+// formulaNode 描述了公式语法树的节点。
+type formulaNode struct {
+	kind  formulaNodeKind
+	pos   int            // 在表达式中的位置，用于错误提示
+	op    string         // 一元或二元运算符
+	name  string         // 函数名，或者 prop 引用的字段名
+	keyID string         // prop 引用的字段 ID，编译时解析
+	lit   formulaValue   // 字面量的值
+	args  []*formulaNode // 运算数或者函数参数
+	typ   FormulaResultType
+}
+
+type formulaParser struct {
+	tokens []*formulaToken
+	pos    int
+}
+
+// parseFormula 解析公式表达式，支持四则运算、比较、逻辑运算、函数调用和 prop("字段名") 引用。
+func parseFormula(expr string) (ret *formulaNode, err error) {
+	tokens, err := tokenizeFormula(expr)
+	if nil != err {
+		return
+	}
+
+	p := &formulaParser{tokens: tokens}
+	if formulaTokenEOF == p.peek().kind {
+		return nil, fmt.Errorf("empty formula")
+	}
+	if ret, err = p.parseOr(); nil != err {
+		return
+	}
+	if t := p.peek(); formulaTokenEOF != t.kind {
+		return nil, fmt.Errorf("unexpected [%s] at %d", t.text, t.start)
+	}
+	return
+}
+
+func (p *formulaParser) peek() *formulaToken {
+	return p.tokens[p.pos]
+}
+
+func (p *formulaParser) next() *formulaToken {
+	t := p.tokens[p.pos]
+	if formulaTokenEOF != t.kind {
+		p.pos++
+	}
+	return t
+}
+
+// acceptOperator 在当前词法单元是 ops 中的某个运算符时消费它并返回统一后的运算符。
+func (p *formulaParser) acceptOperator(ops ...string) (ret string, pos int) {
+	t := p.peek()
+	for _, op := range ops {
+		if formulaTokenOperator == t.kind && op == t.text {
+			p.next()
+			return op, t.start
+		}
+		if formulaTokenIdent == t.kind && (("&&" == op && "and" == t.text) || ("||" == op && "or" == t.text) || ("!" == op && "not" == t.text)) {
+			p.next()
+			return op, t.start
+		}
+	}
+	return "", t.start
+}
+
+func (p *formulaParser) expect(op string) error {
+	t := p.next()
+	if formulaTokenOperator != t.kind || op != t.text {
+		if formulaTokenEOF == t.kind {
+			return fmt.Errorf("missing [%s] at %d", op, t.start)
+		}
+		return fmt.Errorf("expected [%s] but got [%s] at %d", op, t.text, t.start)
+	}
+	return nil
+}
+
+func (p *formulaParser) parseBinary(operand func() (*formulaNode, error), ops ...string) (ret *formulaNode, err error) {
+	if ret, err = operand(); nil != err {
+		return
+	}
+	for {
+		op, pos := p.acceptOperator(ops...)
+		if "" == op {
+			return
+		}
+		right, rightErr := operand()
+		if nil != rightErr {
+			return nil, rightErr
+		}
+		ret = &formulaNode{kind: formulaNodeBinary, pos: pos, op: op, args: []*formulaNode{ret, right}}
+	}
+}
+
+func (p *formulaParser) parseOr() (*formulaNode, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *formulaParser) parseAnd() (*formulaNode, error) {
+	return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *formulaParser) parseComparison() (*formulaNode, error) {
+	return p.parseBinary(p.parseAdditive, "==", "!=", "<=", ">=", "<", ">")
+}
+
+func (p *formulaParser) parseAdditive() (*formulaNode, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *formulaParser) parseMultiplicative() (*formulaNode, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *formulaParser) parseUnary() (ret *formulaNode, err error) {
+	if op, pos := p.acceptOperator("-", "!"); "" != op {
+		operand, operandErr := p.parseUnary()
+		if nil != operandErr {
+			return nil, operandErr
+		}
+		return &formulaNode{kind: formulaNodeUnary, pos: pos, op: op, args: []*formulaNode{operand}}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *formulaParser) parsePrimary() (ret *formulaNode, err error) {
+	t := p.next()
+	switch t.kind {
+	case formulaTokenNumber:
+		n, parseErr := strconv.ParseFloat(t.text, 64)
+		if nil != parseErr {
+			return nil, fmt.Errorf("invalid number [%s] at %d", t.text, t.start)
+		}
+		return &formulaNode{kind: formulaNodeLiteral, pos: t.start, lit: formulaValue{typ: FormulaResultTypeNumber, num: n}}, nil
+	case formulaTokenString:
+		return &formulaNode{kind: formulaNodeLiteral, pos: t.start, lit: formulaValue{typ: FormulaResultTypeText, str: t.text}}, nil
+	case formulaTokenIdent:
+		switch t.text {
+		case "true", "false":
+			return &formulaNode{kind: formulaNodeLiteral, pos: t.start, lit: formulaValue{typ: FormulaResultTypeCheckbox, b: "true" == t.text}}, nil
+		}
+
+		if err = p.expect("("); nil != err {
+			return nil, fmt.Errorf("unknown identifier [%s] at %d", t.text, t.start)
+		}
+		ret = &formulaNode{kind: formulaNodeCall, pos: t.start, name: t.text}
+		if op, _ := p.acceptOperator(")"); "" == op {
+			for {
+				arg, argErr := p.parseOr()
+				if nil != argErr {
+					return nil, argErr
+				}
+				ret.args = append(ret.args, arg)
+				if op, _ = p.acceptOperator(","); "" == op {
+					break
+				}
+			}
+			if err = p.expect(")"); nil != err {
+				return nil, err
+			}
+		}
+
+		if "prop" == t.text {
+			if 1 != len(ret.args) || formulaNodeLiteral != ret.args[0].kind || FormulaResultTypeText != ret.args[0].lit.typ {
+				return nil, fmt.Errorf("prop requires a field name string at %d", t.start)
+			}
+			ret = &formulaNode{kind: formulaNodeProp, pos: t.start, name: ret.args[0].lit.str}
+		}
+		return
+	case formulaTokenOperator:
+		if "(" == t.text {
+			if ret, err = p.parseOr(); nil != err {
+				return
+			}
+			err = p.expect(")")
+			return
+		}
+		return nil, fmt.Errorf("unexpected [%s] at %d", t.text, t.start)
+	}
+	return nil, fmt.Errorf("unexpected end of formula")
+}
+
+// RenameFormulaProp 将公式表达式中对字段 oldName 的引用改为 newName，表达式无法解析时原样返回。
+func RenameFormulaProp(expr, oldName, newName string) string {
+	tokens, err := tokenizeFormula(expr)
+	if nil != err {
+		return expr
+	}
+
+	buf := strings.Builder{}
+	last := 0
+	for i := 0; i+3 < len(tokens); i++ {
+		if formulaTokenIdent != tokens[i].kind || "prop" != tokens[i].text ||
+			formulaTokenOperator != tokens[i+1].kind || "(" != tokens[i+1].text ||
+			formulaTokenString != tokens[i+2].kind || oldName != tokens[i+2].text ||
+			formulaTokenOperator != tokens[i+3].kind || ")" != tokens[i+3].text {
+			continue
+		}
+
+		buf.WriteString(expr[last:tokens[i+2].start])
+		buf.WriteString(quoteFormulaString(newName))
+		last = tokens[i+2].end
+	}
+	buf.WriteString(expr[last:])
+	return buf.String()
+}
+
+func quoteFormulaString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
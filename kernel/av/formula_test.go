@@ -0,0 +1,329 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package av
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormula(t *testing.T) {
+	cases := []struct {
+		expr string
+		err  string // 为空时表示解析成功
+	}{
+		{`1 + 2 * 3`, ""},
+		{`-(1 + 2) % 2`, ""},
+		{`prop("数量") * prop("单价")`, ""},
+		{`prop('备注') + "\"后缀\""`, ""},
+		{`1 < 2 and not false or true`, ""},
+		{`if(prop("完成"), "是", "否")`, ""},
+		{`now()`, ""},
+		{`1.5e3 = 1500`, ""},
+		{``, "empty formula"},
+		{`   `, "empty formula"},
+		{`1 +`, "unexpected end of formula"},
+		{`(1 + 2`, "missing [)]"},
+		{`1 2`, "unexpected [2]"},
+		{`"abc`, "unterminated string"},
+		{`1 # 2`, "unexpected character [#]"},
+		{`foo`, "unknown identifier [foo]"},
+		{`prop(1)`, "prop requires a field name string"},
+		{`prop("a", "b")`, "prop requires a field name string"},
+		{`max(1, 2`, "missing [)]"},
+	}
+	for _, c := range cases {
+		_, err := parseFormula(c.expr)
+		if "" == c.err {
+			if nil != err {
+				t.Errorf("parse [%s] failed: %s", c.expr, err)
+			}
+			continue
+		}
+		if nil == err || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("parse [%s] expected error [%s], got [%v]", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestRenameFormulaProp(t *testing.T) {
+	expr := `prop("数量") + prop('数量') * prop("单价")`
+	if got := RenameFormulaProp(expr, "数量", `个"数`); `prop("个\"数") + prop("个\"数") * prop("单价")` != got {
+		t.Errorf("unexpected renamed formula [%s]", got)
+	}
+}
+
+// newFormulaTestAttrView 返回包含各类字段和一个公式字段的属性视图，以及一个项目的字段值。
+func newFormulaTestAttrView(expr string) (attrView *AttributeView, values map[string]*Value) {
+	date := time.Date(2024, 1, 31, 12, 0, 0, 0, time.Local).UnixMilli()
+	keys := []*Key{
+		{ID: "name", Name: "名称", Type: KeyTypeBlock},
+		{ID: "quantity", Name: "数量", Type: KeyTypeNumber},
+		{ID: "price", Name: "单价", Type: KeyTypeNumber},
+		{ID: "empty", Name: "空数字", Type: KeyTypeNumber},
+		{ID: "date", Name: "日期", Type: KeyTypeDate},
+		{ID: "done", Name: "完成", Type: KeyTypeCheckbox},
+		{ID: "notes", Name: "备注", Type: KeyTypeText},
+		{ID: "formula", Name: "公式", Type: KeyTypeFormula, Formula: &Formula{Expr: expr}},
+	}
+	values = map[string]*Value{
+		"name":     {Type: KeyTypeBlock, Block: &ValueBlock{Content: "苹果"}},
+		"quantity": {Type: KeyTypeNumber, Number: &ValueNumber{Content: 3, IsNotEmpty: true}},
+		"price":    {Type: KeyTypeNumber, Number: &ValueNumber{Content: 2.5, IsNotEmpty: true}},
+		"empty":    {Type: KeyTypeNumber, Number: &ValueNumber{}},
+		"date":     {Type: KeyTypeDate, Date: &ValueDate{Content: date, IsNotEmpty: true}},
+		"done":     {Type: KeyTypeCheckbox, Checkbox: &ValueCheckbox{Checked: true}},
+		"notes":    {Type: KeyTypeText, Text: &ValueText{Content: " 42 "}},
+	}
+	attrView = &AttributeView{}
+	for _, key := range keys {
+		attrView.KeyValues = append(attrView.KeyValues, &KeyValues{Key: key})
+	}
+	return
+}
+
+func evalTestFormula(expr string) (ret *ValueFormula, err error) {
+	attrView, values := newFormulaTestAttrView(expr)
+	compiled, err := CompileFormula(attrView, "formula")
+	if nil != err {
+		return
+	}
+	ret = compiled.Eval(func(keyID string) *Value { return values[keyID] })
+	return
+}
+
+func TestEvalFormula(t *testing.T) {
+	cases := []struct {
+		expr    string
+		typ     FormulaResultType
+		want    string // 结果的文本形式
+		evalErr string // 计算时的错误
+	}{
+		// 运算和优先级
+		{`1 + 2 * 3`, FormulaResultTypeNumber, "7", ""},
+		{`(1 + 2) * 3`, FormulaResultTypeNumber, "9", ""},
+		{`-2 - -3`, FormulaResultTypeNumber, "1", ""},
+		{`7 % 4`, FormulaResultTypeNumber, "3", ""},
+		{`prop("数量") * prop("单价")`, FormulaResultTypeNumber, "7.5", ""},
+		{`1 / 0`, FormulaResultTypeNumber, "", "division by zero"},
+		{`pow(10, 400)`, FormulaResultTypeNumber, "", "number out of range"},
+
+		// 空值
+		{`prop("空数字") + 1`, FormulaResultTypeNumber, "", ""},
+		{`empty(prop("空数字"))`, FormulaResultTypeCheckbox, "true", ""},
+		{`prop("空数字") == prop("空数字")`, FormulaResultTypeCheckbox, "true", ""},
+		{`prop("空数字") < 1`, FormulaResultTypeCheckbox, "false", ""},
+		{`max(prop("空数字"), 1, 5, 3)`, FormulaResultTypeNumber, "5", ""},
+
+		// 比较和逻辑
+		{`prop("数量") >= 3 and prop("完成")`, FormulaResultTypeCheckbox, "true", ""},
+		{`not prop("完成") || 1 > 2`, FormulaResultTypeCheckbox, "false", ""},
+		{`"a" < "b"`, FormulaResultTypeCheckbox, "true", ""},
+		{`if(prop("数量") > 2, "多", "少")`, FormulaResultTypeText, "多", ""},
+
+		// 类型转换
+		{`prop("名称") + prop("数量")`, FormulaResultTypeText, "苹果3", ""},
+		{`"完成：" + prop("完成")`, FormulaResultTypeText, "完成：true", ""},
+		{`toNumber(prop("备注")) + 1`, FormulaResultTypeNumber, "43", ""},
+		{`toNumber("abc")`, FormulaResultTypeNumber, "", ""},
+		{`toNumber(true) + toNumber(false)`, FormulaResultTypeNumber, "1", ""},
+		{`concat(prop("名称"), "-", 1, "-", false)`, FormulaResultTypeText, "苹果-1-false", ""},
+		{`format(1.50)`, FormulaResultTypeText, "1.5", ""},
+
+		// 文本函数
+		{`length("思源笔记")`, FormulaResultTypeNumber, "4", ""},
+		{`upper(trim(prop("备注")) + "x")`, FormulaResultTypeText, "42X", ""},
+		{`replace("a-b-c", "-", "+")`, FormulaResultTypeText, "a+b+c", ""},
+		{`slice("思源笔记", 1, 3)`, FormulaResultTypeText, "源笔", ""},
+		{`slice("思源笔记", 3, 1)`, FormulaResultTypeText, "", ""},
+		{`contains(prop("名称"), "果")`, FormulaResultTypeCheckbox, "true", ""},
+
+		// 日期函数
+		{`formatDate(prop("日期"), "YYYY/MM/DD HH:mm")`, FormulaResultTypeText, "2024/01/31 12:00", ""},
+		{`formatDate(dateAdd(prop("日期"), 1, "months"), "YYYY-MM-DD")`, FormulaResultTypeText, "2024-03-02", ""},
+		{`formatDate(dateSubtract(prop("日期"), 2, "hours"), "HH:mm")`, FormulaResultTypeText, "10:00", ""},
+		{`dateBetween(dateAdd(prop("日期"), 3, "weeks"), prop("日期"), "days")`, FormulaResultTypeNumber, "21", ""},
+		{`year(prop("日期")) * 100 + month(prop("日期"))`, FormulaResultTypeNumber, "202401", ""},
+		{`timestamp(fromTimestamp(timestamp(prop("日期")))) == timestamp(prop("日期"))`, FormulaResultTypeCheckbox, "true", ""},
+		{`dateAdd(prop("日期"), 1, "fortnights")`, FormulaResultTypeDate, "", "invalid unit [fortnights]"},
+		{`fromTimestamp(1e300)`, FormulaResultTypeDate, "", "timestamp out of range"},
+		{`dateAdd(prop("日期"), 1e300, "days")`, FormulaResultTypeDate, "", "date out of range"},
+		{`dateAdd(prop("日期"), 1e19, "years")`, FormulaResultTypeDate, "", "date out of range"},
+		{`dateAdd(prop("日期"), 8000, "years")`, FormulaResultTypeDate, "", "date out of range"},
+		{`dateAdd(prop("日期"), 1e15, "seconds")`, FormulaResultTypeDate, "", "date out of range"},
+	}
+	for _, c := range cases {
+		result, err := evalTestFormula(c.expr)
+		if nil != err {
+			t.Errorf("compile [%s] failed: %s", c.expr, err)
+			continue
+		}
+		if c.typ != result.Type {
+			t.Errorf("formula [%s] expected type [%s], got [%s]", c.expr, c.typ, result.Type)
+			continue
+		}
+		if "" == c.evalErr && "" != result.Error || !strings.Contains(result.Error, c.evalErr) {
+			t.Errorf("formula [%s] expected error [%s], got [%s]", c.expr, c.evalErr, result.Error)
+			continue
+		}
+
+		got := ""
+		switch result.Type {
+		case FormulaResultTypeNumber:
+			if result.Number.IsNotEmpty {
+				got = strconv.FormatFloat(result.Number.Content, 'f', -1, 64)
+			}
+		case FormulaResultTypeCheckbox:
+			got = strconv.FormatBool(result.Checkbox.Checked)
+		default:
+			got = (&Value{Type: KeyTypeFormula, Formula: result}).GetFormulaResult().String(false)
+		}
+		if c.want != got {
+			t.Errorf("formula [%s] expected [%s], got [%s]", c.expr, c.want, got)
+		}
+	}
+}
+
+func TestCompileFormulaErrors(t *testing.T) {
+	cases := []struct {
+		expr string
+		err  string
+	}{
+		{`prop("不存在")`, "field [不存在] not found"},
+		{`prop("公式") + 1`, "circular reference"},
+		{`1 + true`, "operator [+] can not be applied"},
+		{`-"a"`, "operator [-] requires a number"},
+		{`not 1`, "operator [not] requires a checkbox"},
+		{`1 == "1"`, "can not compare number with text"},
+		{`true < false`, "can not compare checkbox with checkbox"},
+		{`prop("数量") && true`, "requires checkboxes"},
+		{`if(1, 2, 3)`, "condition of function [if] must be a checkbox"},
+		{`if(true, 1, "a")`, "branches of function [if] must have the same type"},
+		{`if(true, 1)`, "function [if] requires 3 arguments"},
+		{`unknown(1)`, "unknown function [unknown]"},
+		{`abs()`, "wrong number of arguments"},
+		{`abs(1, 2)`, "wrong number of arguments"},
+		{`length(1)`, "argument 1 of function [length] must be text"},
+		{`dateAdd(prop("日期"), "1", "days")`, "argument 2 of function [dateAdd] must be number"},
+	}
+	for _, c := range cases {
+		_, err := evalTestFormula(c.expr)
+		if nil == err || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("compile [%s] expected error [%s], got [%v]", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestCompileFormulasOrder(t *testing.T) {
+	attrView := &AttributeView{KeyValues: []*KeyValues{
+		{Key: &Key{ID: "c", Name: "C", Type: KeyTypeFormula, Formula: &Formula{Expr: `prop("B") + 1`}}},
+		{Key: &Key{ID: "b", Name: "B", Type: KeyTypeFormula, Formula: &Formula{Expr: `prop("A") * 2`}}},
+		{Key: &Key{ID: "a", Name: "A", Type: KeyTypeNumber}},
+		{Key: &Key{ID: "x", Name: "X", Type: KeyTypeFormula, Formula: &Formula{Expr: `prop("Y")`}}},
+		{Key: &Key{ID: "y", Name: "Y", Type: KeyTypeFormula, Formula: &Formula{Expr: `prop("X")`}}},
+		{Key: &Key{ID: "z", Name: "Z", Type: KeyTypeFormula, Formula: &Formula{Expr: `prop("X") + "!"`}}},
+		{Key: &Key{ID: "e", Name: "E", Type: KeyTypeFormula, Formula: &Formula{}}},
+		{Key: &Key{ID: "f", Name: "F", Type: KeyTypeFormula, Formula: &Formula{Expr: `prop("E") + "?"`}}},
+	}}
+	formulas, errs := CompileFormulas(attrView)
+	var ids []string
+	for _, f := range formulas {
+		ids = append(ids, f.Key.ID)
+	}
+	// 被引用的公式字段排在前面
+	if order := strings.Join(ids, ","); 3 != len(ids) || strings.Index(order, "b") > strings.Index(order, "c") || !strings.Contains(order, "f") {
+		t.Errorf("unexpected compile order [%s]", strings.Join(ids, ","))
+	}
+	for _, id := range []string{"x", "y"} { // 循环引用
+		if err := errs[id]; nil == err || !strings.Contains(err.Error(), "circular reference") {
+			t.Errorf("formula [%s] expected circular reference error, got [%v]", id, err)
+		}
+	}
+	if err := errs["z"]; nil == err || !strings.Contains(err.Error(), "circular reference") {
+		t.Errorf("formula [z] depends on a circular reference, got [%v]", err)
+	}
+	if _, ok := errs["e"]; ok {
+		t.Errorf("empty formula should be ignored")
+	}
+
+	values := map[string]*Value{"a": {Type: KeyTypeNumber, Number: &ValueNumber{Content: 4, IsNotEmpty: true}}}
+	for _, f := range formulas {
+		values[f.Key.ID] = &Value{Type: KeyTypeFormula, Formula: f.Eval(func(keyID string) *Value { return values[keyID] })}
+	}
+	if got := values["c"].Formula.Number.Content; 9 != got {
+		t.Errorf("formula [c] expected 9, got %v", got)
+	}
+	if got := values["f"].Formula.Text.Content; "?" != got {
+		t.Errorf("formula [f] expected [?], got [%s]", got)
+	}
+}
+
+func newFormulaNumberValue(n float64) *Value {
+	return &Value{Type: KeyTypeFormula, Formula: &ValueFormula{Type: FormulaResultTypeNumber, Number: &ValueNumber{Content: n, IsNotEmpty: true}}}
+}
+
+func TestFormulaFilter(t *testing.T) {
+	emptyFormula := &Value{Type: KeyTypeFormula, Formula: &ValueFormula{}}
+	cases := []struct {
+		name     string
+		value    *Value
+		other    *Value
+		operator FilterOperator
+		want     bool
+	}{
+		{"number greater", newFormulaNumberValue(5), newFormulaNumberValue(3), FilterOperatorIsGreater, true},
+		{"number less", newFormulaNumberValue(5), newFormulaNumberValue(3), FilterOperatorIsLess, false},
+		{"number equal", newFormulaNumberValue(3), newFormulaNumberValue(3), FilterOperatorIsEqual, true},
+		{"text contains", &Value{Type: KeyTypeFormula, Formula: &ValueFormula{Type: FormulaResultTypeText, Text: &ValueText{Content: "思源笔记"}}},
+			&Value{Type: KeyTypeFormula, Formula: &ValueFormula{Type: FormulaResultTypeText, Text: &ValueText{Content: "笔记"}}}, FilterOperatorContains, true},
+		{"checkbox true", &Value{Type: KeyTypeFormula, Formula: &ValueFormula{Type: FormulaResultTypeCheckbox, Checkbox: &ValueCheckbox{Checked: true}}},
+			&Value{Type: KeyTypeFormula, Formula: &ValueFormula{Type: FormulaResultTypeCheckbox, Checkbox: &ValueCheckbox{}}}, FilterOperatorIsTrue, true},
+		{"result type changed", newFormulaNumberValue(5), &Value{Type: KeyTypeFormula, Formula: &ValueFormula{Type: FormulaResultTypeText, Text: &ValueText{Content: "a"}}}, FilterOperatorIsEqual, true},
+		{"both without result", emptyFormula, &Value{Type: KeyTypeFormula, Formula: &ValueFormula{}}, FilterOperatorIsEqual, true},
+		{"empty formula is empty", emptyFormula, &Value{Type: KeyTypeFormula, Formula: &ValueFormula{}}, FilterOperatorIsEmpty, true},
+		{"empty formula is not empty", emptyFormula, &Value{Type: KeyTypeFormula, Formula: &ValueFormula{}}, FilterOperatorIsNotEmpty, false},
+		{"number is empty", newFormulaNumberValue(1), nil, FilterOperatorIsEmpty, false},
+	}
+	for _, c := range cases {
+		if got := c.value.filter(c.other, nil, nil, c.operator); c.want != got {
+			t.Errorf("filter [%s] expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestFormulaCompare(t *testing.T) {
+	emptyFormula := &Value{Type: KeyTypeFormula, Formula: &ValueFormula{}}
+	if 0 <= newFormulaNumberValue(1).Compare(newFormulaNumberValue(2), nil) {
+		t.Errorf("1 should be less than 2")
+	}
+	if 0 >= newFormulaNumberValue(10).Compare(newFormulaNumberValue(2), nil) {
+		t.Errorf("10 should be greater than 2")
+	}
+	text := func(s string) *Value {
+		return &Value{Type: KeyTypeFormula, Formula: &ValueFormula{Type: FormulaResultTypeText, Text: &ValueText{Content: s}}}
+	}
+	if 0 <= text("a").Compare(text("b"), nil) {
+		t.Errorf("a should be less than b")
+	}
+
+	// 没有结果或者结果类型不一致时不能 panic
+	emptyFormula.Compare(newFormulaNumberValue(1), nil)
+	newFormulaNumberValue(1).Compare(emptyFormula, nil)
+	newFormulaNumberValue(1).Compare(text("a"), nil)
+}
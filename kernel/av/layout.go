@@ -84,6 +84,15 @@ func NewViewBaseInstance(view *View) *BaseInstance {
 	case LayoutTypeKanban:
 		showIcon = view.Kanban.ShowIcon
 		wrapField = view.Kanban.WrapField
+	case LayoutTypeCalendar:
+		showIcon = view.Calendar.ShowIcon
+		wrapField = view.Calendar.WrapField
+	case LayoutTypeTimeline:
+		showIcon = view.Timeline.ShowIcon
+		wrapField = view.Timeline.WrapField
+	case LayoutTypeChart:
+		showIcon = view.Chart.ShowIcon
+		wrapField = view.Chart.WrapField
 	}
 	return &BaseInstance{
 		ID:               view.ID,
@@ -161,6 +170,7 @@ type BaseInstanceField struct {
 	Date         *Date           `json:"date,omitempty"`     // 日期设置
 	Created      *Created        `json:"created,omitempty"`  // 创建时间设置
 	Updated      *Updated        `json:"updated,omitempty"`  // 更新时间设置
+	Formula      *Formula        `json:"formula,omitempty"`  // 公式设置
 }
 
 func (baseInstanceField *BaseInstanceField) GetID() string {
@@ -0,0 +1,335 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package av
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/88250/lute/ast"
+)
+
+// CalendarMode 描述了日历视图的显示模式。
+type CalendarMode string
+
+const (
+	CalendarModeMonth CalendarMode = "month" // 月视图
+	CalendarModeWeek  CalendarMode = "week"  // 周视图
+)
+
+// LayoutCalendar 描述了日历视图的结构。
+type LayoutCalendar struct {
+	*BaseLayout
+
+	StartKeyID     string       `json:"startKeyID"`           // 开始日期字段 ID
+	EndKeyID       string       `json:"endKeyID,omitempty"`   // 结束日期字段 ID，为空时使用开始日期字段的结束日期
+	TitleKeyID     string       `json:"titleKeyID,omitempty"` // 标题字段 ID，为空时使用主键
+	Mode           CalendarMode `json:"mode"`                 // 显示模式
+	FirstDayOfWeek int          `json:"firstDayOfWeek"`       // 每周的第一天，0：周日，1：周一
+
+	Fields []*ViewCalendarField `json:"fields"` // 字段
+}
+
+func NewLayoutCalendar() *LayoutCalendar {
+	return &LayoutCalendar{
+		BaseLayout: &BaseLayout{
+			Spec:     0,
+			ID:       ast.NewNodeID(),
+			ShowIcon: true,
+		},
+		Mode:           CalendarModeMonth,
+		FirstDayOfWeek: 1,
+	}
+}
+
+// IsCalendarDateKey 判断字段是否可以作为日历视图的开始或结束日期字段。
+func IsCalendarDateKey(key *Key) bool {
+	return nil != key && (KeyTypeDate == key.Type || KeyTypeCreated == key.Type || KeyTypeUpdated == key.Type)
+}
+
+// ViewCalendarField 描述了日历字段的结构。
+type ViewCalendarField struct {
+	*BaseField
+}
+
+// Calendar 描述了日历视图实例的结构。
+type Calendar struct {
+	*BaseInstance
+
+	StartKeyID     string           `json:"startKeyID"`     // 开始日期字段 ID
+	EndKeyID       string           `json:"endKeyID"`       // 结束日期字段 ID
+	TitleKeyID     string           `json:"titleKeyID"`     // 标题字段 ID
+	Mode           CalendarMode     `json:"mode"`           // 显示模式
+	FirstDayOfWeek int              `json:"firstDayOfWeek"` // 每周的第一天
+	Fields         []*CalendarField `json:"fields"`         // 项目字段
+	Items          []*CalendarItem  `json:"items"`          // 项目
+	ItemCount      int              `json:"itemCount"`      // 总项目数
+
+	RangeStart       string         `json:"rangeStart,omitempty"` // 渲染范围的开始日期，格式为 yyyy-MM-dd
+	RangeEnd         string         `json:"rangeEnd,omitempty"`   // 渲染范围的结束日期（包含）
+	Days             []*CalendarDay `json:"days,omitempty"`       // 按天分组的项目
+	UnscheduledCount int            `json:"unscheduledCount"`     // 没有开始日期的项目数
+}
+
+// CalendarItem 描述了日历实例项目的结构。
+type CalendarItem struct {
+	ID     string                `json:"id"`     // 项目 ID
+	Values []*CalendarFieldValue `json:"values"` // 项目字段值
+
+	Title  string `json:"title"`  // 标题
+	Start  int64  `json:"start"`  // 开始时间戳，为 0 时表示没有安排日期
+	End    int64  `json:"end"`    // 结束时间戳
+	AllDay bool   `json:"allDay"` // 是否全天（日期不包含时间）
+}
+
+// CalendarField 描述了日历实例字段的结构。
+type CalendarField struct {
+	*BaseInstanceField
+}
+
+// CalendarFieldValue 描述了日历项目字段实例值的结构。
+type CalendarFieldValue struct {
+	*BaseValue
+}
+
+// CalendarDay 描述了日历中一天的项目。
+type CalendarDay struct {
+	Date  string             `json:"date"`  // 日期，格式为 yyyy-MM-dd
+	Items []*CalendarDayItem `json:"items"` // 当天的项目
+}
+
+// CalendarDayItem 描述了日历中一天的一个项目，跨多天的项目会出现在每一天中。
+type CalendarDayItem struct {
+	ID      string `json:"id"`      // 项目 ID
+	IsStart bool   `json:"isStart"` // 是否是项目的第一天
+	IsEnd   bool   `json:"isEnd"`   // 是否是项目的最后一天
+	Span    int    `json:"span"`    // 从当天开始到项目结束（不超过当周）的天数，用于绘制跨天的横条
+}
+
+func (item *CalendarItem) GetID() string {
+	return item.ID
+}
+
+func (item *CalendarItem) GetBlockValue() (ret *Value) {
+	for _, v := range item.Values {
+		if KeyTypeBlock == v.ValueType {
+			ret = v.Value
+			break
+		}
+	}
+	return
+}
+
+func (item *CalendarItem) GetValues() (ret []*Value) {
+	ret = []*Value{}
+	for _, v := range item.Values {
+		ret = append(ret, v.Value)
+	}
+	return
+}
+
+func (item *CalendarItem) GetValue(keyID string) (ret *Value) {
+	for _, value := range item.Values {
+		if nil != value.Value && keyID == value.Value.KeyID {
+			ret = value.Value
+			break
+		}
+	}
+	return
+}
+
+// FillSchedule 根据开始、结束和标题字段的值计算项目的标题和时间范围。
+func (item *CalendarItem) FillSchedule(startKeyID, endKeyID, titleKeyID string) {
+	item.Title, item.Start, item.End, item.AllDay = itemSchedule(item, startKeyID, endKeyID, titleKeyID)
+}
+
+// itemSchedule 根据开始、结束和标题字段的值计算项目的标题和时间范围，没有开始日期时 start 为 0。
+func itemSchedule(item Item, startKeyID, endKeyID, titleKeyID string) (title string, start, end int64, allDay bool) {
+	if "" != titleKeyID {
+		if v := item.GetValue(titleKeyID); nil != v {
+			title = strings.TrimSpace(v.String(false))
+		}
+	}
+	if "" == title {
+		if v := item.GetBlockValue(); nil != v {
+			title = strings.TrimSpace(v.String(false))
+		}
+	}
+
+	start, end, hasEnd, isNotTime := calendarValueTime(item.GetValue(startKeyID))
+	if 0 == start {
+		return
+	}
+	end, allDay = max(end, start), isNotTime
+	if !hasEnd {
+		end = start
+	}
+	if "" != endKeyID && endKeyID != startKeyID {
+		if endStart, _, _, _ := calendarValueTime(item.GetValue(endKeyID)); endStart >= start {
+			end = endStart
+		}
+	}
+	return
+}
+
+func calendarValueTime(value *Value) (start, end int64, hasEnd, isNotTime bool) {
+	if nil == value {
+		return
+	}
+	switch value.Type {
+	case KeyTypeDate:
+		if nil != value.Date && value.Date.IsNotEmpty {
+			start, isNotTime = value.Date.Content, value.Date.IsNotTime
+			if value.Date.HasEndDate && value.Date.IsNotEmpty2 {
+				end, hasEnd = value.Date.Content2, true
+			}
+		}
+	case KeyTypeCreated:
+		if nil != value.Created && value.Created.IsNotEmpty {
+			start = value.Created.Content
+		}
+	case KeyTypeUpdated:
+		if nil != value.Updated && value.Updated.IsNotEmpty {
+			start = value.Updated.Content
+		}
+	}
+	return
+}
+
+// BucketByDay 只保留和 [rangeStart, rangeEnd] 有交集的项目，并按天分组。跨多天的项目会出现在每一天中。
+func (calendar *Calendar) BucketByDay(rangeStart, rangeEnd time.Time) {
+	rangeStart = calendarDay(rangeStart)
+	rangeEnd = calendarDay(rangeEnd)
+	calendar.RangeStart, calendar.RangeEnd = rangeStart.Format("2006-01-02"), rangeEnd.Format("2006-01-02")
+	calendar.UnscheduledCount = 0
+
+	var items []*CalendarItem
+	for _, item := range calendar.Items {
+		if 0 == item.Start {
+			calendar.UnscheduledCount++
+			continue
+		}
+		start, end := calendarDay(time.UnixMilli(item.Start)), calendarDay(time.UnixMilli(item.End))
+		if end.Before(rangeStart) || start.After(rangeEnd) {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	// 先开始、跨度长的项目排在前面，这样跨天的横条在每一天中的位置比较稳定
+	sort.SliceStable(items, func(i, j int) bool {
+		di, dj := calendarDay(time.UnixMilli(items[i].Start)), calendarDay(time.UnixMilli(items[j].Start))
+		if !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		return items[i].End-items[i].Start > items[j].End-items[j].Start
+	})
+	calendar.Items = items
+
+	calendar.Days = []*CalendarDay{}
+	days := map[string]*CalendarDay{}
+	for d := rangeStart; !d.After(rangeEnd); d = d.AddDate(0, 0, 1) {
+		day := &CalendarDay{Date: d.Format("2006-01-02"), Items: []*CalendarDayItem{}}
+		calendar.Days = append(calendar.Days, day)
+		days[day.Date] = day
+	}
+
+	for _, item := range items {
+		start, end := calendarDay(time.UnixMilli(item.Start)), calendarDay(time.UnixMilli(item.End))
+		for d := maxTime(start, rangeStart); !d.After(end) && !d.After(rangeEnd); d = d.AddDate(0, 0, 1) {
+			isWeekStart := int(d.Weekday()) == calendar.FirstDayOfWeek
+			dayItem := &CalendarDayItem{ID: item.ID, IsStart: d.Equal(start), IsEnd: d.Equal(end)}
+			if dayItem.IsStart || isWeekStart || d.Equal(rangeStart) {
+				// 横条在项目开始、每周第一天和范围开始时绘制，长度不超过当周和渲染范围
+				weekEnd := d.AddDate(0, 0, (calendar.FirstDayOfWeek+6-int(d.Weekday()))%7)
+				spanEnd := minTime(minTime(end, weekEnd), rangeEnd)
+				dayItem.Span = int(spanEnd.Sub(d).Hours()/24+0.5) + 1
+			}
+			days[d.Format("2006-01-02")].Items = append(days[d.Format("2006-01-02")].Items, dayItem)
+		}
+	}
+}
+
+// calendarDay 返回本地时区当天零点。
+func calendarDay(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (calendar *Calendar) GetItems() (ret []Item) {
+	ret = []Item{}
+	for _, item := range calendar.Items {
+		ret = append(ret, item)
+	}
+	return
+}
+
+func (calendar *Calendar) SetItems(items []Item) {
+	calendar.Items = []*CalendarItem{}
+	for _, item := range items {
+		calendar.Items = append(calendar.Items, item.(*CalendarItem))
+	}
+}
+
+func (calendar *Calendar) CountItems() int {
+	return len(calendar.Items)
+}
+
+func (calendar *Calendar) GetFields() (ret []Field) {
+	ret = []Field{}
+	for _, field := range calendar.Fields {
+		ret = append(ret, field)
+	}
+	return ret
+}
+
+func (calendar *Calendar) GetField(id string) (ret Field, fieldIndex int) {
+	for i, field := range calendar.Fields {
+		if field.ID == id {
+			return field, i
+		}
+	}
+	return nil, -1
+}
+
+func (calendar *Calendar) GetValue(itemID, keyID string) (ret *Value) {
+	for _, item := range calendar.Items {
+		if item.ID == itemID {
+			return item.GetValue(keyID)
+		}
+	}
+	return nil
+}
+
+func (calendar *Calendar) GetType() LayoutType {
+	return LayoutTypeCalendar
+}
@@ -0,0 +1,395 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package av
+
+import (
+	"sort"
+
+	"github.com/88250/lute/ast"
+)
+
+// ChartType 描述了图表视图的图表类型。
+type ChartType string
+
+const (
+	ChartTypeBar        ChartType = "bar"        // 柱状图
+	ChartTypeLine       ChartType = "line"       // 折线图
+	ChartTypePie        ChartType = "pie"        // 饼图
+	ChartTypeStackedBar ChartType = "stackedBar" // 堆叠柱状图
+)
+
+// ChartAggregate 描述了图表视图的聚合方式。
+type ChartAggregate struct {
+	Operator CalcOperator `json:"operator"`        // 聚合操作符，支持 Count all、Count values、Sum、Average、Median、Min 和 Max
+	KeyID    string       `json:"keyID,omitempty"` // 被聚合的数字字段 ID，Count all 时可以为空
+}
+
+// IsChartAggregateOperator 判断聚合操作符是否可以用于图表视图。
+func IsChartAggregateOperator(operator CalcOperator) bool {
+	switch operator {
+	case CalcOperatorCountAll, CalcOperatorCountValues, CalcOperatorSum, CalcOperatorAverage, CalcOperatorMedian, CalcOperatorMin, CalcOperatorMax:
+		return true
+	}
+	return false
+}
+
+// IsChartAggregateKey 判断字段是否可以作为图表视图的聚合字段（数字字段或者结果为数字的公式字段）。
+func IsChartAggregateKey(key *Key) bool {
+	if nil == key {
+		return false
+	}
+	return KeyTypeNumber == key.Type || (KeyTypeFormula == key.Type && nil != key.Formula && FormulaResultTypeNumber == key.Formula.ResultType)
+}
+
+// LayoutChart 描述了图表视图的结构。
+//
+// 图表的分类（横轴）使用视图的分组规则 View.Group，分组方式和其他视图一致；每个分类内再按系列字段的值拆分为多个系列。
+type LayoutChart struct {
+	*BaseLayout
+
+	ChartType   ChartType       `json:"chartType"`             // 图表类型
+	SeriesKeyID string          `json:"seriesKeyID,omitempty"` // 系列字段 ID，为空时只有一个系列
+	Aggregate   *ChartAggregate `json:"aggregate"`             // 聚合方式
+
+	Fields []*ViewChartField `json:"fields"` // 字段
+}
+
+func NewLayoutChart() *LayoutChart {
+	return &LayoutChart{
+		BaseLayout: &BaseLayout{
+			Spec:     0,
+			ID:       ast.NewNodeID(),
+			ShowIcon: true,
+		},
+		ChartType: ChartTypeBar,
+		Aggregate: &ChartAggregate{Operator: CalcOperatorCountAll},
+	}
+}
+
+// ViewChartField 描述了图表字段的结构。
+type ViewChartField struct {
+	*BaseField
+}
+
+// Chart 描述了图表视图实例的结构。
+type Chart struct {
+	*BaseInstance
+
+	ChartType   ChartType       `json:"chartType"`   // 图表类型
+	SeriesKeyID string          `json:"seriesKeyID"` // 系列字段 ID
+	Aggregate   *ChartAggregate `json:"aggregate"`   // 聚合方式
+	Fields      []*ChartField   `json:"fields"`      // 项目字段
+	Items       []*ChartItem    `json:"items"`       // 项目，聚合后清空
+	ItemCount   int             `json:"itemCount"`   // 总项目数
+
+	Categories []*ChartCategory `json:"categories"` // 分类（横轴）
+	Series     []*ChartSeries   `json:"series"`     // 系列
+}
+
+// ChartCategory 描述了图表分类的结构。
+type ChartCategory struct {
+	ID    string `json:"id"`    // 分类对应的分组视图 ID，未分组时为视图 ID
+	Name  string `json:"name"`  // 分类名称
+	Value *Value `json:"value"` // 分组值，未分组时为空
+}
+
+// ChartSeries 描述了图表系列的结构。
+type ChartSeries struct {
+	Name  string    `json:"name"`            // 系列名称，即系列字段的值，未设置系列字段时为空
+	Color string    `json:"color,omitempty"` // 系列颜色，系列字段为单选或多选时使用选项颜色
+	Data  []float64 `json:"data"`            // 各分类的聚合值，和 Categories 一一对应
+}
+
+// ChartItem 描述了图表实例项目的结构。
+type ChartItem struct {
+	ID     string             `json:"id"`     // 项目 ID
+	Values []*ChartFieldValue `json:"values"` // 项目字段值
+}
+
+// ChartField 描述了图表实例字段的结构。
+type ChartField struct {
+	*BaseInstanceField
+}
+
+// ChartFieldValue 描述了图表项目字段实例值的结构。
+type ChartFieldValue struct {
+	*BaseValue
+}
+
+func (item *ChartItem) GetID() string {
+	return item.ID
+}
+
+func (item *ChartItem) GetBlockValue() (ret *Value) {
+	for _, v := range item.Values {
+		if KeyTypeBlock == v.ValueType {
+			ret = v.Value
+			break
+		}
+	}
+	return
+}
+
+func (item *ChartItem) GetValues() (ret []*Value) {
+	ret = []*Value{}
+	for _, v := range item.Values {
+		ret = append(ret, v.Value)
+	}
+	return
+}
+
+func (item *ChartItem) GetValue(keyID string) (ret *Value) {
+	for _, value := range item.Values {
+		if nil != value.Value && keyID == value.Value.KeyID {
+			ret = value.Value
+			break
+		}
+	}
+	return
+}
+
+// FillSeries 计算图表的分类和系列。设置了分组时每个显示的分组为一个分类，否则所有项目为一个分类。
+// 计算后清空项目以减少返回的数据量。
+func (chart *Chart) FillSeries(attrView *AttributeView) {
+	type category struct {
+		*ChartCategory
+		items []*ChartItem
+	}
+
+	var categories []*category
+	if 0 < len(chart.Groups) {
+		for _, group := range chart.Groups {
+			groupChart := group.(*Chart)
+			if 0 != groupChart.GroupHidden {
+				continue
+			}
+			categories = append(categories, &category{
+				ChartCategory: &ChartCategory{ID: groupChart.ID, Name: groupChart.Name, Value: groupChart.GroupValue},
+				items:         groupChart.Items,
+			})
+			groupChart.Items = nil
+		}
+	} else {
+		categories = append(categories, &category{ChartCategory: &ChartCategory{ID: chart.ID, Name: chart.Name}, items: chart.Items})
+	}
+
+	var seriesKey *Key
+	if "" != chart.SeriesKeyID {
+		seriesKey, _ = attrView.GetKey(chart.SeriesKeyID)
+	}
+
+	// 收集系列，单选和多选按选项顺序排列，其他按值排序，空值排在最后
+	seriesIndexes := map[string]int{}
+	chart.Series = []*ChartSeries{}
+	addSeries := func(name, color string) {
+		if _, ok := seriesIndexes[name]; ok {
+			return
+		}
+		seriesIndexes[name] = len(chart.Series)
+		chart.Series = append(chart.Series, &ChartSeries{Name: name, Color: color})
+	}
+	if nil == seriesKey {
+		addSeries("", "")
+	} else {
+		if KeyTypeSelect == seriesKey.Type || KeyTypeMSelect == seriesKey.Type {
+			for _, opt := range seriesKey.Options {
+				addSeries(opt.Name, opt.Color)
+			}
+		}
+		var names []string
+		hasEmpty := false
+		for _, c := range categories {
+			for _, item := range c.items {
+				itemSeries := chartItemSeries(item, seriesKey)
+				if 1 > len(itemSeries) {
+					hasEmpty = true
+				}
+				for _, name := range itemSeries {
+					if _, ok := seriesIndexes[name]; !ok {
+						names = append(names, name)
+						seriesIndexes[name] = -1
+					}
+				}
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			delete(seriesIndexes, name)
+			addSeries(name, "")
+		}
+		if hasEmpty {
+			addSeries("", "")
+		}
+	}
+
+	chart.Categories = []*ChartCategory{}
+	for _, c := range categories {
+		chart.Categories = append(chart.Categories, c.ChartCategory)
+
+		buckets := make([][]*ChartItem, len(chart.Series))
+		for _, item := range c.items {
+			if nil == seriesKey {
+				buckets[0] = append(buckets[0], item)
+				continue
+			}
+
+			itemSeries := chartItemSeries(item, seriesKey)
+			if 1 > len(itemSeries) {
+				itemSeries = []string{""}
+			}
+			for _, name := range itemSeries {
+				if i, ok := seriesIndexes[name]; ok {
+					buckets[i] = append(buckets[i], item)
+				}
+			}
+		}
+
+		for i, series := range chart.Series {
+			series.Data = append(series.Data, chart.aggregateItems(buckets[i]))
+		}
+	}
+
+	chart.Items = nil
+}
+
+// chartItemSeries 返回项目所属的系列名称，多选和关联字段的项目可能同时属于多个系列，值为空时返回空列表。
+func chartItemSeries(item *ChartItem, seriesKey *Key) (ret []string) {
+	value := item.GetValue(seriesKey.ID)
+	if nil == value || value.IsBlank() {
+		return
+	}
+
+	switch seriesKey.Type {
+	case KeyTypeSelect, KeyTypeMSelect:
+		for _, opt := range value.MSelect {
+			ret = append(ret, opt.Content)
+		}
+	case KeyTypeRelation:
+		for _, content := range value.Relation.Contents {
+			ret = append(ret, content.String(false))
+		}
+	default:
+		ret = append(ret, value.String(false))
+	}
+	return
+}
+
+// aggregateItems 按聚合方式计算一组项目的值，没有可聚合的值时返回 0。
+func (chart *Chart) aggregateItems(items []*ChartItem) float64 {
+	if nil == chart.Aggregate || CalcOperatorCountAll == chart.Aggregate.Operator || "" == chart.Aggregate.KeyID {
+		return float64(len(items))
+	}
+
+	var numbers []float64
+	for _, item := range items {
+		value := item.GetValue(chart.Aggregate.KeyID)
+		if nil != value && KeyTypeFormula == value.Type {
+			value = value.GetFormulaResult()
+		}
+		if nil != value && nil != value.Number && value.Number.IsNotEmpty {
+			numbers = append(numbers, value.Number.Content)
+		}
+	}
+
+	switch chart.Aggregate.Operator {
+	case CalcOperatorCountValues:
+		return float64(len(numbers))
+	}
+	if 1 > len(numbers) {
+		return 0
+	}
+
+	switch chart.Aggregate.Operator {
+	case CalcOperatorSum, CalcOperatorAverage:
+		sum := 0.0
+		for _, n := range numbers {
+			sum += n
+		}
+		if CalcOperatorAverage == chart.Aggregate.Operator {
+			return sum / float64(len(numbers))
+		}
+		return sum
+	case CalcOperatorMedian:
+		sort.Float64s(numbers)
+		if 0 == len(numbers)%2 {
+			return (numbers[len(numbers)/2-1] + numbers[len(numbers)/2]) / 2
+		}
+		return numbers[len(numbers)/2]
+	case CalcOperatorMin:
+		ret := numbers[0]
+		for _, n := range numbers[1:] {
+			ret = min(ret, n)
+		}
+		return ret
+	case CalcOperatorMax:
+		ret := numbers[0]
+		for _, n := range numbers[1:] {
+			ret = max(ret, n)
+		}
+		return ret
+	}
+	return float64(len(items))
+}
+
+func (chart *Chart) GetItems() (ret []Item) {
+	ret = []Item{}
+	for _, item := range chart.Items {
+		ret = append(ret, item)
+	}
+	return
+}
+
+func (chart *Chart) SetItems(items []Item) {
+	chart.Items = []*ChartItem{}
+	for _, item := range items {
+		chart.Items = append(chart.Items, item.(*ChartItem))
+	}
+}
+
+func (chart *Chart) CountItems() int {
+	return len(chart.Items)
+}
+
+func (chart *Chart) GetFields() (ret []Field) {
+	ret = []Field{}
+	for _, field := range chart.Fields {
+		ret = append(ret, field)
+	}
+	return ret
+}
+
+func (chart *Chart) GetField(id string) (ret Field, fieldIndex int) {
+	for i, field := range chart.Fields {
+		if field.ID == id {
+			return field, i
+		}
+	}
+	return nil, -1
+}
+
+func (chart *Chart) GetValue(itemID, keyID string) (ret *Value) {
+	for _, item := range chart.Items {
+		if item.ID == itemID {
+			return item.GetValue(keyID)
+		}
+	}
+	return nil
+}
+
+func (chart *Chart) GetType() LayoutType {
+	return LayoutTypeChart
+}
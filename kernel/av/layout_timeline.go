@@ -0,0 +1,289 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package av
+
+import (
+	"sort"
+	"time"
+
+	"github.com/88250/lute/ast"
+)
+
+// TimelineZoom 描述了时间线视图的缩放级别，即时间轴上一格的长度。
+type TimelineZoom string
+
+const (
+	TimelineZoomDay   TimelineZoom = "day"   // 按天
+	TimelineZoomWeek  TimelineZoom = "week"  // 按周
+	TimelineZoomMonth TimelineZoom = "month" // 按月
+)
+
+// LayoutTimeline 描述了时间线视图的结构。
+type LayoutTimeline struct {
+	*BaseLayout
+
+	StartKeyID          string       `json:"startKeyID"`                // 开始日期字段 ID
+	EndKeyID            string       `json:"endKeyID,omitempty"`        // 结束日期字段 ID，为空时使用开始日期字段的结束日期
+	TitleKeyID          string       `json:"titleKeyID,omitempty"`      // 标题字段 ID，为空时使用主键
+	DependencyKeyID     string       `json:"dependencyKeyID,omitempty"` // 依赖字段 ID，必须是关联到当前数据库的关联字段，值为前置项目
+	CascadeDependencies bool         `json:"cascadeDependencies"`       // 前置项目推迟时是否自动推迟后续项目
+	Zoom                TimelineZoom `json:"zoom"`                      // 缩放级别
+
+	Fields []*ViewTimelineField `json:"fields"` // 字段
+}
+
+func NewLayoutTimeline() *LayoutTimeline {
+	return &LayoutTimeline{
+		BaseLayout: &BaseLayout{
+			Spec:     0,
+			ID:       ast.NewNodeID(),
+			ShowIcon: true,
+		},
+		Zoom: TimelineZoomDay,
+	}
+}
+
+// ViewTimelineField 描述了时间线字段的结构。
+type ViewTimelineField struct {
+	*BaseField
+}
+
+// Timeline 描述了时间线视图实例的结构。
+type Timeline struct {
+	*BaseInstance
+
+	StartKeyID          string           `json:"startKeyID"`          // 开始日期字段 ID
+	EndKeyID            string           `json:"endKeyID"`            // 结束日期字段 ID
+	TitleKeyID          string           `json:"titleKeyID"`          // 标题字段 ID
+	DependencyKeyID     string           `json:"dependencyKeyID"`     // 依赖字段 ID
+	CascadeDependencies bool             `json:"cascadeDependencies"` // 前置项目推迟时是否自动推迟后续项目
+	Zoom                TimelineZoom     `json:"zoom"`                // 缩放级别
+	Fields              []*TimelineField `json:"fields"`              // 项目字段
+	Items               []*TimelineItem  `json:"items"`               // 项目
+	ItemCount           int              `json:"itemCount"`           // 总项目数
+
+	RangeStart       string   `json:"rangeStart,omitempty"` // 渲染范围的开始日期，格式为 yyyy-MM-dd，按缩放级别对齐到周一或者月初
+	RangeEnd         string   `json:"rangeEnd,omitempty"`   // 渲染范围的结束日期（包含）
+	Units            []string `json:"units,omitempty"`      // 时间轴上每一格的开始日期
+	UnscheduledCount int      `json:"unscheduledCount"`     // 没有开始日期的项目数
+}
+
+// TimelineItem 描述了时间线实例项目的结构。
+type TimelineItem struct {
+	ID     string                `json:"id"`     // 项目 ID
+	Values []*TimelineFieldValue `json:"values"` // 项目字段值
+
+	Title        string   `json:"title"`        // 标题
+	Start        int64    `json:"start"`        // 开始时间戳，为 0 时表示没有安排日期
+	End          int64    `json:"end"`          // 结束时间戳
+	AllDay       bool     `json:"allDay"`       // 是否全天（日期不包含时间）
+	Dependencies []string `json:"dependencies"` // 前置项目 ID
+
+	Offset float64 `json:"offset"` // 横条距离渲染范围开始的格数，可能为负数（开始于渲染范围之前）
+	Length float64 `json:"length"` // 横条的格数
+}
+
+// TimelineField 描述了时间线实例字段的结构。
+type TimelineField struct {
+	*BaseInstanceField
+}
+
+// TimelineFieldValue 描述了时间线项目字段实例值的结构。
+type TimelineFieldValue struct {
+	*BaseValue
+}
+
+func (item *TimelineItem) GetID() string {
+	return item.ID
+}
+
+func (item *TimelineItem) GetBlockValue() (ret *Value) {
+	for _, v := range item.Values {
+		if KeyTypeBlock == v.ValueType {
+			ret = v.Value
+			break
+		}
+	}
+	return
+}
+
+func (item *TimelineItem) GetValues() (ret []*Value) {
+	ret = []*Value{}
+	for _, v := range item.Values {
+		ret = append(ret, v.Value)
+	}
+	return
+}
+
+func (item *TimelineItem) GetValue(keyID string) (ret *Value) {
+	for _, value := range item.Values {
+		if nil != value.Value && keyID == value.Value.KeyID {
+			ret = value.Value
+			break
+		}
+	}
+	return
+}
+
+// FillSchedule 根据开始、结束、标题和依赖字段的值计算项目的标题、时间范围和前置项目。
+func (item *TimelineItem) FillSchedule(startKeyID, endKeyID, titleKeyID, dependencyKeyID string) {
+	item.Title, item.Start, item.End, item.AllDay = itemSchedule(item, startKeyID, endKeyID, titleKeyID)
+	item.Dependencies = []string{}
+	if "" == dependencyKeyID {
+		return
+	}
+	if v := item.GetValue(dependencyKeyID); nil != v && nil != v.Relation {
+		for _, id := range v.Relation.BlockIDs {
+			if id != item.ID {
+				item.Dependencies = append(item.Dependencies, id)
+			}
+		}
+	}
+}
+
+// Layout 只保留和 [rangeStart, rangeEnd] 有交集的项目，并按缩放级别计算每个项目横条的位置。
+func (timeline *Timeline) Layout(rangeStart, rangeEnd time.Time) {
+	origin := timelineUnitStart(calendarDay(rangeStart), timeline.Zoom)
+	rangeEnd = calendarDay(rangeEnd)
+	timeline.RangeStart, timeline.RangeEnd = origin.Format("2006-01-02"), rangeEnd.Format("2006-01-02")
+	timeline.UnscheduledCount = 0
+
+	timeline.Units = []string{}
+	for u := origin; !u.After(rangeEnd); u = timelineNextUnit(u, timeline.Zoom) {
+		timeline.Units = append(timeline.Units, u.Format("2006-01-02"))
+	}
+	rangeEndExclusive := rangeEnd.AddDate(0, 0, 1)
+
+	var items []*TimelineItem
+	for _, item := range timeline.Items {
+		if 0 == item.Start {
+			timeline.UnscheduledCount++
+			continue
+		}
+
+		start, end := time.UnixMilli(item.Start).Local(), time.UnixMilli(item.End).Local()
+		if item.AllDay {
+			// 全天项目的结束日期包含当天
+			start, end = calendarDay(start), calendarDay(end).AddDate(0, 0, 1)
+		}
+		if !end.After(origin) || !start.Before(rangeEndExclusive) {
+			continue
+		}
+
+		item.Offset = timelineUnits(origin, start, timeline.Zoom)
+		item.Length = timelineUnits(origin, end, timeline.Zoom) - item.Offset
+		items = append(items, item)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Start < items[j].Start
+	})
+	timeline.Items = items
+}
+
+// timelineUnitStart 返回 t 所在格的开始时间，周从周一开始。
+func timelineUnitStart(t time.Time, zoom TimelineZoom) time.Time {
+	switch zoom {
+	case TimelineZoomWeek:
+		return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	case TimelineZoomMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	}
+	return t
+}
+
+func timelineNextUnit(t time.Time, zoom TimelineZoom) time.Time {
+	switch zoom {
+	case TimelineZoomWeek:
+		return t.AddDate(0, 0, 7)
+	case TimelineZoomMonth:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// timelineUnits 返回 t 距离 origin 的格数，按月缩放时按 t 所在月份的天数计算小数部分。
+func timelineUnits(origin, t time.Time, zoom TimelineZoom) float64 {
+	day := calendarDay(t)
+	dayFraction := t.Sub(day).Hours() / 24
+	switch zoom {
+	case TimelineZoomWeek:
+		return (timelineDays(origin, day) + dayFraction) / 7
+	case TimelineZoomMonth:
+		monthStart := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.Local)
+		daysInMonth := timelineDays(monthStart, monthStart.AddDate(0, 1, 0))
+		months := (day.Year()-origin.Year())*12 + int(day.Month()) - int(origin.Month())
+		return float64(months) + (timelineDays(monthStart, day)+dayFraction)/daysInMonth
+	}
+	return timelineDays(origin, day) + dayFraction
+}
+
+// timelineDays 返回两个零点之间的天数，按日历日计算以避免夏令时切换带来的误差。
+func timelineDays(from, to time.Time) float64 {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return to.Sub(from).Hours() / 24
+}
+
+func (timeline *Timeline) GetItems() (ret []Item) {
+	ret = []Item{}
+	for _, item := range timeline.Items {
+		ret = append(ret, item)
+	}
+	return
+}
+
+func (timeline *Timeline) SetItems(items []Item) {
+	timeline.Items = []*TimelineItem{}
+	for _, item := range items {
+		timeline.Items = append(timeline.Items, item.(*TimelineItem))
+	}
+}
+
+func (timeline *Timeline) CountItems() int {
+	return len(timeline.Items)
+}
+
+func (timeline *Timeline) GetFields() (ret []Field) {
+	ret = []Field{}
+	for _, field := range timeline.Fields {
+		ret = append(ret, field)
+	}
+	return ret
+}
+
+func (timeline *Timeline) GetField(id string) (ret Field, fieldIndex int) {
+	for i, field := range timeline.Fields {
+		if field.ID == id {
+			return field, i
+		}
+	}
+	return nil, -1
+}
+
+func (timeline *Timeline) GetValue(itemID, keyID string) (ret *Value) {
+	for _, item := range timeline.Items {
+		if item.ID == itemID {
+			return item.GetValue(keyID)
+		}
+	}
+	return nil
+}
+
+func (timeline *Timeline) GetType() LayoutType {
+	return LayoutTypeTimeline
+}
@@ -385,6 +385,14 @@ func (value *Value) Compare(other *Value, attrView *AttributeView) int {
 			}
 			return 1
 		}
+	case KeyTypeFormula:
+		if nil != value.Formula && nil != other.Formula {
+			v1, v2 := value.GetFormulaResult(), other.GetFormulaResult()
+			if nil != v1 && nil != v2 && v1.Type == v2.Type {
+				// 按结果类型排序
+				return v1.Compare(v2, attrView)
+			}
+		}
 	case KeyTypeCheckbox:
 		if nil != value.Checkbox && nil != other.Checkbox {
 			if value.Checkbox.Checked && !other.Checkbox.Checked {
@@ -57,6 +57,7 @@ type Value struct {
 	Checkbox *ValueCheckbox `json:"checkbox,omitempty"`
 	Relation *ValueRelation `json:"relation,omitempty"`
 	Rollup   *ValueRollup   `json:"rollup,omitempty"`
+	Formula  *ValueFormula  `json:"formula,omitempty"`
 
 	IsRenderAutoFill bool `json:"-"` // 标识是否是渲染阶段自动填充的值，保存数据的时候要删掉
 }
@@ -179,6 +180,12 @@ func (value *Value) String(format bool) string {
 			ret = append(ret, v.String(format))
 		}
 		return strings.TrimSpace(strings.Join(ret, ", "))
+	case KeyTypeFormula:
+		result := value.GetFormulaResult()
+		if result.IsEmpty() {
+			return ""
+		}
+		return result.String(format)
 	default:
 		return ""
 	}
@@ -210,7 +217,7 @@ func (value *Value) IsEdited() bool {
 		return true
 	}
 
-	if KeyTypeUpdated == value.Type || KeyTypeCreated == value.Type {
+	if KeyTypeUpdated == value.Type || KeyTypeCreated == value.Type || KeyTypeFormula == value.Type {
 		return true
 	}
 
@@ -299,6 +306,8 @@ func (value *Value) IsBlank() bool {
 		return 1 > len(value.Relation.Contents)
 	case KeyTypeRollup:
 		return 1 > len(value.Rollup.Contents)
+	case KeyTypeFormula:
+		return value.GetFormulaResult().IsEmpty()
 	}
 	return false
 }
@@ -377,6 +386,8 @@ func (value *Value) IsEmpty() bool {
 		return 1 > len(value.Relation.Contents)
 	case KeyTypeRollup:
 		return 1 > len(value.Rollup.Contents)
+	case KeyTypeFormula:
+		return value.GetFormulaResult().IsEmpty()
 	}
 	return false
 }
@@ -415,6 +426,8 @@ func (value *Value) SetValByType(typ KeyType, val interface{}) {
 		value.Relation = val.(*ValueRelation)
 	case KeyTypeRollup:
 		value.Rollup = val.(*ValueRollup)
+	case KeyTypeFormula:
+		value.Formula = val.(*ValueFormula)
 	}
 }
 
@@ -452,6 +465,8 @@ func (value *Value) GetValByType(typ KeyType) (ret interface{}) {
 		return value.Relation
 	case KeyTypeRollup:
 		return value.Rollup
+	case KeyTypeFormula:
+		return value.Formula
 	}
 	return
 }
@@ -819,7 +834,7 @@ func (r *ValueRollup) BuildContents(keyValues []*KeyValues, destKey *Key, relati
 	r.Contents = nil
 	for _, blockID := range relationVal.Relation.BlockIDs {
 		destVal := GetValue(keyValues, destKey.ID, blockID)
-		if nil != furtherCollection && (KeyTypeTemplate == destKey.Type || KeyTypeUpdated == destKey.Type || KeyTypeCreated == destKey.Type) {
+		if nil != furtherCollection && (KeyTypeTemplate == destKey.Type || KeyTypeFormula == destKey.Type || KeyTypeUpdated == destKey.Type || KeyTypeCreated == destKey.Type) {
 			destVal = furtherCollection.GetValue(blockID, destKey.ID)
 		}
 
@@ -1336,6 +1351,8 @@ func GetAttributeViewDefaultValue(valueID, keyID, blockID string, typ KeyType, k
 		ret.Relation = &ValueRelation{}
 	case KeyTypeRollup:
 		ret.Rollup = &ValueRollup{}
+	case KeyTypeFormula:
+		ret.Formula = &ValueFormula{}
 	}
 	return
 }
@@ -28,6 +28,10 @@ import (
 type AI struct {
 	OpenAI   *OpenAI   `json:"openAI"`   // LLM/Chat models for conversation and analysis
 	Embedding *Embedding `json:"embedding"` // Embedding models for vectorization
+	Agent    *Agent    `json:"agent"`    // Tool-calling agent settings
+	Providers *AIProviders `json:"providers"` // Provider registry and task routing
+	Usage    *AIUsage  `json:"usage"`    // Token budgets
+	EmbeddingIndex *EmbeddingIndex `json:"embeddingIndex"` // Model that produced the stored vectors
 }
 
 type OpenAI struct {
@@ -52,6 +56,63 @@ type Embedding struct {
 	EncodingFormat string `json:"encodingFormat"`  // float, base64
 	Timeout        int    `json:"timeout"`         // Request timeout in seconds
 	Enabled        bool   `json:"enabled"`         // Whether vectorization is enabled
+	ChunkSize      int    `json:"chunkSize"`       // 资源文件分块长度，单位字符
+	ChunkOverlap   int    `json:"chunkOverlap"`    // 相邻分块的重叠长度，单位字符
+}
+
+// EmbeddingIndex 已存储的向量所属的模型。
+// 切换向量化模型后，在重新向量化完成切换前仍使用该模型向量化查询，保证旧向量继续可用
+type EmbeddingIndex struct {
+	Provider     string `json:"provider"`
+	Model        string `json:"model"`
+	Dimension    int    `json:"dimension"`              // 向量维度，为 0 时在第一次查询后记录
+	ProviderName string `json:"providerName,omitempty"` // 服务商注册表中的服务商名称，连接信息和密钥从服务商读取，为空时使用旧版向量化配置
+}
+
+// Agent 工具调用智能体配置
+type Agent struct {
+	MaxSteps        int               `json:"maxSteps"`        // 单次对话最多允许的工具调用轮数
+	ToolPermissions map[string]string `json:"toolPermissions"` // 工具名 -> allow/confirm/deny，未配置的工具使用默认权限
+}
+
+const (
+	AgentToolPermAllow   = "allow"   // 直接执行
+	AgentToolPermConfirm = "confirm" // 仅生成待确认的操作
+	AgentToolPermDeny    = "deny"    // 不暴露给模型
+)
+
+// AIUsage 用量配额配置
+type AIUsage struct {
+	DefaultBudget *AIBudget            `json:"defaultBudget"` // 未单独配置的用户使用的配额
+	Budgets       map[string]*AIBudget `json:"budgets"`       // 用户名 -> 配额
+}
+
+// AIBudget Token 配额，0 表示不限制
+type AIBudget struct {
+	Daily   int64 `json:"daily"`   // 每日 Token 上限
+	Monthly int64 `json:"monthly"` // 每月 Token 上限
+}
+
+func NewAIUsage() *AIUsage {
+	return &AIUsage{
+		DefaultBudget: &AIBudget{},
+		Budgets:       map[string]*AIBudget{},
+	}
+}
+
+// GetBudget 获取用户的配额
+func (u *AIUsage) GetBudget(username string) *AIBudget {
+	if budget := u.Budgets[username]; nil != budget {
+		return budget
+	}
+	return u.DefaultBudget
+}
+
+func NewAgent() *Agent {
+	return &Agent{
+		MaxSteps:        8,
+		ToolPermissions: map[string]string{},
+	}
 }
 
 func NewAI() *AI {
@@ -163,6 +224,8 @@ func NewAI() *AI {
 		EncodingFormat: "float",
 		Timeout:        30,
 		Enabled:        false, // Disabled by default until API key is configured
+		ChunkSize:      2000,
+		ChunkOverlap:   200,
 	}
 
 	// Load embedding settings from environment variables
@@ -197,5 +260,8 @@ func NewAI() *AI {
 	return &AI{
 		OpenAI:   openAI,
 		Embedding: embedding,
+		Agent:    NewAgent(),
+		Providers: NewAIProviders(),
+		Usage:     NewAIUsage(),
 	}
 }
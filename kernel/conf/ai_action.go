@@ -0,0 +1,106 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/88250/gulu"
+)
+
+// AI 动作的输出方式
+const (
+	AIActionOutputReplace     = "replace"     // 替换选中的块
+	AIActionOutputInsertBelow = "insertBelow" // 插入到最后一个选中的块下方
+	AIActionOutputNewDoc      = "newDoc"      // 创建为当前文档的子文档
+	AIActionOutputSetAttr     = "setAttr"     // 写入第一个选中的块的属性
+)
+
+var AIActionOutputs = []string{AIActionOutputReplace, AIActionOutputInsertBelow, AIActionOutputNewDoc, AIActionOutputSetAttr}
+
+// AIActions 用户的 AI 动作库
+type AIActions struct {
+	Actions []*AIAction `json:"actions"`
+}
+
+// AIAction 用户自定义的 AI 动作
+type AIAction struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Prompt      string   `json:"prompt"`      // 提示词 Go 模板，可以使用 .Content .Title .Selection .Attrs .ID .RootID
+	Provider    string   `json:"provider"`    // 服务商名称，为空时使用对话路由
+	Model       string   `json:"model"`       // 模型，为空时使用服务商或对话路由的模型
+	Temperature *float64 `json:"temperature"` // 采样温度，为空时使用对话路由的配置
+	Output      string   `json:"output"`      // 输出方式
+	Attr        string   `json:"attr"`        // 输出方式为 setAttr 时写入的属性名，必须以 custom- 开头
+	KeepContext bool     `json:"keepContext"` // 是否在同一文档内保留多轮对话上下文
+}
+
+func NewAIActions() *AIActions {
+	return &AIActions{Actions: []*AIAction{}}
+}
+
+// GetAction 通过 ID 获取动作
+func (a *AIActions) GetAction(id string) *AIAction {
+	if nil == a {
+		return nil
+	}
+	for _, action := range a.Actions {
+		if action.ID == id {
+			return action
+		}
+	}
+	return nil
+}
+
+// Validate 校验动作库，返回所有发现的问题。提示词模板由调用方解析校验
+func (a *AIActions) Validate() (ret []string) {
+	if nil == a {
+		return
+	}
+
+	ids := map[string]bool{}
+	for i, action := range a.Actions {
+		if nil == action {
+			ret = append(ret, fmt.Sprintf("actions[%d] is empty", i))
+			continue
+		}
+		if "" != action.ID {
+			if ids[action.ID] {
+				ret = append(ret, fmt.Sprintf("action [%s] is duplicated", action.ID))
+			}
+			ids[action.ID] = true
+		}
+		if "" == strings.TrimSpace(action.Name) {
+			ret = append(ret, fmt.Sprintf("actions[%d] name is empty", i))
+		}
+		if "" == strings.TrimSpace(action.Prompt) {
+			ret = append(ret, fmt.Sprintf("action [%s] prompt is empty", action.Name))
+		}
+		if !gulu.Str.Contains(action.Output, AIActionOutputs) {
+			ret = append(ret, fmt.Sprintf("action [%s] output [%s] is invalid", action.Name, action.Output))
+		}
+		if AIActionOutputSetAttr == action.Output && !strings.HasPrefix(action.Attr, "custom-") {
+			ret = append(ret, fmt.Sprintf("action [%s] attr [%s] must start with custom-", action.Name, action.Attr))
+		}
+		if nil != action.Temperature && (0 > *action.Temperature || 2 < *action.Temperature) {
+			ret = append(ret, fmt.Sprintf("action [%s] temperature must be between 0 and 2", action.Name))
+		}
+	}
+	return
+}
@@ -0,0 +1,230 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/88250/gulu"
+)
+
+// AI 任务类型，每种任务通过路由选择一个服务商
+const (
+	AITaskChat      = "chat"      // 对话
+	AITaskEmbedding = "embedding" // 向量化
+	AITaskRerank    = "rerank"    // 重排序
+	AITaskASR       = "asr"       // 语音识别
+	AITaskOCR       = "ocr"       // 文字识别
+	AITaskMeeting   = "meeting"   // 会议纪要，未配置时使用对话路由
+)
+
+var AITasks = []string{AITaskChat, AITaskEmbedding, AITaskRerank, AITaskASR, AITaskOCR, AITaskMeeting}
+
+// AI 服务商接口类型
+const (
+	AIProviderTypeOpenAI      = "openai"      // OpenAI 兼容接口
+	AIProviderTypeAzure       = "azure"       // Azure OpenAI
+	AIProviderTypeSiliconFlow = "siliconflow" // 硅基流动
+	AIProviderTypeFunASR      = "funasr"      // FunASR WebSocket 语音识别
+	AIProviderTypeUmiOCR      = "umiocr"      // Umi-OCR / PaddleOCR HTTP 接口
+	AIProviderTypeHTTPASR     = "httpasr"     // 通用 HTTP 语音识别接口，上传 WAV 返回文本
+	AIProviderTypeTesseract   = "tesseract"   // 本地 Tesseract 文字识别，不需要接口地址
+)
+
+var AIProviderTypes = []string{AIProviderTypeOpenAI, AIProviderTypeAzure, AIProviderTypeSiliconFlow, AIProviderTypeFunASR, AIProviderTypeUmiOCR, AIProviderTypeHTTPASR, AIProviderTypeTesseract}
+
+// AIProviders AI 服务商注册表和任务路由
+type AIProviders struct {
+	Providers []*AIProvider       `json:"providers"`
+	Routes    map[string]*AIRoute `json:"routes"` // 任务类型 -> 路由
+}
+
+// AIProvider AI 服务商
+type AIProvider struct {
+	Name       string         `json:"name"`       // 唯一名称，路由通过名称引用服务商
+	Type       string         `json:"type"`       // 接口类型
+	BaseURL    string         `json:"baseURL"`    // 接口地址
+	APIKey     string         `json:"apiKey"`     // 接口密钥
+	APIVersion string         `json:"apiVersion"` // Azure API 版本
+	Proxy      string         `json:"proxy"`      // 代理地址
+	Models     []string       `json:"models"`     // 可用模型，为空时不校验路由中的模型
+	Timeout    int            `json:"timeout"`    // 请求超时，单位秒
+	Retry      *AIRetryPolicy `json:"retry"`      // 重试策略
+}
+
+// AIRetryPolicy 请求失败（网络错误、429 和 5xx）时的重试策略
+type AIRetryPolicy struct {
+	MaxAttempts int `json:"maxAttempts"` // 最多尝试次数，包含首次请求
+	Backoff     int `json:"backoff"`     // 首次重试前的等待时间，单位毫秒，之后每次翻倍
+}
+
+// AIRoute 任务路由
+type AIRoute struct {
+	Provider    string  `json:"provider"`    // 服务商名称
+	Model       string  `json:"model"`       // 模型，为空时使用服务商的第一个模型
+	Temperature float64 `json:"temperature"` // 采样温度，仅对话类任务使用
+	MaxTokens   int     `json:"maxTokens"`   // 最大输出长度，仅对话类任务使用
+}
+
+func NewAIProviders() *AIProviders {
+	return &AIProviders{Providers: []*AIProvider{}, Routes: map[string]*AIRoute{}}
+}
+
+// GetProvider 通过名称获取服务商
+func (p *AIProviders) GetProvider(name string) *AIProvider {
+	if nil == p {
+		return nil
+	}
+	for _, provider := range p.Providers {
+		if provider.Name == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+// GetRoute 获取任务路由
+func (p *AIProviders) GetRoute(task string) *AIRoute {
+	if nil == p || nil == p.Routes {
+		return nil
+	}
+	return p.Routes[task]
+}
+
+// MaskedAPIKey 是返回给前端的脱敏密钥中间的占位部分
+const MaskedAPIKey = "******"
+
+// MaskAPIKey 隐藏密钥，只保留首尾几个字符用于辨认
+func MaskAPIKey(key string) string {
+	if "" == key {
+		return ""
+	}
+	if 12 > len(key) {
+		return MaskedAPIKey
+	}
+	return key[:3] + MaskedAPIKey + key[len(key)-4:]
+}
+
+// Masked 返回隐藏了密钥的副本，用于返回给前端
+func (p *AIProviders) Masked() (ret *AIProviders) {
+	ret = NewAIProviders()
+	if nil == p {
+		return
+	}
+	for _, provider := range p.Providers {
+		if nil == provider {
+			continue
+		}
+		ret.Providers = append(ret.Providers, provider.Masked())
+	}
+	for task, route := range p.Routes {
+		ret.Routes[task] = route
+	}
+	return
+}
+
+// Masked 返回隐藏了密钥的副本
+func (provider *AIProvider) Masked() *AIProvider {
+	ret := *provider
+	ret.APIKey = MaskAPIKey(provider.APIKey)
+	return &ret
+}
+
+// RestoreMaskedAPIKeys 将前端提交的脱敏密钥还原为 saved 中同名服务商的密钥，这样修改注册表时不需要重新填写密钥。
+// 只有类型和地址都没有变化时才还原，避免密钥被发送到新的地址
+func (p *AIProviders) RestoreMaskedAPIKeys(saved *AIProviders) {
+	if nil == p {
+		return
+	}
+	for _, provider := range p.Providers {
+		if nil == provider || !strings.Contains(provider.APIKey, MaskedAPIKey) {
+			continue
+		}
+		old := saved.GetProvider(provider.Name)
+		if nil == old || old.Type != provider.Type || old.BaseURL != provider.BaseURL {
+			continue
+		}
+		if MaskAPIKey(old.APIKey) == provider.APIKey {
+			provider.APIKey = old.APIKey
+		}
+	}
+}
+
+// Validate 校验注册表，返回所有发现的问题。fallback 用于解析在其他注册表中定义的服务商（比如用户路由引用全局服务商）
+func (p *AIProviders) Validate(fallback *AIProviders) (ret []string) {
+	if nil == p {
+		return
+	}
+
+	names := map[string]bool{}
+	for i, provider := range p.Providers {
+		if nil == provider {
+			ret = append(ret, fmt.Sprintf("providers[%d] is empty", i))
+			continue
+		}
+		if "" == strings.TrimSpace(provider.Name) {
+			ret = append(ret, fmt.Sprintf("providers[%d] name is empty", i))
+		} else if names[provider.Name] {
+			ret = append(ret, fmt.Sprintf("provider [%s] is duplicated", provider.Name))
+		}
+		names[provider.Name] = true
+
+		if !gulu.Str.Contains(provider.Type, AIProviderTypes) {
+			ret = append(ret, fmt.Sprintf("provider [%s] type [%s] is invalid", provider.Name, provider.Type))
+		}
+		if AIProviderTypeTesseract != provider.Type && !strings.HasPrefix(provider.BaseURL, "http://") && !strings.HasPrefix(provider.BaseURL, "https://") &&
+			!strings.HasPrefix(provider.BaseURL, "ws://") && !strings.HasPrefix(provider.BaseURL, "wss://") {
+			ret = append(ret, fmt.Sprintf("provider [%s] baseURL [%s] is invalid", provider.Name, provider.BaseURL))
+		}
+		if 0 > provider.Timeout {
+			ret = append(ret, fmt.Sprintf("provider [%s] timeout must not be negative", provider.Name))
+		}
+		if nil != provider.Retry && (0 > provider.Retry.MaxAttempts || 10 < provider.Retry.MaxAttempts || 0 > provider.Retry.Backoff) {
+			ret = append(ret, fmt.Sprintf("provider [%s] retry policy is invalid", provider.Name))
+		}
+	}
+
+	for task, route := range p.Routes {
+		if !gulu.Str.Contains(task, AITasks) {
+			ret = append(ret, fmt.Sprintf("route task [%s] is invalid", task))
+			continue
+		}
+		if nil == route {
+			continue
+		}
+
+		provider := p.GetProvider(route.Provider)
+		if nil == provider {
+			provider = fallback.GetProvider(route.Provider)
+		}
+		if nil == provider {
+			ret = append(ret, fmt.Sprintf("route [%s] provider [%s] not found", task, route.Provider))
+			continue
+		}
+		if "" != route.Model && 0 < len(provider.Models) && !gulu.Str.Contains(route.Model, provider.Models) {
+			ret = append(ret, fmt.Sprintf("route [%s] model [%s] is not provided by [%s]", task, route.Model, provider.Name))
+		}
+		if 0 > route.Temperature || 2 < route.Temperature {
+			ret = append(ret, fmt.Sprintf("route [%s] temperature must be between 0 and 2", task))
+		}
+		if 0 > route.MaxTokens {
+			ret = append(ret, fmt.Sprintf("route [%s] maxTokens must not be negative", task))
+		}
+	}
+	return
+}
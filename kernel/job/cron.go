@@ -41,8 +41,9 @@ func StartCron() {
 	go every(util.SQLFlushInterval, sql.FlushAssetContentTxJob)
 	go every(10*time.Minute, model.IndexEmbedBlockJob)
 	go every(10*time.Minute, model.CacheVirtualBlockRefJob)
-	// [OCR 功能已禁用] go every(30*time.Second, model.OCRAssetsJob)
-	// [OCR 功能已禁用] go every(30*time.Second, model.FlushAssetsTextsJob)
+	go every(30*time.Second, model.OCRQueueJob)
+	go every(time.Minute, model.AttrViewAutomationJob)
+	go every(5*time.Minute, model.AttrViewCalendarJob)
 	go every(30*time.Second, model.HookDesktopUIProcJob)
 	go every(24*time.Hour, model.AutoPurgeRepoJob)
 	go every(30*time.Minute, model.AutoCheckMicrosoftDefenderJob)
@@ -29,6 +29,7 @@ import (
 )
 
 func main() {
+	mcpStdio := model.PrepareMCPStdio()
 	util.Boot()
 
 	model.InitConf()
@@ -64,6 +65,9 @@ func main() {
 	// [历史记录功能已禁用] go model.AutoGenerateFileHistory()
 	go cache.LoadAssets()
 	go util.CheckFileSysStatus()
+	if mcpStdio {
+		go model.ServeMCPStdio()
+	}
 
 	model.WatchAssets()
 	model.WatchEmojis()
@@ -38,6 +38,7 @@ import (
 	"github.com/88250/lute/parse"
 	"github.com/sashabaranov/go-openai"
 	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/conf"
 	"github.com/siyuan-note/siyuan/kernel/sql"
 	"github.com/siyuan-note/siyuan/kernel/treenode"
 	"github.com/siyuan-note/siyuan/kernel/util"
@@ -50,72 +51,92 @@ func init() {
 }
 
 func ChatGPT(msg string) (ret string) {
-	if !isOpenAIAPIEnabled() {
+	return ChatGPTWithContext(GetDefaultWorkspaceContext(), msg)
+}
+
+// ChatGPTWithContext 对话，上下文按用户隔离
+func ChatGPTWithContext(ctx *WorkspaceContext, msg string) (ret string) {
+	if !isOpenAIAPIEnabled(ctx) {
 		return
 	}
 
-	return chatGPT(msg, false)
+	return chatGPT(ctx, msg, false)
 }
 
 func ChatGPTWithAction(ids []string, action string) (ret string) {
-	if !isOpenAIAPIEnabled() {
+	return ChatGPTWithActionWithContext(GetDefaultWorkspaceContext(), ids, action)
+}
+
+// ChatGPTWithActionWithContext 使用动作处理块内容，上下文按用户和文档隔离
+func ChatGPTWithActionWithContext(ctx *WorkspaceContext, ids []string, action string) (ret string) {
+	if !isOpenAIAPIEnabled(ctx) {
 		return
 	}
 
 	if "Clear context" == action {
 		// AI clear context action https://github.com/siyuan-note/siyuan/issues/10255
-		cachedContextMsg = nil
+		ClearAIConversation(ctx, "")
+		if 0 < len(ids) {
+			if bt := getBlockTreeWithContext(ctx, ids[0]); nil != bt {
+				ClearAIConversation(ctx, bt.RootID)
+			}
+		}
 		return
 	}
 
-	msg := getBlocksContent(ids)
-	ret = chatGPTWithAction(msg, action, false)
+	msg := getBlocksContent(ctx, ids)
+	ret = chatGPTWithAction(ctx, msg, action, false)
 	return
 }
 
-var cachedContextMsg []string
-
-func chatGPT(msg string, cloud bool) (ret string) {
+func chatGPT(ctx *WorkspaceContext, msg string, cloud bool) (ret string) {
 	if "Clear context" == strings.TrimSpace(msg) {
 		// AI clear context action https://github.com/siyuan-note/siyuan/issues/10255
-		cachedContextMsg = nil
+		ClearAIConversation(ctx, "")
 		return
 	}
 
-	ret, retCtxMsgs, err := chatGPTContinueWrite(msg, cachedContextMsg, cloud)
+	ret, retCtxMsgs, err := chatGPTContinueWrite(ctx, msg, getAIConversation(ctx, ""), cloud, AIFeatureChatGPT)
 	if err != nil {
 		return
 	}
-	cachedContextMsg = append(cachedContextMsg, retCtxMsgs...)
+	appendAIConversation(ctx, "", retCtxMsgs...)
 	return
 }
 
-func chatGPTWithAction(msg string, action string, cloud bool) (ret string) {
+func chatGPTWithAction(ctx *WorkspaceContext, msg string, action string, cloud bool) (ret string) {
 	action = strings.TrimSpace(action)
 	if "" != action {
 		msg = action + ":\n\n" + msg
 	}
-	ret, _, err := chatGPTContinueWrite(msg, nil, cloud)
+	ret, _, err := chatGPTContinueWrite(ctx, msg, nil, cloud, AIFeatureAction)
 	if err != nil {
 		return
 	}
 	return
 }
 
-func chatGPTContinueWrite(msg string, contextMsgs []string, cloud bool) (ret string, retContextMsgs []string, err error) {
+func chatGPTContinueWrite(ctx *WorkspaceContext, msg string, contextMsgs []string, cloud bool, feature string) (ret string, retContextMsgs []string, err error) {
+	if !cloud {
+		if err = CheckAIBudget(ctx); nil != err {
+			pushAIBudgetExceeded()
+			return
+		}
+	}
+
 	util.PushEndlessProgress("Requesting...")
 	defer util.ClearPushProgress(100)
 
 	// RAG 增强：搜索相关文档
-	embeddingService := NewEmbeddingService()
+	embeddingService := NewEmbeddingServiceWithContext(ctx)
 	if embeddingService != nil && embeddingService.IsEnabled() {
 		// 搜索最相关的10个分块
-		chunks, err := SemanticSearchAssetChunks(util.DataDir, msg, 10, nil)
+		chunks, err := SemanticSearchAssetChunksWithContext(ctx, msg, 10, nil)
 		if err == nil && len(chunks) > 0 {
 			var contextBuilder strings.Builder
 			contextBuilder.WriteString("以下是相关的文档内容供参考：\n\n")
 			for i, chunk := range chunks {
-				contextBuilder.WriteString(fmt.Sprintf("【片段%d】\n%s\n\n", i+1, chunk.Content))
+				contextBuilder.WriteString(fmt.Sprintf("【片段%d - 来源: %s】\n%s\n\n", i+1, chunk.Citation(), chunk.Content))
 			}
 			contextBuilder.WriteString("请基于以上文档内容回答用户的问题：\n")
 			contextBuilder.WriteString(msg)
@@ -130,13 +151,12 @@ func chatGPTContinueWrite(msg string, contextMsgs []string, cloud bool) (ret str
 		contextMsgs = contextMsgs[len(contextMsgs)-Conf.AI.OpenAI.APIMaxContexts:]
 	}
 
-	apiKey, apiBaseURL, apiModel, _, _ := getEffectiveAIConfig()
-
 	var gpt GPT
 	if cloud {
 		gpt = &CloudGPT{}
 	} else {
-		gpt = &OpenAIGPT{c: util.NewOpenAIClient(apiKey, Conf.AI.OpenAI.APIProxy, apiBaseURL, Conf.AI.OpenAI.APIUserAgent, Conf.AI.OpenAI.APIVersion, Conf.AI.OpenAI.APIProvider), model: apiModel}
+		client, provider, apiModel, _, _ := getEffectiveAIConfig(ctx)
+		gpt = &OpenAIGPT{c: client, model: apiModel, ctx: ctx, feature: feature, provider: provider}
 	}
 
 	buf := &bytes.Buffer{}
@@ -168,93 +188,74 @@ type DefaultModelConfig struct {
 	MaxTokens   int     `json:"max_tokens"`
 }
 
-func getEffectiveAIConfig() (apiKey, apiBaseURL, apiModel string, maxTokens int, temperature float64) {
-	apiKey = Conf.AI.OpenAI.APIKey
-	apiBaseURL = Conf.AI.OpenAI.APIBaseURL
+// getEffectiveAIConfig 获取对话使用的客户端和参数。
+// 用户的路由覆盖优先；其次是设置中明确配置的 OpenAI 接口；当未配置密钥或者选择了内置模型时使用全局路由
+func getEffectiveAIConfig(ctx *WorkspaceContext) (client *openai.Client, provider, apiModel string, maxTokens int, temperature float64) {
+	provider = Conf.AI.OpenAI.APIProvider
 	apiModel = Conf.AI.OpenAI.APIModel
 	maxTokens = Conf.AI.OpenAI.APIMaxTokens
 	temperature = Conf.AI.OpenAI.APITemperature
 
-	// 当APIKey为空、为USE_DEFAULT_CONFIG，或者APIProvider为builtin时，读取默认配置
-	needDefaultConfig := apiKey == "" || apiKey == "USE_DEFAULT_CONFIG" || apiModel == "USE_DEFAULT_CONFIG" || Conf.AI.OpenAI.APIProvider == "builtin"
-
-	if needDefaultConfig {
-		configPath := "/root/code/unified-settings-service/config/default-models.json"
-		data, err := os.ReadFile(configPath)
-		if err == nil {
-			var models map[string]DefaultModelConfig
-			if err := json.Unmarshal(data, &models); err == nil {
-				// 优先使用思源笔记专用模型配置
-				var targetConfig DefaultModelConfig
-				if val, ok := models["builtin_free_siyuan"]; ok {
-					targetConfig = val
-				} else if val, ok := models["builtin_free_neuralink"]; ok {
-					// 兼容旧配置
-					targetConfig = val
-				} else if val, ok := models["builtin_free"]; ok {
-					targetConfig = val
-				}
+	ep := ResolveAIEndpoint(ctx, conf.AITaskChat)
+	useRegistry := nil != ep && (ep.UserOverride || useDefaultChatConfig())
+	if !useRegistry {
+		client = util.NewOpenAIClient(Conf.AI.OpenAI.APIKey, Conf.AI.OpenAI.APIProxy, Conf.AI.OpenAI.APIBaseURL, Conf.AI.OpenAI.APIUserAgent, Conf.AI.OpenAI.APIVersion, Conf.AI.OpenAI.APIProvider)
+		return
+	}
 
-				if targetConfig.APIKey != "" {
-					apiKey = targetConfig.APIKey
-					apiBaseURL = targetConfig.BaseURL
-					apiModel = targetConfig.ModelName
-					if targetConfig.MaxTokens > 0 {
-						maxTokens = targetConfig.MaxTokens
-					}
-					if targetConfig.Temperature > 0 {
-						temperature = targetConfig.Temperature
-					}
-				}
-			}
-		}
+	client = ep.OpenAIClient()
+	provider = ep.Provider.Name
+	apiModel = ep.Model
+	if 0 < ep.MaxTokens {
+		maxTokens = ep.MaxTokens
+	}
+	if 0 < ep.Temperature {
+		temperature = ep.Temperature
 	}
 	return
 }
 
+// useDefaultChatConfig 当 APIKey 为空、为 USE_DEFAULT_CONFIG，或者 APIProvider 为 builtin 时，使用全局路由配置的模型
+func useDefaultChatConfig() bool {
+	apiKey := Conf.AI.OpenAI.APIKey
+	return "" == apiKey || "USE_DEFAULT_CONFIG" == apiKey || "USE_DEFAULT_CONFIG" == Conf.AI.OpenAI.APIModel || "builtin" == Conf.AI.OpenAI.APIProvider
+}
+
 // ChatWithContext 聊天（支持用户上下文）
 func ChatWithContext(ctx *WorkspaceContext, messages []openai.ChatCompletionMessage, allowedAssets []string) (ret string, err error) {
-	if !isOpenAIAPIEnabled() {
+	if !isOpenAIAPIEnabled(ctx) {
 		return "", fmt.Errorf("AI not enabled")
 	}
+	if err = CheckAIBudget(ctx); nil != err {
+		return
+	}
 
 	// RAG 增强：从用户消息中提取查询，搜索相关文档
 	messages = EnhanceMessagesWithRAGContext(ctx, messages, allowedAssets)
-
-	apiKey, apiBaseURL, apiModel, maxTokens, temperature := getEffectiveAIConfig()
-
-	client := util.NewOpenAIClient(apiKey, Conf.AI.OpenAI.APIProxy, apiBaseURL, Conf.AI.OpenAI.APIUserAgent, Conf.AI.OpenAI.APIVersion, Conf.AI.OpenAI.APIProvider)
-
-	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
-		Model:       apiModel,
-		Messages:    messages,
-		MaxTokens:   maxTokens,
-		Temperature: float32(temperature),
-	})
-
-	if err != nil {
-		return "", err
-	}
-
-	if len(resp.Choices) > 0 {
-		return resp.Choices[0].Message.Content, nil
-	}
-
-	return "", fmt.Errorf("no response from AI")
+	return createChatCompletion(ctx, messages)
 }
 
 // Chat 聊天（兼容旧版本）
 func Chat(messages []openai.ChatCompletionMessage, allowedAssets []string) (ret string, err error) {
-	if !isOpenAIAPIEnabled() {
+	if !isOpenAIAPIEnabled(GetDefaultWorkspaceContext()) {
 		return "", fmt.Errorf("AI not enabled")
 	}
+	ctx := GetDefaultWorkspaceContext()
+	if err = CheckAIBudget(ctx); nil != err {
+		return
+	}
 
 	// RAG 增强：从用户消息中提取查询，搜索相关文档
 	messages = EnhanceMessagesWithRAG(messages, allowedAssets)
+	return createChatCompletion(ctx, messages)
+}
 
-	apiKey, apiBaseURL, apiModel, maxTokens, temperature := getEffectiveAIConfig()
+func createChatCompletion(ctx *WorkspaceContext, messages []openai.ChatCompletionMessage) (ret string, err error) {
+	return createChatCompletionWithFeature(ctx, AIFeatureChat, messages)
+}
 
-	client := util.NewOpenAIClient(apiKey, Conf.AI.OpenAI.APIProxy, apiBaseURL, Conf.AI.OpenAI.APIUserAgent, Conf.AI.OpenAI.APIVersion, Conf.AI.OpenAI.APIProvider)
+func createChatCompletionWithFeature(ctx *WorkspaceContext, feature string, messages []openai.ChatCompletionMessage) (ret string, err error) {
+	client, provider, apiModel, maxTokens, temperature := getEffectiveAIConfig(ctx)
 
 	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
 		Model:       apiModel,
@@ -268,80 +269,54 @@ func Chat(messages []openai.ChatCompletionMessage, allowedAssets []string) (ret
 	}
 
 	if len(resp.Choices) > 0 {
-		return resp.Choices[0].Message.Content, nil
+		ret = resp.Choices[0].Message.Content
 	}
-
-	return "", fmt.Errorf("no response from AI")
+	recordAIChatUsage(ctx, feature, provider, apiModel, &resp.Usage, messages, ret)
+	if 1 > len(resp.Choices) {
+		return "", fmt.Errorf("no response from AI")
+	}
+	return
 }
 
 // ChatStreamWithContext 流式聊天，通过 channel 返回每个 token（支持用户上下文）
 func ChatStreamWithContext(ctx *WorkspaceContext, messages []openai.ChatCompletionMessage, allowedAssets []string, onToken func(token string) error) error {
-	if !isOpenAIAPIEnabled() {
+	if !isOpenAIAPIEnabled(ctx) {
 		return fmt.Errorf("AI not enabled")
 	}
+	if err := CheckAIBudget(ctx); nil != err {
+		return err
+	}
 
 	// RAG 增强
 	messages = EnhanceMessagesWithRAGContext(ctx, messages, allowedAssets)
-
-	apiKey, apiBaseURL, apiModel, maxTokens, temperature := getEffectiveAIConfig()
-
-	client := util.NewOpenAIClient(apiKey, Conf.AI.OpenAI.APIProxy, apiBaseURL, Conf.AI.OpenAI.APIUserAgent, Conf.AI.OpenAI.APIVersion, Conf.AI.OpenAI.APIProvider)
-
-	req := openai.ChatCompletionRequest{
-		Model:       apiModel,
-		Messages:    messages,
-		MaxTokens:   maxTokens,
-		Temperature: float32(temperature),
-		Stream:      true,
-	}
-
-	stream, err := client.CreateChatCompletionStream(context.Background(), req)
-	if err != nil {
-		return fmt.Errorf("创建流式请求失败: %v", err)
-	}
-	defer stream.Close()
-
-	for {
-		response, err := stream.Recv()
-		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
-			return fmt.Errorf("接收流式响应失败: %v", err)
-		}
-
-		if len(response.Choices) > 0 {
-			token := response.Choices[0].Delta.Content
-			if token != "" {
-				if err := onToken(token); err != nil {
-					return err
-				}
-			}
-		}
-	}
-
-	return nil
+	return createChatCompletionStream(ctx, messages, onToken)
 }
 
 // ChatStream 流式聊天，通过 channel 返回每个 token（兼容旧版本）
 func ChatStream(messages []openai.ChatCompletionMessage, allowedAssets []string, onToken func(token string) error) error {
-	if !isOpenAIAPIEnabled() {
+	if !isOpenAIAPIEnabled(GetDefaultWorkspaceContext()) {
 		return fmt.Errorf("AI not enabled")
 	}
+	ctx := GetDefaultWorkspaceContext()
+	if err := CheckAIBudget(ctx); nil != err {
+		return err
+	}
 
 	// RAG 增强
 	messages = EnhanceMessagesWithRAG(messages, allowedAssets)
+	return createChatCompletionStream(ctx, messages, onToken)
+}
 
-	apiKey, apiBaseURL, apiModel, maxTokens, temperature := getEffectiveAIConfig()
-
-	client := util.NewOpenAIClient(apiKey, Conf.AI.OpenAI.APIProxy, apiBaseURL, Conf.AI.OpenAI.APIUserAgent, Conf.AI.OpenAI.APIVersion, Conf.AI.OpenAI.APIProvider)
+func createChatCompletionStream(ctx *WorkspaceContext, messages []openai.ChatCompletionMessage, onToken func(token string) error) error {
+	client, provider, apiModel, maxTokens, temperature := getEffectiveAIConfig(ctx)
 
 	req := openai.ChatCompletionRequest{
-		Model:       apiModel,
-		Messages:    messages,
-		MaxTokens:   maxTokens,
-		Temperature: float32(temperature),
-		Stream:      true,
+		Model:         apiModel,
+		Messages:      messages,
+		MaxTokens:     maxTokens,
+		Temperature:   float32(temperature),
+		Stream:        true,
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
 	}
 
 	stream, err := client.CreateChatCompletionStream(context.Background(), req)
@@ -350,6 +325,13 @@ func ChatStream(messages []openai.ChatCompletionMessage, allowedAssets []string,
 	}
 	defer stream.Close()
 
+	// 流结束或者中断时都记录已经产生的用量
+	var usage *openai.Usage
+	completion := &strings.Builder{}
+	defer func() {
+		recordAIChatUsage(ctx, AIFeatureChatStream, provider, apiModel, usage, messages, completion.String())
+	}()
+
 	for {
 		response, err := stream.Recv()
 		if err != nil {
@@ -359,9 +341,13 @@ func ChatStream(messages []openai.ChatCompletionMessage, allowedAssets []string,
 			return fmt.Errorf("接收流式响应失败: %v", err)
 		}
 
+		if nil != response.Usage {
+			usage = response.Usage
+		}
 		if len(response.Choices) > 0 {
 			token := response.Choices[0].Delta.Content
 			if token != "" {
+				completion.WriteString(token)
 				if err := onToken(token); err != nil {
 					return err
 				}
@@ -379,7 +365,7 @@ func EnhanceMessagesWithRAGContext(ctx *WorkspaceContext, messages []openai.Chat
 		return messages
 	}
 	
-	embeddingService := NewEmbeddingService()
+	embeddingService := NewEmbeddingServiceWithContext(ctx)
 	if embeddingService == nil || !embeddingService.IsEnabled() {
 		return messages
 	}
@@ -465,7 +451,7 @@ func EnhanceMessagesWithRAGContext(ctx *WorkspaceContext, messages []openai.Chat
 					ragContext.WriteString("...(由于长度限制，后续片段已忽略)\n")
 					break
 				}
-				ragContext.WriteString(fmt.Sprintf("【片段%d - 来源: %s】\n%s\n\n", i+1, chunk.Source, chunk.Content))
+				ragContext.WriteString(fmt.Sprintf("【片段%d - 来源: %s】\n%s\n\n", i+1, chunk.Citation(), chunk.Content))
 				totalLen += len(chunk.Content)
 			}
 		}
@@ -582,7 +568,7 @@ func EnhanceMessagesWithRAG(messages []openai.ChatCompletionMessage, allowedAsse
 					ragContext.WriteString("...(由于长度限制，后续片段已忽略)\n")
 					break
 				}
-				ragContext.WriteString(fmt.Sprintf("【片段%d - 来源: %s】\n%s\n\n", i+1, chunk.Source, chunk.Content))
+				ragContext.WriteString(fmt.Sprintf("【片段%d - 来源: %s】\n%s\n\n", i+1, chunk.Citation(), chunk.Content))
 				totalLen += len(chunk.Content)
 			}
 		}
@@ -611,36 +597,31 @@ func EnhanceMessagesWithRAG(messages []openai.ChatCompletionMessage, allowedAsse
 	return enhancedMessages
 }
 
-func isOpenAIAPIEnabled() bool {
-	// 如果配置了USE_DEFAULT_CONFIG或builtin provider，说明使用内置模型，也应该启用
-	if Conf.AI.OpenAI.APIKey == "USE_DEFAULT_CONFIG" || Conf.AI.OpenAI.APIModel == "USE_DEFAULT_CONFIG" || Conf.AI.OpenAI.APIProvider == "builtin" {
+func isOpenAIAPIEnabled(ctx *WorkspaceContext) bool {
+	if !useDefaultChatConfig() {
 		return true
 	}
-	// 如果APIKey为空，尝试检查是否有默认配置可用
-	if "" == Conf.AI.OpenAI.APIKey {
-		// 检查默认配置文件是否存在
-		configPath := "/root/code/unified-settings-service/config/default-models.json"
-		if _, err := os.Stat(configPath); err == nil {
-			return true // 有默认配置文件，允许使用
-		}
-		util.PushMsg(Conf.Language(193), 5000)
-		return false
+	// 未配置密钥或者选择了内置模型时，需要注册表中有可用的对话路由，用户的路由优先
+	if nil != ResolveAIEndpoint(ctx, conf.AITaskChat) {
+		return true
 	}
-	return true
+	util.PushMsg(Conf.Language(193), 5000)
+	return false
 }
 
-func getBlocksContent(ids []string) string {
+// getBlocksContent 从 ctx 对应的工作空间读取块并导出为 Markdown
+func getBlocksContent(ctx *WorkspaceContext, ids []string) string {
 	var nodes []*ast.Node
 	trees := map[string]*parse.Tree{}
 	for _, id := range ids {
-		bt := treenode.GetBlockTree(id)
+		bt := getBlockTreeWithContext(ctx, id)
 		if nil == bt {
 			continue
 		}
 
 		var tree *parse.Tree
 		if tree = trees[bt.RootID]; nil == tree {
-			tree, _ = LoadTreeByBlockID(bt.RootID)
+			tree, _ = loadTreeByBlockTreeWithContext(ctx, bt)
 			if nil == tree {
 				continue
 			}
@@ -676,6 +657,11 @@ type GPT interface {
 type OpenAIGPT struct {
 	c     *openai.Client
 	model string
+
+	// 用于记录用量
+	ctx      *WorkspaceContext
+	feature  string
+	provider string
 }
 
 func (gpt *OpenAIGPT) chat(msg string, contextMsgs []string) (partRet string, stop bool, err error) {
@@ -683,7 +669,15 @@ func (gpt *OpenAIGPT) chat(msg string, contextMsgs []string) (partRet string, st
 	if modelName == "" {
 		modelName = Conf.AI.OpenAI.APIModel
 	}
-	return util.ChatGPT(msg, contextMsgs, gpt.c, modelName, Conf.AI.OpenAI.APIMaxTokens, Conf.AI.OpenAI.APITemperature, Conf.AI.OpenAI.APITimeout)
+	partRet, stop, usage, err := util.ChatGPTWithUsage(msg, contextMsgs, gpt.c, modelName, Conf.AI.OpenAI.APIMaxTokens, Conf.AI.OpenAI.APITemperature, Conf.AI.OpenAI.APITimeout)
+	if nil == err && "" != gpt.feature {
+		var msgs []openai.ChatCompletionMessage
+		for _, m := range append(contextMsgs, msg) {
+			msgs = append(msgs, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: m})
+		}
+		recordAIChatUsage(gpt.ctx, gpt.feature, gpt.provider, modelName, &usage, msgs, partRet)
+	}
+	return
 }
 
 type CloudGPT struct {
@@ -709,6 +703,9 @@ type BlockVector struct {
 	Content    string    `json:"content"`
 	Vector     []float64 `json:"vector"`
 	UpdatedAt  time.Time `json:"updatedAt"`
+	Provider   string    `json:"provider,omitempty"`  // 生成向量的服务商，旧版向量为空
+	Model      string    `json:"model,omitempty"`     // 生成向量的模型，旧版向量为空
+	Dimension  int       `json:"dimension,omitempty"` // 向量维度
 }
 
 // NotebookSummary 笔记本摘要
@@ -724,6 +721,7 @@ type NotebookSummary struct {
 type EmbeddingService interface {
 	VectorizeText(text string) ([]float64, error)
 	IsEnabled() bool
+	ModelInfo() EmbeddingModelInfo
 }
 
 // OpenAIService OpenAI LLM服务实现
@@ -733,6 +731,7 @@ type OpenAIService struct {
 
 // SiliconFlowEmbeddingService SiliconFlow向量化服务实现
 type SiliconFlowEmbeddingService struct {
+	ctx            *WorkspaceContext // 用于预算检查和用量记录
 	apiKey         string
 	baseURL        string
 	model          string
@@ -742,7 +741,10 @@ type SiliconFlowEmbeddingService struct {
 
 // OpenAIEmbeddingService OpenAI向量化服务实现
 type OpenAIEmbeddingService struct {
-	client *openai.Client
+	ctx      *WorkspaceContext // 用于预算检查和用量记录
+	client   *openai.Client
+	model    string
+	provider string
 }
 
 // NewOpenAIService 创建OpenAI LLM服务
@@ -763,47 +765,33 @@ type GlobalEmbeddingConfig struct {
 	Enabled        bool   `json:"enabled"`
 }
 
-// 全局配置文件路径
-const globalEmbeddingConfigPath = "/root/code/unified-settings-service/config/embedding-config.json"
-
-// loadGlobalEmbeddingConfig 加载全局向量化配置
-func loadGlobalEmbeddingConfig() *GlobalEmbeddingConfig {
-	data, err := os.ReadFile(globalEmbeddingConfigPath)
-	if err != nil {
-		logging.LogWarnf("读取全局向量化配置失败: %v", err)
+// loadGlobalEmbeddingConfig 从服务商注册表加载向量化路由，用户配置了向量化路由时优先使用
+func loadGlobalEmbeddingConfig(ctx *WorkspaceContext) *GlobalEmbeddingConfig {
+	ep := ResolveAIEndpoint(ctx, conf.AITaskEmbedding)
+	if nil == ep {
 		return nil
 	}
 
-	var config GlobalEmbeddingConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		logging.LogWarnf("解析全局向量化配置失败: %v", err)
-		return nil
+	return &GlobalEmbeddingConfig{
+		Provider:   ep.Provider.Type,
+		APIKey:     ep.Provider.APIKey,
+		Model:      ep.Model,
+		APIBaseURL: ep.Provider.BaseURL,
+		Timeout:    ep.Provider.Timeout,
+		Enabled:    true,
 	}
-
-	return &config
 }
 
 // NewEmbeddingService 创建向量化服务
-// 优先使用全局配置，如果全局配置不存在或未启用，则使用用户配置
+// 优先使用注册表中的向量化路由，如果没有配置，则使用用户配置
 func NewEmbeddingService() EmbeddingService {
-	// 优先尝试全局配置
-	globalConfig := loadGlobalEmbeddingConfig()
-	if globalConfig != nil && globalConfig.Enabled && globalConfig.APIKey != "" {
-		logging.LogInfof("使用全局向量化配置: provider=%s, model=%s", globalConfig.Provider, globalConfig.Model)
-		switch globalConfig.Provider {
-		case "siliconflow":
-			return &SiliconFlowEmbeddingService{
-				apiKey:         globalConfig.APIKey,
-				baseURL:        globalConfig.APIBaseURL,
-				model:          globalConfig.Model,
-				encodingFormat: globalConfig.EncodingFormat,
-				timeout:        globalConfig.Timeout,
-			}
-		case "openai":
-			return &OpenAIEmbeddingService{
-				client: util.NewOpenAIClient(globalConfig.APIKey, "", globalConfig.APIBaseURL, "", "", "openai"),
-			}
-		}
+	return NewEmbeddingServiceWithContext(GetDefaultWorkspaceContext())
+}
+
+// NewEmbeddingServiceWithContext 创建向量化服务，向量化的用量计入 ctx 对应用户的预算
+func NewEmbeddingServiceWithContext(ctx *WorkspaceContext) EmbeddingService {
+	if ep := ResolveAIEndpoint(getEmbeddingServiceContext(ctx), conf.AITaskEmbedding); nil != ep {
+		return &OpenAIEmbeddingService{ctx: ctx, client: ep.OpenAIClient(), model: ep.Model, provider: ep.Provider.Name}
 	}
 
 	// 回退到用户配置
@@ -814,6 +802,7 @@ func NewEmbeddingService() EmbeddingService {
 	switch Conf.AI.Embedding.Provider {
 	case "siliconflow":
 		return &SiliconFlowEmbeddingService{
+			ctx:            ctx,
 			apiKey:         Conf.AI.Embedding.APIKey,
 			baseURL:        Conf.AI.Embedding.APIBaseURL,
 			model:          Conf.AI.Embedding.Model,
@@ -822,13 +811,24 @@ func NewEmbeddingService() EmbeddingService {
 		}
 	case "openai":
 		return &OpenAIEmbeddingService{
-			client: util.NewOpenAIClient(Conf.AI.Embedding.APIKey, "", Conf.AI.Embedding.APIBaseURL, "", "", "openai"),
+			ctx:      ctx,
+			client:   util.NewOpenAIClient(Conf.AI.Embedding.APIKey, "", Conf.AI.Embedding.APIBaseURL, "", "", "openai"),
+			model:    Conf.AI.Embedding.Model,
+			provider: Conf.AI.Embedding.Provider,
 		}
 	default:
 		return nil
 	}
 }
 
+// getEmbeddingServiceContext 返回向量化服务所属的用户上下文，没有指定时使用默认工作空间
+func getEmbeddingServiceContext(ctx *WorkspaceContext) *WorkspaceContext {
+	if nil == ctx {
+		return GetDefaultWorkspaceContext()
+	}
+	return ctx
+}
+
 // IsEnabled 检查向量化服务是否启用
 func (s *SiliconFlowEmbeddingService) IsEnabled() bool {
 	return s.apiKey != "" && s.model != ""
@@ -839,11 +839,29 @@ func (s *OpenAIEmbeddingService) IsEnabled() bool {
 	return s.client != nil
 }
 
+// ModelInfo 返回生成向量使用的服务商和模型
+func (s *SiliconFlowEmbeddingService) ModelInfo() EmbeddingModelInfo {
+	return EmbeddingModelInfo{Provider: "siliconflow", Model: s.model}
+}
+
+// ModelInfo 返回生成向量使用的服务商和模型
+func (s *OpenAIEmbeddingService) ModelInfo() EmbeddingModelInfo {
+	model := s.model
+	if "" == model {
+		model = string(openai.AdaEmbeddingV2)
+	}
+	return EmbeddingModelInfo{Provider: s.provider, Model: model}
+}
+
 // VectorizeText 向量化文本 (SiliconFlow)
 func (s *SiliconFlowEmbeddingService) VectorizeText(text string) ([]float64, error) {
 	if text == "" {
 		return nil, fmt.Errorf("文本为空")
 	}
+	ctx := getEmbeddingServiceContext(s.ctx)
+	if err := CheckAIBudget(ctx); nil != err {
+		return nil, err
+	}
 
 	// Create a custom client for SiliconFlow
 	client := openai.NewClient(s.apiKey)
@@ -860,6 +878,7 @@ func (s *SiliconFlowEmbeddingService) VectorizeText(text string) ([]float64, err
 	if err != nil {
 		return nil, fmt.Errorf("SiliconFlow向量化失败: %v", err)
 	}
+	recordAIEmbeddingUsage(ctx, "siliconflow", s.model, &resp.Usage, text)
 
 	if len(resp.Data) == 0 {
 		return nil, fmt.Errorf("未获取到向量数据")
@@ -878,14 +897,23 @@ func (s *OpenAIEmbeddingService) VectorizeText(text string) ([]float64, error) {
 	if text == "" {
 		return nil, fmt.Errorf("文本为空")
 	}
+	ctx := getEmbeddingServiceContext(s.ctx)
+	if err := CheckAIBudget(ctx); nil != err {
+		return nil, err
+	}
 
+	model := openai.AdaEmbeddingV2
+	if "" != s.model {
+		model = openai.EmbeddingModel(s.model)
+	}
 	resp, err := s.client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
-		Model: openai.AdaEmbeddingV2,
+		Model: model,
 		Input: []string{text},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("OpenAI向量化失败: %v", err)
 	}
+	recordAIEmbeddingUsage(ctx, s.provider, string(model), &resp.Usage, text)
 
 	if len(resp.Data) == 0 {
 		return nil, fmt.Errorf("未获取到向量数据")
@@ -901,7 +929,13 @@ func (s *OpenAIEmbeddingService) VectorizeText(text string) ([]float64, error) {
 
 // SemanticSearch 语义搜索
 func SemanticSearch(query string, notebookID string, limit int) ([]*BlockVector, error) {
-	embeddingService := NewEmbeddingService()
+	return SemanticSearchWithContext(GetDefaultWorkspaceContext(), query, notebookID, limit)
+}
+
+// SemanticSearchWithContext 语义搜索，查询向量化的用量计入 ctx 对应用户的预算
+func SemanticSearchWithContext(ctx *WorkspaceContext, query string, notebookID string, limit int) ([]*BlockVector, error) {
+	// 使用已存储向量所属的模型向量化查询，切换模型后在重新向量化完成前旧向量仍然可用
+	embeddingService := newQueryEmbeddingService(ctx)
 	if embeddingService == nil || !embeddingService.IsEnabled() {
 		return nil, fmt.Errorf("向量化服务未启用或未配置")
 	}
@@ -911,9 +945,10 @@ func SemanticSearch(query string, notebookID string, limit int) ([]*BlockVector,
 	if err != nil {
 		return nil, fmt.Errorf("向量化查询失败: %v", err)
 	}
+	recordEmbeddingIndexDimension(len(queryVector))
 
-	// 加载所有块向量
-	vectors, err := loadBlockVectors()
+	// 加载和查询向量属于同一模型的块向量
+	vectors, err := loadServingBlockVectors()
 	if err != nil {
 		return nil, fmt.Errorf("加载向量数据失败: %v", err)
 	}
@@ -1006,7 +1041,7 @@ func cosineSimilarity(a, b []float64) float64 {
 
 // GenerateNotebookSummary 生成笔记本摘要
 func GenerateNotebookSummary(notebookID string) (*NotebookSummary, error) {
-	if !isOpenAIAPIEnabled() {
+	if !isOpenAIAPIEnabled(GetDefaultWorkspaceContext()) {
 		return nil, fmt.Errorf("AI功能未启用")
 	}
 
@@ -1060,7 +1095,7 @@ func GenerateNotebookSummary(notebookID string) (*NotebookSummary, error) {
 
 // generateSummaryWithAI 使用AI生成摘要
 func generateSummaryWithAI(content string) (string, []string, error) {
-	client := util.NewOpenAIClient(Conf.AI.OpenAI.APIKey, Conf.AI.OpenAI.APIProxy, Conf.AI.OpenAI.APIBaseURL, Conf.AI.OpenAI.APIUserAgent, Conf.AI.OpenAI.APIVersion, Conf.AI.OpenAI.APIProvider)
+	client, _, apiModel, _, _ := getEffectiveAIConfig(GetDefaultWorkspaceContext())
 
 	prompt := `你是一个专业的内容分析师。请对以下内容进行总结，并提取主要话题。
 请用JSON格式返回，包含以下字段：
@@ -1071,7 +1106,7 @@ func generateSummaryWithAI(content string) (string, []string, error) {
 ` + content
 
 	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
-		Model: Conf.AI.OpenAI.APIModel,
+		Model: apiModel,
 		Messages: []openai.ChatCompletionMessage{
 			{
 				Role:    openai.ChatMessageRoleUser,
@@ -1127,12 +1162,16 @@ func VectorizeBlock(blockID string) error {
 		return fmt.Errorf("向量化失败: %v", err)
 	}
 
+	modelInfo := embeddingService.ModelInfo()
 	blockVector := &BlockVector{
 		ID:         blockID,
 		NotebookID: block.Box,
 		Content:    block.Content,
 		Vector:     vector,
 		UpdatedAt:  time.Now(),
+		Provider:   modelInfo.Provider,
+		Model:      modelInfo.Model,
+		Dimension:  len(vector),
 	}
 
 	return saveBlockVector(blockVector)
@@ -1144,6 +1183,16 @@ type VectorChunk struct {
 	Source  string    `json:"source"`  // 片段来源文件名
 	Content string    `json:"content"`
 	Vector  []float64 `json:"vector"`
+	Link    string    `json:"link,omitempty"` // 定位到片段位置的资源链接
+	AssetProvenance
+}
+
+// Citation 返回片段的引用描述，包含来源文件名和位置
+func (chunk *VectorChunk) Citation() string {
+	if label := chunk.Label(); "" != label {
+		return chunk.Source + " " + label
+	}
+	return chunk.Source
 }
 
 // AssetVector 资源文件向量数据
@@ -1157,6 +1206,9 @@ type AssetVector struct {
 	Chunks    []*VectorChunk         `json:"chunks"`    // 分块向量数据
 	UpdatedAt time.Time              `json:"updatedAt"` // 更新时间
 	Metadata  map[string]interface{} `json:"metadata"`  // 额外元数据
+	Provider  string                 `json:"provider,omitempty"`  // 生成向量的服务商，旧版向量为空
+	Model     string                 `json:"model,omitempty"`     // 生成向量的模型，旧版向量为空
+	Dimension int                    `json:"dimension,omitempty"` // 向量维度
 }
 
 // VectorizeAsset 向量化单个资源文件
@@ -1167,53 +1219,47 @@ func VectorizeAsset(assetPath string) (*AssetVector, error) {
 		return nil, fmt.Errorf("向量化服务未启用或未配置")
 	}
 
-	// 解析资源文件内容
-	content, err := ParseAttachment(assetPath)
+	// 解析资源文件为带位置信息的结构化片段
+	segments, err := ParseAttachmentSegments(assetPath)
 	if err != nil {
 		return nil, fmt.Errorf("解析资源文件失败: %v", err)
 	}
 
+	content := joinAssetSegments(segments)
 	if strings.TrimSpace(content) == "" || strings.Contains(content, "找到的 PDF 文件没有找到") || strings.Contains(content, "解析失败") {
 		return nil, fmt.Errorf("资源文件内容无效或解析失败，跳过向量化")
 	}
+	contentLength := 0
+	for _, segment := range segments {
+		contentLength += len([]rune(segment.Text))
+	}
 
-	// 分块逻辑：每 2000 字符一块，重叠 200 字符
-	const chunkSize = 2000
-	const overlap = 200
+	// 按页、标题、幻灯片和工作表边界分块
+	chunkSize, overlap := 2000, 200
+	if nil != Conf.AI.Embedding {
+		chunkSize, overlap = Conf.AI.Embedding.ChunkSize, Conf.AI.Embedding.ChunkOverlap
+	}
 	var chunks []*VectorChunk
-	runes := []rune(content)
-	
-	logging.LogInfof("开始分块向量化资源文件: %s, 总长度: %d", filepath.Base(assetPath), len(runes))
+	segmentChunks := chunkAssetSegments(segments, chunkSize, overlap)
 
-	for i := 0; i < len(runes); i += (chunkSize - overlap) {
-		end := i + chunkSize
-		if end > len(runes) {
-			end = len(runes)
-		}
-		
-		chunkText := string(runes[i:end])
-		if len(strings.TrimSpace(chunkText)) < 10 {
-			if end == len(runes) { break }
-			continue
-		}
+	logging.LogInfof("开始分块向量化资源文件: %s, 总长度: %d, 片段数: %d, 分块数: %d", filepath.Base(assetPath), contentLength, len(segments), len(segmentChunks))
 
+	for _, segmentChunk := range segmentChunks {
 		// 向量化文本
-		vector, err := embeddingService.VectorizeText(chunkText)
+		vector, err := embeddingService.VectorizeText(segmentChunk.Text)
 		if err != nil {
 			logging.LogErrorf("分块向量化失败 (块 %d): %v", len(chunks), err)
 			continue
 		}
 
 		chunks = append(chunks, &VectorChunk{
-			ID:      fmt.Sprintf("%s_c%d", assetPath, len(chunks)),
-			Source:  filepath.Base(assetPath),
-			Content: chunkText,
-			Vector:  vector,
+			ID:              fmt.Sprintf("%s_c%d", assetPath, len(chunks)),
+			Source:          filepath.Base(assetPath),
+			Content:         segmentChunk.Text,
+			Vector:          vector,
+			Link:            assetDeepLink(assetPath, segmentChunk.AssetProvenance),
+			AssetProvenance: segmentChunk.AssetProvenance,
 		})
-		
-		if end == len(runes) {
-			break
-		}
 	}
 
 	if len(chunks) == 0 {
@@ -1230,6 +1276,7 @@ func VectorizeAsset(assetPath string) (*AssetVector, error) {
 	fileType := strings.ToLower(strings.TrimPrefix(filepath.Ext(assetPath), "."))
 
 	// 创建资源向量对象
+	modelInfo := embeddingService.ModelInfo()
 	assetVector := &AssetVector{
 		ID:        assetID,
 		AssetPath: assetPath,
@@ -1240,10 +1287,13 @@ func VectorizeAsset(assetPath string) (*AssetVector, error) {
 		Chunks:    chunks,
 		UpdatedAt: time.Now(),
 		Metadata: map[string]interface{}{
-			"contentLength": len(content),
+			"contentLength": contentLength,
 			"chunkCount":    len(chunks),
 			"vectorDim":     len(chunks[0].Vector),
 		},
+		Provider:  modelInfo.Provider,
+		Model:     modelInfo.Model,
+		Dimension: len(chunks[0].Vector),
 	}
 
 	// 保存向量文件（与资源文件同目录）
@@ -1257,6 +1307,10 @@ func VectorizeAsset(assetPath string) (*AssetVector, error) {
 
 // saveBlockVector 保存块向量
 func saveBlockVector(blockVector *BlockVector) error {
+	blockVectorsLock.Lock()
+	defer blockVectorsLock.Unlock()
+	defer invalidateDocVectorsCache()
+
 	vectors, err := loadBlockVectors()
 	if err != nil {
 		vectors = make(map[string]*BlockVector)
@@ -1425,22 +1479,31 @@ type RerankerService struct {
 	model    string
 	apiKey   string
 	baseURL  string
+	client   *http.Client
 	enabled  bool
 }
 
+// defaultRerankModel 只配置了向量化路由时使用的重排序模型
+const defaultRerankModel = "BAAI/bge-reranker-v2-m3"
+
 // NewRerankerService 创建重排序服务实例
 func NewRerankerService() *RerankerService {
-	// 使用全局向量化配置
-	config := loadGlobalEmbeddingConfig()
-	if config == nil || !config.Enabled {
-		return &RerankerService{enabled: false}
+	ctx := GetDefaultWorkspaceContext()
+	ep := ResolveAIEndpoint(ctx, conf.AITaskRerank)
+	if nil == ep {
+		// 未配置重排序路由时复用向量化服务商
+		if ep = ResolveAIEndpoint(ctx, conf.AITaskEmbedding); nil == ep {
+			return &RerankerService{enabled: false}
+		}
+		ep.Model = defaultRerankModel
 	}
 
 	return &RerankerService{
-		provider: config.Provider,
-		model:    "BAAI/bge-reranker-v2-m3", // 固定使用重排序模型
-		apiKey:   config.APIKey,
-		baseURL:  config.APIBaseURL,
+		provider: ep.Provider.Type,
+		model:    ep.Model,
+		apiKey:   ep.Provider.APIKey,
+		baseURL:  ep.Provider.BaseURL,
+		client:   ep.HTTPClient(30 * time.Second),
 		enabled:  true,
 	}
 }
@@ -1504,8 +1567,7 @@ func (s *RerankerService) Rerank(query string, documents []string) ([]float64, e
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+s.apiKey)
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("请求失败: %v", err)
 	}
@@ -1541,12 +1603,16 @@ func SemanticSearchAssetChunksWithContext(ctx *WorkspaceContext, query string, l
 	if ctx == nil {
 		return nil, fmt.Errorf("用户上下文不能为空")
 	}
-	return SemanticSearchAssetChunks(ctx.GetDataDir(), query, limit, allowedAssets)
+	return semanticSearchAssetChunks(ctx, ctx.GetDataDir(), query, limit, allowedAssets)
 }
 
 // SemanticSearchAssetChunks 资源文件分块语义搜索（带重排序）
 func SemanticSearchAssetChunks(dataDir, query string, limit int, allowedAssets []string) ([]*VectorChunk, error) {
-	embeddingService := NewEmbeddingService()
+	return semanticSearchAssetChunks(GetDefaultWorkspaceContext(), dataDir, query, limit, allowedAssets)
+}
+
+func semanticSearchAssetChunks(ctx *WorkspaceContext, dataDir, query string, limit int, allowedAssets []string) ([]*VectorChunk, error) {
+	embeddingService := newQueryEmbeddingService(ctx)
 	if embeddingService == nil || !embeddingService.IsEnabled() {
 		return nil, fmt.Errorf("向量化服务未启用或未配置")
 	}
@@ -1556,6 +1622,8 @@ func SemanticSearchAssetChunks(dataDir, query string, limit int, allowedAssets [
 	if err != nil {
 		return nil, fmt.Errorf("向量化查询失败: %v", err)
 	}
+	recordEmbeddingIndexDimension(len(queryVector))
+	index := getEmbeddingIndex()
 
 	// 加载工作空间下所有资源向量
 	assets, err := loadAllAssetVectors(dataDir)
@@ -1576,6 +1644,10 @@ func SemanticSearchAssetChunks(dataDir, query string, limit int, allowedAssets [
 			}
 			if !found { continue }
 		}
+		// 跳过其他模型生成的向量
+		if !isServingVector(index, asset.Provider, asset.Model, len(asset.Vector)) {
+			continue
+		}
 
 		for _, chunk := range asset.Chunks {
 			sim := cosineSimilarity(queryVector, chunk.Vector)
@@ -1672,7 +1744,13 @@ func StartAssetVectorizeWorker() {
 			// 检查是否是支持的文档类型
 			ext := strings.ToLower(filepath.Ext(assetPath))
 			switch ext {
-			case ".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".md", ".txt":
+			case ".png", ".jpg", ".jpeg", ".bmp", ".gif", ".webp", ".tif", ".tiff":
+				// 图片只有完成文字识别后才能向量化
+				if "" == getAssetOCRText(assetPath) {
+					continue
+				}
+				fallthrough
+			case ".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".epub", ".md", ".txt":
 				logging.LogInfof("开始自动向量化资源文件: %s", assetPath)
 				if _, err := VectorizeAsset(assetPath); err != nil {
 					logging.LogErrorf("自动向量化失败 [%s]: %v", assetPath, err)
@@ -1802,8 +1880,10 @@ func ParseAttachmentWithContext(ctx *WorkspaceContext, assetPath string) (string
 		return parseOdt(fullPath)
 	case ".csv":
 		return parseCsv(fullPath)
+	case ".epub":
+		return parseEpub(fullPath)
 	default:
-		return "", fmt.Errorf("不支持的文件格式: %s (支持: pdf, doc, docx, xls, xlsx, pptx, txt, md, csv, rtf, odt等)", ext)
+		return "", fmt.Errorf("不支持的文件格式: %s (支持: pdf, doc, docx, xls, xlsx, pptx, epub, txt, md, csv, rtf, odt等)", ext)
 	}
 }
 
@@ -1849,8 +1929,10 @@ func ParseAttachment(assetPath string) (string, error) {
 		return parseOdt(fullPath)
 	case ".csv":
 		return parseCsv(fullPath)
+	case ".epub":
+		return parseEpub(fullPath)
 	default:
-		return "", fmt.Errorf("不支持的文件格式: %s (支持: pdf, doc, docx, xls, xlsx, pptx, txt, md, csv, rtf, odt等)", ext)
+		return "", fmt.Errorf("不支持的文件格式: %s (支持: pdf, doc, docx, xls, xlsx, pptx, epub, txt, md, csv, rtf, odt等)", ext)
 	}
 }
 
@@ -1941,8 +2023,12 @@ func parsePDF(filePath string) (string, error) {
 
 // tryOCRForPDF 尝试对PDF进行OCR
 func tryOCRForPDF(filePath string) (string, error) {
+	if content := getAssetOCRText(filePath); "" != content {
+		return content, nil
+	}
+
 	// 检查 OCR 服务是否可用
-	healthy, msg := PaddleOCRHealthCheck()
+	healthy, msg := OCRHealthCheck()
 	if !healthy {
 		return "", fmt.Errorf("OCR 服务不可用: %s", msg)
 	}
@@ -2194,8 +2280,12 @@ func GetVectorizeProgress() VectorizeProgress {
 	defer vectorizeProgressLock.RUnlock()
 	
 	progress := vectorizeProgress
-	
-	// 计算预计剩余时间
+	progress.estimateTimeLeft()
+	return progress
+}
+
+// estimateTimeLeft 根据已处理文件的平均耗时计算预计剩余时间
+func (progress *VectorizeProgress) estimateTimeLeft() {
 	if progress.IsRunning && progress.ProcessedFiles > 0 {
 		elapsed := time.Since(progress.StartTime)
 		avgTimePerFile := elapsed / time.Duration(progress.ProcessedFiles)
@@ -2210,8 +2300,6 @@ func GetVectorizeProgress() VectorizeProgress {
 			progress.EstimatedTimeLeft = fmt.Sprintf("%.1f 小时", estimatedTime.Hours())
 		}
 	}
-	
-	return progress
 }
 
 // updateVectorizeProgress 更新向量化进度
@@ -2260,7 +2348,7 @@ func BatchVectorizeAllAssets(dataDir string) {
 	}
 	
 	// 支持的文档格式
-	supportedExts := []string{".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".md", ".txt"}
+	supportedExts := []string{".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".epub", ".md", ".txt"}
 	
 	// 扫描 assets 目录
 	assetsDir := filepath.Join(dataDir, "assets")
@@ -2437,7 +2525,7 @@ func scanAndVectorizeAllUsers() {
 	}
 	
 	var allTasks []FileTask
-	supportedExts := []string{".pdf", ".doc", ".docx", ".xls", ".xlsx", ".pptx", ".md", ".txt"}
+	supportedExts := []string{".pdf", ".doc", ".docx", ".xls", ".xlsx", ".pptx", ".epub", ".md", ".txt"}
 	
 	for _, userDir := range userDirs {
 		if !userDir.IsDir() {
@@ -2552,7 +2640,7 @@ func scanAndVectorizeAllUsers() {
 // 返回: (处理数量, 成功数量, 失败数量)
 func vectorizeUserAssets(assetsDir string, username string) (int, int, int) {
 	// 支持的文档格式
-	supportedExts := []string{".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".md", ".txt"}
+	supportedExts := []string{".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".epub", ".md", ".txt"}
 	
 	processed := 0
 	success := 0
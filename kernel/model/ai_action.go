@@ -0,0 +1,404 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/88250/gulu"
+	"github.com/88250/lute"
+	"github.com/88250/lute/ast"
+	"github.com/88250/lute/parse"
+	"github.com/sashabaranov/go-openai"
+	"github.com/siyuan-note/filelock"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+	"github.com/siyuan-note/siyuan/kernel/filesys"
+	"github.com/siyuan-note/siyuan/kernel/treenode"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+const userAIActionsFileName = "ai-actions.json"
+
+var (
+	userAIActionsCache = map[string]*conf.AIActions{}
+	userAIActionsLock  = sync.Mutex{}
+)
+
+func userAIActionsPath(ctx *WorkspaceContext) string {
+	if nil == ctx || "" == ctx.GetConfDir() {
+		return filepath.Join(util.ConfDir, userAIActionsFileName)
+	}
+	return filepath.Join(ctx.GetConfDir(), userAIActionsFileName)
+}
+
+// GetAIActions 获取用户的 AI 动作库
+func GetAIActions(ctx *WorkspaceContext) (ret *conf.AIActions) {
+	p := userAIActionsPath(ctx)
+
+	userAIActionsLock.Lock()
+	defer userAIActionsLock.Unlock()
+	if ret = userAIActionsCache[p]; nil != ret {
+		return
+	}
+
+	ret = conf.NewAIActions()
+	if gulu.File.IsExist(p) {
+		data, err := filelock.ReadFile(p)
+		if nil != err {
+			logging.LogErrorf("read AI actions [%s] failed: %s", p, err)
+			return
+		}
+		if err = gulu.JSON.UnmarshalJSON(data, ret); nil != err {
+			logging.LogErrorf("unmarshal AI actions [%s] failed: %s", p, err)
+			return conf.NewAIActions()
+		}
+	}
+	userAIActionsCache[p] = ret
+	return
+}
+
+// SetAIActions 保存用户的 AI 动作库，没有 ID 的动作会分配新的 ID
+func SetAIActions(ctx *WorkspaceContext, actions *conf.AIActions) (err error) {
+	if nil == actions.Actions {
+		actions.Actions = []*conf.AIAction{}
+	}
+	problems := actions.Validate()
+	for _, action := range actions.Actions {
+		if nil == action {
+			continue
+		}
+		if _, parseErr := parseAIActionPrompt(action.Prompt); nil != parseErr {
+			problems = append(problems, fmt.Sprintf("action [%s] prompt is invalid: %s", action.Name, parseErr))
+		}
+	}
+	if 0 < len(problems) {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	for _, action := range actions.Actions {
+		if "" == action.ID {
+			action.ID = ast.NewNodeID()
+		}
+	}
+
+	data, err := gulu.JSON.MarshalIndentJSON(actions, "", "  ")
+	if nil != err {
+		return
+	}
+	p := userAIActionsPath(ctx)
+	if err = os.MkdirAll(filepath.Dir(p), 0755); nil != err {
+		return
+	}
+	if err = filelock.WriteFile(p, data); nil != err {
+		logging.LogErrorf("write AI actions [%s] failed: %s", p, err)
+		return
+	}
+
+	userAIActionsLock.Lock()
+	userAIActionsCache[p] = actions
+	userAIActionsLock.Unlock()
+	return
+}
+
+// AIActionPromptData 渲染动作提示词模板时可以使用的数据
+type AIActionPromptData struct {
+	ID        string            // 第一个选中的块的 ID
+	RootID    string            // 所在文档的 ID
+	Title     string            // 所在文档的标题
+	Content   string            // 选中的块的 Markdown
+	Selection string            // 块内选中的文本
+	Attrs     map[string]string // 第一个选中的块的属性
+}
+
+func parseAIActionPrompt(prompt string) (*template.Template, error) {
+	return template.New("").Funcs(filesys.BuiltInTemplateFuncs()).Parse(prompt)
+}
+
+func renderAIActionPrompt(prompt string, data *AIActionPromptData) (ret string, err error) {
+	tpl, err := parseAIActionPrompt(prompt)
+	if nil != err {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if err = tpl.Execute(buf, data); nil != err {
+		return
+	}
+	ret = strings.TrimSpace(buf.String())
+	return
+}
+
+// AIActionResult 执行 AI 动作的结果
+type AIActionResult struct {
+	Output       string         `json:"output"`                 // 模型的输出
+	Mode         string         `json:"mode"`                   // 输出方式
+	DocID        string         `json:"docID,omitempty"`        // 输出方式为 newDoc 时创建的文档
+	Transactions []*Transaction `json:"transactions,omitempty"` // 写入输出时执行的事务
+}
+
+// RunAIAction 在服务端执行用户的 AI 动作并按动作的输出方式写入结果，preview 为 true 时只返回模型的输出
+func RunAIAction(ctx *WorkspaceContext, actionID string, ids []string, selection string, preview bool) (ret *AIActionResult, err error) {
+	if !isOpenAIAPIEnabled(ctx) {
+		return nil, errors.New("AI not enabled")
+	}
+	action := GetAIActions(ctx).GetAction(actionID)
+	if nil == action {
+		return nil, fmt.Errorf("AI action [%s] not found", actionID)
+	}
+	if 1 > len(ids) {
+		return nil, errors.New("block ids are required")
+	}
+	for _, id := range ids {
+		if !ast.IsNodeIDPattern(id) {
+			return nil, fmt.Errorf("invalid block id [%s]", id)
+		}
+	}
+	if err = CheckAIBudget(ctx); nil != err {
+		return
+	}
+
+	FlushTxQueue()
+	tree, err := LoadTreeByBlockIDWithContext(ctx, ids[0])
+	if nil != err {
+		return
+	}
+	node := treenode.GetNodeInTree(tree, ids[0])
+	if nil == node {
+		return nil, ErrBlockNotFound
+	}
+
+	data := &AIActionPromptData{
+		ID:        ids[0],
+		RootID:    tree.ID,
+		Title:     tree.Root.IALAttr("title"),
+		Content:   strings.TrimSpace(getBlocksContent(ctx, ids)),
+		Selection: selection,
+		Attrs:     parse.IAL2Map(node.KramdownIAL),
+	}
+	prompt, err := renderAIActionPrompt(action.Prompt, data)
+	if nil != err {
+		return nil, fmt.Errorf("render prompt failed: %s", err)
+	}
+	if "" == prompt {
+		return nil, errors.New("prompt is empty")
+	}
+
+	var contextMsgs []string
+	if action.KeepContext {
+		contextMsgs = getAIConversation(ctx, tree.ID)
+	}
+	output, err := completeAIAction(ctx, action, contextMsgs, prompt)
+	if nil != err {
+		return
+	}
+	if action.KeepContext {
+		appendAIConversation(ctx, tree.ID, prompt, output)
+	}
+
+	ret = &AIActionResult{Output: output, Mode: action.Output}
+	if preview || "" == output {
+		return
+	}
+
+	switch action.Output {
+	case conf.AIActionOutputReplace, conf.AIActionOutputInsertBelow:
+		luteEngine := util.NewLute()
+		luteEngine.SetHTMLTag2TextMark(true)
+		dom := luteEngine.Md2BlockDOM(output, true)
+		ops := []*Operation{{Action: "insert", Data: dom, PreviousID: ids[len(ids)-1]}}
+		var deletedIDs []string
+		if conf.AIActionOutputReplace == action.Output {
+			deletedIDs = ids
+			for _, id := range ids {
+				ops = append(ops, &Operation{Action: "delete", ID: id})
+			}
+		}
+		undoOps, undoErr := newAIActionUndoOperations(ctx, luteEngine, tree, dom, deletedIDs)
+		if nil != undoErr {
+			return nil, undoErr
+		}
+		ret.Transactions = []*Transaction{{DoOperations: ops, UndoOperations: undoOps}}
+		performAgentTransactions(ctx, ret.Transactions)
+	case conf.AIActionOutputNewDoc:
+		// 新建文档不是块事务，撤销时删除返回的文档即可
+		title := strings.ReplaceAll(action.Name+" - "+data.Title, "/", "／")
+		ret.DocID, err = CreateWithMarkdownWithContext(ctx, "", tree.Box, path.Join(tree.HPath, title), output, tree.ID, "", false, "")
+	case conf.AIActionOutputSetAttr:
+		attrs, _ := gulu.JSON.MarshalJSON(map[string]string{action.Attr: output})
+		undoAttrs, _ := gulu.JSON.MarshalJSON(map[string]string{action.Attr: parse.IAL2MapUnEsc(node.KramdownIAL)[action.Attr]})
+		ret.Transactions = []*Transaction{{
+			DoOperations:   []*Operation{{Action: "setAttrs", ID: ids[0], Data: string(attrs)}},
+			UndoOperations: []*Operation{{Action: "setAttrs", ID: ids[0], Data: string(undoAttrs)}},
+		}}
+		performAgentTransactions(ctx, ret.Transactions)
+	}
+	return
+}
+
+// newAIActionUndoOperations 生成撤销写入输出的操作：先删除插入的块，再按原来的位置恢复被替换的块。
+// 被替换的块的子块会随父块一起恢复，不需要单独恢复
+func newAIActionUndoOperations(ctx *WorkspaceContext, luteEngine *lute.Lute, tree *parse.Tree, dom string, deletedIDs []string) (ret []*Operation, err error) {
+	subTree := luteEngine.BlockDOM2Tree(dom)
+	for n := subTree.Root.FirstChild; nil != n; n = n.Next {
+		if ast.NodeKramdownBlockIAL == n.Type || "" == n.ID {
+			continue
+		}
+		ret = append(ret, &Operation{Action: "delete", ID: n.ID})
+	}
+
+	deleted := map[string]bool{}
+	for _, id := range deletedIDs {
+		deleted[id] = true
+	}
+	for _, id := range deletedIDs {
+		t := tree
+		node := treenode.GetNodeInTree(t, id)
+		if nil == node {
+			if t, err = LoadTreeByBlockIDWithContext(ctx, id); nil != err {
+				return
+			}
+			if node = treenode.GetNodeInTree(t, id); nil == node {
+				return nil, ErrBlockNotFound
+			}
+		}
+
+		restoredWithParent := false
+		for p := node.Parent; nil != p; p = p.Parent {
+			if deleted[p.ID] {
+				restoredWithParent = true
+				break
+			}
+		}
+		if restoredWithParent {
+			continue
+		}
+
+		op := &Operation{Action: "insert", ID: id, Data: luteEngine.RenderNodeBlockDOM(node)}
+		if previous := treenode.PreviousBlock(node); nil != previous {
+			op.PreviousID = previous.ID
+		}
+		if parent := treenode.ParentBlock(node); nil != parent {
+			op.ParentID = parent.ID
+		}
+		ret = append(ret, op)
+	}
+	return
+}
+
+// completeAIAction 使用动作配置的服务商、模型和温度请求补全，未配置的部分使用对话路由
+func completeAIAction(ctx *WorkspaceContext, action *conf.AIAction, contextMsgs []string, prompt string) (ret string, err error) {
+	client, provider, apiModel, maxTokens, temperature := getEffectiveAIConfig(ctx)
+	if "" != action.Provider {
+		p := GetUserAIProviders(ctx).GetProvider(action.Provider)
+		if nil == p {
+			p = Conf.AI.Providers.GetProvider(action.Provider)
+		}
+		if nil == p {
+			return "", fmt.Errorf("AI provider [%s] not found", action.Provider)
+		}
+		ep := newAIEndpoint(conf.AITaskChat, &conf.AIRoute{Provider: p.Name, Model: action.Model}, p, false)
+		client, provider, apiModel = ep.OpenAIClient(), p.Name, ep.Model
+	} else if "" != action.Model {
+		apiModel = action.Model
+	}
+	if nil != action.Temperature {
+		temperature = *action.Temperature
+	}
+
+	// 上下文按提问和回答交替保存
+	var messages []openai.ChatCompletionMessage
+	for i, msg := range contextMsgs {
+		role := openai.ChatMessageRoleUser
+		if 1 == i%2 {
+			role = openai.ChatMessageRoleAssistant
+		}
+		messages = append(messages, openai.ChatCompletionMessage{Role: role, Content: msg})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt})
+
+	util.PushEndlessProgress("Requesting...")
+	defer util.ClearPushProgress(100)
+	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:       apiModel,
+		Messages:    messages,
+		MaxTokens:   maxTokens,
+		Temperature: float32(temperature),
+	})
+	if nil != err {
+		return
+	}
+	if 0 < len(resp.Choices) {
+		ret = strings.TrimSpace(resp.Choices[0].Message.Content)
+	}
+	recordAIChatUsage(ctx, AIFeatureAction, provider, apiModel, &resp.Usage, messages, ret)
+	if 1 > len(resp.Choices) {
+		return "", errors.New("no response from AI")
+	}
+	return
+}
+
+// aiConversations 保存每个用户在每个文档中的对话上下文，键为 用户 ID/文档 ID。
+// 不在文档中的对话（比如 /api/ai/chatGPT）文档 ID 为空
+var (
+	aiConversations     = map[string][]string{}
+	aiConversationsLock = sync.Mutex{}
+)
+
+func aiConversationKey(ctx *WorkspaceContext, rootID string) string {
+	userID := ""
+	if nil != ctx {
+		userID = ctx.UserID
+	}
+	return userID + "/" + rootID
+}
+
+func getAIConversation(ctx *WorkspaceContext, rootID string) (ret []string) {
+	aiConversationsLock.Lock()
+	defer aiConversationsLock.Unlock()
+	return append(ret, aiConversations[aiConversationKey(ctx, rootID)]...)
+}
+
+func appendAIConversation(ctx *WorkspaceContext, rootID string, msgs ...string) {
+	aiConversationsLock.Lock()
+	defer aiConversationsLock.Unlock()
+
+	key := aiConversationKey(ctx, rootID)
+	conversation := append(aiConversations[key], msgs...)
+	if maxContexts := Conf.AI.OpenAI.APIMaxContexts; 0 < maxContexts && maxContexts < len(conversation) {
+		// 保证从提问开始
+		start := len(conversation) - maxContexts
+		start += start % 2
+		conversation = conversation[start:]
+	}
+	aiConversations[key] = conversation
+}
+
+// ClearAIConversation 清空用户在文档中的对话上下文，rootID 为空时清空不在文档中的对话
+func ClearAIConversation(ctx *WorkspaceContext, rootID string) {
+	aiConversationsLock.Lock()
+	defer aiConversationsLock.Unlock()
+	delete(aiConversations, aiConversationKey(ctx, rootID))
+}
@@ -0,0 +1,636 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/88250/gulu"
+	"github.com/88250/lute"
+	"github.com/88250/lute/ast"
+	"github.com/88250/lute/parse"
+	"github.com/88250/vitess-sqlparser/sqlparser"
+	sqlparser2 "github.com/rqlite/sql"
+	"github.com/sashabaranov/go-openai"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+	"github.com/siyuan-note/siyuan/kernel/sql"
+	"github.com/siyuan-note/siyuan/kernel/treenode"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// AgentTool 智能体可调用的工具
+type AgentTool struct {
+	Name        string                 // 工具名，即 function calling 中的 function name
+	Description string                 // 提供给模型的工具说明
+	Parameters  map[string]interface{} // JSON Schema 形式的参数定义
+	Write       bool                   // 是否为写操作，写操作可以被确认或者预演
+
+	call func(ctx *WorkspaceContext, args map[string]interface{}) (ret interface{}, err error)
+}
+
+// AgentOptions 智能体单次运行选项
+type AgentOptions struct {
+	DryRun   bool     `json:"dryRun"`   // 预演模式：写操作仅返回待确认的操作，不实际执行
+	MaxSteps int      `json:"maxSteps"` // 最大工具调用轮数，0 表示使用配置值
+	Tools    []string `json:"tools"`    // 本次允许使用的工具，为空表示使用全部已授权工具
+}
+
+// AgentProposal 待用户确认的写操作
+type AgentProposal struct {
+	ID   string                 `json:"id"`
+	Tool string                 `json:"tool"`
+	Args map[string]interface{} `json:"args"`
+}
+
+// AgentStep 智能体执行的一步工具调用
+type AgentStep struct {
+	Tool     string                 `json:"tool"`
+	Args     map[string]interface{} `json:"args"`
+	Result   interface{}            `json:"result,omitempty"`
+	Err      string                 `json:"err,omitempty"`
+	Proposed bool                   `json:"proposed"`
+}
+
+// AgentResult 智能体运行结果
+type AgentResult struct {
+	Content      string           `json:"content"`
+	Steps        []*AgentStep     `json:"steps"`
+	Proposals    []*AgentProposal `json:"proposals"`
+	Transactions []*Transaction   `json:"transactions"`
+	StepLimitHit bool             `json:"stepLimitHit"`
+}
+
+var agentTools = []*AgentTool{
+	{
+		Name:        "search_fulltext",
+		Description: "Full-text search blocks in the user's notes. Returns block IDs, document paths and content snippets.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{"type": "string", "description": "Keywords to search"},
+				"limit": map[string]interface{}{"type": "integer", "description": "Max results, default 16"},
+			},
+			"required": []string{"query"},
+		},
+		call: agentSearchFulltext,
+	},
+	{
+		Name:        "query_sql",
+		Description: "Run a read-only SQL SELECT against the blocks database (tables: blocks, refs, attributes, spans, assets).",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"stmt": map[string]interface{}{"type": "string", "description": "A single SELECT statement"},
+			},
+			"required": []string{"stmt"},
+		},
+		call: agentQuerySQL,
+	},
+	{
+		Name:        "semantic_search",
+		Description: "Semantic search over vectorized attachments (PDF, Office documents, etc.). Returns the most relevant text chunks.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{"type": "string", "description": "Natural language query"},
+				"limit": map[string]interface{}{"type": "integer", "description": "Max results, default 8"},
+			},
+			"required": []string{"query"},
+		},
+		call: agentSemanticSearch,
+	},
+	{
+		Name:        "get_block_kramdown",
+		Description: "Get the Kramdown source of a block by its ID.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"id": map[string]interface{}{"type": "string", "description": "Block ID"},
+			},
+			"required": []string{"id"},
+		},
+		call: agentGetBlockKramdown,
+	},
+	{
+		Name:        "list_docs",
+		Description: "List documents under a path of a notebook. Use path \"/\" for the notebook root. Omit notebook to list notebooks.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"notebook": map[string]interface{}{"type": "string", "description": "Notebook ID"},
+				"path":     map[string]interface{}{"type": "string", "description": "Document path such as /20210808180117-6v0mkxr.sy"},
+			},
+		},
+		call: agentListDocs,
+	},
+	{
+		Name:        "create_doc",
+		Description: "Create a document with Markdown content at a human-readable path such as /Projects/Plan.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"notebook": map[string]interface{}{"type": "string", "description": "Notebook ID"},
+				"path":     map[string]interface{}{"type": "string", "description": "Human-readable document path"},
+				"markdown": map[string]interface{}{"type": "string", "description": "Document content in Markdown"},
+			},
+			"required": []string{"notebook", "path", "markdown"},
+		},
+		Write: true,
+		call:  agentCreateDoc,
+	},
+	{
+		Name:        "append_block",
+		Description: "Append Markdown content as new blocks at the end of a parent block or document.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"parentID": map[string]interface{}{"type": "string", "description": "Parent block or document ID"},
+				"markdown": map[string]interface{}{"type": "string", "description": "Content in Markdown"},
+			},
+			"required": []string{"parentID", "markdown"},
+		},
+		Write: true,
+		call:  agentAppendBlock,
+	},
+	{
+		Name:        "set_block_attrs",
+		Description: "Set custom attributes of a block. Attribute names must start with custom-; an empty value removes the attribute.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"id":    map[string]interface{}{"type": "string", "description": "Block ID"},
+				"attrs": map[string]interface{}{"type": "object", "description": "Attribute name-value pairs", "additionalProperties": map[string]interface{}{"type": "string"}},
+			},
+			"required": []string{"id", "attrs"},
+		},
+		Write: true,
+		call:  agentSetBlockAttrs,
+	},
+}
+
+// GetAgentTools 返回工具列表及其当前权限
+func GetAgentTools() (ret []map[string]interface{}) {
+	for _, tool := range agentTools {
+		ret = append(ret, map[string]interface{}{
+			"name":        tool.Name,
+			"description": tool.Description,
+			"write":       tool.Write,
+			"permission":  agentToolPermission(tool),
+		})
+	}
+	return
+}
+
+func getAgentTool(name string) *AgentTool {
+	for _, tool := range agentTools {
+		if tool.Name == name {
+			return tool
+		}
+	}
+	return nil
+}
+
+// agentToolPermission 获取工具权限，写工具默认需要确认，读工具默认允许
+func agentToolPermission(tool *AgentTool) string {
+	if nil != Conf.AI.Agent {
+		switch perm := Conf.AI.Agent.ToolPermissions[tool.Name]; perm {
+		case conf.AgentToolPermAllow, conf.AgentToolPermConfirm, conf.AgentToolPermDeny:
+			return perm
+		}
+	}
+	if tool.Write {
+		return conf.AgentToolPermConfirm
+	}
+	return conf.AgentToolPermAllow
+}
+
+// ChatWithAgent 智能体模式聊天，模型可以通过 function calling 调用工具读写笔记
+func ChatWithAgent(ctx *WorkspaceContext, messages []openai.ChatCompletionMessage, opts *AgentOptions) (ret *AgentResult, err error) {
+	if !isOpenAIAPIEnabled(ctx) {
+		return nil, fmt.Errorf("AI not enabled")
+	}
+	if nil == ctx {
+		return nil, fmt.Errorf("用户上下文不能为空")
+	}
+	if nil == opts {
+		opts = &AgentOptions{}
+	}
+
+	maxSteps := opts.MaxSteps
+	if 1 > maxSteps {
+		maxSteps = 8
+		if nil != Conf.AI.Agent && 0 < Conf.AI.Agent.MaxSteps {
+			maxSteps = Conf.AI.Agent.MaxSteps
+		}
+	}
+
+	var tools []openai.Tool
+	for _, tool := range agentTools {
+		if conf.AgentToolPermDeny == agentToolPermission(tool) {
+			continue
+		}
+		if 0 < len(opts.Tools) && !gulu.Str.Contains(tool.Name, opts.Tools) {
+			continue
+		}
+		tools = append(tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	client, provider, apiModel, maxTokens, temperature := getEffectiveAIConfig(ctx)
+
+	ret = &AgentResult{}
+	for step := 0; ; step++ {
+		// 每一轮调用前都检查配额，避免工具调用循环耗尽配额后仍继续请求
+		if err = CheckAIBudget(ctx); nil != err {
+			return nil, err
+		}
+		if step >= maxSteps {
+			// 达到步数上限后不再提供工具，让模型基于已有信息直接作答
+			ret.StepLimitHit = true
+			tools = nil
+		}
+
+		resp, chatErr := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+			Model:       apiModel,
+			Messages:    messages,
+			MaxTokens:   maxTokens,
+			Temperature: float32(temperature),
+			Tools:       tools,
+		})
+		if nil != chatErr {
+			return nil, chatErr
+		}
+		if 1 > len(resp.Choices) {
+			return nil, fmt.Errorf("no response from AI")
+		}
+
+		msg := resp.Choices[0].Message
+		recordAIChatUsage(ctx, AIFeatureAgent, provider, apiModel, &resp.Usage, messages, msg.Content+msg.ReasoningContent)
+		if 1 > len(msg.ToolCalls) || nil == tools {
+			ret.Content = msg.Content
+			return
+		}
+
+		messages = append(messages, msg)
+		for _, toolCall := range msg.ToolCalls {
+			content := runAgentToolCall(ctx, toolCall, opts.DryRun, ret)
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    content,
+				ToolCallID: toolCall.ID,
+			})
+		}
+	}
+}
+
+func runAgentToolCall(ctx *WorkspaceContext, toolCall openai.ToolCall, dryRun bool, result *AgentResult) (content string) {
+	step := &AgentStep{Tool: toolCall.Function.Name, Args: map[string]interface{}{}}
+	result.Steps = append(result.Steps, step)
+
+	tool := getAgentTool(toolCall.Function.Name)
+	if nil == tool || conf.AgentToolPermDeny == agentToolPermission(tool) {
+		step.Err = "tool not available"
+		return agentToolContent(nil, errors.New(step.Err))
+	}
+
+	if "" != strings.TrimSpace(toolCall.Function.Arguments) {
+		if err := gulu.JSON.UnmarshalJSON([]byte(toolCall.Function.Arguments), &step.Args); err != nil {
+			step.Err = "invalid arguments: " + err.Error()
+			return agentToolContent(nil, errors.New(step.Err))
+		}
+	}
+
+	if tool.Write && (dryRun || conf.AgentToolPermConfirm == agentToolPermission(tool)) {
+		proposal := &AgentProposal{ID: ast.NewNodeID(), Tool: tool.Name, Args: step.Args}
+		result.Proposals = append(result.Proposals, proposal)
+		step.Proposed = true
+		step.Result = proposal.ID
+		return agentToolContent(map[string]interface{}{
+			"status":     "pending_confirmation",
+			"proposalID": proposal.ID,
+			"note":       "The operation has been recorded and will be applied after the user confirms it.",
+		}, nil)
+	}
+
+	ret, err := tool.call(ctx, step.Args)
+	if nil != err {
+		logging.LogWarnf("agent tool [%s] failed: %s", tool.Name, err)
+		step.Err = err.Error()
+		return agentToolContent(nil, err)
+	}
+	if txs, ok := ret.([]*Transaction); ok {
+		result.Transactions = append(result.Transactions, txs...)
+		ret = map[string]interface{}{"status": "done"}
+	}
+	step.Result = ret
+	return agentToolContent(ret, nil)
+}
+
+// ApplyAgentProposals 执行用户确认后的写操作
+func ApplyAgentProposals(ctx *WorkspaceContext, proposals []*AgentProposal) (ret []*Transaction, err error) {
+	for _, proposal := range proposals {
+		tool := getAgentTool(proposal.Tool)
+		if nil == tool || !tool.Write {
+			return ret, fmt.Errorf("invalid agent tool [%s]", proposal.Tool)
+		}
+		if conf.AgentToolPermDeny == agentToolPermission(tool) {
+			return ret, fmt.Errorf("agent tool [%s] is denied", proposal.Tool)
+		}
+
+		result, callErr := tool.call(ctx, proposal.Args)
+		if nil != callErr {
+			return ret, callErr
+		}
+		if txs, ok := result.([]*Transaction); ok {
+			ret = append(ret, txs...)
+		}
+	}
+	return
+}
+
+func agentToolContent(data interface{}, err error) string {
+	if nil != err {
+		data = map[string]interface{}{"error": err.Error()}
+	}
+	buf, _ := gulu.JSON.MarshalJSON(data)
+	content := string(buf)
+	// 工具结果会作为上下文回传给模型，避免单次结果过大
+	const maxLen = 16 * 1024
+	if maxLen < len(content) {
+		content = gulu.Str.SubStr(content, maxLen) + "...(truncated)"
+	}
+	return content
+}
+
+func agentArgString(args map[string]interface{}, name string) string {
+	if v, ok := args[name].(string); ok {
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+func agentSearchFulltext(ctx *WorkspaceContext, args map[string]interface{}) (ret interface{}, err error) {
+	query := agentArgString(args, "query")
+	if "" == query {
+		return nil, errors.New("query is required")
+	}
+	limit := 16
+	if l, ok := args["limit"].(float64); ok && 0 < l && 64 >= l {
+		limit = int(l)
+	}
+
+	blocks, _, _, _, _ := FullTextSearchBlockWithContext(ctx, query, nil, nil, nil, 0, 0, 0, 1, limit)
+	var items []map[string]interface{}
+	for _, b := range blocks {
+		items = append(items, map[string]interface{}{
+			"id":      b.ID,
+			"rootID":  b.RootID,
+			"box":     b.Box,
+			"hPath":   b.HPath,
+			"type":    b.Type,
+			"content": util.RemoveInvalid(b.Content),
+		})
+	}
+	return items, nil
+}
+
+func agentQuerySQL(ctx *WorkspaceContext, args map[string]interface{}) (ret interface{}, err error) {
+	stmt := agentArgString(args, "stmt")
+	stmt = strings.TrimSpace(strings.TrimSuffix(stmt, ";"))
+	if strings.Contains(stmt, ";") {
+		return nil, errors.New("only a single statement is allowed")
+	}
+	if !isAgentSelectStmt(stmt) {
+		return nil, errors.New("only SELECT statements are allowed")
+	}
+	return sql.QueryWithContext(ctx, stmt, 64)
+}
+
+// isAgentSelectStmt 解析语句并判断是否为单条查询语句，无法解析的语句一律视为非查询语句。
+func isAgentSelectStmt(stmt string) bool {
+	// 和 sql.Query 一样先使用支持 WITH 和 || 的解析器，无法解析时再使用支持 UNION 的解析器
+	parsedStmt, err := sqlparser2.NewParser(strings.NewReader(stmt)).ParseStatement()
+	if nil == err {
+		_, ok := parsedStmt.(*sqlparser2.SelectStatement)
+		return ok
+	}
+
+	parsedStmt2, err := sqlparser.Parse(stmt)
+	if nil != err {
+		return false
+	}
+	switch parsedStmt2.(type) {
+	case *sqlparser.Select, *sqlparser.Union:
+		return true
+	}
+	return false
+}
+
+func agentSemanticSearch(ctx *WorkspaceContext, args map[string]interface{}) (ret interface{}, err error) {
+	query := agentArgString(args, "query")
+	if "" == query {
+		return nil, errors.New("query is required")
+	}
+	limit := 8
+	if l, ok := args["limit"].(float64); ok && 0 < l && 32 >= l {
+		limit = int(l)
+	}
+
+	chunks, err := SemanticSearchAssetChunksWithContext(ctx, query, limit, nil)
+	if nil != err {
+		return nil, err
+	}
+	var items []map[string]interface{}
+	for _, chunk := range chunks {
+		items = append(items, map[string]interface{}{
+			"id":       chunk.ID,
+			"source":   chunk.Source,
+			"location": chunk.Label(),
+			"link":     chunk.Link,
+			"content":  chunk.Content,
+		})
+	}
+	return items, nil
+}
+
+func agentGetBlockKramdown(ctx *WorkspaceContext, args map[string]interface{}) (ret interface{}, err error) {
+	id := agentArgString(args, "id")
+	if !ast.IsNodeIDPattern(id) {
+		return nil, errors.New("invalid block id")
+	}
+	kramdown := GetBlockKramdownWithContext(ctx, id, "md")
+	if "" == kramdown {
+		return nil, ErrBlockNotFound
+	}
+	return map[string]interface{}{"id": id, "kramdown": kramdown}, nil
+}
+
+func agentListDocs(ctx *WorkspaceContext, args map[string]interface{}) (ret interface{}, err error) {
+	boxID := agentArgString(args, "notebook")
+	if "" == boxID {
+		var boxes []map[string]interface{}
+		for _, box := range Conf.GetOpenedBoxesWithContext(ctx) {
+			boxes = append(boxes, map[string]interface{}{"id": box.ID, "name": box.Name})
+		}
+		return boxes, nil
+	}
+
+	p := agentArgString(args, "path")
+	if "" == p {
+		p = "/"
+	}
+	files, _, err := ListDocTree(ctx, boxID, p, util.SortModeUnassigned, false, false, Conf.FileTree.MaxListCount)
+	if nil != err {
+		return nil, err
+	}
+	var docs []map[string]interface{}
+	for _, file := range files {
+		docs = append(docs, map[string]interface{}{
+			"id":           file.ID,
+			"title":        strings.TrimSuffix(file.Name, ".sy"),
+			"path":         file.Path,
+			"subFileCount": file.SubFileCount,
+		})
+	}
+	return docs, nil
+}
+
+func agentCreateDoc(ctx *WorkspaceContext, args map[string]interface{}) (ret interface{}, err error) {
+	boxID := agentArgString(args, "notebook")
+	hPath := agentArgString(args, "path")
+	md := agentArgString(args, "markdown")
+	if "" == boxID || "" == hPath {
+		return nil, errors.New("notebook and path are required")
+	}
+	if !strings.HasPrefix(hPath, "/") {
+		hPath = "/" + hPath
+	}
+	hPath = path.Clean(hPath)
+
+	// 创建文档时内部通过 create 事务写入，与编辑器创建文档一致
+	id, err := CreateWithMarkdownWithContext(ctx, "", boxID, hPath, md, "", "", false, "")
+	if nil != err {
+		return nil, err
+	}
+	return map[string]interface{}{"id": id, "hPath": hPath}, nil
+}
+
+func agentAppendBlock(ctx *WorkspaceContext, args map[string]interface{}) (ret interface{}, err error) {
+	parentID := agentArgString(args, "parentID")
+	md := agentArgString(args, "markdown")
+	if !ast.IsNodeIDPattern(parentID) {
+		return nil, errors.New("invalid parent id")
+	}
+	if "" == md {
+		return nil, errors.New("markdown is required")
+	}
+
+	luteEngine := util.NewLute()
+	luteEngine.SetHTMLTag2TextMark(true)
+	dom := luteEngine.Md2BlockDOM(md, true)
+	transactions := []*Transaction{newAppendInsertTransaction(luteEngine, dom, parentID)}
+	performAgentTransactions(ctx, transactions)
+	return transactions, nil
+}
+
+// newAppendInsertTransaction 生成在父块末尾插入块的事务，撤销时删除插入的块。
+func newAppendInsertTransaction(luteEngine *lute.Lute, dom, parentID string) (ret *Transaction) {
+	ret = &Transaction{DoOperations: []*Operation{{Action: "appendInsert", Data: dom, ParentID: parentID}}}
+	subTree := luteEngine.BlockDOM2Tree(dom)
+	for n := subTree.Root.FirstChild; nil != n; n = n.Next {
+		if ast.NodeKramdownBlockIAL == n.Type || "" == n.ID {
+			continue
+		}
+		ret.UndoOperations = append(ret.UndoOperations, &Operation{Action: "delete", ID: n.ID})
+	}
+	return
+}
+
+func agentSetBlockAttrs(ctx *WorkspaceContext, args map[string]interface{}) (ret interface{}, err error) {
+	id := agentArgString(args, "id")
+	if !ast.IsNodeIDPattern(id) {
+		return nil, errors.New("invalid block id")
+	}
+	attrsArg, _ := args["attrs"].(map[string]interface{})
+	if 1 > len(attrsArg) {
+		return nil, errors.New("attrs is required")
+	}
+
+	attrs := map[string]string{}
+	for name, value := range attrsArg {
+		if !strings.HasPrefix(name, "custom-") {
+			return nil, fmt.Errorf("attribute [%s] must start with custom-", name)
+		}
+		attrs[name] = fmt.Sprint(value)
+		if nil == value {
+			attrs[name] = ""
+		}
+	}
+
+	tree, err := LoadTreeByBlockIDWithContext(ctx, id)
+	if nil != err {
+		return nil, err
+	}
+	node := treenode.GetNodeInTree(tree, id)
+	if nil == node {
+		return nil, ErrBlockNotFound
+	}
+	ial := parse.IAL2MapUnEsc(node.KramdownIAL)
+	oldAttrs := map[string]string{}
+	for name := range attrs {
+		oldAttrs[name] = ial[name]
+	}
+
+	data, _ := gulu.JSON.MarshalJSON(attrs)
+	undoData, _ := gulu.JSON.MarshalJSON(oldAttrs)
+	transactions := []*Transaction{
+		{
+			DoOperations:   []*Operation{{Action: "setAttrs", ID: id, Data: string(data)}},
+			UndoOperations: []*Operation{{Action: "setAttrs", ID: id, Data: string(undoData)}},
+		},
+	}
+	performAgentTransactions(ctx, transactions)
+	return transactions, nil
+}
+
+// performAgentTransactions 执行事务并等待完成，返回第一个执行失败的事务的错误。
+func performAgentTransactions(ctx *WorkspaceContext, transactions []*Transaction) (err error) {
+	PerformTransactionsWithContext(ctx, &transactions)
+	FlushTxQueue()
+
+	evt := util.NewCmdResult("transactions", 0, util.PushModeBroadcast)
+	evt.Data = transactions
+	util.PushEvent(evt)
+
+	for _, tx := range transactions {
+		if err = tx.Err(); nil != err {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,359 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/gorilla/websocket"
+	"github.com/sashabaranov/go-openai"
+	"github.com/siyuan-note/filelock"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// AIEndpoint 按任务路由解析得到的服务端点
+type AIEndpoint struct {
+	Task         string           `json:"task"`
+	Provider     *conf.AIProvider `json:"provider"`
+	Model        string           `json:"model"`
+	Temperature  float64          `json:"temperature"`
+	MaxTokens    int              `json:"maxTokens"`
+	UserOverride bool             `json:"userOverride"` // 是否来自用户的路由覆盖
+}
+
+// Timeout 请求超时，未配置时使用 fallback
+func (ep *AIEndpoint) Timeout(fallback time.Duration) time.Duration {
+	if 0 < ep.Provider.Timeout {
+		return time.Duration(ep.Provider.Timeout) * time.Second
+	}
+	return fallback
+}
+
+func (ep *AIEndpoint) retryPolicy() (maxAttempts int, backoff time.Duration) {
+	maxAttempts, backoff = 1, 0
+	if retry := ep.Provider.Retry; nil != retry && 1 < retry.MaxAttempts {
+		maxAttempts, backoff = retry.MaxAttempts, time.Duration(retry.Backoff)*time.Millisecond
+	}
+	return
+}
+
+// OpenAIClient 创建 OpenAI 兼容客户端
+func (ep *AIEndpoint) OpenAIClient() *openai.Client {
+	maxAttempts, backoff := ep.retryPolicy()
+	return util.NewOpenAIClientWithRetry(ep.Provider.APIKey, ep.Provider.Proxy, ep.Provider.BaseURL, util.UserAgent, ep.Provider.APIVersion, ep.Provider.Type,
+		ep.Timeout(0), maxAttempts, backoff)
+}
+
+// HTTPClient 创建普通 HTTP 客户端，用于重排序、OCR 等非 OpenAI 接口
+func (ep *AIEndpoint) HTTPClient(fallbackTimeout time.Duration) *http.Client {
+	maxAttempts, backoff := ep.retryPolicy()
+	return util.NewRetryHTTPClient(ep.Provider.Proxy, ep.Timeout(fallbackTimeout), maxAttempts, backoff)
+}
+
+// ResolveAIEndpoint 解析任务使用的服务端点，优先使用用户的路由覆盖，其次使用全局路由，都没有配置时返回 nil
+func ResolveAIEndpoint(ctx *WorkspaceContext, task string) (ret *AIEndpoint) {
+	if ret = resolveAIEndpoint(ctx, task); nil == ret && conf.AITaskMeeting == task {
+		if ret = resolveAIEndpoint(ctx, conf.AITaskChat); nil != ret {
+			ret.Task = task
+		}
+	}
+	return
+}
+
+func resolveAIEndpoint(ctx *WorkspaceContext, task string) *AIEndpoint {
+	global := Conf.AI.Providers
+	if user := getUserAIProviders(ctx); nil != user {
+		if route := user.GetRoute(task); nil != route {
+			provider := user.GetProvider(route.Provider)
+			if nil == provider {
+				provider = global.GetProvider(route.Provider)
+			}
+			if nil != provider {
+				return newAIEndpoint(task, route, provider, true)
+			}
+			logging.LogWarnf("AI route [%s] of user [%s] references missing provider [%s]", task, ctx.Username, route.Provider)
+		}
+	}
+
+	if route := global.GetRoute(task); nil != route {
+		if provider := global.GetProvider(route.Provider); nil != provider {
+			return newAIEndpoint(task, route, provider, false)
+		}
+		logging.LogWarnf("AI route [%s] references missing provider [%s]", task, route.Provider)
+	}
+	return nil
+}
+
+func newAIEndpoint(task string, route *conf.AIRoute, provider *conf.AIProvider, userOverride bool) *AIEndpoint {
+	ret := &AIEndpoint{Task: task, Provider: provider, Model: route.Model, Temperature: route.Temperature, MaxTokens: route.MaxTokens, UserOverride: userOverride}
+	if "" == ret.Model && 0 < len(provider.Models) {
+		ret.Model = provider.Models[0]
+	}
+	return ret
+}
+
+const userAIProvidersFileName = "ai-providers.json"
+
+var (
+	userAIProvidersCache = map[string]*conf.AIProviders{}
+	userAIProvidersLock  = sync.Mutex{}
+)
+
+func userAIProvidersPath(ctx *WorkspaceContext) string {
+	// 只有 Web 模式下的用户有独立的配置目录，默认工作空间直接使用全局配置
+	if nil == ctx || "" == ctx.UserID || "" == ctx.GetConfDir() || util.ConfDir == ctx.GetConfDir() {
+		return ""
+	}
+	return filepath.Join(ctx.GetConfDir(), userAIProvidersFileName)
+}
+
+func getUserAIProviders(ctx *WorkspaceContext) *conf.AIProviders {
+	p := userAIProvidersPath(ctx)
+	if "" == p {
+		return nil
+	}
+
+	userAIProvidersLock.Lock()
+	defer userAIProvidersLock.Unlock()
+	if ret, ok := userAIProvidersCache[p]; ok {
+		return ret
+	}
+
+	var ret *conf.AIProviders
+	if gulu.File.IsExist(p) {
+		data, err := filelock.ReadFile(p)
+		if nil != err {
+			logging.LogErrorf("read AI providers [%s] failed: %s", p, err)
+			return nil
+		}
+		ret = conf.NewAIProviders()
+		if err = gulu.JSON.UnmarshalJSON(data, ret); nil != err {
+			logging.LogErrorf("unmarshal AI providers [%s] failed: %s", p, err)
+			return nil
+		}
+	}
+	userAIProvidersCache[p] = ret
+	return ret
+}
+
+// GetUserAIProviders 获取用户的服务商和路由覆盖
+func GetUserAIProviders(ctx *WorkspaceContext) (ret *conf.AIProviders) {
+	if ret = getUserAIProviders(ctx); nil == ret {
+		ret = conf.NewAIProviders()
+	}
+	return
+}
+
+// SetUserAIProviders 保存用户的服务商和路由覆盖
+func SetUserAIProviders(ctx *WorkspaceContext, providers *conf.AIProviders) (err error) {
+	p := userAIProvidersPath(ctx)
+	if "" == p {
+		return errors.New("user AI providers are only available in web mode")
+	}
+	if problems := providers.Validate(Conf.AI.Providers); 0 < len(problems) {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	data, err := gulu.JSON.MarshalIndentJSON(providers, "", "  ")
+	if nil != err {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0755); nil != err {
+		return
+	}
+	if err = filelock.WriteFile(p, data); nil != err {
+		logging.LogErrorf("write AI providers [%s] failed: %s", p, err)
+		return
+	}
+
+	userAIProvidersLock.Lock()
+	userAIProvidersCache[p] = providers
+	userAIProvidersLock.Unlock()
+	return
+}
+
+// SetAIProviders 保存全局服务商注册表和路由
+func SetAIProviders(providers *conf.AIProviders) (err error) {
+	if nil == providers.Routes {
+		providers.Routes = map[string]*conf.AIRoute{}
+	}
+	if problems := providers.Validate(nil); 0 < len(problems) {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	// 路由可能切换了向量化模型，先记录旧向量所属的模型
+	EnsureEmbeddingIndex()
+	Conf.AI.Providers = providers
+	Conf.Save()
+	SwitchEmptyEmbeddingIndex(util.DataDir)
+	return
+}
+
+// TestAIEndpoint 测试服务端点是否可用，返回耗时
+func TestAIEndpoint(ep *AIEndpoint) (elapsed time.Duration, err error) {
+	start := time.Now()
+	defer func() { elapsed = time.Since(start) }()
+
+	timeout := ep.Timeout(15 * time.Second)
+	c, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch ep.Provider.Type {
+	case conf.AIProviderTypeFunASR:
+		dialer := websocket.Dialer{HandshakeTimeout: timeout}
+		conn, _, dialErr := dialer.DialContext(c, toWebSocketURL(ep.Provider.BaseURL), nil)
+		if nil != dialErr {
+			return 0, dialErr
+		}
+		conn.Close()
+	case conf.AIProviderTypeUmiOCR:
+		resp, getErr := ep.HTTPClient(timeout).Get(strings.TrimSuffix(ep.Provider.BaseURL, "/") + "/")
+		if nil != getErr {
+			return 0, getErr
+		}
+		resp.Body.Close()
+		if http.StatusOK != resp.StatusCode {
+			return 0, fmt.Errorf("status %d", resp.StatusCode)
+		}
+	case conf.AIProviderTypeHTTPASR:
+		// 通用接口没有约定探活地址，能连上即可
+		resp, getErr := ep.HTTPClient(timeout).Get(ep.Provider.BaseURL)
+		if nil != getErr {
+			return 0, getErr
+		}
+		resp.Body.Close()
+	default:
+		switch ep.Task {
+		case conf.AITaskEmbedding:
+			_, err = ep.OpenAIClient().CreateEmbeddings(c, openai.EmbeddingRequest{Model: openai.EmbeddingModel(ep.Model), Input: []string{"ping"}})
+		case conf.AITaskChat, conf.AITaskMeeting:
+			_, err = ep.OpenAIClient().CreateChatCompletion(c, openai.ChatCompletionRequest{
+				Model:     ep.Model,
+				Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "ping"}},
+				MaxTokens: 1,
+			})
+		default:
+			_, err = ep.OpenAIClient().ListModels(c)
+		}
+	}
+	return
+}
+
+// toWebSocketURL 将 http(s) 地址转换为 ws(s) 地址，并补全末尾斜杠
+func toWebSocketURL(endpoint string) (ret string) {
+	switch {
+	case strings.HasPrefix(endpoint, "ws://"), strings.HasPrefix(endpoint, "wss://"):
+		ret = endpoint
+	case strings.HasPrefix(endpoint, "http://"):
+		ret = "ws://" + strings.TrimPrefix(endpoint, "http://")
+	case strings.HasPrefix(endpoint, "https://"):
+		ret = "wss://" + strings.TrimPrefix(endpoint, "https://")
+	default:
+		ret = "ws://" + endpoint
+	}
+	if !strings.HasSuffix(ret, "/") {
+		ret += "/"
+	}
+	return
+}
+
+// 旧版本从固定路径的 JSON 文件读取内置服务配置，首次启动时导入到注册表中，之后只使用注册表
+const legacyAIConfigDir = "/root/code/unified-settings-service/config"
+const legacyOCRConfigPath = "/root/code/neu-siyuan-note/config/ocr-config.json"
+
+func importLegacyAIProviders(providers *conf.AIProviders) {
+	dir := legacyAIConfigDir
+	if envDir := os.Getenv("SIYUAN_LEGACY_AI_CONFIG_DIR"); "" != envDir {
+		dir = envDir
+	}
+
+	readLegacy := func(p string, v interface{}) bool {
+		data, err := os.ReadFile(p)
+		if nil != err {
+			return false
+		}
+		if err = json.Unmarshal(data, v); nil != err {
+			logging.LogWarnf("parse legacy AI config [%s] failed: %s", p, err)
+			return false
+		}
+		return true
+	}
+
+	var models map[string]DefaultModelConfig
+	if readLegacy(filepath.Join(dir, "default-models.json"), &models) {
+		for _, key := range []string{"builtin_free_siyuan", "builtin_free_neuralink", "builtin_free"} {
+			if m, ok := models[key]; ok && "" != m.APIKey {
+				addLegacyAIProvider(providers, conf.AITaskChat, key, conf.AIProviderTypeOpenAI, m.BaseURL, m.APIKey, m.ModelName, m.Temperature, m.MaxTokens)
+				break
+			}
+		}
+		if m, ok := models["builtin_free_meeting"]; ok {
+			addLegacyAIProvider(providers, conf.AITaskMeeting, "builtin_free_meeting", conf.AIProviderTypeOpenAI, m.BaseURL, m.APIKey, m.ModelName, m.Temperature, m.MaxTokens)
+		}
+	}
+
+	embedding := &GlobalEmbeddingConfig{}
+	if readLegacy(filepath.Join(dir, "embedding-config.json"), embedding) && embedding.Enabled && "" != embedding.APIKey {
+		typ := conf.AIProviderTypeOpenAI
+		if conf.AIProviderTypeSiliconFlow == embedding.Provider {
+			typ = conf.AIProviderTypeSiliconFlow
+		}
+		p := addLegacyAIProvider(providers, conf.AITaskEmbedding, "builtin_embedding", typ, embedding.APIBaseURL, embedding.APIKey, embedding.Model, 0, 0)
+		p.Timeout = embedding.Timeout
+		// 旧版本重排序复用向量化服务的密钥，固定使用 bge-reranker-v2-m3
+		p.Models = append(p.Models, defaultRerankModel)
+		providers.Routes[conf.AITaskRerank] = &conf.AIRoute{Provider: p.Name, Model: defaultRerankModel}
+	}
+
+	asr := &struct {
+		Endpoint string `json:"endpoint"`
+	}{}
+	if readLegacy(filepath.Join(dir, "asr-config.json"), asr) && "" != asr.Endpoint {
+		addLegacyAIProvider(providers, conf.AITaskASR, "builtin_asr", conf.AIProviderTypeFunASR, toWebSocketURL(asr.Endpoint), "", "", 0, 0)
+	}
+
+	ocr := &PaddleOCRConfig{}
+	if readLegacy(legacyOCRConfigPath, ocr) && ocr.Enabled && "" != ocr.BaseURL {
+		addLegacyAIProvider(providers, conf.AITaskOCR, "builtin_ocr", conf.AIProviderTypeUmiOCR, ocr.BaseURL, "", "", 0, 0)
+	}
+
+	if 0 < len(providers.Providers) {
+		logging.LogInfof("imported [%d] legacy AI providers", len(providers.Providers))
+	}
+}
+
+func addLegacyAIProvider(providers *conf.AIProviders, task, name, typ, baseURL, apiKey, model string, temperature float64, maxTokens int) *conf.AIProvider {
+	provider := &conf.AIProvider{Name: name, Type: typ, BaseURL: baseURL, APIKey: apiKey, Timeout: 60}
+	if "" != model {
+		provider.Models = []string{model}
+	}
+	providers.Providers = append(providers.Providers, provider)
+	providers.Routes[task] = &conf.AIRoute{Provider: name, Model: model, Temperature: temperature, MaxTokens: maxTokens}
+	return provider
+}
@@ -0,0 +1,375 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// AI 用量统计的功能分类
+const (
+	AIFeatureChat       = "chat"       // 对话
+	AIFeatureChatStream = "chatStream" // 流式对话
+	AIFeatureChatGPT    = "chatGPT"    // 编辑器内续写
+	AIFeatureAction     = "action"     // 编辑器内块操作
+	AIFeatureAgent      = "agent"      // 工具调用智能体
+	AIFeatureOpenAI     = "openai"     // OpenAI 兼容接口
+	AIFeatureEmbedding  = "embedding"  // 向量化
+	AIFeatureMeeting    = "meeting"    // 会议纪要
+	AIFeatureFlashcard  = "flashcard"  // 闪卡生成
+	AIFeatureAVExtract  = "avExtract"  // 数据库属性提取
+)
+
+var ErrAIBudgetExceeded = errors.New("AI token budget exceeded")
+
+// AIUsageRecord 一次 AI 调用的用量
+type AIUsageRecord struct {
+	Time             int64  `json:"time"` // 毫秒时间戳
+	UserID           string `json:"userID"`
+	Username         string `json:"username"`
+	Feature          string `json:"feature"`
+	Provider         string `json:"provider"`
+	Model            string `json:"model"`
+	PromptTokens     int    `json:"promptTokens"`
+	CompletionTokens int    `json:"completionTokens"`
+	EmbeddingTokens  int    `json:"embeddingTokens"`
+	TotalTokens      int    `json:"totalTokens"`
+	Estimated        bool   `json:"estimated"` // 上游没有返回用量时按文本长度估算
+}
+
+// aiUsageCounter 工作空间当天和当月的用量累计，用于配额检查
+type aiUsageCounter struct {
+	day         string
+	dayTokens   int64
+	month       string
+	monthTokens int64
+	monthLoaded bool
+	dayByUser   map[string]int64
+	monthByUser map[string]int64
+}
+
+var (
+	aiUsageCounters = map[string]*aiUsageCounter{}
+	aiUsageLock     = sync.Mutex{}
+)
+
+func aiUsageDir(ctx *WorkspaceContext) string {
+	return filepath.Join(ctx.GetDataDir(), "storage", "ai-usage")
+}
+
+// aiUsageUserKey 用量和配额都按用户名统计，和配置中的配额对应
+func aiUsageUserKey(ctx *WorkspaceContext) string {
+	return ctx.Username
+}
+
+// getAIUsageCounter 获取工作空间的用量累计，跨天或者跨月时重置，调用方需要持有 aiUsageLock
+func getAIUsageCounter(ctx *WorkspaceContext, now time.Time) *aiUsageCounter {
+	dir := aiUsageDir(ctx)
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	counter := aiUsageCounters[dir]
+	if nil == counter || counter.month != month {
+		counter = &aiUsageCounter{month: month, monthByUser: map[string]int64{}}
+		aiUsageCounters[dir] = counter
+	}
+	if counter.day != day {
+		counter.day = day
+		counter.dayTokens = 0
+		counter.dayByUser = map[string]int64{}
+		counter.monthLoaded = false
+	}
+	if !counter.monthLoaded {
+		counter.monthTokens = 0
+		counter.monthByUser = map[string]int64{}
+		for _, record := range readAIUsageRecords(dir, month) {
+			key := record.Username
+			counter.monthTokens += int64(record.TotalTokens)
+			counter.monthByUser[key] += int64(record.TotalTokens)
+			if time.UnixMilli(record.Time).Format("2006-01-02") == day {
+				counter.dayTokens += int64(record.TotalTokens)
+				counter.dayByUser[key] += int64(record.TotalTokens)
+			}
+		}
+		counter.monthLoaded = true
+	}
+	return counter
+}
+
+// CheckAIBudget 检查用户是否还有可用配额
+func CheckAIBudget(ctx *WorkspaceContext) error {
+	if nil == ctx {
+		ctx = GetDefaultWorkspaceContext()
+	}
+	key := aiUsageUserKey(ctx)
+	budget := Conf.AI.Usage.GetBudget(key)
+	if nil == budget || (1 > budget.Daily && 1 > budget.Monthly) {
+		return nil
+	}
+
+	aiUsageLock.Lock()
+	defer aiUsageLock.Unlock()
+	counter := getAIUsageCounter(ctx, time.Now())
+	if 0 < budget.Daily && budget.Daily <= counter.dayByUser[key] {
+		return ErrAIBudgetExceeded
+	}
+	if 0 < budget.Monthly && budget.Monthly <= counter.monthByUser[key] {
+		return ErrAIBudgetExceeded
+	}
+	return nil
+}
+
+// GetAIUsageStatus 获取用户当天和当月的用量以及配额
+func GetAIUsageStatus(ctx *WorkspaceContext) map[string]interface{} {
+	aiUsageLock.Lock()
+	counter := getAIUsageCounter(ctx, time.Now())
+	key := aiUsageUserKey(ctx)
+	daily, monthly := counter.dayByUser[key], counter.monthByUser[key]
+	aiUsageLock.Unlock()
+
+	budget := Conf.AI.Usage.GetBudget(key)
+	if nil == budget {
+		budget = &conf.AIBudget{}
+	}
+	return map[string]interface{}{
+		"daily":   map[string]int64{"used": daily, "limit": budget.Daily},
+		"monthly": map[string]int64{"used": monthly, "limit": budget.Monthly},
+	}
+}
+
+// SetAIBudget 设置用户的配额，username 为空时设置默认配额
+func SetAIBudget(username string, budget *conf.AIBudget) {
+	if 0 > budget.Daily {
+		budget.Daily = 0
+	}
+	if 0 > budget.Monthly {
+		budget.Monthly = 0
+	}
+
+	if "" == username {
+		Conf.AI.Usage.DefaultBudget = budget
+	} else if 1 > budget.Daily && 1 > budget.Monthly {
+		delete(Conf.AI.Usage.Budgets, username)
+	} else {
+		Conf.AI.Usage.Budgets[username] = budget
+	}
+	Conf.Save()
+}
+
+// recordAIChatUsage 记录对话用量，上游没有返回用量时按消息长度估算
+func recordAIChatUsage(ctx *WorkspaceContext, feature, provider, model string, usage *openai.Usage, messages []openai.ChatCompletionMessage, completion string) {
+	record := &AIUsageRecord{Feature: feature, Provider: provider, Model: model}
+	if nil != usage && 0 < usage.TotalTokens {
+		record.PromptTokens, record.CompletionTokens = usage.PromptTokens, usage.CompletionTokens
+	} else {
+		for _, msg := range messages {
+			record.PromptTokens += estimateTokens(msg.Content)
+		}
+		record.CompletionTokens = estimateTokens(completion)
+		record.Estimated = true
+	}
+	record.TotalTokens = record.PromptTokens + record.CompletionTokens
+	recordAIUsage(ctx, record)
+}
+
+// recordAIEmbeddingUsage 记录向量化用量
+func recordAIEmbeddingUsage(ctx *WorkspaceContext, provider, model string, usage *openai.Usage, input string) {
+	record := &AIUsageRecord{Feature: AIFeatureEmbedding, Provider: provider, Model: model}
+	if nil != usage && 0 < usage.PromptTokens {
+		record.EmbeddingTokens = usage.PromptTokens
+	} else {
+		record.EmbeddingTokens = estimateTokens(input)
+		record.Estimated = true
+	}
+	record.TotalTokens = record.EmbeddingTokens
+	recordAIUsage(ctx, record)
+}
+
+func recordAIUsage(ctx *WorkspaceContext, record *AIUsageRecord) {
+	if nil == ctx {
+		ctx = GetDefaultWorkspaceContext()
+	}
+
+	now := time.Now()
+	record.Time = now.UnixMilli()
+	record.UserID, record.Username = ctx.UserID, ctx.Username
+
+	data, err := json.Marshal(record)
+	if nil != err {
+		return
+	}
+
+	aiUsageLock.Lock()
+	defer aiUsageLock.Unlock()
+
+	// 先加载累计再写入，避免首次加载时把本条记录重复计入
+	counter := getAIUsageCounter(ctx, now)
+	dir := aiUsageDir(ctx)
+	if err = os.MkdirAll(dir, 0755); nil != err {
+		logging.LogErrorf("create AI usage dir [%s] failed: %s", dir, err)
+		return
+	}
+	p := filepath.Join(dir, now.Format("2006-01")+".jsonl")
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if nil != err {
+		logging.LogErrorf("open AI usage [%s] failed: %s", p, err)
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(append(data, '\n')); nil != err {
+		logging.LogErrorf("write AI usage [%s] failed: %s", p, err)
+		return
+	}
+
+	key := aiUsageUserKey(ctx)
+	counter.dayTokens += int64(record.TotalTokens)
+	counter.monthTokens += int64(record.TotalTokens)
+	counter.dayByUser[key] += int64(record.TotalTokens)
+	counter.monthByUser[key] += int64(record.TotalTokens)
+}
+
+func readAIUsageRecords(dir, month string) (ret []*AIUsageRecord) {
+	p := filepath.Join(dir, month+".jsonl")
+	f, err := os.Open(p)
+	if nil != err {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		record := &AIUsageRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), record); nil != err {
+			continue
+		}
+		ret = append(ret, record)
+	}
+	return
+}
+
+// AIUsageReportRow 用量报表的一行
+type AIUsageReportRow struct {
+	Day              string `json:"day,omitempty"`
+	Model            string `json:"model,omitempty"`
+	Feature          string `json:"feature,omitempty"`
+	Provider         string `json:"provider,omitempty"`
+	Username         string `json:"username,omitempty"`
+	Calls            int    `json:"calls"`
+	PromptTokens     int    `json:"promptTokens"`
+	CompletionTokens int    `json:"completionTokens"`
+	EmbeddingTokens  int    `json:"embeddingTokens"`
+	TotalTokens      int    `json:"totalTokens"`
+}
+
+// GetAIUsageReport 按维度聚合 [from, to] 日期范围内的用量，groupBy 可包含 day、model、feature、provider 和 user。
+// allUsers 为 false 时只统计当前用户的用量
+func GetAIUsageReport(ctx *WorkspaceContext, from, to time.Time, groupBy []string, allUsers bool) (ret []*AIUsageReportRow) {
+	ret = []*AIUsageReportRow{}
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, 1)
+	if !from.Before(to) {
+		return
+	}
+
+	dims := map[string]bool{}
+	for _, g := range groupBy {
+		dims[g] = true
+	}
+
+	dir := aiUsageDir(ctx)
+	rows := map[string]*AIUsageReportRow{}
+	for month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.Local); month.Before(to); month = month.AddDate(0, 1, 0) {
+		for _, record := range readAIUsageRecords(dir, month.Format("2006-01")) {
+			t := time.UnixMilli(record.Time)
+			if t.Before(from) || !t.Before(to) {
+				continue
+			}
+			if !allUsers && record.Username != aiUsageUserKey(ctx) {
+				continue
+			}
+
+			row := &AIUsageReportRow{}
+			if dims["day"] {
+				row.Day = t.Format("2006-01-02")
+			}
+			if dims["model"] {
+				row.Model = record.Model
+			}
+			if dims["feature"] {
+				row.Feature = record.Feature
+			}
+			if dims["provider"] {
+				row.Provider = record.Provider
+			}
+			if dims["user"] {
+				row.Username = record.Username
+			}
+			key := strings.Join([]string{row.Day, row.Model, row.Feature, row.Provider, row.Username}, "\x00")
+			if existing := rows[key]; nil != existing {
+				row = existing
+			} else {
+				rows[key] = row
+				ret = append(ret, row)
+			}
+			row.Calls++
+			row.PromptTokens += record.PromptTokens
+			row.CompletionTokens += record.CompletionTokens
+			row.EmbeddingTokens += record.EmbeddingTokens
+			row.TotalTokens += record.TotalTokens
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Day != ret[j].Day {
+			return ret[i].Day < ret[j].Day
+		}
+		return ret[i].TotalTokens > ret[j].TotalTokens
+	})
+	return
+}
+
+// estimateTokens 粗略估算 Token 数：中日韩字符按一个 Token 计算，其他字符按四个字符一个 Token 计算
+func estimateTokens(text string) (ret int) {
+	others := 0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			ret++
+		} else {
+			others++
+		}
+	}
+	ret += (others + 3) / 4
+	return
+}
+
+// pushAIBudgetExceeded 通知用户配额已用完
+func pushAIBudgetExceeded() {
+	util.PushErrMsg(ErrAIBudgetExceeded.Error(), 5000)
+}
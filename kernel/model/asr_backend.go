@@ -0,0 +1,481 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/websocket"
+	"github.com/sashabaranov/go-openai"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+)
+
+// ASRAudioFormat 语音识别后端要求的 PCM 格式，采样均为 16 位小端
+type ASRAudioFormat struct {
+	SampleRate int
+	Channels   int
+}
+
+// ASRBackend 语音识别后端
+type ASRBackend interface {
+	Name() string
+	// AudioFormat 返回 Transcribe 接收的 PCM 格式
+	AudioFormat() ASRAudioFormat
+	// Transcribe 识别一段 PCM 音频并返回文本
+	Transcribe(pcm []byte) (string, error)
+}
+
+const (
+	asrChunkSeconds        = 60 // 长录音的分段长度
+	asrChunkOverlapSeconds = 3  // 相邻分段的重叠长度，用于拼接时对齐
+	asrMaxParallel         = 4  // 同时识别的分段数
+)
+
+var defaultASRAudioFormat = ASRAudioFormat{SampleRate: 16000, Channels: 1}
+
+// newASRBackend 根据用户的语音识别路由创建后端
+func newASRBackend(ctx *WorkspaceContext) (ASRBackend, error) {
+	ep := ResolveAIEndpoint(ctx, conf.AITaskASR)
+	if nil == ep {
+		return nil, errors.New("no AI provider is routed for speech recognition")
+	}
+
+	switch ep.Provider.Type {
+	case conf.AIProviderTypeFunASR:
+		return &FunASRBackend{url: toWebSocketURL(ep.Provider.BaseURL)}, nil
+	case conf.AIProviderTypeHTTPASR:
+		return &HTTPASRBackend{url: ep.Provider.BaseURL, apiKey: ep.Provider.APIKey, model: ep.Model, client: ep.HTTPClient(180 * time.Second)}, nil
+	default:
+		model := ep.Model
+		if "" == model {
+			model = openai.Whisper1
+		}
+		return &OpenAIASRBackend{client: ep.OpenAIClient(), model: model}, nil
+	}
+}
+
+// FunASRBackend FunASR WebSocket 服务
+type FunASRBackend struct {
+	url string
+}
+
+func (b *FunASRBackend) Name() string {
+	return "funasr"
+}
+
+func (b *FunASRBackend) AudioFormat() ASRAudioFormat {
+	return defaultASRAudioFormat
+}
+
+// Transcribe 通过 WebSocket 发送 PCM 数据并收集 2pass 模式的识别结果
+func (b *FunASRBackend) Transcribe(pcmData []byte) (string, error) {
+	logging.LogDebugf("ASR: Connecting to %s, PCM data size: %d bytes", b.url, len(pcmData))
+
+	dialer := &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 15 * time.Second}
+
+	conn, resp, err := dialer.Dial(b.url, nil)
+	if err != nil {
+		status := "unknown"
+		if resp != nil {
+			status = resp.Status
+			body, _ := io.ReadAll(resp.Body)
+			logging.LogErrorf("ASR Handshake failed. Status: %s, Body: %s", status, string(body))
+		}
+		return "", fmt.Errorf("ASR WebSocket connection failed: %v (Status: %s)", err, status)
+	}
+	defer conn.Close()
+
+	logging.LogDebugf("ASR: WebSocket connected successfully")
+
+	// 2. 发送开始配置 (根据文档使用 2pass 模式)
+	startConfig := map[string]interface{}{
+		"mode":           "2pass",
+		"chunk_size":     []int{5, 10, 5},
+		"chunk_interval": 10,
+		"wav_name":       "meeting",
+		"is_speaking":    true,
+	}
+	if err := conn.WriteJSON(startConfig); err != nil {
+		return "", fmt.Errorf("failed to send start config: %v", err)
+	}
+
+	// 准备收集结果
+	var fullTranscriptBuilder bytes.Buffer
+	// 暂存最新的流式中间结果，防止最后一句因未 finalize 而丢失
+	var latestPartialText string
+
+	// 用于同步发送端状态的通道
+	sendErrChan := make(chan error, 1)
+
+	// 3. 启动协程并发发送音频数据
+	go func() {
+		defer close(sendErrChan)
+
+		logging.LogDebugf("ASR: Start sending PCM data (%d bytes)...", len(pcmData))
+
+		// 直接批量发送，无需延迟
+		// 分块大小：使用较大的 chunk 以减少网络开销，但不超过 64KB
+		const chunkSize = 64000
+
+		for i := 0; i < len(pcmData); i += chunkSize {
+			end := i + chunkSize
+			if end > len(pcmData) {
+				end = len(pcmData)
+			}
+
+			if err := conn.WriteMessage(websocket.BinaryMessage, pcmData[i:end]); err != nil {
+				sendErrChan <- fmt.Errorf("failed to send audio chunk: %v", err)
+				return
+			}
+		}
+
+		logging.LogDebugf("ASR: Audio data sent, sending end signal...")
+
+		// 4. 发送结束信号 (is_speaking 为 false)
+		endConfig := map[string]interface{}{
+			"is_speaking": false,
+		}
+		if err := conn.WriteJSON(endConfig); err != nil {
+			sendErrChan <- fmt.Errorf("failed to send end signal: %v", err)
+			return
+		}
+		logging.LogDebugf("ASR: End signal sent.")
+	}()
+
+	// 5. 主协程循环读取识别结果
+	// 读取循环会在 socket 关闭或出错时退出
+
+	messageCount := 0
+	timeout := time.After(180 * time.Second) // 延长超时到180秒
+	logging.LogDebugf("ASR: Waiting for recognition results...")
+
+	for {
+		// 检查发送端是否有错误
+		select {
+		case err := <-sendErrChan:
+			if err != nil {
+				// 发送失败直接返回目前已识别的内容+错误
+				if latestPartialText != "" {
+					fullTranscriptBuilder.WriteString(latestPartialText)
+				}
+				return fullTranscriptBuilder.String(), err
+			}
+		case <-timeout:
+			logging.LogWarnf("ASR: Timeout waiting for result.")
+			// 超时返回当前结果
+			if latestPartialText != "" {
+				fullTranscriptBuilder.WriteString(latestPartialText)
+			}
+			return fullTranscriptBuilder.String(), nil
+		default:
+		}
+
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) || err == io.EOF {
+				logging.LogDebugf("ASR connection closed normally.")
+			} else {
+				// 没有设置读取超时，静音时读取会一直阻塞，出错说明连接已经断开
+				logging.LogDebugf("ASR connection read stop: %v", err)
+			}
+
+			// 连接关闭意味着转录结束，将最后未 finalize 的内容拼上去
+			if latestPartialText != "" {
+				logging.LogDebugf("ASR: Appending unfinalized tail: '%s'", latestPartialText)
+				fullTranscriptBuilder.WriteString(latestPartialText)
+			}
+			// 清理 ASR 返回的标签
+			rawText := fullTranscriptBuilder.String()
+			cleanedText := cleanASRTags(rawText)
+			logging.LogDebugf("ASR: Raw text length: %d, Cleaned text length: %d", len(rawText), len(cleanedText))
+			return cleanedText, nil
+		}
+
+		messageCount++
+
+		// 打印原始消息用于调试
+		logging.LogDebugf("ASR: Raw message #%d: %s", messageCount, string(message))
+
+		var result struct {
+			Text    string `json:"text"`
+			IsFinal bool   `json:"is_final"`
+			Mode    string `json:"mode"`
+		}
+		if err := json.Unmarshal(message, &result); err != nil {
+			logging.LogWarnf("Failed to parse ASR result: %v. Message: %s", err, string(message))
+			continue
+		}
+
+		// 2pass 模式下，2pass-offline 的结果是最终的高质量识别结果
+		// 需要收集这些结果，而不是等待 is_final=true 的空消息
+		if result.Mode == "2pass-offline" && result.Text != "" {
+			logging.LogDebugf("ASR: 2pass-offline result: '%s'", result.Text)
+			fullTranscriptBuilder.WriteString(result.Text)
+			latestPartialText = ""
+
+			// 如果 is_final 为 true，说明识别完成，立即返回
+			if result.IsFinal {
+				logging.LogDebugf("ASR: Recognition completed with is_final=true")
+				rawText := fullTranscriptBuilder.String()
+				cleanedText := cleanASRTags(rawText)
+				logging.LogDebugf("ASR: Raw text length: %d, Cleaned text length: %d", len(rawText), len(cleanedText))
+				return cleanedText, nil
+			}
+		} else if result.IsFinal {
+			logging.LogDebugf("ASR: Sentence finalized: '%s'", result.Text)
+			if result.Text != "" {
+				fullTranscriptBuilder.WriteString(result.Text)
+			}
+			// 此句话已经确定，清空暂存区
+			latestPartialText = ""
+			break
+		} else {
+			// 将中间结果暂存 (2pass-online 的实时结果)
+			latestPartialText = result.Text
+		}
+	}
+
+	rawText := fullTranscriptBuilder.String()
+	cleanedText := cleanASRTags(rawText)
+	return cleanedText, nil
+}
+
+// OpenAIASRBackend OpenAI 兼容的 /audio/transcriptions 接口
+type OpenAIASRBackend struct {
+	client *openai.Client
+	model  string
+}
+
+func (b *OpenAIASRBackend) Name() string {
+	return "openai"
+}
+
+func (b *OpenAIASRBackend) AudioFormat() ASRAudioFormat {
+	return defaultASRAudioFormat
+}
+
+// Transcribe 把 PCM 封装为 WAV 后上传
+func (b *OpenAIASRBackend) Transcribe(pcm []byte) (string, error) {
+	resp, err := b.client.CreateTranscription(context.Background(), openai.AudioRequest{
+		Model:    b.model,
+		FilePath: "audio.wav",
+		Reader:   bytes.NewReader(encodeWAV(pcm, b.AudioFormat())),
+		Format:   openai.AudioResponseFormatJSON,
+	})
+	if err != nil {
+		return "", fmt.Errorf("transcription request failed: %v", err)
+	}
+	return strings.TrimSpace(resp.Text), nil
+}
+
+// HTTPASRBackend 通用 HTTP 语音识别接口：以 multipart 表单的 file 字段上传 WAV，
+// 返回 {"text": "..."}、{"result": "..."} 形式的 JSON 或者纯文本
+type HTTPASRBackend struct {
+	url    string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+func (b *HTTPASRBackend) Name() string {
+	return "http"
+}
+
+func (b *HTTPASRBackend) AudioFormat() ASRAudioFormat {
+	return defaultASRAudioFormat
+}
+
+func (b *HTTPASRBackend) Transcribe(pcm []byte) (string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return "", err
+	}
+	if _, err = part.Write(encodeWAV(pcm, b.AudioFormat())); err != nil {
+		return "", err
+	}
+	if "" != b.model {
+		writer.WriteField("model", b.model)
+	}
+	if err = writer.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, b.url, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if "" != b.apiKey {
+		req.Header.Set("Authorization", "Bearer "+b.apiKey)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if http.StatusOK != resp.StatusCode {
+		return "", fmt.Errorf("ASR service returned status %d: %s", resp.StatusCode, data)
+	}
+
+	var result struct {
+		Text   string `json:"text"`
+		Result string `json:"result"`
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if "" != result.Text {
+		return strings.TrimSpace(result.Text), nil
+	}
+	return strings.TrimSpace(result.Result), nil
+}
+
+// transcribeAudioChunks 并行识别分段，按顺序拼接为带时间的片段。有分段失败时返回错误
+func transcribeAudioChunks(backend ASRBackend, format ASRAudioFormat, chunks []*audioChunk) (ret []*MeetingStreamSegment, err error) {
+	if 1 > len(chunks) {
+		return nil, errors.New("audio is empty")
+	}
+
+	texts := make([]string, len(chunks))
+	errs := make([]error, len(chunks))
+	semaphore := make(chan struct{}, asrMaxParallel)
+	wg := sync.WaitGroup{}
+	for i, chunk := range chunks {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, chunk *audioChunk) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			texts[i], errs[i] = backend.Transcribe(chunk.PCM)
+			texts[i] = cleanASRTags(texts[i])
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	for i, transcribeErr := range errs {
+		if nil != transcribeErr {
+			return nil, fmt.Errorf("transcribe chunk [%d] failed: %v", i, transcribeErr)
+		}
+	}
+
+	bytesPerSecond := float64(format.SampleRate * format.Channels * 2)
+	transcript := ""
+	for i, text := range texts {
+		segment := &MeetingStreamSegment{Start: chunks[i].Start, End: chunks[i].Start + float64(len(chunks[i].PCM))/bytesPerSecond}
+		keep, skip, sep := stitchTranscriptOffsets(transcript, text)
+		if 0 < len(ret) {
+			// 重叠部分已经由前一段识别，从前一段的结束时间开始
+			segment.Start = ret[len(ret)-1].End
+			ret = truncateSegments(ret, keep)
+		}
+		segment.Text = sep + string([]rune(text)[skip:])
+		ret = append(ret, segment)
+		transcript = joinSegmentTexts(ret)
+	}
+	return
+}
+
+// truncateSegments 从末尾截断片段的文字，使所有片段的文字总长度为 runes 个字符
+func truncateSegments(segments []*MeetingStreamSegment, runes int) []*MeetingStreamSegment {
+	for i, segment := range segments {
+		text := []rune(segment.Text)
+		if runes < len(text) {
+			segment.Text = string(text[:runes])
+			return segments[:i+1]
+		}
+		runes -= len(text)
+	}
+	return segments
+}
+
+func joinSegmentTexts(segments []*MeetingStreamSegment) string {
+	buf := strings.Builder{}
+	for _, segment := range segments {
+		buf.WriteString(segment.Text)
+	}
+	return buf.String()
+}
+
+// stitchTranscripts 拼接相邻分段的识别结果
+func stitchTranscripts(prev, next string) string {
+	keep, skip, sep := stitchTranscriptOffsets(prev, next)
+	return string([]rune(prev)[:keep]) + sep + string([]rune(next)[skip:])
+}
+
+// stitchTranscriptOffsets 计算相邻分段的衔接位置：保留前一段的前 keep 个字符，跳过后一段的前 skip 个字符，中间加上 sep。
+// 两段在重叠的音频上会识别出相同的文字，在前一段的末尾和后一段的开头之间找最长的公共子串，从公共子串处衔接，去掉重复的部分
+func stitchTranscriptOffsets(prev, next string) (keep, skip int, sep string) {
+	prevRunes, nextRunes := []rune(prev), []rune(next)
+	if "" == prev || "" == next {
+		return len(prevRunes), 0, ""
+	}
+
+	const window = 64
+	tailStart := max(0, len(prevRunes)-window)
+	tail, head := prevRunes[tailStart:], nextRunes[:min(window, len(nextRunes))]
+
+	// 最长公共子串
+	best, bestTailEnd, bestHeadEnd := 0, 0, 0
+	lengths := make([]int, len(head)+1)
+	for i := 1; i <= len(tail); i++ {
+		prevDiagonal := 0
+		for j := 1; j <= len(head); j++ {
+			current := lengths[j]
+			if tail[i-1] == head[j-1] {
+				lengths[j] = prevDiagonal + 1
+				if lengths[j] > best {
+					best, bestTailEnd, bestHeadEnd = lengths[j], i, j
+				}
+			} else {
+				lengths[j] = 0
+			}
+			prevDiagonal = current
+		}
+	}
+
+	if 3 > best {
+		// 两侧都是字母或数字时补一个空格
+		if isASCIIWordRune(prevRunes[len(prevRunes)-1]) && isASCIIWordRune(nextRunes[0]) {
+			sep = " "
+		}
+		return len(prevRunes), 0, sep
+	}
+	return tailStart + bestTailEnd, bestHeadEnd, ""
+}
+
+func isASCIIWordRune(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
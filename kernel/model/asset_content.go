@@ -305,12 +305,7 @@ func indexAssetContent(absPath string) {
 	defer logging.Recover()
 
 	ext := filepath.Ext(absPath)
-	parser := assetContentSearcher.GetParser(ext)
-	if nil == parser {
-		return
-	}
-
-	result := parser.Parse(absPath)
+	result := assetContentSearcher.parse(absPath)
 	if nil == result {
 		return
 	}
@@ -423,6 +418,27 @@ func (searcher *AssetsSearcher) GetParser(ext string) AssetParser {
 	return searcher.parsers[strings.ToLower(ext)]
 }
 
+// parse 解析资源文件内容。图片和扫描版 PDF 使用文字识别队列保存的识别结果
+func (searcher *AssetsSearcher) parse(absPath string) (ret *AssetParseResult) {
+	if parser := searcher.GetParser(filepath.Ext(absPath)); nil != parser {
+		logging.LogInfof("parsing asset content [%s]", absPath)
+		ret = parser.Parse(absPath)
+	}
+
+	if !isOCRQueueAsset(absPath) {
+		return
+	}
+	if ocrText := getAssetOCRText(absPath); "" != ocrText {
+		if nil == ret {
+			ret = &AssetParseResult{}
+		}
+		if len(strings.TrimSpace(ret.Content)) < len(ocrText) {
+			ret.Content = ocrText
+		}
+	}
+	return
+}
+
 func (searcher *AssetsSearcher) FullIndex() {
 	defer logging.Recover()
 
@@ -445,15 +461,7 @@ func (searcher *AssetsSearcher) FullIndex() {
 			return nil
 		}
 
-		ext := filepath.Ext(absPath)
-		parser := searcher.GetParser(ext)
-		if nil == parser {
-			return nil
-		}
-
-		logging.LogInfof("parsing asset content [%s]", absPath)
-
-		result := parser.Parse(absPath)
+		result := searcher.parse(absPath)
 		if nil == result {
 			return nil
 		}
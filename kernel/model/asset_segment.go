@@ -0,0 +1,740 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/88250/gulu"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/util"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/net/html"
+)
+
+// AssetProvenance 片段在资源文件中的位置
+type AssetProvenance struct {
+	Page        int      `json:"page,omitempty"`        // PDF 页码，从 1 开始
+	HeadingPath []string `json:"headingPath,omitempty"` // 所在的标题路径，从顶级标题开始
+	Slide       int      `json:"slide,omitempty"`       // 幻灯片序号，从 1 开始
+	Sheet       string   `json:"sheet,omitempty"`       // 工作表名称
+	CellRange   string   `json:"cellRange,omitempty"`   // 单元格范围，比如 A1:F20
+}
+
+// Label 返回可读的位置描述，用于搜索结果和引用
+func (p AssetProvenance) Label() string {
+	var parts []string
+	if 0 < p.Page {
+		parts = append(parts, fmt.Sprintf("第 %d 页", p.Page))
+	}
+	if 0 < p.Slide {
+		parts = append(parts, fmt.Sprintf("第 %d 张幻灯片", p.Slide))
+	}
+	if "" != p.Sheet {
+		if "" != p.CellRange {
+			parts = append(parts, p.Sheet+"!"+p.CellRange)
+		} else {
+			parts = append(parts, p.Sheet)
+		}
+	}
+	if 0 < len(p.HeadingPath) {
+		parts = append(parts, strings.Join(p.HeadingPath, " > "))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// sameContainer 判断两个位置是否属于同一个页、幻灯片、工作表或标题下，分块不能跨越这些边界
+func (p AssetProvenance) sameContainer(other AssetProvenance) bool {
+	return p.Page == other.Page && p.Slide == other.Slide && p.Sheet == other.Sheet &&
+		strings.Join(p.HeadingPath, "\n") == strings.Join(other.HeadingPath, "\n")
+}
+
+// AssetSegment 资源文件解析出的结构化片段
+type AssetSegment struct {
+	Text string `json:"text"`
+	AssetProvenance
+}
+
+// assetDeepLink 生成定位到片段位置的资源链接，PDF 使用 ?page= 定位到页
+func assetDeepLink(assetPath string, provenance AssetProvenance) string {
+	link := filepath.ToSlash(assetPath)
+	if idx := strings.Index(link, "assets/"); 0 <= idx {
+		link = link[idx:]
+	} else {
+		link = path.Base(link)
+	}
+	if 0 < provenance.Page && ".pdf" == strings.ToLower(path.Ext(link)) {
+		link += "?page=" + strconv.Itoa(provenance.Page)
+	}
+	return link
+}
+
+// ParseAttachmentSegments 解析附件为结构化片段，PDF 按页、Word/EPUB/Markdown 按标题、PPT 按幻灯片、Excel 按工作表和行切分，
+// 其他格式作为一个没有位置信息的片段返回
+func ParseAttachmentSegments(assetPath string) ([]*AssetSegment, error) {
+	var fullPath string
+	if strings.HasPrefix(assetPath, "assets/") {
+		fullPath = filepath.Join(util.DataDir, assetPath)
+	} else if strings.HasPrefix(assetPath, "/") {
+		fullPath = assetPath
+	} else {
+		fullPath = filepath.Join(util.DataDir, "assets", assetPath)
+	}
+	if !gulu.File.IsExist(fullPath) {
+		return nil, fmt.Errorf("文件不存在: %s", fullPath)
+	}
+
+	var segments []*AssetSegment
+	var err error
+	switch strings.ToLower(filepath.Ext(fullPath)) {
+	case ".pdf":
+		segments, err = parsePDFSegments(fullPath)
+	case ".md", ".markdown":
+		var data []byte
+		if data, err = os.ReadFile(fullPath); nil == err {
+			segments = parseMarkdownSegments(string(data))
+		}
+	case ".docx":
+		segments, err = parseDocxSegments(fullPath)
+	case ".pptx":
+		segments, err = parsePptxSegments(fullPath)
+	case ".xlsx":
+		segments, err = parseXlsxSegments(fullPath)
+	case ".epub":
+		segments, err = parseEpubSegments(fullPath)
+	case ".png", ".jpg", ".jpeg", ".bmp", ".gif", ".webp", ".tif", ".tiff":
+		ocrText := getAssetOCRText(fullPath)
+		if "" == ocrText {
+			return nil, fmt.Errorf("图片尚未完成文字识别: %s", fullPath)
+		}
+		segments = []*AssetSegment{{Text: ocrText}}
+	default:
+		var content string
+		if content, err = ParseAttachment(fullPath); nil == err {
+			segments = []*AssetSegment{{Text: content}}
+		}
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	var ret []*AssetSegment
+	for _, segment := range segments {
+		segment.Text = strings.TrimSpace(segment.Text)
+		if "" != segment.Text {
+			ret = append(ret, segment)
+		}
+	}
+	if 1 > len(ret) {
+		return nil, fmt.Errorf("资源文件内容为空")
+	}
+	return ret, nil
+}
+
+// joinAssetSegments 将片段拼接为纯文本，供只需要全文的调用方使用
+func joinAssetSegments(segments []*AssetSegment) string {
+	var texts []string
+	for _, segment := range segments {
+		texts = append(texts, segment.Text)
+	}
+	content := strings.Join(texts, "\n\n")
+	if len(content) > 50000 {
+		content = content[:50000] + "\n...(内容已截断)"
+	}
+	return content
+}
+
+// chunkAssetSegments 按片段边界分块：同一容器内相邻的小片段会合并，超长片段在段落或句子边界处切分，
+// 相邻分块之间保留 overlap 个字符的重叠，分块不会跨越页、幻灯片、工作表或标题
+func chunkAssetSegments(segments []*AssetSegment, size, overlap int) (ret []*AssetSegment) {
+	if 1 > size {
+		size = 2000
+	}
+	if 0 > overlap || size/2 < overlap {
+		overlap = size / 10
+	}
+
+	var merged []*AssetSegment
+	var cur *AssetSegment
+	for _, segment := range segments {
+		if nil != cur && cur.sameContainer(segment.AssetProvenance) &&
+			len([]rune(cur.Text))+len([]rune(segment.Text))+1 <= size {
+			cur.Text += "\n" + segment.Text
+			cur.CellRange = mergeCellRange(cur.CellRange, segment.CellRange)
+			continue
+		}
+		if nil != cur {
+			merged = append(merged, cur)
+		}
+		copied := *segment
+		cur = &copied
+	}
+	if nil != cur {
+		merged = append(merged, cur)
+	}
+
+	for _, segment := range merged {
+		for _, text := range splitSegmentText(segment.Text, size, overlap) {
+			if 10 > len(strings.TrimSpace(text)) {
+				continue
+			}
+			chunk := *segment
+			chunk.Text = text
+			ret = append(ret, &chunk)
+		}
+	}
+	return
+}
+
+// splitSegmentText 将超长文本切分为不超过 size 个字符的块，优先在空行、换行和句末切分
+func splitSegmentText(text string, size, overlap int) (ret []string) {
+	runes := []rune(text)
+	if len(runes) <= size {
+		return []string{text}
+	}
+
+	for start := 0; start < len(runes); {
+		end := start + size
+		if end >= len(runes) {
+			ret = append(ret, string(runes[start:]))
+			break
+		}
+
+		// 只在窗口后半段寻找切分点，避免产生过小的块
+		cut := -1
+		window := runes[start+size/2 : end]
+		for _, seps := range []string{"\n\n", "\n", "。！？.!?；;"} {
+			if "\n\n" == seps {
+				if idx := strings.LastIndex(string(window), "\n\n"); 0 <= idx {
+					cut = start + size/2 + len([]rune(string(window)[:idx])) + 2
+				}
+			} else {
+				for i := len(window) - 1; 0 <= i; i-- {
+					if strings.ContainsRune(seps, window[i]) {
+						cut = start + size/2 + i + 1
+						break
+					}
+				}
+			}
+			if 0 <= cut {
+				break
+			}
+		}
+		if 0 > cut {
+			cut = end
+		}
+
+		ret = append(ret, string(runes[start:cut]))
+		next := cut - overlap
+		if next <= start {
+			next = cut
+		}
+		start = next
+	}
+	return
+}
+
+// mergeCellRange 合并两个单元格范围为覆盖二者的最小范围
+func mergeCellRange(a, b string) string {
+	if "" == a {
+		return b
+	}
+	if "" == b {
+		return a
+	}
+
+	minCol, minRow, maxCol, maxRow := -1, -1, -1, -1
+	for _, r := range []string{a, b} {
+		for _, cell := range strings.Split(r, ":") {
+			col, row, err := excelize.CellNameToCoordinates(cell)
+			if nil != err {
+				return a
+			}
+			if 0 > minCol || col < minCol {
+				minCol = col
+			}
+			if 0 > minRow || row < minRow {
+				minRow = row
+			}
+			if col > maxCol {
+				maxCol = col
+			}
+			if row > maxRow {
+				maxRow = row
+			}
+		}
+	}
+	from, _ := excelize.CoordinatesToCellName(minCol, minRow)
+	to, _ := excelize.CoordinatesToCellName(maxCol, maxRow)
+	return from + ":" + to
+}
+
+var (
+	markdownHeadingRegexp = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+	pdfPageMarkerRegexp   = regexp.MustCompile(`^-{3}\s*第\s*(\d+)\s*页\s*-{3}$`)
+)
+
+// parseMarkdownSegments 按标题切分 Markdown，同时识别 OCR 生成的“--- 第 N 页 ---”页码标记
+func parseMarkdownSegments(content string) (ret []*AssetSegment) {
+	var headingPath []string
+	page := 0
+	buf := strings.Builder{}
+	flush := func() {
+		if "" != strings.TrimSpace(buf.String()) {
+			ret = append(ret, &AssetSegment{Text: buf.String(), AssetProvenance: AssetProvenance{Page: page, HeadingPath: append([]string{}, headingPath...)}})
+		}
+		buf.Reset()
+	}
+
+	inCodeBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inCodeBlock = !inCodeBlock
+		}
+		if !inCodeBlock {
+			if m := pdfPageMarkerRegexp.FindStringSubmatch(trimmed); nil != m {
+				flush()
+				page, _ = strconv.Atoi(m[1])
+				continue
+			}
+			if m := markdownHeadingRegexp.FindStringSubmatch(trimmed); nil != m {
+				flush()
+				level := len(m[1])
+				if len(headingPath) >= level {
+					headingPath = headingPath[:level-1]
+				}
+				headingPath = append(headingPath, m[2])
+				buf.WriteString(m[2] + "\n")
+				continue
+			}
+		}
+		buf.WriteString(line + "\n")
+	}
+	flush()
+	return
+}
+
+// parsePDFSegments 按页解析 PDF。优先使用文字识别队列保存的识别结果和旧版本 OCR 生成的 Markdown，
+// 其次使用 pdftotext（以换页符分页），文本过少时视为扫描版并尝试 OCR
+func parsePDFSegments(filePath string) (ret []*AssetSegment, err error) {
+	if ocrText := getAssetOCRText(filePath); "" != ocrText {
+		if ret = parseMarkdownSegments(ocrText); 0 < len(ret) {
+			return
+		}
+	}
+
+	mdPath := filePath + ".md"
+	if gulu.File.IsExist(mdPath) {
+		if data, readErr := os.ReadFile(mdPath); nil == readErr && 100 < len(data) {
+			if ret = parseMarkdownSegments(string(data)); 0 < len(ret) {
+				return
+			}
+		}
+	}
+
+	output, err := exec.Command("pdftotext", "-enc", "UTF-8", "-layout", filePath, "-").Output()
+	if nil != err {
+		if output, err = exec.Command("pdftotext", "-enc", "UTF-8", filePath, "-").Output(); nil != err {
+			logging.LogWarnf("pdftotext 解析失败，尝试 OCR: %v", err)
+			return parsePDFSegmentsByOCR(filePath)
+		}
+	}
+
+	textLen := 0
+	for i, pageText := range strings.Split(string(output), "\f") {
+		pageText = strings.TrimSpace(pageText)
+		if "" == pageText {
+			continue
+		}
+		textLen += len(strings.Join(strings.Fields(pageText), ""))
+		ret = append(ret, &AssetSegment{Text: pageText, AssetProvenance: AssetProvenance{Page: i + 1}})
+	}
+
+	if 50 > textLen {
+		logging.LogInfof("PDF 文本内容过少 (%d 字符)，尝试 OCR: %s", textLen, filePath)
+		if ocrSegments, ocrErr := parsePDFSegmentsByOCR(filePath); nil == ocrErr && 0 < len(ocrSegments) {
+			return ocrSegments, nil
+		} else if 1 > len(ret) {
+			return nil, fmt.Errorf("PDF内容为空且OCR失败: %v", ocrErr)
+		}
+	}
+	return ret, nil
+}
+
+// parsePDFSegmentsByOCR 对 PDF 进行 OCR，并按页码标记切分结果
+func parsePDFSegmentsByOCR(filePath string) ([]*AssetSegment, error) {
+	healthy, msg := OCRHealthCheck()
+	if !healthy {
+		return nil, fmt.Errorf("OCR 服务不可用: %s", msg)
+	}
+
+	result, err := OCRAsset(filePath)
+	if nil != err {
+		return nil, err
+	}
+	return parseMarkdownSegments(result.FullText), nil
+}
+
+// docxHeadingStyleRegexp 匹配 Word 内置标题样式，中文版 Word 的标题样式 ID 为纯数字
+var docxHeadingStyleRegexp = regexp.MustCompile(`^(?i:heading\s*|标题\s*)?([1-9])$`)
+
+// parseDocxSegments 按标题解析 Word 文档，标题由段落样式或大纲级别确定
+func parseDocxSegments(filePath string) (ret []*AssetSegment, err error) {
+	reader, err := zip.OpenReader(filePath)
+	if nil != err {
+		return nil, fmt.Errorf("DOCX解析失败: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := readZipEntry(&reader.Reader, "word/document.xml")
+	if nil != err {
+		return nil, fmt.Errorf("DOCX解析失败: %v", err)
+	}
+
+	var headingPath []string
+	section := strings.Builder{}
+	flush := func() {
+		if "" != strings.TrimSpace(section.String()) {
+			ret = append(ret, &AssetSegment{Text: section.String(), AssetProvenance: AssetProvenance{HeadingPath: append([]string{}, headingPath...)}})
+		}
+		section.Reset()
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	paragraph := strings.Builder{}
+	level, inText := 0, false
+	for {
+		token, tokenErr := decoder.Token()
+		if nil != tokenErr {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				paragraph.Reset()
+				level = 0
+			case "pStyle":
+				if m := docxHeadingStyleRegexp.FindStringSubmatch(xmlAttr(t, "val")); nil != m {
+					level, _ = strconv.Atoi(m[1])
+				}
+			case "outlineLvl":
+				if lvl, convErr := strconv.Atoi(xmlAttr(t, "val")); nil == convErr && 0 == level && 9 > lvl {
+					level = lvl + 1
+				}
+			case "t":
+				inText = true
+			case "tab":
+				paragraph.WriteString("\t")
+			case "br", "cr":
+				paragraph.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text := strings.TrimSpace(paragraph.String())
+				if "" == text {
+					continue
+				}
+				if 0 < level {
+					flush()
+					if len(headingPath) >= level {
+						headingPath = headingPath[:level-1]
+					}
+					headingPath = append(headingPath, text)
+				}
+				section.WriteString(text + "\n")
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(t)
+			}
+		}
+	}
+	flush()
+	return
+}
+
+var pptxSlideRegexp = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+
+// parsePptxSegments 按幻灯片解析 PowerPoint
+func parsePptxSegments(filePath string) (ret []*AssetSegment, err error) {
+	reader, err := zip.OpenReader(filePath)
+	if nil != err {
+		return nil, fmt.Errorf("PPTX解析失败: %v", err)
+	}
+	defer reader.Close()
+
+	slides := map[int]*zip.File{}
+	var numbers []int
+	for _, f := range reader.File {
+		if m := pptxSlideRegexp.FindStringSubmatch(f.Name); nil != m {
+			n, _ := strconv.Atoi(m[1])
+			slides[n] = f
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+
+	for _, n := range numbers {
+		data, readErr := readZipFile(slides[n])
+		if nil != readErr {
+			logging.LogWarnf("read slide [%d] of [%s] failed: %s", n, filePath, readErr)
+			continue
+		}
+
+		buf := strings.Builder{}
+		decoder := xml.NewDecoder(bytes.NewReader(data))
+		inText := false
+		for {
+			token, tokenErr := decoder.Token()
+			if nil != tokenErr {
+				break
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				if "t" == t.Name.Local {
+					inText = true
+				} else if "br" == t.Name.Local {
+					buf.WriteString("\n")
+				}
+			case xml.EndElement:
+				if "t" == t.Name.Local {
+					inText = false
+				} else if "p" == t.Name.Local {
+					buf.WriteString("\n")
+				}
+			case xml.CharData:
+				if inText {
+					buf.Write(t)
+				}
+			}
+		}
+		ret = append(ret, &AssetSegment{Text: buf.String(), AssetProvenance: AssetProvenance{Slide: n}})
+	}
+	if 1 > len(ret) {
+		return nil, fmt.Errorf("PPTX内容为空或无法提取文本")
+	}
+	return
+}
+
+// parseXlsxSegments 按工作表解析 Excel，每行一个片段并记录单元格范围，分块时同一工作表的相邻行会合并
+func parseXlsxSegments(filePath string) (ret []*AssetSegment, err error) {
+	x, err := excelize.OpenFile(filePath)
+	if nil != err {
+		return nil, fmt.Errorf("Excel解析失败: %v", err)
+	}
+	defer x.Close()
+
+	for _, sheet := range x.GetSheetList() {
+		rows, rowsErr := x.GetRows(sheet)
+		if nil != rowsErr {
+			logging.LogWarnf("get rows from sheet [%s] of [%s] failed: %s", sheet, filePath, rowsErr)
+			continue
+		}
+		for i, row := range rows {
+			var cells []string
+			lastCol := 0
+			for j, cell := range row {
+				if cell = strings.TrimSpace(cell); "" != cell {
+					cells = append(cells, cell)
+					lastCol = j + 1
+				}
+			}
+			if 1 > len(cells) {
+				continue
+			}
+			from, _ := excelize.CoordinatesToCellName(1, i+1)
+			to, _ := excelize.CoordinatesToCellName(lastCol, i+1)
+			ret = append(ret, &AssetSegment{Text: strings.Join(cells, " | "), AssetProvenance: AssetProvenance{Sheet: sheet, CellRange: from + ":" + to}})
+		}
+	}
+	return
+}
+
+// parseEpub 解析 EPUB 电子书为纯文本
+func parseEpub(filePath string) (string, error) {
+	segments, err := parseEpubSegments(filePath)
+	if nil != err {
+		return "", err
+	}
+	return joinAssetSegments(segments), nil
+}
+
+// parseEpubSegments 按书脊顺序读取 EPUB 章节，并按 h1-h6 标题切分
+func parseEpubSegments(filePath string) (ret []*AssetSegment, err error) {
+	reader, err := zip.OpenReader(filePath)
+	if nil != err {
+		return nil, fmt.Errorf("EPUB解析失败: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := readZipEntry(&reader.Reader, "META-INF/container.xml")
+	if nil != err {
+		return nil, fmt.Errorf("EPUB解析失败: %v", err)
+	}
+	container := struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}{}
+	if err = xml.Unmarshal(data, &container); nil != err || 1 > len(container.Rootfiles) {
+		return nil, fmt.Errorf("EPUB解析失败: 找不到 OPF 文件")
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+	if data, err = readZipEntry(&reader.Reader, opfPath); nil != err {
+		return nil, fmt.Errorf("EPUB解析失败: %v", err)
+	}
+	opf := struct {
+		Items []struct {
+			ID   string `xml:"id,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"manifest>item"`
+		ItemRefs []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"spine>itemref"`
+	}{}
+	if err = xml.Unmarshal(data, &opf); nil != err {
+		return nil, fmt.Errorf("EPUB解析失败: %v", err)
+	}
+	hrefs := map[string]string{}
+	for _, item := range opf.Items {
+		hrefs[item.ID] = item.Href
+	}
+
+	var headingPath []string
+	buf := strings.Builder{}
+	flush := func() {
+		if "" != strings.TrimSpace(buf.String()) {
+			ret = append(ret, &AssetSegment{Text: buf.String(), AssetProvenance: AssetProvenance{HeadingPath: append([]string{}, headingPath...)}})
+		}
+		buf.Reset()
+	}
+
+	baseDir := path.Dir(opfPath)
+	for _, itemRef := range opf.ItemRefs {
+		href, ok := hrefs[itemRef.IDRef]
+		if !ok {
+			continue
+		}
+		chapter, readErr := readZipEntry(&reader.Reader, path.Join(baseDir, href))
+		if nil != readErr {
+			logging.LogWarnf("read chapter [%s] of [%s] failed: %s", href, filePath, readErr)
+			continue
+		}
+
+		tokenizer := html.NewTokenizer(bytes.NewReader(chapter))
+		level, skip := 0, 0
+		heading := strings.Builder{}
+		for done := false; !done; {
+			switch tokenizer.Next() {
+			case html.ErrorToken:
+				done = true
+			case html.StartTagToken:
+				name, _ := tokenizer.TagName()
+				tag := string(name)
+				switch tag {
+				case "h1", "h2", "h3", "h4", "h5", "h6":
+					level = int(tag[1] - '0')
+					heading.Reset()
+				case "script", "style", "head":
+					skip++
+				}
+			case html.EndTagToken:
+				name, _ := tokenizer.TagName()
+				tag := string(name)
+				switch tag {
+				case "h1", "h2", "h3", "h4", "h5", "h6":
+					if text := strings.TrimSpace(heading.String()); "" != text && 0 < level {
+						flush()
+						if len(headingPath) >= level {
+							headingPath = headingPath[:level-1]
+						}
+						headingPath = append(headingPath, text)
+						buf.WriteString(text + "\n")
+					}
+					level = 0
+				case "script", "style", "head":
+					if 0 < skip {
+						skip--
+					}
+				case "p", "div", "li", "tr", "blockquote", "pre":
+					buf.WriteString("\n")
+				}
+			case html.SelfClosingTagToken:
+				if name, _ := tokenizer.TagName(); "br" == string(name) {
+					buf.WriteString("\n")
+				}
+			case html.TextToken:
+				if 0 < skip {
+					continue
+				}
+				text := string(tokenizer.Text())
+				if 0 < level {
+					heading.WriteString(text)
+				} else {
+					buf.WriteString(text)
+				}
+			}
+		}
+	}
+	flush()
+	return
+}
+
+func readZipEntry(reader *zip.Reader, name string) ([]byte, error) {
+	for _, f := range reader.File {
+		if f.Name == name {
+			return readZipFile(f)
+		}
+	}
+	return nil, fmt.Errorf("entry [%s] not found", name)
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if nil != err {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
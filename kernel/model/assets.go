@@ -67,6 +67,8 @@ func GetAssetPathByHash(hash string) string {
 func HandleAssetsRemoveEvent(assetAbsPath string) {
 	removeIndexAssetContent(assetAbsPath)
 	removeAssetThumbnail(assetAbsPath)
+	dataDir := getAssetDataDir(assetAbsPath)
+	removeOCRAsset(dataDir, toOCRAssetPath(dataDir, assetAbsPath))
 }
 
 func HandleAssetsChangeEvent(assetAbsPath string) {
@@ -1013,6 +1015,7 @@ func RenameAsset(oldPath, newName string) (newPath string, err error) {
 		// 图片重命名后 ocr-texts.json 需要更新 https://github.com/siyuan-note/siyuan/issues/12974
 		util.SetAssetText(newPath, ocrText)
 	}
+	renameOCRResult(util.DataDir, oldPath, newPath)
 
 	IncSync()
 	return
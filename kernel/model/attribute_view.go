@@ -159,7 +159,7 @@ func getAttrViewAddingBlockDefaultValues(attrView *av.AttributeView, view, group
 	}
 
 	filterKeyIDs := map[string]bool{}
-	for _, filter := range view.Filters {
+	for _, filter := range av.GetRequiredFilters(view.Filters) { // OR 过滤组中的过滤规则不一定需要满足，不用于生成默认值
 		filterKeyIDs[filter.Column] = true
 		keyValues, _ := attrView.GetKeyValues(filter.Column)
 		if nil == keyValues {
@@ -442,7 +442,7 @@ func syncAttrViewTableColWidth(operation *Operation) (err error) {
 				break
 			}
 		}
-	case av.LayoutTypeGallery, av.LayoutTypeKanban:
+	case av.LayoutTypeGallery, av.LayoutTypeKanban, av.LayoutTypeCalendar, av.LayoutTypeTimeline, av.LayoutTypeChart:
 		return
 	}
 
@@ -730,7 +730,7 @@ func ChangeAttrViewLayout(blockID, avID string, newLayout av.LayoutType) (err er
 
 	switch newLayout {
 	case av.LayoutTypeTable:
-		if view.Name == av.GetAttributeViewI18n("gallery") || view.Name == av.GetAttributeViewI18n("kanban") {
+		if view.Name == av.GetAttributeViewI18n("gallery") || view.Name == av.GetAttributeViewI18n("kanban") || view.Name == av.GetAttributeViewI18n("calendar") || view.Name == av.GetAttributeViewI18n("timeline") || view.Name == av.GetAttributeViewI18n("chart") {
 			view.Name = av.GetAttributeViewI18n("table")
 		}
 
@@ -748,9 +748,21 @@ func ChangeAttrViewLayout(blockID, avID string, newLayout av.LayoutType) (err er
 			for _, field := range view.Kanban.Fields {
 				view.Table.Columns = append(view.Table.Columns, &av.ViewTableColumn{BaseField: &av.BaseField{ID: field.ID}})
 			}
+		case av.LayoutTypeCalendar:
+			for _, field := range view.Calendar.Fields {
+				view.Table.Columns = append(view.Table.Columns, &av.ViewTableColumn{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range view.Timeline.Fields {
+				view.Table.Columns = append(view.Table.Columns, &av.ViewTableColumn{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range view.Chart.Fields {
+				view.Table.Columns = append(view.Table.Columns, &av.ViewTableColumn{BaseField: &av.BaseField{ID: field.ID}})
+			}
 		}
 	case av.LayoutTypeGallery:
-		if view.Name == av.GetAttributeViewI18n("table") || view.Name == av.GetAttributeViewI18n("kanban") {
+		if view.Name == av.GetAttributeViewI18n("table") || view.Name == av.GetAttributeViewI18n("kanban") || view.Name == av.GetAttributeViewI18n("calendar") || view.Name == av.GetAttributeViewI18n("timeline") || view.Name == av.GetAttributeViewI18n("chart") {
 			view.Name = av.GetAttributeViewI18n("gallery")
 		}
 
@@ -768,9 +780,21 @@ func ChangeAttrViewLayout(blockID, avID string, newLayout av.LayoutType) (err er
 			for _, field := range view.Kanban.Fields {
 				view.Gallery.CardFields = append(view.Gallery.CardFields, &av.ViewGalleryCardField{BaseField: &av.BaseField{ID: field.ID}})
 			}
+		case av.LayoutTypeCalendar:
+			for _, field := range view.Calendar.Fields {
+				view.Gallery.CardFields = append(view.Gallery.CardFields, &av.ViewGalleryCardField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range view.Timeline.Fields {
+				view.Gallery.CardFields = append(view.Gallery.CardFields, &av.ViewGalleryCardField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range view.Chart.Fields {
+				view.Gallery.CardFields = append(view.Gallery.CardFields, &av.ViewGalleryCardField{BaseField: &av.BaseField{ID: field.ID}})
+			}
 		}
 	case av.LayoutTypeKanban:
-		if view.Name == av.GetAttributeViewI18n("table") || view.Name == av.GetAttributeViewI18n("gallery") {
+		if view.Name == av.GetAttributeViewI18n("table") || view.Name == av.GetAttributeViewI18n("gallery") || view.Name == av.GetAttributeViewI18n("calendar") || view.Name == av.GetAttributeViewI18n("timeline") || view.Name == av.GetAttributeViewI18n("chart") {
 			view.Name = av.GetAttributeViewI18n("kanban")
 		}
 
@@ -788,11 +812,138 @@ func ChangeAttrViewLayout(blockID, avID string, newLayout av.LayoutType) (err er
 			for _, field := range view.Gallery.CardFields {
 				view.Kanban.Fields = append(view.Kanban.Fields, &av.ViewKanbanField{BaseField: &av.BaseField{ID: field.ID}})
 			}
+		case av.LayoutTypeCalendar:
+			for _, field := range view.Calendar.Fields {
+				view.Kanban.Fields = append(view.Kanban.Fields, &av.ViewKanbanField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range view.Timeline.Fields {
+				view.Kanban.Fields = append(view.Kanban.Fields, &av.ViewKanbanField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range view.Chart.Fields {
+				view.Kanban.Fields = append(view.Kanban.Fields, &av.ViewKanbanField{BaseField: &av.BaseField{ID: field.ID}})
+			}
 		}
 
 		preferredGroupKey := getKanbanPreferredGroupKey(attrView)
 		group := &av.ViewGroup{Field: preferredGroupKey.ID}
 		setAttributeViewGroup(attrView, view, group)
+	case av.LayoutTypeCalendar:
+		if view.Name == av.GetAttributeViewI18n("table") || view.Name == av.GetAttributeViewI18n("gallery") || view.Name == av.GetAttributeViewI18n("kanban") || view.Name == av.GetAttributeViewI18n("timeline") || view.Name == av.GetAttributeViewI18n("chart") {
+			view.Name = av.GetAttributeViewI18n("calendar")
+		}
+
+		if nil != view.Calendar {
+			break
+		}
+
+		view.Calendar = av.NewLayoutCalendar()
+		switch oldLayout {
+		case av.LayoutTypeTable:
+			for _, col := range view.Table.Columns {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: col.ID}})
+			}
+		case av.LayoutTypeGallery:
+			for _, field := range view.Gallery.CardFields {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeKanban:
+			for _, field := range view.Kanban.Fields {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range view.Timeline.Fields {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range view.Chart.Fields {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		}
+
+		if preferredDateKey := getCalendarPreferredDateKey(attrView); nil != preferredDateKey {
+			view.Calendar.StartKeyID = preferredDateKey.ID
+		}
+	case av.LayoutTypeTimeline:
+		if view.Name == av.GetAttributeViewI18n("table") || view.Name == av.GetAttributeViewI18n("gallery") || view.Name == av.GetAttributeViewI18n("kanban") || view.Name == av.GetAttributeViewI18n("calendar") || view.Name == av.GetAttributeViewI18n("chart") {
+			view.Name = av.GetAttributeViewI18n("timeline")
+		}
+
+		if nil != view.Timeline {
+			break
+		}
+
+		view.Timeline = av.NewLayoutTimeline()
+		switch oldLayout {
+		case av.LayoutTypeTable:
+			for _, col := range view.Table.Columns {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: col.ID}})
+			}
+		case av.LayoutTypeGallery:
+			for _, field := range view.Gallery.CardFields {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeKanban:
+			for _, field := range view.Kanban.Fields {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeCalendar:
+			for _, field := range view.Calendar.Fields {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range view.Chart.Fields {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		}
+
+		if nil != view.Calendar {
+			// 从日历视图切换过来时沿用日历的日期和标题字段
+			view.Timeline.StartKeyID = view.Calendar.StartKeyID
+			view.Timeline.EndKeyID = view.Calendar.EndKeyID
+			view.Timeline.TitleKeyID = view.Calendar.TitleKeyID
+		} else if preferredDateKey := getCalendarPreferredDateKey(attrView); nil != preferredDateKey {
+			view.Timeline.StartKeyID = preferredDateKey.ID
+		}
+	case av.LayoutTypeChart:
+		if view.Name == av.GetAttributeViewI18n("table") || view.Name == av.GetAttributeViewI18n("gallery") || view.Name == av.GetAttributeViewI18n("kanban") || view.Name == av.GetAttributeViewI18n("calendar") || view.Name == av.GetAttributeViewI18n("timeline") {
+			view.Name = av.GetAttributeViewI18n("chart")
+		}
+
+		if nil != view.Chart {
+			break
+		}
+
+		view.Chart = av.NewLayoutChart()
+		switch oldLayout {
+		case av.LayoutTypeTable:
+			for _, col := range view.Table.Columns {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: col.ID}})
+			}
+		case av.LayoutTypeGallery:
+			for _, field := range view.Gallery.CardFields {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeKanban:
+			for _, field := range view.Kanban.Fields {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeCalendar:
+			for _, field := range view.Calendar.Fields {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range view.Timeline.Fields {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		}
+
+		if !view.IsGroupView() {
+			if preferredGroupKey := getChartPreferredGroupKey(attrView); nil != preferredGroupKey {
+				setAttributeViewGroup(attrView, view, &av.ViewGroup{Field: preferredGroupKey.ID})
+			}
+		}
 	}
 
 	blockIDs := treenode.GetMirrorAttrViewBlockIDs(avID)
@@ -874,6 +1025,21 @@ func setAttrViewWrapField(operation *Operation) (err error) {
 		for _, field := range view.Kanban.Fields {
 			field.Wrap = allFieldWrap
 		}
+	case av.LayoutTypeCalendar:
+		view.Calendar.WrapField = allFieldWrap
+		for _, field := range view.Calendar.Fields {
+			field.Wrap = allFieldWrap
+		}
+	case av.LayoutTypeTimeline:
+		view.Timeline.WrapField = allFieldWrap
+		for _, field := range view.Timeline.Fields {
+			field.Wrap = allFieldWrap
+		}
+	case av.LayoutTypeChart:
+		view.Chart.WrapField = allFieldWrap
+		for _, field := range view.Chart.Fields {
+			field.Wrap = allFieldWrap
+		}
 	}
 
 	err = av.SaveAttributeView(attrView)
@@ -906,6 +1072,12 @@ func setAttrViewShowIcon(operation *Operation) (err error) {
 		view.Gallery.ShowIcon = operation.Data.(bool)
 	case av.LayoutTypeKanban:
 		view.Kanban.ShowIcon = operation.Data.(bool)
+	case av.LayoutTypeCalendar:
+		view.Calendar.ShowIcon = operation.Data.(bool)
+	case av.LayoutTypeTimeline:
+		view.Timeline.ShowIcon = operation.Data.(bool)
+	case av.LayoutTypeChart:
+		view.Chart.ShowIcon = operation.Data.(bool)
 	}
 
 	err = av.SaveAttributeView(attrView)
@@ -1111,8 +1283,23 @@ func AppendAttributeViewDetachedBlocksWithValues(avID string, blocksValues [][]*
 		return
 	}
 
+	if _, err = appendAttrViewDetachedItems(attrView, blocksValues); nil != err {
+		return
+	}
+
+	regenAttrViewGroups(attrView)
+	if err = av.SaveAttributeView(attrView); err != nil {
+		logging.LogErrorf("save attribute view [%s] failed: %s", avID, err)
+		return
+	}
+
+	ReloadAttrView(avID)
+	return
+}
+
+// appendAttrViewDetachedItems 将带有字段值的非绑定项追加到数据库中（不保存），返回新增项的 ID。
+func appendAttrViewDetachedItems(attrView *av.AttributeView, blocksValues [][]*av.Value) (blockIDs []string, err error) {
 	now := util.CurrentTimeMillis()
-	var blockIDs []string
 	for _, blockValues := range blocksValues {
 		blockID := ast.NewNodeID()
 		blockIDs = append(blockIDs, blockID)
@@ -1161,14 +1348,6 @@ func AppendAttributeViewDetachedBlocksWithValues(avID string, blocksValues [][]*
 			v.ItemIDs = append(v.ItemIDs, addingBlockID)
 		}
 	}
-
-	regenAttrViewGroups(attrView)
-	if err = av.SaveAttributeView(attrView); err != nil {
-		logging.LogErrorf("save attribute view [%s] failed: %s", avID, err)
-		return
-	}
-
-	ReloadAttrView(avID)
 	return
 }
 
@@ -1912,6 +2091,15 @@ func genAttrViewGroups(view *av.View, attrView *av.AttributeView) {
 		case av.LayoutTypeKanban:
 			v = av.NewKanbanView()
 			v.Kanban = av.NewLayoutKanban()
+		case av.LayoutTypeCalendar:
+			v = av.NewCalendarView()
+			v.Calendar = av.NewLayoutCalendar()
+		case av.LayoutTypeTimeline:
+			v = av.NewTimelineView()
+			v.Timeline = av.NewLayoutTimeline()
+		case av.LayoutTypeChart:
+			v = av.NewChartView()
+			v.Chart = av.NewLayoutChart()
 		default:
 			logging.LogWarnf("unknown layout type [%s] for group view", view.LayoutType)
 			return
@@ -2269,13 +2457,9 @@ func updateAttributeViewColRollup(operation *Operation) (err error) {
 
 	// 如果存在该汇总字段的过滤条件，则移除该过滤条件 https://github.com/siyuan-note/siyuan/issues/15660
 	for _, view := range attrView.Views {
-		for i, filter := range view.Filters {
-			if filter.Column != rollUpKey.ID {
-				continue
-			}
-
-			view.Filters = append(view.Filters[:i], view.Filters[i+1:]...)
-		}
+		view.Filters, _ = av.RemoveFilters(view.Filters, func(filter *av.ViewFilter) bool {
+			return filter.Column == rollUpKey.ID
+		})
 	}
 
 	err = av.SaveAttributeView(attrView)
@@ -2409,6 +2593,12 @@ func updateAttributeViewColRelation(operation *Operation) (err error) {
 				v.Gallery.CardFields = append(v.Gallery.CardFields, &av.ViewGalleryCardField{BaseField: &av.BaseField{ID: operation.BackRelationKeyID}})
 			case av.LayoutTypeKanban:
 				v.Kanban.Fields = append(v.Kanban.Fields, &av.ViewKanbanField{BaseField: &av.BaseField{ID: operation.BackRelationKeyID}})
+			case av.LayoutTypeCalendar:
+				v.Calendar.Fields = append(v.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: operation.BackRelationKeyID}})
+			case av.LayoutTypeTimeline:
+				v.Timeline.Fields = append(v.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: operation.BackRelationKeyID}})
+			case av.LayoutTypeChart:
+				v.Chart.Fields = append(v.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: operation.BackRelationKeyID}})
 			}
 		}
 
@@ -2630,6 +2820,12 @@ func (tx *Transaction) doDuplicateAttrViewView(operation *Operation) (ret *TxErr
 		view = av.NewGalleryView()
 	case av.LayoutTypeKanban:
 		view = av.NewKanbanView()
+	case av.LayoutTypeCalendar:
+		view = av.NewCalendarView()
+	case av.LayoutTypeTimeline:
+		view = av.NewTimelineView()
+	case av.LayoutTypeChart:
+		view = av.NewChartView()
 	}
 
 	view.ID = operation.ID
@@ -2643,16 +2839,7 @@ func (tx *Transaction) doDuplicateAttrViewView(operation *Operation) (ret *TxErr
 	view.LayoutType = masterView.LayoutType
 	view.PageSize = masterView.PageSize
 
-	for _, filter := range masterView.Filters {
-		view.Filters = append(view.Filters, &av.ViewFilter{
-			Column:        filter.Column,
-			Qualifier:     filter.Qualifier,
-			Operator:      filter.Operator,
-			Value:         filter.Value,
-			RelativeDate:  filter.RelativeDate,
-			RelativeDate2: filter.RelativeDate2,
-		})
-	}
+	view.Filters = av.CloneFilters(masterView.Filters)
 
 	for _, s := range masterView.Sorts {
 		view.Sorts = append(view.Sorts, &av.ViewSort{
@@ -2718,6 +2905,64 @@ func (tx *Transaction) doDuplicateAttrViewView(operation *Operation) (ret *TxErr
 		view.Kanban.FillColBackgroundColor = masterView.Kanban.FillColBackgroundColor
 		view.Kanban.ShowIcon = masterView.Kanban.ShowIcon
 		view.Kanban.WrapField = masterView.Kanban.WrapField
+	case av.LayoutTypeCalendar:
+		for _, field := range masterView.Calendar.Fields {
+			view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{
+				BaseField: &av.BaseField{
+					ID:     field.ID,
+					Wrap:   field.Wrap,
+					Hidden: field.Hidden,
+					Desc:   field.Desc,
+				},
+			})
+		}
+
+		view.Calendar.StartKeyID = masterView.Calendar.StartKeyID
+		view.Calendar.EndKeyID = masterView.Calendar.EndKeyID
+		view.Calendar.TitleKeyID = masterView.Calendar.TitleKeyID
+		view.Calendar.Mode = masterView.Calendar.Mode
+		view.Calendar.FirstDayOfWeek = masterView.Calendar.FirstDayOfWeek
+		view.Calendar.ShowIcon = masterView.Calendar.ShowIcon
+		view.Calendar.WrapField = masterView.Calendar.WrapField
+	case av.LayoutTypeTimeline:
+		for _, field := range masterView.Timeline.Fields {
+			view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{
+				BaseField: &av.BaseField{
+					ID:     field.ID,
+					Wrap:   field.Wrap,
+					Hidden: field.Hidden,
+					Desc:   field.Desc,
+				},
+			})
+		}
+
+		view.Timeline.StartKeyID = masterView.Timeline.StartKeyID
+		view.Timeline.EndKeyID = masterView.Timeline.EndKeyID
+		view.Timeline.TitleKeyID = masterView.Timeline.TitleKeyID
+		view.Timeline.DependencyKeyID = masterView.Timeline.DependencyKeyID
+		view.Timeline.CascadeDependencies = masterView.Timeline.CascadeDependencies
+		view.Timeline.Zoom = masterView.Timeline.Zoom
+		view.Timeline.ShowIcon = masterView.Timeline.ShowIcon
+		view.Timeline.WrapField = masterView.Timeline.WrapField
+	case av.LayoutTypeChart:
+		for _, field := range masterView.Chart.Fields {
+			view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{
+				BaseField: &av.BaseField{
+					ID:     field.ID,
+					Wrap:   field.Wrap,
+					Hidden: field.Hidden,
+					Desc:   field.Desc,
+				},
+			})
+		}
+
+		view.Chart.ChartType = masterView.Chart.ChartType
+		view.Chart.SeriesKeyID = masterView.Chart.SeriesKeyID
+		if nil != masterView.Chart.Aggregate {
+			view.Chart.Aggregate = &av.ChartAggregate{Operator: masterView.Chart.Aggregate.Operator, KeyID: masterView.Chart.Aggregate.KeyID}
+		}
+		view.Chart.ShowIcon = masterView.Chart.ShowIcon
+		view.Chart.WrapField = masterView.Chart.WrapField
 	}
 
 	view.ItemIDs = masterView.ItemIDs
@@ -2787,6 +3032,18 @@ func addAttrViewView(avID, viewID, blockID string, layout av.LayoutType) (err er
 			for _, field := range firstView.Kanban.Fields {
 				view.Table.Columns = append(view.Table.Columns, &av.ViewTableColumn{BaseField: &av.BaseField{ID: field.ID}})
 			}
+		case av.LayoutTypeCalendar:
+			for _, field := range firstView.Calendar.Fields {
+				view.Table.Columns = append(view.Table.Columns, &av.ViewTableColumn{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range firstView.Timeline.Fields {
+				view.Table.Columns = append(view.Table.Columns, &av.ViewTableColumn{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range firstView.Chart.Fields {
+				view.Table.Columns = append(view.Table.Columns, &av.ViewTableColumn{BaseField: &av.BaseField{ID: field.ID}})
+			}
 		}
 	case av.LayoutTypeGallery:
 		view = av.NewGalleryView()
@@ -2803,6 +3060,18 @@ func addAttrViewView(avID, viewID, blockID string, layout av.LayoutType) (err er
 			for _, field := range firstView.Kanban.Fields {
 				view.Gallery.CardFields = append(view.Gallery.CardFields, &av.ViewGalleryCardField{BaseField: &av.BaseField{ID: field.ID}})
 			}
+		case av.LayoutTypeCalendar:
+			for _, field := range firstView.Calendar.Fields {
+				view.Gallery.CardFields = append(view.Gallery.CardFields, &av.ViewGalleryCardField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range firstView.Timeline.Fields {
+				view.Gallery.CardFields = append(view.Gallery.CardFields, &av.ViewGalleryCardField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range firstView.Chart.Fields {
+				view.Gallery.CardFields = append(view.Gallery.CardFields, &av.ViewGalleryCardField{BaseField: &av.BaseField{ID: field.ID}})
+			}
 		}
 	case av.LayoutTypeKanban:
 		view = av.NewKanbanView()
@@ -2819,6 +3088,110 @@ func addAttrViewView(avID, viewID, blockID string, layout av.LayoutType) (err er
 			for _, field := range firstView.Kanban.Fields {
 				view.Kanban.Fields = append(view.Kanban.Fields, &av.ViewKanbanField{BaseField: &av.BaseField{ID: field.ID}})
 			}
+		case av.LayoutTypeCalendar:
+			for _, field := range firstView.Calendar.Fields {
+				view.Kanban.Fields = append(view.Kanban.Fields, &av.ViewKanbanField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range firstView.Timeline.Fields {
+				view.Kanban.Fields = append(view.Kanban.Fields, &av.ViewKanbanField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range firstView.Chart.Fields {
+				view.Kanban.Fields = append(view.Kanban.Fields, &av.ViewKanbanField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		}
+	case av.LayoutTypeCalendar:
+		view = av.NewCalendarView()
+		switch firstView.LayoutType {
+		case av.LayoutTypeTable:
+			for _, col := range firstView.Table.Columns {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: col.ID}})
+			}
+		case av.LayoutTypeGallery:
+			for _, field := range firstView.Gallery.CardFields {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeKanban:
+			for _, field := range firstView.Kanban.Fields {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeCalendar:
+			for _, field := range firstView.Calendar.Fields {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range firstView.Timeline.Fields {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range firstView.Chart.Fields {
+				view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		}
+
+		if preferredDateKey := getCalendarPreferredDateKey(attrView); nil != preferredDateKey {
+			view.Calendar.StartKeyID = preferredDateKey.ID
+		}
+	case av.LayoutTypeTimeline:
+		view = av.NewTimelineView()
+		switch firstView.LayoutType {
+		case av.LayoutTypeTable:
+			for _, col := range firstView.Table.Columns {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: col.ID}})
+			}
+		case av.LayoutTypeGallery:
+			for _, field := range firstView.Gallery.CardFields {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeKanban:
+			for _, field := range firstView.Kanban.Fields {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeCalendar:
+			for _, field := range firstView.Calendar.Fields {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range firstView.Timeline.Fields {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range firstView.Chart.Fields {
+				view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		}
+
+		if preferredDateKey := getCalendarPreferredDateKey(attrView); nil != preferredDateKey {
+			view.Timeline.StartKeyID = preferredDateKey.ID
+		}
+	case av.LayoutTypeChart:
+		view = av.NewChartView()
+		switch firstView.LayoutType {
+		case av.LayoutTypeTable:
+			for _, col := range firstView.Table.Columns {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: col.ID}})
+			}
+		case av.LayoutTypeGallery:
+			for _, field := range firstView.Gallery.CardFields {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeKanban:
+			for _, field := range firstView.Kanban.Fields {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeCalendar:
+			for _, field := range firstView.Calendar.Fields {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeTimeline:
+			for _, field := range firstView.Timeline.Fields {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: field.ID}})
+			}
+		case av.LayoutTypeChart:
+			for _, field := range firstView.Chart.Fields {
+				view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: &av.BaseField{ID: field.ID}})
+			}
 		}
 	default:
 		err = av.ErrWrongLayoutType
@@ -2837,6 +3210,13 @@ func addAttrViewView(avID, viewID, blockID string, layout av.LayoutType) (err er
 		setAttributeViewGroup(attrView, view, group)
 	}
 
+	if av.LayoutTypeChart == layout {
+		// 图表视图默认按第一个单选字段分类
+		if preferredGroupKey := getChartPreferredGroupKey(attrView); nil != preferredGroupKey {
+			setAttributeViewGroup(attrView, view, &av.ViewGroup{Field: preferredGroupKey.ID})
+		}
+	}
+
 	node, tree, _ := getNodeByBlockID(nil, blockID)
 	if nil == node {
 		logging.LogErrorf("get node by block ID [%s] failed", blockID)
@@ -2872,6 +3252,18 @@ func getKanbanPreferredGroupKey(attrView *av.AttributeView) (ret *av.Key) {
 	return
 }
 
+// getCalendarPreferredDateKey 返回日历和时间线视图默认使用的开始日期字段，优先使用日期字段，其次是创建时间字段。
+func getCalendarPreferredDateKey(attrView *av.AttributeView) (ret *av.Key) {
+	for _, keyType := range []av.KeyType{av.KeyTypeDate, av.KeyTypeCreated, av.KeyTypeUpdated} {
+		for _, kv := range attrView.KeyValues {
+			if keyType == kv.Key.Type {
+				return kv.Key
+			}
+		}
+	}
+	return
+}
+
 func (tx *Transaction) doSetAttrViewViewName(operation *Operation) (ret *TxErr) {
 	var err error
 	avID := operation.AvID
@@ -3065,6 +3457,9 @@ func setAttributeViewFilters(operation *Operation) (err error) {
 	if err = gulu.JSON.UnmarshalJSON(data, &view.Filters); err != nil {
 		return
 	}
+	if err = av.ValidateFilters(view.Filters); nil != err {
+		return
+	}
 
 	err = av.SaveAttributeView(attrView)
 	return
@@ -3166,7 +3561,7 @@ func setAttributeViewColumnCalc(operation *Operation) (err error) {
 				break
 			}
 		}
-	case av.LayoutTypeGallery, av.LayoutTypeKanban:
+	case av.LayoutTypeGallery, av.LayoutTypeKanban, av.LayoutTypeCalendar, av.LayoutTypeTimeline, av.LayoutTypeChart:
 		return
 	}
 
@@ -3179,10 +3574,21 @@ func (tx *Transaction) doInsertAttrViewBlock(operation *Operation) (ret *TxErr)
 		operation.Context = map[string]interface{}{}
 	}
 
+	// 预先生成项目 ID，以便事务提交后执行添加项目的自动化规则
+	for _, src := range operation.Srcs {
+		if nil == src["itemID"] {
+			src["itemID"] = ast.NewNodeID()
+		}
+	}
+
 	err := AddAttributeViewBlock(tx, operation.Srcs, operation.AvID, operation.BlockID, operation.ViewID, operation.GroupID, operation.PreviousID, operation.IgnoreDefaultFill, operation.Context)
 	if err != nil {
 		return &TxErr{code: TxErrHandleAttributeView, id: operation.AvID, msg: err.Error()}
 	}
+
+	for _, src := range operation.Srcs {
+		tx.avAutomationEvents = append(tx.avAutomationEvents, &attrViewAutomationEvent{typ: av.AutomationTriggerItemAdded, avID: operation.AvID, itemID: src["itemID"].(string)})
+	}
 	return
 }
 
@@ -3682,6 +4088,54 @@ func duplicateAttributeViewKey(operation *Operation) (err error) {
 					break
 				}
 			}
+		case av.LayoutTypeCalendar:
+			for i, field := range view.Calendar.Fields {
+				if field.ID == key.ID {
+					view.Calendar.Fields = append(view.Calendar.Fields[:i+1], append([]*av.ViewCalendarField{
+						{
+							BaseField: &av.BaseField{
+								ID:     copyKey.ID,
+								Wrap:   field.Wrap,
+								Hidden: field.Hidden,
+								Desc:   field.Desc,
+							},
+						},
+					}, view.Calendar.Fields[i+1:]...)...)
+					break
+				}
+			}
+		case av.LayoutTypeTimeline:
+			for i, field := range view.Timeline.Fields {
+				if field.ID == key.ID {
+					view.Timeline.Fields = append(view.Timeline.Fields[:i+1], append([]*av.ViewTimelineField{
+						{
+							BaseField: &av.BaseField{
+								ID:     copyKey.ID,
+								Wrap:   field.Wrap,
+								Hidden: field.Hidden,
+								Desc:   field.Desc,
+							},
+						},
+					}, view.Timeline.Fields[i+1:]...)...)
+					break
+				}
+			}
+		case av.LayoutTypeChart:
+			for i, field := range view.Chart.Fields {
+				if field.ID == key.ID {
+					view.Chart.Fields = append(view.Chart.Fields[:i+1], append([]*av.ViewChartField{
+						{
+							BaseField: &av.BaseField{
+								ID:     copyKey.ID,
+								Wrap:   field.Wrap,
+								Hidden: field.Hidden,
+								Desc:   field.Desc,
+							},
+						},
+					}, view.Chart.Fields[i+1:]...)...)
+					break
+				}
+			}
 		}
 	}
 
@@ -3716,7 +4170,7 @@ func setAttributeViewColWidth(operation *Operation) (err error) {
 				break
 			}
 		}
-	case av.LayoutTypeGallery, av.LayoutTypeKanban:
+	case av.LayoutTypeGallery, av.LayoutTypeKanban, av.LayoutTypeCalendar, av.LayoutTypeTimeline, av.LayoutTypeChart:
 		return
 	}
 
@@ -3770,6 +4224,30 @@ func setAttributeViewColWrap(operation *Operation) (err error) {
 			allFieldWrap = allFieldWrap && field.Wrap
 		}
 		view.Kanban.WrapField = allFieldWrap
+	case av.LayoutTypeCalendar:
+		for _, field := range view.Calendar.Fields {
+			if field.ID == operation.ID {
+				field.Wrap = newWrap
+			}
+			allFieldWrap = allFieldWrap && field.Wrap
+		}
+		view.Calendar.WrapField = allFieldWrap
+	case av.LayoutTypeTimeline:
+		for _, field := range view.Timeline.Fields {
+			if field.ID == operation.ID {
+				field.Wrap = newWrap
+			}
+			allFieldWrap = allFieldWrap && field.Wrap
+		}
+		view.Timeline.WrapField = allFieldWrap
+	case av.LayoutTypeChart:
+		for _, field := range view.Chart.Fields {
+			if field.ID == operation.ID {
+				field.Wrap = newWrap
+			}
+			allFieldWrap = allFieldWrap && field.Wrap
+		}
+		view.Chart.WrapField = allFieldWrap
 	}
 
 	err = av.SaveAttributeView(attrView)
@@ -3817,6 +4295,27 @@ func setAttributeViewColHidden(operation *Operation) (err error) {
 				break
 			}
 		}
+	case av.LayoutTypeCalendar:
+		for _, field := range view.Calendar.Fields {
+			if field.ID == operation.ID {
+				field.Hidden = operation.Data.(bool)
+				break
+			}
+		}
+	case av.LayoutTypeTimeline:
+		for _, field := range view.Timeline.Fields {
+			if field.ID == operation.ID {
+				field.Hidden = operation.Data.(bool)
+				break
+			}
+		}
+	case av.LayoutTypeChart:
+		for _, field := range view.Chart.Fields {
+			if field.ID == operation.ID {
+				field.Hidden = operation.Data.(bool)
+				break
+			}
+		}
 	}
 
 	err = av.SaveAttributeView(attrView)
@@ -3850,7 +4349,7 @@ func setAttributeViewColPin(operation *Operation) (err error) {
 				break
 			}
 		}
-	case av.LayoutTypeGallery, av.LayoutTypeKanban:
+	case av.LayoutTypeGallery, av.LayoutTypeKanban, av.LayoutTypeCalendar, av.LayoutTypeTimeline, av.LayoutTypeChart:
 		return
 	}
 
@@ -3939,7 +4438,7 @@ func sortAttributeViewRow(operation *Operation) (err error) {
 		if groupView := view.GetGroupByID(operation.GroupID); nil != groupView {
 			groupKey := view.GetGroupKey(attrView)
 			isAcrossGroup := operation.GroupID != operation.TargetGroupID
-			if isAcrossGroup && (av.KeyTypeTemplate == groupKey.Type || av.KeyTypeCreated == groupKey.Type || av.KeyTypeUpdated == groupKey.Type) {
+			if isAcrossGroup && (av.KeyTypeTemplate == groupKey.Type || av.KeyTypeFormula == groupKey.Type || av.KeyTypeCreated == groupKey.Type || av.KeyTypeUpdated == groupKey.Type) {
 				// 这些字段类型不支持跨分组移动，因为它们的值是自动计算生成的
 				return
 			}
@@ -4099,6 +4598,69 @@ func SortAttributeViewViewKey(avID, blockID, keyID, previousKeyID string) (err e
 			}
 		}
 		view.Kanban.Fields = util.InsertElem(view.Kanban.Fields, previousIndex, field)
+	case av.LayoutTypeCalendar:
+		var field *av.ViewCalendarField
+		for i, calendarField := range view.Calendar.Fields {
+			if calendarField.ID == keyID {
+				field = calendarField
+				curIndex = i
+				break
+			}
+		}
+		if nil == field {
+			return
+		}
+
+		view.Calendar.Fields = append(view.Calendar.Fields[:curIndex], view.Calendar.Fields[curIndex+1:]...)
+		for i, calendarField := range view.Calendar.Fields {
+			if calendarField.ID == previousKeyID {
+				previousIndex = i + 1
+				break
+			}
+		}
+		view.Calendar.Fields = util.InsertElem(view.Calendar.Fields, previousIndex, field)
+	case av.LayoutTypeTimeline:
+		var field *av.ViewTimelineField
+		for i, timelineField := range view.Timeline.Fields {
+			if timelineField.ID == keyID {
+				field = timelineField
+				curIndex = i
+				break
+			}
+		}
+		if nil == field {
+			return
+		}
+
+		view.Timeline.Fields = append(view.Timeline.Fields[:curIndex], view.Timeline.Fields[curIndex+1:]...)
+		for i, timelineField := range view.Timeline.Fields {
+			if timelineField.ID == previousKeyID {
+				previousIndex = i + 1
+				break
+			}
+		}
+		view.Timeline.Fields = util.InsertElem(view.Timeline.Fields, previousIndex, field)
+	case av.LayoutTypeChart:
+		var field *av.ViewChartField
+		for i, chartField := range view.Chart.Fields {
+			if chartField.ID == keyID {
+				field = chartField
+				curIndex = i
+				break
+			}
+		}
+		if nil == field {
+			return
+		}
+
+		view.Chart.Fields = append(view.Chart.Fields[:curIndex], view.Chart.Fields[curIndex+1:]...)
+		for i, chartField := range view.Chart.Fields {
+			if chartField.ID == previousKeyID {
+				previousIndex = i + 1
+				break
+			}
+		}
+		view.Chart.Fields = util.InsertElem(view.Chart.Fields, previousIndex, field)
 	}
 
 	err = av.SaveAttributeView(attrView)
@@ -4207,12 +4769,15 @@ func AddAttributeViewKey(avID, keyID, keyName, keyType, keyIcon, previousKeyID s
 	switch keyTyp {
 	case av.KeyTypeText, av.KeyTypeNumber, av.KeyTypeDate, av.KeyTypeSelect, av.KeyTypeMSelect, av.KeyTypeURL, av.KeyTypeEmail,
 		av.KeyTypePhone, av.KeyTypeMAsset, av.KeyTypeTemplate, av.KeyTypeCreated, av.KeyTypeUpdated, av.KeyTypeCheckbox,
-		av.KeyTypeRelation, av.KeyTypeRollup, av.KeyTypeLineNumber:
+		av.KeyTypeRelation, av.KeyTypeRollup, av.KeyTypeLineNumber, av.KeyTypeFormula:
 
 		key := av.NewKey(keyID, keyName, keyIcon, keyTyp)
 		if av.KeyTypeRollup == keyTyp {
 			key.Rollup = &av.Rollup{Calc: &av.RollupCalc{Operator: av.CalcOperatorNone}}
 		}
+		if av.KeyTypeFormula == keyTyp {
+			key.Formula = &av.Formula{}
+		}
 
 		attrView.KeyValues = append(attrView.KeyValues, &av.KeyValues{Key: key})
 
@@ -4222,8 +4787,8 @@ func AddAttributeViewKey(avID, keyID, keyName, keyType, keyIcon, previousKeyID s
 				newField.Wrap = view.Table.WrapField
 
 				if "" == previousKeyID {
-					if av.LayoutTypeGallery == currentView.LayoutType || av.LayoutTypeKanban == currentView.LayoutType {
-						// 如果当前视图是卡片或看板视图则添加到最后
+					if av.LayoutTypeGallery == currentView.LayoutType || av.LayoutTypeKanban == currentView.LayoutType || av.LayoutTypeCalendar == currentView.LayoutType || av.LayoutTypeTimeline == currentView.LayoutType || av.LayoutTypeChart == currentView.LayoutType {
+						// 如果当前视图不是表格视图则添加到最后
 						view.Table.Columns = append(view.Table.Columns, &av.ViewTableColumn{BaseField: newField})
 					} else {
 						view.Table.Columns = append([]*av.ViewTableColumn{{BaseField: newField}}, view.Table.Columns...)
@@ -4282,6 +4847,66 @@ func AddAttributeViewKey(avID, keyID, keyName, keyType, keyIcon, previousKeyID s
 					}
 				}
 			}
+
+			if nil != view.Calendar {
+				newField.Wrap = view.Calendar.WrapField
+
+				if "" == previousKeyID {
+					view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: newField})
+				} else {
+					added := false
+					for i, field := range view.Calendar.Fields {
+						if field.ID == previousKeyID {
+							view.Calendar.Fields = append(view.Calendar.Fields[:i+1], append([]*av.ViewCalendarField{{BaseField: newField}}, view.Calendar.Fields[i+1:]...)...)
+							added = true
+							break
+						}
+					}
+					if !added {
+						view.Calendar.Fields = append(view.Calendar.Fields, &av.ViewCalendarField{BaseField: newField})
+					}
+				}
+			}
+
+			if nil != view.Timeline {
+				newField.Wrap = view.Timeline.WrapField
+
+				if "" == previousKeyID {
+					view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: newField})
+				} else {
+					added := false
+					for i, field := range view.Timeline.Fields {
+						if field.ID == previousKeyID {
+							view.Timeline.Fields = append(view.Timeline.Fields[:i+1], append([]*av.ViewTimelineField{{BaseField: newField}}, view.Timeline.Fields[i+1:]...)...)
+							added = true
+							break
+						}
+					}
+					if !added {
+						view.Timeline.Fields = append(view.Timeline.Fields, &av.ViewTimelineField{BaseField: newField})
+					}
+				}
+			}
+
+			if nil != view.Chart {
+				newField.Wrap = view.Chart.WrapField
+
+				if "" == previousKeyID {
+					view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: newField})
+				} else {
+					added := false
+					for i, field := range view.Chart.Fields {
+						if field.ID == previousKeyID {
+							view.Chart.Fields = append(view.Chart.Fields[:i+1], append([]*av.ViewChartField{{BaseField: newField}}, view.Chart.Fields[i+1:]...)...)
+							added = true
+							break
+						}
+					}
+					if !added {
+						view.Chart.Fields = append(view.Chart.Fields, &av.ViewChartField{BaseField: newField})
+					}
+				}
+			}
 		}
 	}
 
@@ -4367,13 +4992,24 @@ func updateAttributeViewColumn(operation *Operation) (err error) {
 	switch colType {
 	case av.KeyTypeBlock, av.KeyTypeText, av.KeyTypeNumber, av.KeyTypeDate, av.KeyTypeSelect, av.KeyTypeMSelect, av.KeyTypeURL, av.KeyTypeEmail,
 		av.KeyTypePhone, av.KeyTypeMAsset, av.KeyTypeTemplate, av.KeyTypeCreated, av.KeyTypeUpdated, av.KeyTypeCheckbox,
-		av.KeyTypeRelation, av.KeyTypeRollup, av.KeyTypeLineNumber:
+		av.KeyTypeRelation, av.KeyTypeRollup, av.KeyTypeLineNumber, av.KeyTypeFormula:
 		for _, keyValues := range attrView.KeyValues {
 			if keyValues.Key.ID == operation.ID {
+				oldName := keyValues.Key.Name
 				keyValues.Key.Name = strings.TrimSpace(operation.Name)
+				if oldName != keyValues.Key.Name {
+					renameAttrViewFormulaProps(attrView, oldName, keyValues.Key.Name)
+				}
 
 				changeType = keyValues.Key.Type != colType
 				keyValues.Key.Type = colType
+				if av.KeyTypeFormula == colType && nil == keyValues.Key.Formula {
+					keyValues.Key.Formula = &av.Formula{}
+				}
+				if changeType && nil != keyValues.Key.Constraint && nil != av.CheckKeyConstraint(keyValues.Key, keyValues.Key.Constraint) {
+					// 修改类型后不再适用的校验规则需要清除
+					keyValues.Key.Constraint = nil
+				}
 
 				for _, value := range keyValues.Values {
 					value.Type = colType
@@ -4501,6 +5137,27 @@ func RemoveAttributeViewKey(avID, keyID string, removeRelationDest bool) (err er
 									break
 								}
 							}
+						case av.LayoutTypeCalendar:
+							for i, field := range view.Calendar.Fields {
+								if field.ID == removedKey.Relation.BackKeyID {
+									view.Calendar.Fields = append(view.Calendar.Fields[:i], view.Calendar.Fields[i+1:]...)
+									break
+								}
+							}
+						case av.LayoutTypeTimeline:
+							for i, field := range view.Timeline.Fields {
+								if field.ID == removedKey.Relation.BackKeyID {
+									view.Timeline.Fields = append(view.Timeline.Fields[:i], view.Timeline.Fields[i+1:]...)
+									break
+								}
+							}
+						case av.LayoutTypeChart:
+							for i, field := range view.Chart.Fields {
+								if field.ID == removedKey.Relation.BackKeyID {
+									view.Chart.Fields = append(view.Chart.Fields[:i], view.Chart.Fields[i+1:]...)
+									break
+								}
+							}
 						}
 					}
 				}
@@ -4554,6 +5211,66 @@ func RemoveAttributeViewKey(avID, keyID string, removeRelationDest bool) (err er
 				}
 			}
 		}
+
+		if nil != view.Calendar {
+			for i, field := range view.Calendar.Fields {
+				if field.ID == keyID {
+					view.Calendar.Fields = append(view.Calendar.Fields[:i], view.Calendar.Fields[i+1:]...)
+					break
+				}
+			}
+
+			// 删除的字段是日历使用的日期或标题字段时清空设置
+			if view.Calendar.StartKeyID == keyID {
+				view.Calendar.StartKeyID = ""
+			}
+			if view.Calendar.EndKeyID == keyID {
+				view.Calendar.EndKeyID = ""
+			}
+			if view.Calendar.TitleKeyID == keyID {
+				view.Calendar.TitleKeyID = ""
+			}
+		}
+
+		if nil != view.Timeline {
+			for i, field := range view.Timeline.Fields {
+				if field.ID == keyID {
+					view.Timeline.Fields = append(view.Timeline.Fields[:i], view.Timeline.Fields[i+1:]...)
+					break
+				}
+			}
+
+			// 删除的字段是时间线使用的日期、标题或依赖字段时清空设置
+			if view.Timeline.StartKeyID == keyID {
+				view.Timeline.StartKeyID = ""
+			}
+			if view.Timeline.EndKeyID == keyID {
+				view.Timeline.EndKeyID = ""
+			}
+			if view.Timeline.TitleKeyID == keyID {
+				view.Timeline.TitleKeyID = ""
+			}
+			if view.Timeline.DependencyKeyID == keyID {
+				view.Timeline.DependencyKeyID = ""
+			}
+		}
+
+		if nil != view.Chart {
+			for i, field := range view.Chart.Fields {
+				if field.ID == keyID {
+					view.Chart.Fields = append(view.Chart.Fields[:i], view.Chart.Fields[i+1:]...)
+					break
+				}
+			}
+
+			// 删除的字段是图表使用的系列或聚合字段时重置设置
+			if view.Chart.SeriesKeyID == keyID {
+				view.Chart.SeriesKeyID = ""
+			}
+			if nil != view.Chart.Aggregate && view.Chart.Aggregate.KeyID == keyID {
+				view.Chart.Aggregate = &av.ChartAggregate{Operator: av.CalcOperatorCountAll}
+			}
+		}
 	}
 
 	for _, view := range attrView.Views {
@@ -4688,9 +5405,14 @@ func BatchReplaceAttributeViewBlocks(avID string, isDetached bool, oldNew []map[
 }
 
 func (tx *Transaction) doUpdateAttrViewCell(operation *Operation) (ret *TxErr) {
+	event := newAttrViewCellChangedEvent(operation.AvID, operation.KeyID, operation.RowID)
 	_, err := UpdateAttributeViewCell(tx, operation.AvID, operation.KeyID, operation.RowID, operation.Data)
 	if err != nil {
-		return &TxErr{code: TxErrHandleAttributeView, id: operation.AvID, msg: err.Error()}
+		return newAttrViewCellTxErr(operation.AvID, err)
+	}
+
+	if nil != event {
+		tx.avAutomationEvents = append(tx.avAutomationEvents, event)
 	}
 	return
 }
@@ -4734,6 +5456,24 @@ func UpdateAttributeViewCell(tx *Transaction, avID, keyID, itemID string, valueD
 }
 
 func updateAttributeViewValue(tx *Transaction, attrView *av.AttributeView, keyID, itemID string, valueData interface{}) (val *av.Value, err error) {
+	val, oldVal, changed, err := applyAttributeViewValue(tx, attrView, keyID, itemID, valueData)
+	if nil != err || !changed {
+		return
+	}
+
+	regenAttrViewGroups(attrView)
+	if err = av.SaveAttributeView(attrView); nil != err {
+		return
+	}
+
+	recordAttrViewCellHistory(tx, attrView.ID, itemID, keyID, oldVal, val)
+	refreshRelatedSrcAvs(attrView.ID)
+	return
+}
+
+// applyAttributeViewValue 校验并更新内存中的单元格值，不保存数据库。
+// changed 为 false 时表示不需要保存，oldVal 用于记录单元格历史
+func applyAttributeViewValue(tx *Transaction, attrView *av.AttributeView, keyID, itemID string, valueData interface{}) (val, oldVal *av.Value, changed bool, err error) {
 	avID := attrView.ID
 	var blockVal *av.Value
 	for _, kv := range attrView.KeyValues {
@@ -4764,6 +5504,7 @@ func updateAttributeViewValue(tx *Transaction, attrView *av.AttributeView, keyID
 			if itemID == value.BlockID {
 				val = value
 				val.Type = keyValues.Key.Type
+				oldVal = val.Clone()
 				break
 			}
 		}
@@ -4843,6 +5584,14 @@ func updateAttributeViewValue(tx *Transaction, attrView *av.AttributeView, keyID
 		}
 	}
 
+	// 校验字段规则，不满足时不保存
+	if nil != key && nil != key.Constraint {
+		if violation := av.CheckValueConstraint(attrView, key, itemID, val); nil != violation {
+			err = violation
+			return
+		}
+	}
+
 	relationChangeMode := 0 // 0：不变（仅排序），1：增加，2：减少
 	if av.KeyTypeRelation == val.Type {
 		// 关联字段得 content 是自动渲染的，所以不需要保存
@@ -4916,13 +5665,7 @@ func updateAttributeViewValue(tx *Transaction, attrView *av.AttributeView, keyID
 		// 双向关联需要同时更新目标字段的值
 		updateTwoWayRelationDestAttrView(attrView, key, val, relationChangeMode, oldRelationBlockIDs)
 	}
-
-	regenAttrViewGroups(attrView)
-	if err = av.SaveAttributeView(attrView); nil != err {
-		return
-	}
-
-	refreshRelatedSrcAvs(avID)
+	changed = true
 	return
 }
 
@@ -5068,21 +5811,7 @@ func bindBlockAv(tx *Transaction, avID, blockID string) {
 }
 
 func bindBlockAv0(tx *Transaction, avID string, node *ast.Node, tree *parse.Tree) {
-	attrs := parse.IAL2Map(node.KramdownIAL)
-	if "" == attrs[av.NodeAttrNameAvs] {
-		attrs[av.NodeAttrNameAvs] = avID
-	} else {
-		avIDs := strings.Split(attrs[av.NodeAttrNameAvs], ",")
-		avIDs = append(avIDs, avID)
-		avIDs = gulu.Str.RemoveDuplicatedElem(avIDs)
-		attrs[av.NodeAttrNameAvs] = strings.Join(avIDs, ",")
-	}
-
-	avNames := getAvNames(attrs[av.NodeAttrNameAvs])
-	if "" != avNames {
-		attrs[av.NodeAttrViewNames] = avNames
-	}
-
+	attrs := getBindBlockAvAttrs(avID, node)
 	var err error
 	if nil != tx {
 		err = setNodeAttrsWithTx(tx, node, tree, attrs)
@@ -5096,6 +5825,54 @@ func bindBlockAv0(tx *Transaction, avID string, node *ast.Node, tree *parse.Tree
 	return
 }
 
+// bindBlockAvWithContext 使用 WorkspaceContext 将块绑定到数据库，用于不在事务中的场景。
+func bindBlockAvWithContext(ctx *WorkspaceContext, avID, blockID string) (err error) {
+	bt := getBlockTreeWithContext(ctx, blockID)
+	if nil == bt {
+		return ErrBlockNotFound
+	}
+	tree, err := loadTreeByBlockTreeWithContext(ctx, bt)
+	if nil != err {
+		return
+	}
+	node := treenode.GetNodeInTree(tree, blockID)
+	if nil == node {
+		return ErrBlockNotFound
+	}
+
+	oldAttrs, err := setNodeAttrs0(node, getBindBlockAvAttrs(avID, node))
+	if nil != err {
+		return
+	}
+	if err = indexWriteTreeUpsertQueueWithContext(tree, ctx); nil != err {
+		return
+	}
+
+	IncSyncWithContext(ctx)
+	cache.PutBlockIAL(node.ID, parse.IAL2Map(node.KramdownIAL))
+	pushBroadcastAttrTransactions(oldAttrs, node)
+	return
+}
+
+// getBindBlockAvAttrs 返回块绑定到数据库后的属性。
+func getBindBlockAvAttrs(avID string, node *ast.Node) (ret map[string]string) {
+	ret = parse.IAL2Map(node.KramdownIAL)
+	if "" == ret[av.NodeAttrNameAvs] {
+		ret[av.NodeAttrNameAvs] = avID
+	} else {
+		avIDs := strings.Split(ret[av.NodeAttrNameAvs], ",")
+		avIDs = append(avIDs, avID)
+		avIDs = gulu.Str.RemoveDuplicatedElem(avIDs)
+		ret[av.NodeAttrNameAvs] = strings.Join(avIDs, ",")
+	}
+
+	avNames := getAvNames(ret[av.NodeAttrNameAvs])
+	if "" != avNames {
+		ret[av.NodeAttrViewNames] = avNames
+	}
+	return
+}
+
 func updateBlockValueStaticText(tx *Transaction, node *ast.Node, tree *parse.Tree, avID, text string) {
 	// 设置静态锚文本 Database-bound block primary key supports setting static anchor text https://github.com/siyuan-note/siyuan/issues/10049
 
@@ -5261,14 +6038,14 @@ func removeAttributeViewColumnOption(operation *Operation) (err error) {
 
 	// 如果存在选项对应的过滤条件，则删除过滤条件中设置的选项值 https://github.com/siyuan-note/siyuan/issues/15536
 	for _, view := range attrView.Views {
-		for _, filter := range view.Filters {
+		view.Filters, _ = av.RemoveFilters(view.Filters, func(filter *av.ViewFilter) bool {
 			if filter.Column != operation.ID {
-				continue
+				return false
 			}
 
 			if nil != filter.Value && (av.KeyTypeSelect == filter.Value.Type || av.KeyTypeMSelect == filter.Value.Type) {
 				if av.FilterOperatorIsEmpty == filter.Operator || av.FilterOperatorIsNotEmpty == filter.Operator {
-					continue
+					return false
 				}
 
 				for i, opt := range filter.Value.MSelect {
@@ -5277,17 +6054,11 @@ func removeAttributeViewColumnOption(operation *Operation) (err error) {
 						break
 					}
 				}
-				if 1 > len(filter.Value.MSelect) {
-					// 如果删除后选项值为空，则删除过滤条件
-					for i, f := range view.Filters {
-						if f.Column == operation.ID && f.Value == filter.Value {
-							view.Filters = append(view.Filters[:i], view.Filters[i+1:]...)
-							break
-						}
-					}
-				}
+				// 如果删除后选项值为空，则删除过滤条件
+				return 1 > len(filter.Value.MSelect)
 			}
-		}
+			return false
+		})
 	}
 
 	regenAttrViewGroups(attrView)
@@ -5392,9 +6163,9 @@ func updateAttributeViewColumnOption(operation *Operation) (err error) {
 	// 如果存在选项对应的过滤条件，需要更新过滤条件中设置的选项值
 	// Database select field filters follow option editing changes https://github.com/siyuan-note/siyuan/issues/10881
 	for _, view := range attrView.Views {
-		for _, filter := range view.Filters {
+		av.WalkFilters(view.Filters, func(filter *av.ViewFilter) {
 			if filter.Column != key.ID {
-				continue
+				return
 			}
 
 			if nil != filter.Value && (av.KeyTypeSelect == filter.Value.Type || av.KeyTypeMSelect == filter.Value.Type) {
@@ -5406,7 +6177,7 @@ func updateAttributeViewColumnOption(operation *Operation) (err error) {
 					}
 				}
 			}
-		}
+		})
 	}
 
 	regenAttrViewGroups(attrView)
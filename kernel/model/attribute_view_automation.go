@@ -0,0 +1,731 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/88250/lute/ast"
+	"github.com/siyuan-note/filelock"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/av"
+	"github.com/siyuan-note/siyuan/kernel/filesys"
+	"github.com/siyuan-note/siyuan/kernel/sql"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// AttrViewAutomationLog 描述了自动化规则执行日志的结构。
+type AttrViewAutomationLog struct {
+	ID             string                         `json:"id"`             // 日志 ID
+	AutomationID   string                         `json:"automationID"`   // 规则 ID
+	AutomationName string                         `json:"automationName"` // 规则名称
+	Trigger        av.AutomationTriggerType       `json:"trigger"`        // 触发类型
+	ItemID         string                         `json:"itemID"`         // 触发规则的项目 ID
+	Created        int64                          `json:"created"`        // 执行时间
+	Success        bool                           `json:"success"`        // 是否所有动作都执行成功
+	Actions        []*AttrViewAutomationActionLog `json:"actions"`        // 动作执行结果
+}
+
+// AttrViewAutomationActionLog 描述了自动化规则动作执行结果的结构。
+type AttrViewAutomationActionLog struct {
+	Type av.AutomationActionType `json:"type"`          // 动作类型
+	Msg  string                  `json:"msg,omitempty"` // 执行失败时的错误信息
+}
+
+// attrViewAutomationEvent 描述了事务中可能触发自动化规则的事件。
+type attrViewAutomationEvent struct {
+	typ      av.AutomationTriggerType
+	avID     string
+	keyID    string
+	itemID   string
+	oldValue *av.Value // 单元格修改前的值
+}
+
+const attrViewAutomationLogMaxCount = 256
+
+var attrViewAutomationLogLock = sync.Mutex{}
+
+func GetAttributeViewAutomations(avID string) (ret []*av.Automation, err error) {
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+
+	ret = attrView.Automations
+	if nil == ret {
+		ret = []*av.Automation{}
+	}
+	return
+}
+
+// SetAttributeViewAutomations 保存数据库的自动化规则。
+//
+// 修改触发条件或者重新启用规则后，到达日期规则只处理保存之后到达的日期，避免一次性触发所有历史项目。
+func SetAttributeViewAutomations(avID string, automations []*av.Automation) (err error) {
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+
+	oldAutomations := map[string]*av.Automation{}
+	for _, automation := range attrView.Automations {
+		oldAutomations[automation.ID] = automation
+	}
+
+	now := time.Now().UnixMilli()
+	for _, automation := range automations {
+		if err = checkAttrViewAutomation(attrView, automation); nil != err {
+			return
+		}
+
+		if "" == automation.ID {
+			automation.ID = ast.NewNodeID()
+		}
+		automation.Name = strings.TrimSpace(automation.Name)
+		automation.Created, automation.Updated, automation.Fired = now, now, nil
+		if old := oldAutomations[automation.ID]; nil != old {
+			automation.Created = old.Created
+			if old.Enabled == automation.Enabled && nil != old.Trigger && *old.Trigger == *automation.Trigger {
+				automation.Updated, automation.Fired = old.Updated, old.Fired
+			}
+		}
+	}
+
+	attrView.Automations = automations
+	if err = av.SaveAttributeView(attrView); nil != err {
+		return
+	}
+
+	setAttrViewDateAutomationIndex(attrView)
+	return
+}
+
+func checkAttrViewAutomation(attrView *av.AttributeView, automation *av.Automation) (err error) {
+	if nil == automation.Trigger {
+		return errors.New("automation trigger is empty")
+	}
+
+	trigger := automation.Trigger
+	switch trigger.Type {
+	case av.AutomationTriggerItemAdded:
+	case av.AutomationTriggerCellChanged:
+		if key, _ := attrView.GetKey(trigger.KeyID); nil == key {
+			return fmt.Errorf("field [%s] not found", trigger.KeyID)
+		}
+	case av.AutomationTriggerDateReached:
+		if key, _ := attrView.GetKey(trigger.KeyID); !av.IsCalendarDateKey(key) {
+			return fmt.Errorf("field [%s] can not be used as automation date", trigger.KeyID)
+		}
+	default:
+		return fmt.Errorf("invalid automation trigger [%s]", trigger.Type)
+	}
+
+	if 1 > len(automation.Actions) {
+		return errors.New("automation actions are empty")
+	}
+
+	for _, action := range automation.Actions {
+		switch action.Type {
+		case av.AutomationActionSetCell:
+			key, _ := attrView.GetKey(action.KeyID)
+			if nil == key {
+				return fmt.Errorf("field [%s] not found", action.KeyID)
+			}
+			switch key.Type {
+			case av.KeyTypeBlock, av.KeyTypeCreated, av.KeyTypeUpdated, av.KeyTypeRollup, av.KeyTypeFormula, av.KeyTypeLineNumber, av.KeyTypeTemplate:
+				return fmt.Errorf("field [%s] can not be set by automation", action.KeyID)
+			}
+		case av.AutomationActionAddToAv, av.AutomationActionRemoveFromAv:
+			if attrView.ID == action.AvID || !av.IsAttributeViewExist(action.AvID) {
+				return fmt.Errorf("invalid automation target database [%s]", action.AvID)
+			}
+		case av.AutomationActionCreateDoc:
+			if nil == Conf.Box(action.BoxID) {
+				return fmt.Errorf("notebook [%s] not found", action.BoxID)
+			}
+			if "" != action.Template {
+				if _, err = getAttrViewAutomationTemplatePath(action.Template); nil != err {
+					return
+				}
+			}
+		case av.AutomationActionNotify:
+		case av.AutomationActionWebhook:
+			u, parseErr := url.Parse(action.URL)
+			if nil != parseErr || ("http" != u.Scheme && "https" != u.Scheme) || "" == u.Host {
+				return fmt.Errorf("invalid webhook url [%s]", action.URL)
+			}
+		default:
+			return fmt.Errorf("invalid automation action [%s]", action.Type)
+		}
+	}
+	return
+}
+
+func GetAttributeViewAutomationLogs(avID string) (ret []*AttrViewAutomationLog) {
+	attrViewAutomationLogLock.Lock()
+	defer attrViewAutomationLogLock.Unlock()
+
+	return readAttrViewAutomationLogs(avID)
+}
+
+func getAttrViewAutomationLogPath(avID string) string {
+	return filepath.Join(util.TempDir, "av", "automation", avID+".json")
+}
+
+func readAttrViewAutomationLogs(avID string) (ret []*AttrViewAutomationLog) {
+	ret = []*AttrViewAutomationLog{}
+	p := getAttrViewAutomationLogPath(avID)
+	if !filelock.IsExist(p) {
+		return
+	}
+
+	data, err := filelock.ReadFile(p)
+	if nil != err {
+		logging.LogErrorf("read attribute view [%s] automation logs failed: %s", avID, err)
+		return
+	}
+	if err = gulu.JSON.UnmarshalJSON(data, &ret); nil != err {
+		logging.LogErrorf("unmarshal attribute view [%s] automation logs failed: %s", avID, err)
+		return []*AttrViewAutomationLog{}
+	}
+	return
+}
+
+// appendAttrViewAutomationLog 保存规则执行日志，最新的日志在前面，每个数据库最多保留 attrViewAutomationLogMaxCount 条。
+func appendAttrViewAutomationLog(avID string, log *AttrViewAutomationLog) {
+	attrViewAutomationLogLock.Lock()
+	defer attrViewAutomationLogLock.Unlock()
+
+	logs := append([]*AttrViewAutomationLog{log}, readAttrViewAutomationLogs(avID)...)
+	if attrViewAutomationLogMaxCount < len(logs) {
+		logs = logs[:attrViewAutomationLogMaxCount]
+	}
+
+	data, err := gulu.JSON.MarshalJSON(logs)
+	if nil != err {
+		logging.LogErrorf("marshal attribute view [%s] automation logs failed: %s", avID, err)
+		return
+	}
+
+	p := getAttrViewAutomationLogPath(avID)
+	if err = os.MkdirAll(filepath.Dir(p), 0755); nil != err {
+		logging.LogErrorf("create attribute view automation log dir failed: %s", err)
+		return
+	}
+	if err = filelock.WriteFile(p, data); nil != err {
+		logging.LogErrorf("write attribute view [%s] automation logs failed: %s", avID, err)
+	}
+}
+
+// newAttrViewCellChangedEvent 在修改单元格前记录旧值，数据库中没有该字段的单元格修改规则时返回 nil。
+func newAttrViewCellChangedEvent(avID, keyID, itemID string) (ret *attrViewAutomationEvent) {
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+
+	found := false
+	for _, automation := range attrView.Automations {
+		if automation.Enabled && nil != automation.Trigger && av.AutomationTriggerCellChanged == automation.Trigger.Type && keyID == automation.Trigger.KeyID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	keyValues, _ := attrView.GetKeyValues(keyID)
+	if nil == keyValues {
+		return
+	}
+
+	ret = &attrViewAutomationEvent{typ: av.AutomationTriggerCellChanged, avID: avID, keyID: keyID, itemID: itemID}
+	if oldValue := keyValues.GetValue(itemID); nil != oldValue {
+		ret.oldValue = oldValue.Clone()
+	} else {
+		ret.oldValue = &av.Value{KeyID: keyID, BlockID: itemID, Type: keyValues.Key.Type}
+	}
+	return
+}
+
+// execAttrViewAutomations 在事务提交后执行事件触发的自动化规则。
+func execAttrViewAutomations(events []*attrViewAutomationEvent) {
+	defer logging.Recover()
+
+	// 等待触发规则的事务写入完成
+	FlushTxQueue()
+
+	attrViews := map[string]*av.AttributeView{}
+	for _, event := range events {
+		attrView := attrViews[event.avID]
+		if nil == attrView {
+			var err error
+			if attrView, err = av.ParseAttributeView(event.avID); nil != err {
+				continue
+			}
+			attrViews[event.avID] = attrView
+		}
+
+		blockValue := attrView.GetBlockValue(event.itemID)
+		if nil == blockValue {
+			// 项目已经被删除或者重复添加相同的绑定块
+			continue
+		}
+
+		for _, automation := range attrView.Automations {
+			if !automation.Enabled || nil == automation.Trigger || event.typ != automation.Trigger.Type {
+				continue
+			}
+
+			if av.AutomationTriggerCellChanged == event.typ {
+				if event.keyID != automation.Trigger.KeyID {
+					continue
+				}
+
+				newValue := av.GetValue(attrView.KeyValues, event.keyID, event.itemID)
+				if "" == strings.TrimSpace(automation.Trigger.Value) {
+					if nil != newValue && event.oldValue.String(false) == newValue.String(false) {
+						continue
+					}
+				} else if !av.MatchAutomationValue(newValue, automation.Trigger.Value) || av.MatchAutomationValue(event.oldValue, automation.Trigger.Value) {
+					continue
+				}
+			}
+
+			execAttrViewAutomation(attrView, automation, blockValue)
+		}
+	}
+}
+
+func execAttrViewAutomation(attrView *av.AttributeView, automation *av.Automation, blockValue *av.Value) {
+	itemID := blockValue.BlockID
+	log := &AttrViewAutomationLog{
+		ID:             ast.NewNodeID(),
+		AutomationID:   automation.ID,
+		AutomationName: automation.Name,
+		Trigger:        automation.Trigger.Type,
+		ItemID:         itemID,
+		Created:        time.Now().UnixMilli(),
+		Success:        true,
+	}
+
+	title := strings.TrimSpace(blockValue.Block.Content)
+	for _, action := range automation.Actions {
+		var err error
+		switch action.Type {
+		case av.AutomationActionSetCell:
+			if _, err = UpdateAttributeViewCell(nil, attrView.ID, action.KeyID, itemID, action.Value); nil == err {
+				ReloadAttrView(attrView.ID)
+			}
+		case av.AutomationActionAddToAv:
+			err = addAttrViewAutomationItem(action.AvID, blockValue)
+		case av.AutomationActionRemoveFromAv:
+			err = removeAttrViewAutomationItem(action.AvID, blockValue)
+		case av.AutomationActionCreateDoc:
+			err = createAttrViewAutomationDoc(action, attrView.ID, blockValue)
+		case av.AutomationActionNotify:
+			msg := action.Message
+			if "" == strings.TrimSpace(msg) {
+				msg = automation.Name + " {{title}}"
+			}
+			util.PushMsg(strings.ReplaceAll(msg, "{{title}}", title), 7000)
+		case av.AutomationActionWebhook:
+			err = postAttrViewAutomationWebhook(action.URL, attrView, automation, itemID)
+		default:
+			err = fmt.Errorf("invalid automation action [%s]", action.Type)
+		}
+
+		actionLog := &AttrViewAutomationActionLog{Type: action.Type}
+		if nil != err {
+			actionLog.Msg = err.Error()
+			log.Success = false
+			logging.LogWarnf("exec attribute view [%s] automation [%s] action [%s] failed: %s", attrView.ID, automation.ID, action.Type, err)
+		}
+		log.Actions = append(log.Actions, actionLog)
+	}
+
+	appendAttrViewAutomationLog(attrView.ID, log)
+}
+
+func addAttrViewAutomationItem(destAvID string, blockValue *av.Value) (err error) {
+	destAv, err := av.ParseAttributeView(destAvID)
+	if nil != err {
+		return
+	}
+
+	src := map[string]interface{}{"isDetached": blockValue.IsDetached, "content": blockValue.Block.Content}
+	if !blockValue.IsDetached {
+		if destAv.ExistBoundBlock(blockValue.Block.ID) {
+			return
+		}
+		src["id"] = blockValue.Block.ID
+	}
+
+	if err = AddAttributeViewBlock(nil, []map[string]interface{}{src}, destAvID, "", "", "", "", false, map[string]interface{}{}); nil != err {
+		return
+	}
+	ReloadAttrView(destAvID)
+	return
+}
+
+func removeAttrViewAutomationItem(destAvID string, blockValue *av.Value) (err error) {
+	if blockValue.IsDetached {
+		return errors.New("detached item can not be removed from other database")
+	}
+
+	destAv, err := av.ParseAttributeView(destAvID)
+	if nil != err {
+		return
+	}
+
+	destBlockValue := destAv.GetBlockValueByBoundID(blockValue.Block.ID)
+	if nil == destBlockValue {
+		return
+	}
+
+	if err = RemoveAttributeViewBlock([]string{destBlockValue.BlockID}, destAvID); nil != err {
+		return
+	}
+	ReloadAttrView(destAvID)
+	return
+}
+
+// createAttrViewAutomationDoc 使用模板创建以项目标题命名的文档，项目未绑定块时将项目绑定到新文档。
+func createAttrViewAutomationDoc(action *av.AutomationAction, avID string, blockValue *av.Value) (err error) {
+	title := strings.TrimSpace(strings.ReplaceAll(blockValue.Block.Content, "/", " "))
+	if "" == title {
+		title = Conf.language(16)
+	}
+
+	var md string
+	if "" != action.Template {
+		p, pathErr := getAttrViewAutomationTemplatePath(action.Template)
+		if nil != pathErr {
+			return pathErr
+		}
+		if md, err = renderAttrViewAutomationTemplate(p, map[string]string{"title": title, "id": blockValue.BlockID}); nil != err {
+			return
+		}
+	}
+
+	hPath := "/" + strings.Trim(strings.TrimSpace(action.HPath), "/")
+	parentID := ""
+	if "/" != hPath {
+		if parentID, err = createDocsByHPath(action.BoxID, hPath, "", "", ""); nil != err {
+			return
+		}
+	}
+
+	docID, err := CreateWithMarkdown("", action.BoxID, path.Join(hPath, title), md, parentID, "", false, "")
+	if nil != err {
+		return
+	}
+
+	if blockValue.IsDetached {
+		if err = BatchReplaceAttributeViewBlocks(avID, false, []map[string]string{{blockValue.BlockID: docID}}); nil != err {
+			return
+		}
+		ReloadAttrView(avID)
+	}
+	return
+}
+
+func getAttrViewAutomationTemplatePath(template string) (ret string, err error) {
+	templates := filepath.Join(util.DataDir, "templates")
+	ret = filepath.Join(templates, template)
+	if !util.IsSubPath(templates, ret) || !gulu.File.IsExist(ret) {
+		err = fmt.Errorf("template [%s] not found", template)
+	}
+	return
+}
+
+func renderAttrViewAutomationTemplate(p string, dataModel map[string]string) (ret string, err error) {
+	md, err := os.ReadFile(p)
+	if nil != err {
+		return
+	}
+
+	goTpl := template.New("").Delims(".action{", "}")
+	tplFuncMap := filesys.BuiltInTemplateFuncs()
+	sql.SQLTemplateFuncs(&tplFuncMap)
+	tpl, err := goTpl.Funcs(tplFuncMap).Parse(gulu.Str.FromBytes(md))
+	if nil != err {
+		err = errors.New(fmt.Sprintf(Conf.Language(44), err.Error()))
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if err = tpl.Execute(buf, dataModel); nil != err {
+		err = errors.New(fmt.Sprintf(Conf.Language(44), err.Error()))
+		return
+	}
+	ret = buf.String()
+	return
+}
+
+// postAttrViewAutomationWebhook 将触发规则的项目以 JSON 的形式 POST 到外部地址，字段值以字段名为键。
+func postAttrViewAutomationWebhook(u string, attrView *av.AttributeView, automation *av.Automation, itemID string) (err error) {
+	values := map[string]string{}
+	for _, kv := range attrView.KeyValues {
+		if value := kv.GetValue(itemID); nil != value {
+			values[kv.Key.Name] = value.String(false)
+		}
+	}
+
+	payload := map[string]interface{}{
+		"avID":           attrView.ID,
+		"avName":         attrView.Name,
+		"automationID":   automation.ID,
+		"automationName": automation.Name,
+		"trigger":        automation.Trigger.Type,
+		"itemID":         itemID,
+		"values":         values,
+	}
+
+	data, err := gulu.JSON.MarshalJSON(payload)
+	if nil != err {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if nil != err {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", util.UserAgent)
+	resp, err := attrViewAutomationWebhookClient.Do(req)
+	if nil != err {
+		return
+	}
+	defer resp.Body.Close()
+	if 200 > resp.StatusCode || 299 < resp.StatusCode {
+		err = fmt.Errorf("webhook responded with status code [%d]", resp.StatusCode)
+	}
+	return
+}
+
+var errAttrViewAutomationWebhookAddress = errors.New("webhook must not target loopback, private or link-local addresses")
+
+// attrViewAutomationWebhookClient 发送 Webhook 的客户端。
+// 在 DNS 解析之后、建立连接之前检查目标 IP，重定向后的地址同样经过检查，避免通过 Webhook 访问内网服务。
+// 不使用代理，否则检查的是代理地址
+var attrViewAutomationWebhookClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if nil != err {
+					return err
+				}
+				if ip := net.ParseIP(host); nil == ip || isForbiddenWebhookIP(ip) {
+					return errAttrViewAutomationWebhookAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		ForceAttemptHTTP2:   true,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if 5 <= len(via) {
+			return errors.New("webhook redirected too many times")
+		}
+		if "http" != req.URL.Scheme && "https" != req.URL.Scheme {
+			return fmt.Errorf("webhook redirected to unsupported scheme [%s]", req.URL.Scheme)
+		}
+		if ip := net.ParseIP(req.URL.Hostname()); nil != ip && isForbiddenWebhookIP(ip) {
+			return errAttrViewAutomationWebhookAddress
+		}
+		return nil
+	},
+}
+
+func isForbiddenWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || webhookSharedAddressSpace.Contains(ip) ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// webhookSharedAddressSpace 运营商级 NAT 使用的共享地址段 100.64.0.0/10（RFC 6598），net.IP.IsPrivate 不包含该地址段
+var webhookSharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+var (
+	attrViewDateAutomationAvIDs     = map[string]bool{} // 包含到达日期规则的数据库 ID
+	attrViewDateAutomationIndexTime time.Time           // 上次全量扫描的时间
+	attrViewDateAutomationIndexLock = sync.Mutex{}
+)
+
+// AttrViewAutomationJob 扫描到达日期的自动化规则并执行。
+func AttrViewAutomationJob() {
+	if !util.IsBooted() {
+		return
+	}
+
+	for _, avID := range getAttrViewDateAutomationAvIDs() {
+		execAttrViewDateAutomations(avID)
+	}
+}
+
+func execAttrViewDateAutomations(avID string) {
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+
+	type firing struct {
+		automation *av.Automation
+		blockValue *av.Value
+	}
+
+	now := time.Now().UnixMilli()
+	var firings []*firing
+	changed := false
+	blockKeyValues := attrView.GetBlockKeyValues()
+	for _, automation := range attrView.Automations {
+		if !automation.Enabled || nil == automation.Trigger || av.AutomationTriggerDateReached != automation.Trigger.Type {
+			continue
+		}
+
+		keyValues, _ := attrView.GetKeyValues(automation.Trigger.KeyID)
+		if nil == keyValues || !av.IsCalendarDateKey(keyValues.Key) {
+			continue
+		}
+
+		fired := map[string]int64{}
+		for _, blockValue := range blockKeyValues.Values {
+			var date int64
+			switch keyValues.Key.Type {
+			case av.KeyTypeDate:
+				if value := keyValues.GetValue(blockValue.BlockID); nil != value && nil != value.Date && value.Date.IsNotEmpty {
+					date = value.Date.Content
+				}
+			case av.KeyTypeCreated:
+				date = blockValue.Block.Created
+			case av.KeyTypeUpdated:
+				date = blockValue.Block.Updated
+			}
+			if 1 > date {
+				continue
+			}
+
+			if firedDate, ok := automation.Fired[blockValue.BlockID]; ok && firedDate == date {
+				fired[blockValue.BlockID] = firedDate
+				continue
+			}
+
+			fireAt := date + automation.Trigger.Offset*60*1000
+			if fireAt > now || fireAt <= automation.Updated {
+				continue
+			}
+
+			fired[blockValue.BlockID] = date
+			firings = append(firings, &firing{automation: automation, blockValue: blockValue})
+		}
+
+		if len(fired) != len(automation.Fired) || 0 < len(firings) {
+			changed = true
+		}
+		automation.Fired = fired
+	}
+
+	if !changed {
+		return
+	}
+
+	// 先保存触发记录再执行动作，避免动作执行失败时重复触发
+	if err = av.SaveAttributeView(attrView); nil != err {
+		return
+	}
+
+	for _, f := range firings {
+		execAttrViewAutomation(attrView, f.automation, f.blockValue)
+	}
+}
+
+// getAttrViewDateAutomationAvIDs 返回包含到达日期规则的数据库，每 30 分钟全量扫描一次以便发现同步下来的规则。
+func getAttrViewDateAutomationAvIDs() (ret []string) {
+	attrViewDateAutomationIndexLock.Lock()
+	defer attrViewDateAutomationIndexLock.Unlock()
+
+	if 30*time.Minute < time.Since(attrViewDateAutomationIndexTime) {
+		attrViewDateAutomationAvIDs = map[string]bool{}
+		avDir := filepath.Join(util.DataDir, "storage", "av")
+		entries, err := os.ReadDir(avDir)
+		if nil != err {
+			logging.LogErrorf("read attribute view dir failed: %s", err)
+			return
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasSuffix(name, ".json") || !ast.IsNodeIDPattern(strings.TrimSuffix(name, ".json")) {
+				continue
+			}
+
+			data, readErr := filelock.ReadFile(filepath.Join(avDir, name))
+			if nil != readErr || !bytes.Contains(data, []byte(av.AutomationTriggerDateReached)) {
+				continue
+			}
+
+			avID := strings.TrimSuffix(name, ".json")
+			if attrView, parseErr := av.ParseAttributeView(avID); nil == parseErr && hasAttrViewDateAutomation(attrView) {
+				attrViewDateAutomationAvIDs[avID] = true
+			}
+		}
+		attrViewDateAutomationIndexTime = time.Now()
+	}
+
+	for avID := range attrViewDateAutomationAvIDs {
+		ret = append(ret, avID)
+	}
+	return
+}
+
+func setAttrViewDateAutomationIndex(attrView *av.AttributeView) {
+	attrViewDateAutomationIndexLock.Lock()
+	defer attrViewDateAutomationIndexLock.Unlock()
+
+	if hasAttrViewDateAutomation(attrView) {
+		attrViewDateAutomationAvIDs[attrView.ID] = true
+	} else {
+		delete(attrViewDateAutomationAvIDs, attrView.ID)
+	}
+}
+
+func hasAttrViewDateAutomation(attrView *av.AttributeView) bool {
+	for _, automation := range attrView.Automations {
+		if automation.Enabled && nil != automation.Trigger && av.AutomationTriggerDateReached == automation.Trigger.Type {
+			return true
+		}
+	}
+	return false
+}
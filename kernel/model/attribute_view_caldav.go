@@ -0,0 +1,644 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/88250/lute/ast"
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav/caldav"
+	"github.com/siyuan-note/filelock"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/av"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+var (
+	attrViewCalendarAvIDs     = map[string]string{} // 日历路径 -> 数据库 ID
+	attrViewCalendarIndexTime time.Time
+	attrViewCalendarIndexLock = sync.Mutex{}
+
+	attrViewCalendarSyncLock = sync.Mutex{} // 数据库导出和日历导入串行执行
+)
+
+// GetAttributeViewCalendar 返回数据库绑定的日历，未绑定时返回 nil。
+func GetAttributeViewCalendar(avID string) (ret *av.CalendarBinding, err error) {
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+	ret = attrView.Calendar
+	return
+}
+
+// BindAttributeViewCalendar 将数据库绑定到日历，绑定后先导入日历中已有的事件，再导出数据库中的日期。
+func BindAttributeViewCalendar(avID, calendarPath, dateKeyID, notesKeyID string) (err error) {
+	calendarPath = PathCleanWithSlash(calendarPath)
+	if err = calendars.Load(); nil != err {
+		return
+	}
+	if _, err = calendars.GetCalendar(calendarPath); nil != err {
+		return
+	}
+
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+
+	dateKey, _ := attrView.GetKey(dateKeyID)
+	if nil == dateKey || av.KeyTypeDate != dateKey.Type {
+		return fmt.Errorf("field [%s] is not a date field", dateKeyID)
+	}
+	if "" != notesKeyID {
+		notesKey, _ := attrView.GetKey(notesKeyID)
+		if nil == notesKey || av.KeyTypeText != notesKey.Type {
+			return fmt.Errorf("field [%s] is not a text field", notesKeyID)
+		}
+	}
+	if boundAvID := getAttrViewCalendarAvID(calendarPath); "" != boundAvID && avID != boundAvID {
+		return fmt.Errorf("calendar [%s] is already bound to database [%s]", calendarPath, boundAvID)
+	}
+
+	binding := &av.CalendarBinding{CalendarPath: calendarPath, DateKeyID: dateKeyID, NotesKeyID: notesKeyID}
+	if nil != attrView.Calendar && calendarPath == attrView.Calendar.CalendarPath {
+		binding.UIDs = attrView.Calendar.UIDs
+		binding.ExportedUIDs = attrView.Calendar.ExportedUIDs
+	}
+	oldBinding := attrView.Calendar
+	attrView.Calendar = binding
+	if err = av.SaveAttributeView(attrView); nil != err {
+		return
+	}
+	if nil != oldBinding {
+		setAttrViewCalendarIndex(oldBinding.CalendarPath, "")
+	}
+	setAttrViewCalendarIndex(calendarPath, avID)
+
+	attrViewCalendarSyncLock.Lock()
+	defer attrViewCalendarSyncLock.Unlock()
+
+	objects, err := calendars.ListCalendarObjects(calendarPath, nil)
+	if nil != err {
+		return
+	}
+	changed := false
+	for _, object := range objects {
+		imported, importErr := importAttrViewCalendarEvent(avID, object.Data)
+		if nil != importErr {
+			logging.LogWarnf("import calendar object [%s] into attribute view [%s] failed: %s", object.Path, avID, importErr)
+			continue
+		}
+		changed = changed || imported
+	}
+	if changed {
+		ReloadAttrView(avID)
+	}
+	err = exportAttrViewCalendar(avID)
+	return
+}
+
+// UnbindAttributeViewCalendar 解除数据库和日历的绑定，日历中已经导出的事件会保留。
+func UnbindAttributeViewCalendar(avID string) (err error) {
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+	if nil == attrView.Calendar {
+		return
+	}
+
+	calendarPath := attrView.Calendar.CalendarPath
+	attrView.Calendar = nil
+	if err = av.SaveAttributeView(attrView); nil != err {
+		return
+	}
+	setAttrViewCalendarIndex(calendarPath, "")
+	return
+}
+
+// AttrViewCalendarJob 导出所有绑定日历的数据库，覆盖没有经过数据库事务的修改，比如绑定块标题变化和数据同步。
+func AttrViewCalendarJob() {
+	if !util.IsBooted() {
+		return
+	}
+
+	syncAttrViewCalendars(getAttrViewCalendarAvIDs())
+}
+
+// syncAttrViewCalendars 导出绑定了日历的数据库，未绑定日历的数据库会被跳过。
+func syncAttrViewCalendars(avIDs []string) {
+	boundAvIDs := map[string]bool{}
+	for _, avID := range getAttrViewCalendarAvIDs() {
+		boundAvIDs[avID] = true
+	}
+
+	attrViewCalendarSyncLock.Lock()
+	defer attrViewCalendarSyncLock.Unlock()
+
+	for _, avID := range avIDs {
+		if !boundAvIDs[avID] {
+			continue
+		}
+		if err := exportAttrViewCalendar(avID); nil != err {
+			logging.LogWarnf("export attribute view [%s] to calendar failed: %s", avID, err)
+		}
+	}
+}
+
+// syncAttrViewCalendarObject 将日历客户端写入的事件导入绑定的数据库，然后导出数据库以便覆盖导入失败的修改。
+func syncAttrViewCalendarObject(objectPath string, data *ical.Calendar) {
+	calendarPath, _, err := ParseCalendarObjectPath(objectPath)
+	if nil != err {
+		return
+	}
+	avID := getAttrViewCalendarAvID(calendarPath)
+	if "" == avID {
+		return
+	}
+
+	attrViewCalendarSyncLock.Lock()
+	defer attrViewCalendarSyncLock.Unlock()
+
+	if changed, importErr := importAttrViewCalendarEvent(avID, data); nil != importErr {
+		logging.LogWarnf("import calendar object [%s] into attribute view [%s] failed: %s", objectPath, avID, importErr)
+	} else if changed {
+		ReloadAttrView(avID)
+	}
+	if err = exportAttrViewCalendar(avID); nil != err {
+		logging.LogWarnf("export attribute view [%s] to calendar failed: %s", avID, err)
+	}
+}
+
+// removeAttrViewCalendarObject 日历客户端删除事件后清空对应项目的日期，项目本身不会被删除。
+func removeAttrViewCalendarObject(objectPath string, data *ical.Calendar) {
+	calendarPath, _, err := ParseCalendarObjectPath(objectPath)
+	if nil != err || nil == data {
+		return
+	}
+	avID := getAttrViewCalendarAvID(calendarPath)
+	if "" == avID {
+		return
+	}
+	uid := getICalendarUID(data)
+	if "" == uid {
+		return
+	}
+
+	attrViewCalendarSyncLock.Lock()
+	defer attrViewCalendarSyncLock.Unlock()
+
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err || nil == attrView.Calendar {
+		return
+	}
+
+	itemID := attrView.Calendar.GetItemID(uid)
+	if dateValue := attrView.GetValue(attrView.Calendar.DateKeyID, itemID); nil != dateValue && nil != dateValue.Date && dateValue.Date.IsNotEmpty {
+		valueData := map[string]interface{}{"date": &av.ValueDate{}}
+		if _, err = UpdateAttributeViewCell(nil, avID, attrView.Calendar.DateKeyID, itemID, valueData); nil != err {
+			logging.LogWarnf("clear attribute view [%s] item [%s] date failed: %s", avID, itemID, err)
+		} else {
+			ReloadAttrView(avID)
+		}
+	}
+	if err = exportAttrViewCalendar(avID); nil != err {
+		logging.LogWarnf("export attribute view [%s] to calendar failed: %s", avID, err)
+	}
+}
+
+// exportAttrViewCalendar 将数据库中日期不为空的项目写入绑定的日历，并删除导出过或者映射过但已经没有对应项目的事件。
+//
+// 内容没有变化的事件不会重写，以免改变 ETag 导致日历客户端的修改被拒绝。
+func exportAttrViewCalendar(avID string) (err error) {
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+	binding := attrView.Calendar
+	if nil == binding {
+		return
+	}
+
+	dateKeyValues, _ := attrView.GetKeyValues(binding.DateKeyID)
+	if nil == dateKeyValues || av.KeyTypeDate != dateKeyValues.Key.Type {
+		return fmt.Errorf("field [%s] is not a date field", binding.DateKeyID)
+	}
+	var notesKeyValues *av.KeyValues
+	if "" != binding.NotesKeyID {
+		if notesKeyValues, _ = attrView.GetKeyValues(binding.NotesKeyID); nil != notesKeyValues && av.KeyTypeText != notesKeyValues.Key.Type {
+			notesKeyValues = nil
+		}
+	}
+
+	objects, err := calendars.ListCalendarObjects(binding.CalendarPath, nil)
+	if nil != err {
+		return
+	}
+	existingObjects := map[string]*caldav.CalendarObject{}
+	for i := range objects {
+		if uid := getICalendarUID(objects[i].Data); "" != uid {
+			existingObjects[uid] = &objects[i]
+		}
+	}
+
+	exportedUIDs := map[string]bool{}
+	for _, blockValue := range attrView.GetBlockKeyValues().Values {
+		dateValue := dateKeyValues.GetValue(blockValue.BlockID)
+		if nil == dateValue || nil == dateValue.Date || !dateValue.Date.IsNotEmpty {
+			continue
+		}
+
+		uid := binding.GetUID(blockValue.BlockID)
+		exportedUIDs[uid] = true
+
+		var notes *string
+		if nil != notesKeyValues {
+			content := ""
+			if notesValue := notesKeyValues.GetValue(blockValue.BlockID); nil != notesValue && nil != notesValue.Text {
+				content = notesValue.Text.Content
+			}
+			notes = &content
+		}
+
+		objectPath := PathJoinWithSlash(binding.CalendarPath, blockValue.BlockID+ICalendarFileExt)
+		var oldData *ical.Calendar
+		if object := existingObjects[uid]; nil != object {
+			objectPath = object.Path
+			oldData = object.Data
+		}
+
+		data, changed := newAttrViewCalendarData(oldData, uid, blockValue.String(false), dateValue.Date, notes)
+		if !changed {
+			continue
+		}
+		if _, err = calendars.PutCalendarObject(objectPath, data, nil); nil != err {
+			return
+		}
+	}
+
+	for uid, object := range existingObjects {
+		if exportedUIDs[uid] || !binding.IsManagedUID(uid) {
+			// 没有导出过的事件可能是导入失败的客户端事件，不能删除
+			continue
+		}
+		if err = calendars.DeleteCalendarObject(object.Path); nil != err {
+			return
+		}
+	}
+
+	uids := make([]string, 0, len(exportedUIDs))
+	for uid := range exportedUIDs {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	if slices.Equal(uids, binding.ExportedUIDs) {
+		return
+	}
+
+	// 重新解析以免覆盖导出期间其他事务的修改
+	if attrView, err = av.ParseAttributeView(avID); nil != err || nil == attrView.Calendar {
+		return
+	}
+	attrView.Calendar.ExportedUIDs = uids
+	err = av.SaveAttributeView(attrView)
+	return
+}
+
+// importAttrViewCalendarEvent 将事件的标题、时间和备注写入对应的项目，没有对应项目时新建非绑定块项目。
+func importAttrViewCalendarEvent(avID string, data *ical.Calendar) (changed bool, err error) {
+	if nil == data {
+		return
+	}
+	events := data.Events()
+	if 1 > len(events) {
+		return
+	}
+	event := events[0]
+	uid, _ := event.Props.Text(ical.PropUID)
+	if "" == uid {
+		return
+	}
+
+	date, err := getICalendarEventDate(&event)
+	if nil != err {
+		return
+	}
+	summary, _ := event.Props.Text(ical.PropSummary)
+	summary = strings.TrimSpace(summary)
+	description, _ := event.Props.Text(ical.PropDescription)
+
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+	binding := attrView.Calendar
+	if nil == binding {
+		return
+	}
+
+	itemID := binding.GetItemID(uid)
+	blockValue := attrView.GetBlockValue(itemID)
+	if nil == blockValue {
+		// 日历客户端新建的事件，UID 不是块 ID 格式时记录映射
+		if !ast.IsNodeIDPattern(itemID) {
+			itemID = ast.NewNodeID()
+		}
+		src := map[string]interface{}{"itemID": itemID, "isDetached": true, "content": summary}
+		if err = AddAttributeViewBlock(nil, []map[string]interface{}{src}, avID, "", "", "", "", false, map[string]interface{}{}); nil != err {
+			return
+		}
+		changed = true
+
+		if attrView, err = av.ParseAttributeView(avID); nil != err {
+			return
+		}
+		if itemID != uid {
+			if nil == attrView.Calendar.UIDs {
+				attrView.Calendar.UIDs = map[string]string{}
+			}
+			attrView.Calendar.UIDs[uid] = itemID
+			if err = av.SaveAttributeView(attrView); nil != err {
+				return
+			}
+		}
+		if blockValue = attrView.GetBlockValue(itemID); nil == blockValue {
+			err = errors.New("add calendar event item failed")
+			return
+		}
+	}
+
+	if "" != summary && summary != blockValue.Block.Content {
+		valueData := map[string]interface{}{"isDetached": blockValue.IsDetached, "block": map[string]interface{}{"id": blockValue.Block.ID, "content": summary}}
+		if _, err = UpdateAttributeViewCell(nil, avID, blockValue.KeyID, itemID, valueData); nil != err {
+			return
+		}
+		changed = true
+	}
+
+	oldDate := attrView.GetValue(binding.DateKeyID, itemID)
+	if nil == oldDate || !isSameAttrViewCalendarDate(oldDate.Date, date) {
+		if _, err = UpdateAttributeViewCell(nil, avID, binding.DateKeyID, itemID, map[string]interface{}{"date": date}); nil != err {
+			return
+		}
+		changed = true
+	}
+
+	if "" != binding.NotesKeyID {
+		oldNotes := ""
+		if notesValue := attrView.GetValue(binding.NotesKeyID, itemID); nil != notesValue && nil != notesValue.Text {
+			oldNotes = notesValue.Text.Content
+		}
+		if oldNotes != description {
+			if _, err = UpdateAttributeViewCell(nil, avID, binding.NotesKeyID, itemID, map[string]interface{}{"text": &av.ValueText{Content: description}}); nil != err {
+				return
+			}
+			changed = true
+		}
+	}
+	return
+}
+
+// newAttrViewCalendarData 根据项目生成日历数据，已有事件时在副本上修改以保留日历客户端设置的其他属性（比如提醒）。
+//
+// 只在值的语义发生变化时才改写属性，避免日历客户端使用不同的时区写法时反复重写。
+func newAttrViewCalendarData(oldData *ical.Calendar, uid, title string, date *av.ValueDate, notes *string) (ret *ical.Calendar, changed bool) {
+	var oldContent []byte
+	if nil != oldData {
+		buf := bytes.Buffer{}
+		if err := ical.NewEncoder(&buf).Encode(oldData); nil == err {
+			oldContent = buf.Bytes()
+			ret, _ = ical.NewDecoder(bytes.NewReader(oldContent)).Decode()
+		}
+	}
+
+	var event *ical.Event
+	if nil != ret {
+		for _, child := range ret.Children {
+			if ical.CompEvent == child.Name {
+				event = &ical.Event{Component: child}
+				break
+			}
+		}
+	}
+	if nil == event {
+		ret = ical.NewCalendar()
+		ret.Props.SetText(ical.PropVersion, "2.0")
+		ret.Props.SetText(ical.PropProductID, "-//SiYuan//Database//EN")
+		event = ical.NewEvent()
+		event.Props.SetText(ical.PropUID, uid)
+		event.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+		ret.Children = append(ret.Children, event.Component)
+	}
+
+	if oldTitle, _ := event.Props.Text(ical.PropSummary); oldTitle != title {
+		event.Props.SetText(ical.PropSummary, title)
+	}
+	if nil != notes {
+		if oldNotes, _ := event.Props.Text(ical.PropDescription); oldNotes != *notes {
+			if "" == *notes {
+				event.Props.Del(ical.PropDescription)
+			} else {
+				event.Props.SetText(ical.PropDescription, *notes)
+			}
+		}
+	}
+
+	allDay := date.IsNotTime
+	start, end := getAttrViewCalendarRange(date)
+	if allDay {
+		end = end.AddDate(0, 0, 1) // 全天事件的结束日期不包含在内
+	}
+
+	if oldDate, err := getICalendarEventDate(event); nil != err || !isSameAttrViewCalendarDate(oldDate, date) {
+		if allDay {
+			event.Props.SetDate(ical.PropDateTimeStart, start)
+			event.Props.SetDate(ical.PropDateTimeEnd, end)
+		} else {
+			event.Props.SetDateTime(ical.PropDateTimeStart, start.UTC())
+			if end.After(start) {
+				event.Props.SetDateTime(ical.PropDateTimeEnd, end.UTC())
+			} else {
+				event.Props.Del(ical.PropDateTimeEnd)
+			}
+		}
+		event.Props.Del(ical.PropDuration)
+	}
+
+	buf := bytes.Buffer{}
+	if err := ical.NewEncoder(&buf).Encode(ret); nil != err {
+		logging.LogWarnf("encode calendar event [%s] failed: %s", uid, err)
+		return
+	}
+	changed = !bytes.Equal(oldContent, buf.Bytes())
+	return
+}
+
+// getICalendarEventDate 将事件的开始和结束时间转换为数据库日期值。
+func getICalendarEventDate(event *ical.Event) (ret *av.ValueDate, err error) {
+	startProp := event.Props.Get(ical.PropDateTimeStart)
+	if nil == startProp {
+		err = errors.New("calendar event has no start time")
+		return
+	}
+
+	start, err := startProp.DateTime(time.Local)
+	if nil != err {
+		return
+	}
+	end, err := event.DateTimeEnd(time.Local)
+	if nil != err {
+		return
+	}
+
+	allDay := ical.ValueDate == startProp.ValueType() || (ical.ValueDefault == startProp.ValueType() && 8 == len(startProp.Value))
+	if allDay {
+		end = end.AddDate(0, 0, -1)
+	}
+
+	ret = &av.ValueDate{Content: start.UnixMilli(), IsNotEmpty: true, IsNotTime: allDay}
+	if end.After(start) {
+		ret.HasEndDate = true
+		ret.Content2 = end.UnixMilli()
+		ret.IsNotEmpty2 = true
+	}
+	return
+}
+
+// isSameAttrViewCalendarDate 判断两个日期在日历中是否表示同一时间，全天日期只比较日期部分。
+func isSameAttrViewCalendarDate(a, b *av.ValueDate) bool {
+	if nil == a || nil == b || !a.IsNotEmpty || !b.IsNotEmpty || a.IsNotTime != b.IsNotTime {
+		return false
+	}
+
+	aStart, aEnd := getAttrViewCalendarRange(a)
+	bStart, bEnd := getAttrViewCalendarRange(b)
+	return aStart.Equal(bStart) && aEnd.Equal(bEnd)
+}
+
+// getAttrViewCalendarRange 返回日期的开始和结束时间，没有结束时间时结束时间和开始时间相同。
+func getAttrViewCalendarRange(date *av.ValueDate) (start, end time.Time) {
+	start = getAttrViewCalendarTime(date.Content, date.IsNotTime)
+	end = start
+	if date.HasEndDate && date.IsNotEmpty2 {
+		if t := getAttrViewCalendarTime(date.Content2, date.IsNotTime); t.After(start) {
+			end = t
+		}
+	}
+	return
+}
+
+// getAttrViewCalendarTime 将毫秒时间戳转换为本地时间，全天日期取当天零点。
+func getAttrViewCalendarTime(ms int64, allDay bool) time.Time {
+	t := time.UnixMilli(ms).Truncate(time.Second)
+	if allDay {
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	}
+	return t
+}
+
+func getICalendarUID(data *ical.Calendar) (ret string) {
+	if nil == data {
+		return
+	}
+	for _, event := range data.Events() {
+		if ret, _ = event.Props.Text(ical.PropUID); "" != ret {
+			return
+		}
+	}
+	return
+}
+
+// getAttrViewCalendarAvID 返回绑定了日历的数据库 ID。
+func getAttrViewCalendarAvID(calendarPath string) string {
+	loadAttrViewCalendarIndex()
+
+	attrViewCalendarIndexLock.Lock()
+	defer attrViewCalendarIndexLock.Unlock()
+	return attrViewCalendarAvIDs[calendarPath]
+}
+
+// getAttrViewCalendarAvIDs 返回所有绑定了日历的数据库 ID。
+func getAttrViewCalendarAvIDs() (ret []string) {
+	loadAttrViewCalendarIndex()
+
+	attrViewCalendarIndexLock.Lock()
+	defer attrViewCalendarIndexLock.Unlock()
+	for _, avID := range attrViewCalendarAvIDs {
+		ret = append(ret, avID)
+	}
+	return
+}
+
+// loadAttrViewCalendarIndex 每 30 分钟全量扫描一次数据库，以便发现同步下来的日历绑定。
+func loadAttrViewCalendarIndex() {
+	attrViewCalendarIndexLock.Lock()
+	defer attrViewCalendarIndexLock.Unlock()
+
+	if 30*time.Minute > time.Since(attrViewCalendarIndexTime) {
+		return
+	}
+
+	attrViewCalendarAvIDs = map[string]string{}
+	avDir := filepath.Join(util.DataDir, "storage", "av")
+	entries, err := os.ReadDir(avDir)
+	if nil != err {
+		logging.LogErrorf("read attribute view dir failed: %s", err)
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") || !ast.IsNodeIDPattern(strings.TrimSuffix(name, ".json")) {
+			continue
+		}
+
+		data, readErr := filelock.ReadFile(filepath.Join(avDir, name))
+		if nil != readErr || !bytes.Contains(data, []byte("\"calendarPath\"")) {
+			continue
+		}
+
+		avID := strings.TrimSuffix(name, ".json")
+		if attrView, parseErr := av.ParseAttributeView(avID); nil == parseErr && nil != attrView.Calendar {
+			attrViewCalendarAvIDs[attrView.Calendar.CalendarPath] = avID
+		}
+	}
+	attrViewCalendarIndexTime = time.Now()
+}
+
+func setAttrViewCalendarIndex(calendarPath, avID string) {
+	loadAttrViewCalendarIndex()
+
+	attrViewCalendarIndexLock.Lock()
+	defer attrViewCalendarIndexLock.Unlock()
+
+	if "" == avID {
+		delete(attrViewCalendarAvIDs, calendarPath)
+		return
+	}
+	attrViewCalendarAvIDs[calendarPath] = avID
+}
@@ -0,0 +1,258 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/siyuan-note/siyuan/kernel/av"
+)
+
+func (tx *Transaction) doSetAttrViewCalendar(operation *Operation) (ret *TxErr) {
+	err := setAttrViewCalendar(operation)
+	if nil != err {
+		return &TxErr{code: TxErrHandleAttributeView, id: operation.AvID, msg: err.Error()}
+	}
+	return
+}
+
+// setAttrViewCalendar 设置日历视图的开始日期、结束日期和标题字段以及显示模式，data 中只包含需要修改的项。
+func setAttrViewCalendar(operation *Operation) (err error) {
+	attrView, err := av.ParseAttributeView(operation.AvID)
+	if nil != err {
+		return
+	}
+
+	view, err := getAttrViewViewByBlockID(attrView, operation.BlockID)
+	if nil != err {
+		return
+	}
+
+	if av.LayoutTypeCalendar != view.LayoutType || nil == view.Calendar {
+		err = av.ErrWrongLayoutType
+		return
+	}
+
+	data, ok := operation.Data.(map[string]interface{})
+	if !ok {
+		err = errors.New("invalid calendar data")
+		return
+	}
+
+	calendar := view.Calendar
+	if v, ok := data["startKeyID"].(string); ok {
+		if key, _ := attrView.GetKey(v); !av.IsCalendarDateKey(key) {
+			err = fmt.Errorf("field [%s] can not be used as calendar start date", v)
+			return
+		}
+		calendar.StartKeyID = v
+	}
+	if v, ok := data["endKeyID"].(string); ok {
+		if "" != v {
+			if key, _ := attrView.GetKey(v); !av.IsCalendarDateKey(key) {
+				err = fmt.Errorf("field [%s] can not be used as calendar end date", v)
+				return
+			}
+		}
+		calendar.EndKeyID = v
+	}
+	if v, ok := data["titleKeyID"].(string); ok {
+		if "" != v {
+			if key, _ := attrView.GetKey(v); nil == key {
+				err = fmt.Errorf("field [%s] not found", v)
+				return
+			}
+		}
+		calendar.TitleKeyID = v
+	}
+	if v, ok := data["mode"].(string); ok {
+		switch av.CalendarMode(v) {
+		case av.CalendarModeMonth, av.CalendarModeWeek:
+			calendar.Mode = av.CalendarMode(v)
+		default:
+			err = fmt.Errorf("invalid calendar mode [%s]", v)
+			return
+		}
+	}
+	if v, ok := data["firstDayOfWeek"].(float64); ok {
+		if 0 > v || 6 < v {
+			err = fmt.Errorf("invalid first day of week [%v]", v)
+			return
+		}
+		calendar.FirstDayOfWeek = int(v)
+	}
+
+	err = av.SaveAttributeView(attrView)
+	return
+}
+
+func (tx *Transaction) doSetAttrViewCalendarItemDate(operation *Operation) (ret *TxErr) {
+	err := setAttrViewCalendarItemDate(tx, operation)
+	if nil != err {
+		return &TxErr{code: TxErrHandleAttributeView, id: operation.AvID, msg: err.Error()}
+	}
+	return
+}
+
+// setAttrViewCalendarItemDate 在日历视图中拖动项目后更新项目的日期。operation.ID 为项目 ID，data 为 {start, end, isNotTime}，
+// start 和 end 是毫秒时间戳，没有传 end 时保持项目原有的时长，没有传 isNotTime 时保持原有设置。
+func setAttrViewCalendarItemDate(tx *Transaction, operation *Operation) (err error) {
+	attrView, err := av.ParseAttributeView(operation.AvID)
+	if nil != err {
+		return
+	}
+
+	view, err := getAttrViewViewByBlockID(attrView, operation.BlockID)
+	if nil != err {
+		return
+	}
+
+	if av.LayoutTypeCalendar != view.LayoutType || nil == view.Calendar {
+		err = av.ErrWrongLayoutType
+		return
+	}
+
+	itemID := operation.ID
+	if nil == attrView.GetBlockValue(itemID) {
+		err = fmt.Errorf("item [%s] not found", itemID)
+		return
+	}
+
+	data, ok := operation.Data.(map[string]interface{})
+	if !ok {
+		err = errors.New("invalid calendar item date")
+		return
+	}
+	startArg, ok := data["start"].(float64)
+	if !ok {
+		err = errors.New("missing calendar item start date")
+		return
+	}
+	start := int64(startArg)
+
+	// 创建时间和更新时间字段是只读的，只有日期字段才能拖动
+	startKeyID, endKeyID := view.Calendar.StartKeyID, view.Calendar.EndKeyID
+	if startKey, _ := attrView.GetKey(startKeyID); nil == startKey || av.KeyTypeDate != startKey.Type {
+		err = errors.New("calendar start date field is not editable")
+		return
+	}
+	if endKeyID == startKeyID {
+		endKeyID = ""
+	}
+	if "" != endKeyID {
+		if endKey, _ := attrView.GetKey(endKeyID); nil == endKey || av.KeyTypeDate != endKey.Type {
+			endKeyID = ""
+		}
+	}
+
+	oldStart := &av.ValueDate{IsNotTime: true}
+	if v := attrView.GetValue(startKeyID, itemID); nil != v && nil != v.Date && v.Date.IsNotEmpty {
+		oldStart = v.Date
+	}
+	oldEnd := oldStart
+	if "" != endKeyID {
+		oldEnd = &av.ValueDate{}
+		if v := attrView.GetValue(endKeyID, itemID); nil != v && nil != v.Date && v.Date.IsNotEmpty {
+			oldEnd = v.Date
+		}
+	}
+
+	isNotTime := oldStart.IsNotTime
+	if v, ok := data["isNotTime"].(bool); ok {
+		isNotTime = v
+	}
+
+	// 没有传结束时间时按原有时长平移
+	var end int64
+	hasEnd := false
+	if v, ok := data["end"].(float64); ok {
+		end, hasEnd = int64(v), true
+	} else if "" != endKeyID && oldEnd.IsNotEmpty && oldStart.IsNotEmpty {
+		end, hasEnd = start+oldEnd.Content-oldStart.Content, true
+	} else if "" == endKeyID && oldStart.HasEndDate && oldStart.IsNotEmpty2 && oldStart.IsNotEmpty {
+		end, hasEnd = start+oldStart.Content2-oldStart.Content, true
+	}
+	if hasEnd && end < start {
+		err = errors.New("calendar item end date is before start date")
+		return
+	}
+
+	dates := map[string]*av.ValueDate{}
+	startDate := &av.ValueDate{Content: start, IsNotEmpty: true, IsNotTime: isNotTime}
+	dates[startKeyID] = startDate
+	if hasEnd {
+		if "" == endKeyID {
+			startDate.HasEndDate, startDate.Content2, startDate.IsNotEmpty2 = true, end, true
+		} else {
+			dates[endKeyID] = &av.ValueDate{Content: end, IsNotEmpty: true, IsNotTime: isNotTime}
+		}
+	}
+	err = setAttrViewItemDates(tx, attrView, map[string]map[string]*av.ValueDate{itemID: dates})
+	return
+}
+
+// setAttrViewItemDates 逐个更新项目的日期字段值后只保存一次，这样开始和结束日期以及受影响的其他项目要么都更新，要么都不更新。
+// 每个单元格都经过 applyAttributeViewValue，和编辑单元格一样校验字段规则、记录单元格历史并触发自动化规则。
+// dates 的键为项目 ID，值为字段 ID 到日期的映射。
+func setAttrViewItemDates(tx *Transaction, attrView *av.AttributeView, dates map[string]map[string]*av.ValueDate) (err error) {
+	for _, keyDates := range dates {
+		for keyID := range keyDates {
+			if key, _ := attrView.GetKey(keyID); nil == key || av.KeyTypeDate != key.Type {
+				return fmt.Errorf("field [%s] is not a date field", keyID)
+			}
+		}
+	}
+
+	type cellChange struct {
+		itemID, keyID string
+		oldVal, val   *av.Value
+	}
+	var changes []*cellChange
+	var events []*attrViewAutomationEvent
+	for itemID, keyDates := range dates {
+		for keyID, date := range keyDates {
+			if event := newAttrViewCellChangedEvent(attrView.ID, keyID, itemID); nil != event {
+				events = append(events, event)
+			}
+			val, oldVal, changed, applyErr := applyAttributeViewValue(tx, attrView, keyID, itemID, map[string]interface{}{"date": date})
+			if nil != applyErr {
+				return applyErr
+			}
+			if changed {
+				changes = append(changes, &cellChange{itemID: itemID, keyID: keyID, oldVal: oldVal, val: val})
+			}
+		}
+	}
+	if 1 > len(changes) {
+		return
+	}
+
+	regenAttrViewGroups(attrView)
+	if err = av.SaveAttributeView(attrView); nil != err {
+		return
+	}
+
+	for _, change := range changes {
+		recordAttrViewCellHistory(tx, attrView.ID, change.itemID, change.keyID, change.oldVal, change.val)
+	}
+	if nil != tx {
+		tx.avAutomationEvents = append(tx.avAutomationEvents, events...)
+	}
+	refreshRelatedSrcAvs(attrView.ID)
+	return
+}
@@ -0,0 +1,107 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/siyuan-note/siyuan/kernel/av"
+)
+
+func (tx *Transaction) doSetAttrViewChart(operation *Operation) (ret *TxErr) {
+	err := setAttrViewChart(operation)
+	if nil != err {
+		return &TxErr{code: TxErrHandleAttributeView, id: operation.AvID, msg: err.Error()}
+	}
+	return
+}
+
+// setAttrViewChart 设置图表视图的图表类型、系列字段和聚合方式，data 中只包含需要修改的项。
+//
+// 图表的分类使用视图的分组规则，通过 setAttrViewGroup 设置。
+func setAttrViewChart(operation *Operation) (err error) {
+	attrView, err := av.ParseAttributeView(operation.AvID)
+	if nil != err {
+		return
+	}
+
+	view, err := getAttrViewViewByBlockID(attrView, operation.BlockID)
+	if nil != err {
+		return
+	}
+
+	if av.LayoutTypeChart != view.LayoutType || nil == view.Chart {
+		err = av.ErrWrongLayoutType
+		return
+	}
+
+	data, ok := operation.Data.(map[string]interface{})
+	if !ok {
+		err = errors.New("invalid chart data")
+		return
+	}
+
+	chart := view.Chart
+	if v, ok := data["chartType"].(string); ok {
+		switch av.ChartType(v) {
+		case av.ChartTypeBar, av.ChartTypeLine, av.ChartTypePie, av.ChartTypeStackedBar:
+			chart.ChartType = av.ChartType(v)
+		default:
+			err = fmt.Errorf("invalid chart type [%s]", v)
+			return
+		}
+	}
+	if v, ok := data["seriesKeyID"].(string); ok {
+		if "" != v {
+			if key, _ := attrView.GetKey(v); nil == key {
+				err = fmt.Errorf("field [%s] not found", v)
+				return
+			}
+		}
+		chart.SeriesKeyID = v
+	}
+	if v, ok := data["aggregate"].(map[string]interface{}); ok {
+		operator, _ := v["operator"].(string)
+		keyID, _ := v["keyID"].(string)
+		aggregate := &av.ChartAggregate{Operator: av.CalcOperator(operator), KeyID: keyID}
+		if !av.IsChartAggregateOperator(aggregate.Operator) {
+			err = fmt.Errorf("invalid chart aggregate operator [%s]", operator)
+			return
+		}
+		if av.CalcOperatorCountAll == aggregate.Operator {
+			aggregate.KeyID = ""
+		} else if key, _ := attrView.GetKey(keyID); !av.IsChartAggregateKey(key) {
+			err = fmt.Errorf("field [%s] can not be aggregated in chart", keyID)
+			return
+		}
+		chart.Aggregate = aggregate
+	}
+
+	err = av.SaveAttributeView(attrView)
+	return
+}
+
+// getChartPreferredGroupKey 返回图表视图默认使用的分类字段，使用第一个单选字段。
+func getChartPreferredGroupKey(attrView *av.AttributeView) (ret *av.Key) {
+	for _, kv := range attrView.KeyValues {
+		if av.KeyTypeSelect == kv.Key.Type {
+			return kv.Key
+		}
+	}
+	return
+}
@@ -0,0 +1,114 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/88250/gulu"
+	"github.com/siyuan-note/siyuan/kernel/av"
+)
+
+func (tx *Transaction) doSetAttrViewColConstraint(operation *Operation) (ret *TxErr) {
+	err := setAttributeViewColConstraint(operation)
+	if nil != err {
+		return &TxErr{code: TxErrHandleAttributeView, id: operation.AvID, msg: err.Error()}
+	}
+	return
+}
+
+// setAttributeViewColConstraint 设置字段的校验规则，operation.ID 为字段 ID，data 为空时清除规则。
+//
+// 设置规则时不会校验已有的值，可以通过 GetAttributeViewConstraintViolations 列出不满足规则的值。
+func setAttributeViewColConstraint(operation *Operation) (err error) {
+	attrView, err := av.ParseAttributeView(operation.AvID)
+	if nil != err {
+		return
+	}
+
+	key, _ := attrView.GetKey(operation.ID)
+	if nil == key {
+		err = fmt.Errorf("field [%s] not found", operation.ID)
+		return
+	}
+
+	if nil == operation.Data {
+		key.Constraint = nil
+		err = av.SaveAttributeView(attrView)
+		return
+	}
+
+	constraint := &av.KeyConstraint{}
+	data, err := gulu.JSON.MarshalJSON(operation.Data)
+	if nil == err {
+		err = gulu.JSON.UnmarshalJSON(data, constraint)
+	}
+	if nil != err {
+		err = errors.New("invalid field constraint")
+		return
+	}
+
+	if err = av.CheckKeyConstraint(key, constraint); nil != err {
+		return
+	}
+
+	key.Constraint = constraint
+	if constraint.IsEmpty() {
+		key.Constraint = nil
+	}
+	err = av.SaveAttributeView(attrView)
+	return
+}
+
+// GetAttributeViewConstraintViolations 列出数据库中不满足当前字段校验规则的值，按项目和字段顺序排列。
+func GetAttributeViewConstraintViolations(avID string) (ret []*av.ConstraintViolation, err error) {
+	ret = []*av.ConstraintViolation{}
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+
+	blockKeyValues := attrView.GetBlockKeyValues()
+	if nil == blockKeyValues {
+		return
+	}
+
+	for _, blockValue := range blockKeyValues.Values {
+		itemID := blockValue.BlockID
+		for _, kv := range attrView.KeyValues {
+			if nil == kv.Key.Constraint {
+				continue
+			}
+
+			value := kv.GetValue(itemID)
+			if violation := av.CheckValueConstraint(attrView, kv.Key, itemID, value); nil != violation {
+				ret = append(ret, violation)
+			}
+		}
+	}
+	return
+}
+
+// newAttrViewCellTxErr 根据修改单元格时的错误构造事务错误，不满足字段校验规则时使用单独的错误码以便提示用户。
+func newAttrViewCellTxErr(avID string, err error) *TxErr {
+	var violation *av.ConstraintViolation
+	if errors.As(err, &violation) {
+		return &TxErr{code: TxErrAttributeViewConstraint, id: avID, msg: violation.Error()}
+	}
+	return &TxErr{code: TxErrHandleAttributeView, id: avID, msg: err.Error()}
+}
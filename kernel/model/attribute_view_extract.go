@@ -0,0 +1,416 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/sashabaranov/go-openai"
+	"github.com/siyuan-note/siyuan/kernel/av"
+	"github.com/siyuan-note/siyuan/kernel/sql"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// AttrViewExtractedCell AI 从项目绑定块的内容中提取的单元格值，确认后再通过 updateAttrViewCell 事务写入
+type AttrViewExtractedCell struct {
+	ItemID     string     `json:"itemID"`
+	KeyID      string     `json:"keyID"`
+	KeyName    string     `json:"keyName"`
+	KeyType    av.KeyType `json:"keyType"`
+	Value      *av.Value  `json:"value"`               // 提取的值，只包含类型和对应类型的内容
+	OldValue   *av.Value  `json:"oldValue,omitempty"`  // 单元格当前的值
+	Text       string     `json:"text"`                // 提取的值的文本形式，用于预览
+	Confidence float64    `json:"confidence"`          // 置信度，0 到 1
+	NewOption  bool       `json:"newOption,omitempty"` // 单选/多选的值不在已有选项中，写入时会新建选项
+}
+
+// AttrViewExtractResult 属性提取结果
+type AttrViewExtractResult struct {
+	Cells  []*AttrViewExtractedCell `json:"cells"`
+	Errors map[string]string        `json:"errors"` // 项目 ID -> 提取失败原因
+}
+
+const maxAttrViewExtractItems = 50
+
+// attrViewExtractableKeyTypes 支持提取的字段类型，主键、关联、汇总、资源和自动生成的字段不提取
+var attrViewExtractableKeyTypes = []string{
+	string(av.KeyTypeText), string(av.KeyTypeNumber), string(av.KeyTypeDate), string(av.KeyTypeSelect), string(av.KeyTypeMSelect),
+	string(av.KeyTypeURL), string(av.KeyTypeEmail), string(av.KeyTypePhone), string(av.KeyTypeCheckbox),
+}
+
+// ExtractAttributeViewCells 读取项目绑定块的内容和字段定义，让 AI 按字段类型输出结构化的值。
+// itemIDs 为空时提取 viewID 视图过滤后的所有项目；keyIDs 为空时提取所有支持的字段；overwrite 为 false 时只提取空白单元格。
+// 该函数不写入数据，返回的结果需要经过确认后调用 ApplyAttributeViewExtractedCells 写入
+func ExtractAttributeViewCells(ctx *WorkspaceContext, avID, viewID string, itemIDs, keyIDs []string, overwrite bool) (ret *AttrViewExtractResult, err error) {
+	if !isOpenAIAPIEnabled(ctx) {
+		return nil, errors.New("AI not enabled")
+	}
+
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+
+	var keys []*av.Key
+	for _, kv := range attrView.KeyValues {
+		if !gulu.Str.Contains(string(kv.Key.Type), attrViewExtractableKeyTypes) {
+			continue
+		}
+		if 0 < len(keyIDs) && !gulu.Str.Contains(kv.Key.ID, keyIDs) {
+			continue
+		}
+		keys = append(keys, kv.Key)
+	}
+	if 1 > len(keys) {
+		return nil, errors.New("no extractable fields")
+	}
+
+	if 1 > len(itemIDs) {
+		view := attrView.GetView(viewID)
+		if nil == view {
+			if view, err = attrView.GetCurrentView(attrView.ViewID); nil != err {
+				return
+			}
+		}
+		itemIDs = getAttrViewFilteredItemIDs(attrView, view)
+	}
+	if maxAttrViewExtractItems < len(itemIDs) {
+		return nil, fmt.Errorf("too many items [%d], at most [%d] items can be extracted at a time", len(itemIDs), maxAttrViewExtractItems)
+	}
+
+	ret = &AttrViewExtractResult{Cells: []*AttrViewExtractedCell{}, Errors: map[string]string{}}
+	for _, itemID := range itemIDs {
+		if err = CheckAIBudget(ctx); nil != err {
+			return
+		}
+
+		var targetKeys []*av.Key
+		for _, key := range keys {
+			if overwrite || nil == attrView.GetValue(key.ID, itemID) || attrView.GetValue(key.ID, itemID).IsEmpty() {
+				targetKeys = append(targetKeys, key)
+			}
+		}
+		if 1 > len(targetKeys) {
+			continue
+		}
+
+		cells, extractErr := extractAttributeViewItemCells(ctx, attrView, itemID, targetKeys)
+		if nil != extractErr {
+			ret.Errors[itemID] = extractErr.Error()
+			continue
+		}
+		ret.Cells = append(ret.Cells, cells...)
+	}
+	err = nil
+	return
+}
+
+// ApplyAttributeViewExtractedCells 将确认后的提取结果通过 updateAttrViewCell 事务写入，置信度低于 minConfidence 的单元格会被跳过
+func ApplyAttributeViewExtractedCells(ctx *WorkspaceContext, avID string, cells []*AttrViewExtractedCell, minConfidence float64) (applied int, err error) {
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+
+	var doOps, undoOps []*Operation
+	for _, cell := range cells {
+		if nil == cell || nil == cell.Value || cell.Confidence < minConfidence {
+			continue
+		}
+		key, _ := attrView.GetKey(cell.KeyID)
+		if nil == key || !gulu.Str.Contains(string(key.Type), attrViewExtractableKeyTypes) || nil == attrView.GetBlockValue(cell.ItemID) {
+			continue
+		}
+
+		// 写入的数据只保留类型和对应类型的内容，避免覆盖单元格的 ID 等信息
+		cell.Value.Type = key.Type
+		if av.KeyTypeCheckbox == key.Type {
+			// 未勾选的复选框也是有效的值，不能按空值跳过，否则无法通过提取取消勾选
+			if nil == cell.Value.Checkbox {
+				continue
+			}
+		} else if cell.Value.IsEmpty() {
+			continue
+		}
+		data := &av.Value{Type: key.Type}
+		data.SetValByType(key.Type, cell.Value.GetValByType(key.Type))
+
+		oldVal := attrView.GetValue(cell.KeyID, cell.ItemID)
+		if nil == oldVal {
+			oldVal = av.GetAttributeViewDefaultValue("", cell.KeyID, cell.ItemID, key.Type, false)
+		}
+		doOps = append(doOps, &Operation{Action: "updateAttrViewCell", AvID: avID, KeyID: cell.KeyID, RowID: cell.ItemID, Data: data})
+		undoOps = append(undoOps, &Operation{Action: "updateAttrViewCell", AvID: avID, KeyID: cell.KeyID, RowID: cell.ItemID, Data: oldVal})
+		applied++
+	}
+	if 1 > len(doOps) {
+		return
+	}
+
+	transactions := []*Transaction{{DoOperations: doOps, UndoOperations: undoOps}}
+	PerformTransactionsWithContext(ctx, &transactions)
+	FlushTxQueue()
+
+	evt := util.NewCmdResult("transactions", 0, util.PushModeBroadcast)
+	evt.Data = transactions
+	util.PushEvent(evt)
+	return
+}
+
+// getAttrViewFilteredItemIDs 获取视图经过过滤后的所有项目 ID
+func getAttrViewFilteredItemIDs(attrView *av.AttributeView, view *av.View) (ret []string) {
+	viewable := sql.RenderView(attrView, view, "")
+	if nil == viewable {
+		return
+	}
+
+	cachedAttrViews := map[string]*av.AttributeView{}
+	rollupFurtherCollections := sql.GetFurtherCollections(attrView, cachedAttrViews)
+	av.Filter(viewable, attrView, rollupFurtherCollections, cachedAttrViews)
+	if collection, ok := viewable.(av.Collection); ok {
+		for _, item := range collection.GetItems() {
+			ret = append(ret, item.GetID())
+		}
+	}
+	return
+}
+
+func extractAttributeViewItemCells(ctx *WorkspaceContext, attrView *av.AttributeView, itemID string, keys []*av.Key) (ret []*AttrViewExtractedCell, err error) {
+	blockVal := attrView.GetBlockValue(itemID)
+	if nil == blockVal || nil == blockVal.Block {
+		return nil, errors.New("item not found")
+	}
+
+	content := blockVal.Block.Content
+	if !blockVal.IsDetached && "" != blockVal.Block.ID {
+		if md, exportErr := exportMCPBlockMd(ctx, blockVal.Block.ID); nil == exportErr && "" != strings.TrimSpace(md) {
+			content = md
+		}
+	}
+	if "" == strings.TrimSpace(content) {
+		return nil, errors.New("item content is empty")
+	}
+
+	var fields []map[string]interface{}
+	for _, key := range keys {
+		field := map[string]interface{}{"id": key.ID, "name": key.Name, "type": key.Type}
+		if "" != key.Desc {
+			field["desc"] = key.Desc
+		}
+		if av.KeyTypeSelect == key.Type || av.KeyTypeMSelect == key.Type {
+			var options []string
+			for _, opt := range key.Options {
+				options = append(options, opt.Name)
+			}
+			field["options"] = options
+		}
+		fields = append(fields, field)
+	}
+	fieldsJSON, _ := gulu.JSON.MarshalJSON(fields)
+
+	prompt := "你是一个信息提取助手。请根据用户提供的内容，为以下数据库字段提取值：\n" + string(fieldsJSON) + "\n\n" +
+		"值的格式要求：text/url/email/phone 为字符串；number 为数字；date 为 \"YYYY-MM-DD\" 或 \"YYYY-MM-DD HH:mm\"，时间段使用 {\"start\": \"...\", \"end\": \"...\"}；" +
+		"select 为一个选项名称；mSelect 为选项名称数组；checkbox 为布尔值。有 options 的字段优先从 options 中选择。\n" +
+		"只输出一个 JSON 对象，键为字段 id，值为 {\"value\": 提取的值, \"confidence\": 0 到 1 之间的置信度}。内容中找不到依据的字段请不要输出，不要编造。"
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: prompt},
+		{Role: openai.ChatMessageRoleUser, Content: truncateAtSentence(content, 8000)},
+	}
+	completion, err := createChatCompletionWithFeature(ctx, AIFeatureAVExtract, messages)
+	if nil != err {
+		return
+	}
+
+	start := strings.Index(completion, "{")
+	end := strings.LastIndex(completion, "}")
+	if 0 > start || end <= start {
+		return nil, errors.New("AI response does not contain JSON")
+	}
+	extracted := map[string]interface{}{}
+	if err = gulu.JSON.UnmarshalJSON([]byte(completion[start:end+1]), &extracted); nil != err {
+		return nil, fmt.Errorf("parse AI response failed: %s", err)
+	}
+
+	for _, key := range keys {
+		raw, ok := extracted[key.ID]
+		if !ok {
+			raw, ok = extracted[key.Name]
+		}
+		if !ok || nil == raw {
+			continue
+		}
+
+		confidence := 0.5
+		if m, isMap := raw.(map[string]interface{}); isMap {
+			if _, hasValue := m["value"]; hasValue {
+				raw = m["value"]
+				if c, isNum := m["confidence"].(float64); isNum {
+					confidence = c
+				}
+			}
+		}
+		confidence = max(0, min(1, confidence))
+
+		val, newOption, convErr := newExtractedAttrViewValue(key, raw)
+		if nil != convErr || nil == val {
+			continue
+		}
+		ret = append(ret, &AttrViewExtractedCell{
+			ItemID:     itemID,
+			KeyID:      key.ID,
+			KeyName:    key.Name,
+			KeyType:    key.Type,
+			Value:      val,
+			OldValue:   attrView.GetValue(key.ID, itemID),
+			Text:       val.String(true),
+			Confidence: confidence,
+			NewOption:  newOption,
+		})
+	}
+	return
+}
+
+// newExtractedAttrViewValue 将 AI 输出的值转换为字段类型对应的值，无法转换或为空时返回 nil
+func newExtractedAttrViewValue(key *av.Key, raw interface{}) (ret *av.Value, newOption bool, err error) {
+	ret = &av.Value{Type: key.Type}
+	switch key.Type {
+	case av.KeyTypeText, av.KeyTypeURL, av.KeyTypeEmail, av.KeyTypePhone:
+		content := strings.TrimSpace(fmt.Sprint(raw))
+		if "" == content {
+			return nil, false, nil
+		}
+		switch key.Type {
+		case av.KeyTypeText:
+			ret.Text = &av.ValueText{Content: content}
+		case av.KeyTypeURL:
+			ret.URL = &av.ValueURL{Content: content}
+		case av.KeyTypeEmail:
+			ret.Email = &av.ValueEmail{Content: content}
+		case av.KeyTypePhone:
+			ret.Phone = &av.ValuePhone{Content: content}
+		}
+	case av.KeyTypeNumber:
+		var number float64
+		switch v := raw.(type) {
+		case float64:
+			number = v
+		case string:
+			s := strings.TrimSpace(strings.ReplaceAll(v, ",", ""))
+			if number, err = strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64); nil != err {
+				return nil, false, err
+			}
+			if strings.HasSuffix(s, "%") && av.NumberFormatPercent == key.NumberFormat {
+				number /= 100
+			}
+		default:
+			return nil, false, fmt.Errorf("invalid number [%v]", raw)
+		}
+		ret.Number = av.NewFormattedValueNumber(number, key.NumberFormat)
+	case av.KeyTypeDate:
+		startStr, endStr := "", ""
+		switch v := raw.(type) {
+		case string:
+			startStr = v
+		case map[string]interface{}:
+			startStr, _ = v["start"].(string)
+			endStr, _ = v["end"].(string)
+		}
+		start, isNotTime, parseErr := parseExtractedDate(startStr)
+		if nil != parseErr {
+			return nil, false, parseErr
+		}
+		var end int64
+		hasEndDate := false
+		if "" != strings.TrimSpace(endStr) {
+			if endTime, endIsNotTime, endErr := parseExtractedDate(endStr); nil == endErr {
+				end, hasEndDate = endTime.UnixMilli(), true
+				isNotTime = isNotTime && endIsNotTime
+			}
+		}
+		ret.Date = av.NewFormattedValueDate(start.UnixMilli(), end, av.DateFormatNone, isNotTime, hasEndDate)
+		ret.Date.IsNotEmpty, ret.Date.IsNotEmpty2 = true, hasEndDate
+	case av.KeyTypeSelect, av.KeyTypeMSelect:
+		var names []string
+		switch v := raw.(type) {
+		case string:
+			if av.KeyTypeMSelect == key.Type {
+				names = strings.FieldsFunc(v, func(r rune) bool { return ',' == r || '，' == r || '、' == r })
+			} else {
+				names = []string{v}
+			}
+		case []interface{}:
+			for _, name := range v {
+				names = append(names, fmt.Sprint(name))
+			}
+		}
+		for _, name := range names {
+			if name = strings.TrimSpace(name); "" == name || av.MSelectExistOption(ret.MSelect, name) {
+				continue
+			}
+			color := ""
+			if opt := key.GetOption(name); nil != opt {
+				color = opt.Color
+			} else {
+				newOption = true
+			}
+			ret.MSelect = append(ret.MSelect, &av.ValueSelect{Content: name, Color: color})
+			if av.KeyTypeSelect == key.Type {
+				break
+			}
+		}
+		if 1 > len(ret.MSelect) {
+			return nil, false, nil
+		}
+	case av.KeyTypeCheckbox:
+		checked := false
+		switch v := raw.(type) {
+		case bool:
+			checked = v
+		case string:
+			checked = gulu.Str.Contains(strings.ToLower(strings.TrimSpace(v)), []string{"true", "yes", "y", "1", "是", "对"})
+		default:
+			return nil, false, fmt.Errorf("invalid checkbox [%v]", raw)
+		}
+		ret.Checkbox = &av.ValueCheckbox{Checked: checked}
+	default:
+		return nil, false, nil
+	}
+	return
+}
+
+func parseExtractedDate(s string) (ret time.Time, isNotTime bool, err error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02", "2006/01/02", "2006.01.02"} {
+		if ret, err = time.ParseInLocation(layout, s, time.Local); nil == err {
+			return ret, true, nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02 15:04:05", "2006/01/02 15:04", "2006-01-02T15:04", "2006-01-02T15:04:05"} {
+		if ret, err = time.ParseInLocation(layout, s, time.Local); nil == err {
+			return ret, false, nil
+		}
+	}
+	if ret, err = time.Parse(time.RFC3339, s); nil == err {
+		return ret.Local(), false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date [%s]", s)
+}
@@ -30,14 +30,11 @@ import (
 
 func checkAttrView(attrView *av.AttributeView, view *av.View) {
 	// 字段删除以后需要删除设置的过滤和排序
-	tmpFilters := []*av.ViewFilter{}
-	for _, f := range view.Filters {
-		if k, _ := attrView.GetKey(f.Column); nil != k {
-			tmpFilters = append(tmpFilters, f)
-		}
-	}
-	changed := len(tmpFilters) != len(view.Filters)
-	view.Filters = tmpFilters
+	var changed bool
+	view.Filters, changed = av.RemoveFilters(view.Filters, func(f *av.ViewFilter) bool {
+		k, _ := attrView.GetKey(f.Column)
+		return nil == k
+	})
 
 	tmpSorts := []*av.ViewSort{}
 	for _, s := range view.Sorts {
@@ -69,6 +66,18 @@ func checkAttrView(attrView *av.AttributeView, view *av.View) {
 				changed = true
 			}
 		}
+		if av.LayoutTypeCalendar == v.LayoutType && nil == v.Calendar {
+			v.Calendar = av.NewLayoutCalendar()
+			changed = true
+		}
+		if av.LayoutTypeTimeline == v.LayoutType && nil == v.Timeline {
+			v.Timeline = av.NewLayoutTimeline()
+			changed = true
+		}
+		if av.LayoutTypeChart == v.LayoutType && nil == v.Chart {
+			v.Chart = av.NewLayoutChart()
+			changed = true
+		}
 	}
 
 	now := util.CurrentTimeMillis()
@@ -0,0 +1,88 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/siyuan-note/siyuan/kernel/av"
+)
+
+func (tx *Transaction) doUpdateAttrViewColFormula(operation *Operation) (ret *TxErr) {
+	err := updateAttributeViewColFormula(operation)
+	if nil != err {
+		return &TxErr{code: TxErrHandleAttributeView, id: operation.AvID, msg: err.Error()}
+	}
+	return
+}
+
+// updateAttributeViewColFormula 设置公式字段的表达式，operation.ID 为字段 ID，data 为表达式。
+// 表达式无法解析、引用了不存在的字段、类型不匹配或者产生循环引用时不保存并返回错误。
+func updateAttributeViewColFormula(operation *Operation) (err error) {
+	attrView, err := av.ParseAttributeView(operation.AvID)
+	if nil != err {
+		return
+	}
+
+	key, _ := attrView.GetKey(operation.ID)
+	if nil == key || av.KeyTypeFormula != key.Type {
+		err = fmt.Errorf("field [%s] is not a formula field", operation.ID)
+		return
+	}
+
+	expr, ok := operation.Data.(string)
+	if !ok {
+		err = errors.New("invalid formula")
+		return
+	}
+
+	if nil == key.Formula {
+		key.Formula = &av.Formula{}
+	}
+	key.Formula.Expr = strings.TrimSpace(expr)
+	key.Formula.ResultType = ""
+	if "" != key.Formula.Expr {
+		formula, compileErr := av.CompileFormula(attrView, key.ID)
+		if nil != compileErr {
+			err = fmt.Errorf("formula field [%s] is invalid: %s", key.Name, compileErr)
+			return
+		}
+		key.Formula.ResultType = formula.ResultType
+	}
+
+	// 引用了该公式的其他公式字段的结果类型可能随之改变
+	formulas, _ := av.CompileFormulas(attrView)
+	for _, f := range formulas {
+		f.Key.Formula.ResultType = f.ResultType
+	}
+
+	regenAttrViewGroups(attrView)
+	err = av.SaveAttributeView(attrView)
+	return
+}
+
+// renameAttrViewFormulaProps 字段重命名后更新公式中通过字段名对该字段的引用。
+func renameAttrViewFormulaProps(attrView *av.AttributeView, oldName, newName string) {
+	for _, keyValues := range attrView.KeyValues {
+		if av.KeyTypeFormula != keyValues.Key.Type || nil == keyValues.Key.Formula {
+			continue
+		}
+		keyValues.Key.Formula.Expr = av.RenameFormulaProp(keyValues.Key.Formula.Expr, oldName, newName)
+	}
+}
@@ -0,0 +1,284 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/88250/lute/ast"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/av"
+	"github.com/siyuan-note/siyuan/kernel/sql"
+)
+
+// GetAttributeViewItemHistories 按时间倒序返回项目的单元格修改记录，keyID 为空时返回所有字段的记录。
+func GetAttributeViewItemHistories(avID, itemID, keyID string) (ret []*sql.AttrViewCellHistory) {
+	sql.FlushHistoryQueue()
+	ret = sql.SelectAttrViewCellHistories(avID, itemID, keyID, 0, 512)
+	return
+}
+
+// RestoreAttributeViewItem 将项目的单元格恢复到 before 时刻之前的值，即撤销 before 及之后的所有修改，keyID 不为空时只恢复该字段。
+//
+// 已经删除的字段和修改过类型的字段会被跳过，恢复本身也会记录到单元格历史中。
+func RestoreAttributeViewItem(avID, itemID, keyID string, before int64) (err error) {
+	sql.FlushHistoryQueue()
+	histories := sql.SelectAttrViewCellHistories(avID, itemID, keyID, before, 0)
+	if 1 > len(histories) {
+		return
+	}
+
+	attrView, err := av.ParseAttributeView(avID)
+	if nil != err {
+		return
+	}
+	if nil == attrView.GetBlockValue(itemID) {
+		return fmt.Errorf("item [%s] not found", itemID)
+	}
+
+	// 记录按时间倒序排列，每个字段最后出现的记录即 before 之后最早的修改，其修改前的值就是要恢复的值
+	var keyIDs []string
+	restores := map[string]*sql.AttrViewCellHistory{}
+	for _, history := range histories {
+		if _, ok := restores[history.KeyID]; !ok {
+			keyIDs = append(keyIDs, history.KeyID)
+		}
+		restores[history.KeyID] = history
+	}
+
+	// 先校验所有字段的目标值，任一字段不满足规则时不做任何修改，避免只恢复了部分字段
+	type cellRestore struct {
+		keyID     string
+		valueData map[string]interface{}
+	}
+	var cellRestores []*cellRestore
+	for _, restoreKeyID := range keyIDs {
+		key, _ := attrView.GetKey(restoreKeyID)
+		if nil == key {
+			continue
+		}
+
+		var value *av.Value
+		if history := restores[restoreKeyID]; "" != history.OldValue {
+			if err = gulu.JSON.UnmarshalJSON([]byte(history.OldValue), &value); nil != err {
+				logging.LogErrorf("unmarshal attribute view cell history [%s] failed: %s", history.ID, err)
+				return
+			}
+			if key.Type != value.Type {
+				continue
+			}
+		}
+
+		valueData, restoreErr := getAttrViewCellRestoreData(key, value)
+		if nil != restoreErr {
+			continue
+		}
+		if err = checkAttrViewCellRestoreData(attrView, key, itemID, valueData); nil != err {
+			return
+		}
+		cellRestores = append(cellRestores, &cellRestore{keyID: restoreKeyID, valueData: valueData})
+	}
+
+	type cellChange struct {
+		keyID       string
+		oldVal, val *av.Value
+	}
+	var changes []*cellChange
+	for _, restore := range cellRestores {
+		val, oldVal, changed, applyErr := applyAttributeViewValue(nil, attrView, restore.keyID, itemID, restore.valueData)
+		if nil != applyErr {
+			return applyErr
+		}
+		if changed {
+			changes = append(changes, &cellChange{keyID: restore.keyID, oldVal: oldVal, val: val})
+		}
+	}
+	if 1 > len(changes) {
+		return
+	}
+
+	regenAttrViewGroups(attrView)
+	if err = av.SaveAttributeView(attrView); nil != err {
+		return
+	}
+	for _, change := range changes {
+		recordAttrViewCellHistory(nil, avID, itemID, change.keyID, change.oldVal, change.val)
+	}
+	refreshRelatedSrcAvs(avID)
+	ReloadAttrView(avID)
+	return
+}
+
+// checkAttrViewCellRestoreData 校验恢复后的单元格值是否满足字段规则，只在副本上校验，不修改数据库。
+func checkAttrViewCellRestoreData(attrView *av.AttributeView, key *av.Key, itemID string, valueData map[string]interface{}) (err error) {
+	value := &av.Value{KeyID: key.ID, BlockID: itemID, Type: key.Type}
+	if current := attrView.GetValue(key.ID, itemID); nil != current {
+		value = current.Clone()
+		value.Type = key.Type
+	}
+
+	data, err := gulu.JSON.MarshalJSON(valueData)
+	if nil != err {
+		return
+	}
+	if err = gulu.JSON.UnmarshalJSON(data, &value); nil != err {
+		return
+	}
+	if violation := av.CheckValueConstraint(attrView, key, itemID, value); nil != violation {
+		return violation
+	}
+	return
+}
+
+// getAttrViewCellRestoreData 生成恢复单元格时更新的数据，值为空时显式写入空值以便覆盖当前值。
+func getAttrViewCellRestoreData(key *av.Key, value *av.Value) (ret map[string]interface{}, err error) {
+	if nil == value {
+		value = &av.Value{Type: key.Type}
+	}
+
+	ret = map[string]interface{}{}
+	switch key.Type {
+	case av.KeyTypeBlock:
+		if nil == value.Block {
+			err = errors.New("primary key value is empty")
+			return
+		}
+		ret["isDetached"] = value.IsDetached
+		ret["block"] = map[string]interface{}{"id": value.Block.ID, "content": value.Block.Content}
+	case av.KeyTypeText:
+		if nil == value.Text {
+			value.Text = &av.ValueText{}
+		}
+		ret["text"] = value.Text
+	case av.KeyTypeNumber:
+		if nil == value.Number {
+			value.Number = &av.ValueNumber{}
+		}
+		ret["number"] = value.Number
+	case av.KeyTypeDate:
+		if nil == value.Date {
+			value.Date = &av.ValueDate{}
+		}
+		ret["date"] = value.Date
+	case av.KeyTypeSelect, av.KeyTypeMSelect:
+		if nil == value.MSelect {
+			value.MSelect = []*av.ValueSelect{}
+		}
+		ret["mSelect"] = value.MSelect
+	case av.KeyTypeURL:
+		if nil == value.URL {
+			value.URL = &av.ValueURL{}
+		}
+		ret["url"] = value.URL
+	case av.KeyTypeEmail:
+		if nil == value.Email {
+			value.Email = &av.ValueEmail{}
+		}
+		ret["email"] = value.Email
+	case av.KeyTypePhone:
+		if nil == value.Phone {
+			value.Phone = &av.ValuePhone{}
+		}
+		ret["phone"] = value.Phone
+	case av.KeyTypeMAsset:
+		if nil == value.MAsset {
+			value.MAsset = []*av.ValueAsset{}
+		}
+		ret["mAsset"] = value.MAsset
+	case av.KeyTypeCheckbox:
+		if nil == value.Checkbox {
+			value.Checkbox = &av.ValueCheckbox{}
+		}
+		ret["checkbox"] = value.Checkbox
+	case av.KeyTypeRelation:
+		if nil == value.Relation {
+			value.Relation = &av.ValueRelation{}
+		}
+		if nil == value.Relation.BlockIDs {
+			value.Relation.BlockIDs = []string{}
+		}
+		ret["relation"] = value.Relation
+	default:
+		err = fmt.Errorf("field [%s] does not support restore", key.Name)
+	}
+	return
+}
+
+// recordAttrViewCellHistory 将单元格修改记录到历史库，值没有变化时不记录。
+func recordAttrViewCellHistory(tx *Transaction, avID, itemID, keyID string, oldValue, newValue *av.Value) {
+	oldContent := getAttrViewCellHistoryContent(oldValue)
+	newContent := getAttrViewCellHistoryContent(newValue)
+	if oldContent == newContent {
+		return
+	}
+
+	history := &sql.AttrViewCellHistory{
+		ID:       ast.NewNodeID(),
+		AvID:     avID,
+		ItemID:   itemID,
+		KeyID:    keyID,
+		OldValue: oldContent,
+		NewValue: newContent,
+		User:     getAttrViewCellHistoryUser(tx),
+		Created:  time.Now().UnixMilli(),
+	}
+	sql.IndexAttrViewCellHistoriesQueue([]*sql.AttrViewCellHistory{history})
+}
+
+// getAttrViewCellHistoryContent 返回值的 JSON，不包含 ID 和时间等不影响内容的字段。
+func getAttrViewCellHistoryContent(value *av.Value) string {
+	if nil == value {
+		return ""
+	}
+
+	value = value.Clone()
+	if nil == value {
+		return ""
+	}
+	value.ID, value.KeyID, value.BlockID = "", "", ""
+	value.CreatedAt, value.UpdatedAt = 0, 0
+	if nil != value.Block {
+		value.Block.Created, value.Block.Updated = 0, 0
+	}
+	if nil != value.Relation {
+		value.Relation.Contents = nil
+	}
+	data, err := gulu.JSON.MarshalJSON(value)
+	if nil != err {
+		return ""
+	}
+	return string(data)
+}
+
+func getAttrViewCellHistoryUser(tx *Transaction) string {
+	ctx := GetCurrentExecutionContext()
+	if nil != tx && nil != tx.ctx {
+		ctx = tx.ctx
+	}
+	if nil != ctx && "" != ctx.Username {
+		return ctx.Username
+	}
+	if nil != Conf {
+		if user := Conf.GetUser(); nil != user {
+			return user.UserName
+		}
+	}
+	return ""
+}
@@ -0,0 +1,808 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/88250/gulu"
+	"github.com/88250/lute/ast"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/av"
+	"github.com/xuri/excelize/v2"
+)
+
+// AttrViewImportColumn 描述了导入时表格列到数据库字段的映射。
+type AttrViewImportColumn struct {
+	Index int        `json:"index"` // 列序号，从 0 开始
+	Name  string     `json:"name"`  // 字段名，为空时使用表头
+	KeyID string     `json:"keyID"` // 追加到已有数据库时映射到的字段 ID，为空时按字段名匹配，匹配不到则新建字段
+	Type  av.KeyType `json:"type"`  // 字段类型，为空时根据列数据推断，主键列为 block
+	Skip  bool       `json:"skip"`  // 是否跳过该列
+}
+
+// AttrViewImportOptions 描述了导入 CSV/XLSX 到数据库的选项。
+type AttrViewImportOptions struct {
+	AvID    string                  `json:"avID"`    // 追加到的数据库 ID，为空时新建数据库
+	Name    string                  `json:"name"`    // 新建数据库的名称
+	Sheet   string                  `json:"sheet"`   // XLSX 工作表名，为空时使用第一个工作表
+	Locale  string                  `json:"locale"`  // 解析数字和日期时使用的区域，比如 en_US、de_DE，为空时使用界面语言
+	Columns []*AttrViewImportColumn `json:"columns"` // 列映射，未指定的列自动推断
+	Bind    bool                    `json:"bind"`    // 是否为每一行新建文档并绑定
+	BoxID   string                  `json:"boxID"`   // 新建文档所在的笔记本
+	HPath   string                  `json:"hPath"`   // 新建文档的父路径
+	DryRun  bool                    `json:"dryRun"`  // 仅解析和转换，不写入数据
+}
+
+// AttrViewImportError 描述了导入时某个单元格的转换错误。
+type AttrViewImportError struct {
+	Row    int    `json:"row"` // 数据行号，从 1 开始，不含表头
+	Column string `json:"column"`
+	Value  string `json:"value"`
+	Msg    string `json:"msg"`
+}
+
+// AttrViewImportResult 描述了导入结果。
+type AttrViewImportResult struct {
+	AvID     string                  `json:"avID"`
+	BlockID  string                  `json:"blockID"` // 新建数据库时需要插入的数据库块 ID
+	Columns  []*AttrViewImportColumn `json:"columns"`
+	Rows     int                     `json:"rows"`
+	Imported int                     `json:"imported"`
+	Errors   []*AttrViewImportError  `json:"errors"`
+}
+
+// ImportAttributeViewCSV 将 CSV 导入到数据库中，第一行作为表头。
+func ImportAttributeViewCSV(reader io.Reader, fileName string, opts *AttrViewImportOptions) (ret *AttrViewImportResult, err error) {
+	return ImportAttributeViewCSVWithContext(GetDefaultWorkspaceContext(), reader, fileName, opts)
+}
+
+// ImportAttributeViewCSVWithContext 使用 WorkspaceContext 将 CSV 导入到数据库中
+func ImportAttributeViewCSVWithContext(ctx *WorkspaceContext, reader io.Reader, fileName string, opts *AttrViewImportOptions) (ret *AttrViewImportResult, err error) {
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if nil != err {
+		logging.LogErrorf("read csv [%s] failed: %s", fileName, err)
+		return
+	}
+	if 0 < len(records) && 0 < len(records[0]) {
+		records[0][0] = strings.TrimPrefix(records[0][0], "\xEF\xBB\xBF")
+	}
+	return importAttributeView(ctx, records, fileName, opts)
+}
+
+// ImportAttributeViewXLSX 将 XLSX 工作表导入到数据库中，第一行作为表头。
+func ImportAttributeViewXLSX(reader io.Reader, fileName string, opts *AttrViewImportOptions) (ret *AttrViewImportResult, err error) {
+	return ImportAttributeViewXLSXWithContext(GetDefaultWorkspaceContext(), reader, fileName, opts)
+}
+
+// ImportAttributeViewXLSXWithContext 使用 WorkspaceContext 将 XLSX 工作表导入到数据库中
+func ImportAttributeViewXLSXWithContext(ctx *WorkspaceContext, reader io.Reader, fileName string, opts *AttrViewImportOptions) (ret *AttrViewImportResult, err error) {
+	x, err := excelize.OpenReader(reader)
+	if nil != err {
+		logging.LogErrorf("open xlsx [%s] failed: %s", fileName, err)
+		return
+	}
+	defer x.Close()
+
+	sheet := opts.Sheet
+	if "" == sheet {
+		if sheets := x.GetSheetList(); 0 < len(sheets) {
+			sheet = sheets[0]
+		}
+	}
+	records, err := x.GetRows(sheet)
+	if nil != err {
+		logging.LogErrorf("get rows from sheet [%s] of [%s] failed: %s", sheet, fileName, err)
+		return
+	}
+	return importAttributeView(ctx, records, fileName, opts)
+}
+
+func importAttributeView(ctx *WorkspaceContext, records [][]string, fileName string, opts *AttrViewImportOptions) (ret *AttrViewImportResult, err error) {
+	if 1 > len(records) {
+		err = errors.New("no data found")
+		return
+	}
+	header, rows := records[0], records[1:]
+	if opts.Bind && !opts.DryRun && "" == opts.BoxID {
+		err = errors.New("notebook is required when binding rows to new docs")
+		return
+	}
+	if opts.Bind && !opts.DryRun && nil == Conf.BoxWithContext(ctx, opts.BoxID) {
+		err = errors.New(Conf.Language(0))
+		return
+	}
+
+	var attrView *av.AttributeView
+	if "" != opts.AvID {
+		if attrView, err = av.ParseAttributeView(opts.AvID); nil != err {
+			return
+		}
+	}
+
+	locale := newAttrViewImportLocale(opts.Locale)
+	columns, err := resolveAttrViewImportColumns(attrView, header, rows, opts.Columns, locale)
+	if nil != err {
+		return
+	}
+	ret = &AttrViewImportResult{AvID: opts.AvID, Columns: columns, Rows: len(rows), Errors: []*AttrViewImportError{}}
+
+	if !opts.DryRun {
+		if nil == attrView {
+			name := opts.Name
+			if "" == name {
+				name = strings.TrimSuffix(path.Base(fileName), path.Ext(fileName))
+			}
+			if attrView, ret.BlockID, err = newAttrViewForImport(name, columns); nil != err {
+				return
+			}
+			ret.AvID = attrView.ID
+		}
+		if err = addAttrViewImportKeys(attrView.ID, columns); nil != err {
+			return
+		}
+		if attrView, err = av.ParseAttributeView(attrView.ID); nil != err {
+			return
+		}
+	}
+
+	keys := map[int]*av.Key{}
+	for _, column := range columns {
+		if column.Skip {
+			continue
+		}
+		var key *av.Key
+		if nil != attrView && "" != column.KeyID {
+			key, _ = attrView.GetKey(column.KeyID)
+		}
+		if nil == key {
+			// 预览时新字段尚未创建，使用临时字段进行转换
+			key = av.NewKey(column.KeyID, column.Name, "", column.Type)
+		}
+		keys[column.Index] = key
+	}
+
+	// 每一行的值都经过字段规则校验，不满足规则的行不导入；写入时通过 applyAttributeViewValue，和编辑单元格一样记录单元格历史
+	type cellChange struct {
+		itemID, keyID string
+		val           *av.Value
+	}
+	var changes []*cellChange
+	var itemIDs, titles []string
+	for i, row := range rows {
+		var values []*av.Value
+		var blockValue *av.Value
+		rowValues := map[string]*av.Value{}
+		for _, column := range columns {
+			key := keys[column.Index]
+			if nil == key {
+				continue
+			}
+
+			content := ""
+			if column.Index < len(row) {
+				content = strings.TrimSpace(row[column.Index])
+			}
+			value, convertErr := parseAttrViewImportValue(key, content, locale)
+			if nil != convertErr {
+				ret.Errors = append(ret.Errors, &AttrViewImportError{Row: i + 1, Column: column.Name, Value: content, Msg: convertErr.Error()})
+				continue
+			}
+			if nil == value {
+				continue
+			}
+			value.KeyID = key.ID
+			if av.KeyTypeBlock == key.Type {
+				blockValue = value
+			} else {
+				values = append(values, value)
+			}
+			rowValues[key.ID] = value
+		}
+
+		if nil == blockValue {
+			blockValue = &av.Value{KeyID: keys[attrViewImportBlockColumn(columns).Index].ID, Type: av.KeyTypeBlock, Block: &av.ValueBlock{}}
+			rowValues[blockValue.KeyID] = blockValue
+		}
+
+		valid := true
+		for _, column := range columns {
+			key := keys[column.Index]
+			if nil == attrView || nil == key || nil == key.Constraint {
+				continue
+			}
+
+			value := rowValues[key.ID]
+			if nil == value {
+				value = &av.Value{KeyID: key.ID, Type: key.Type}
+			}
+			if violation := av.CheckValueConstraint(attrView, key, "", value); nil != violation {
+				content := ""
+				if column.Index < len(row) {
+					content = strings.TrimSpace(row[column.Index])
+				}
+				ret.Errors = append(ret.Errors, &AttrViewImportError{Row: i + 1, Column: column.Name, Value: content, Msg: violation.Error()})
+				valid = false
+			}
+		}
+		if !valid || opts.DryRun {
+			continue
+		}
+
+		rowItemIDs, appendErr := appendAttrViewDetachedItems(attrView, [][]*av.Value{{blockValue}})
+		if nil != appendErr {
+			err = appendErr
+			return
+		}
+		itemID := rowItemIDs[0]
+		changes = append(changes, &cellChange{itemID: itemID, keyID: blockValue.KeyID, val: blockValue})
+		for _, value := range values {
+			val, _, changed, applyErr := applyAttributeViewValue(nil, attrView, value.KeyID, itemID, value)
+			if nil != applyErr {
+				err = applyErr
+				return
+			}
+			if changed {
+				changes = append(changes, &cellChange{itemID: itemID, keyID: value.KeyID, val: val})
+			}
+		}
+		itemIDs = append(itemIDs, itemID)
+		titles = append(titles, blockValue.Block.Content)
+	}
+
+	if opts.DryRun || 1 > len(itemIDs) {
+		return
+	}
+
+	// 先新建并绑定文档，成功后再保存数据库，避免绑定失败时留下导入了一半的数据
+	if opts.Bind {
+		if err = bindAttrViewImportItems(ctx, attrView, itemIDs, titles, opts.BoxID, opts.HPath); nil != err {
+			return
+		}
+	}
+
+	regenAttrViewGroups(attrView)
+	if err = av.SaveAttributeView(attrView); nil != err {
+		logging.LogErrorf("save attribute view [%s] failed: %s", attrView.ID, err)
+		return
+	}
+	ret.Imported = len(itemIDs)
+
+	for _, change := range changes {
+		recordAttrViewCellHistory(nil, attrView.ID, change.itemID, change.keyID, nil, change.val)
+	}
+	refreshRelatedSrcAvs(attrView.ID)
+	ReloadAttrView(attrView.ID)
+	return
+}
+
+// resolveAttrViewImportColumns 根据表头、用户指定的映射和目标数据库确定每一列对应的字段及其类型。
+func resolveAttrViewImportColumns(attrView *av.AttributeView, header []string, rows [][]string, mapping []*AttrViewImportColumn, locale *attrViewImportLocale) (ret []*AttrViewImportColumn, err error) {
+	width := len(header)
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+
+	specified := map[int]*AttrViewImportColumn{}
+	for _, column := range mapping {
+		if 0 > column.Index || column.Index >= width {
+			err = fmt.Errorf("column index [%d] out of range", column.Index)
+			return
+		}
+		specified[column.Index] = column
+	}
+
+	hasBlock := false
+	for i := 0; i < width; i++ {
+		column := &AttrViewImportColumn{Index: i}
+		if s := specified[i]; nil != s {
+			column.Name, column.KeyID, column.Type, column.Skip = s.Name, s.KeyID, s.Type, s.Skip
+		}
+		if "" == column.Name && i < len(header) {
+			column.Name = strings.TrimSpace(header[i])
+		}
+		if "" == column.Name {
+			column.Name = fmt.Sprintf("Column %d", i+1)
+		}
+		if column.Skip {
+			ret = append(ret, column)
+			continue
+		}
+
+		if av.KeyTypeBlock == column.Type {
+			if hasBlock {
+				err = errors.New("only one column can be the primary key")
+				return
+			}
+			hasBlock = true
+		}
+		ret = append(ret, column)
+	}
+
+	mappedKeys := map[string]bool{}
+	mapKey := func(column *AttrViewImportColumn, key *av.Key) error {
+		if mappedKeys[key.ID] {
+			return fmt.Errorf("field [%s] is mapped by more than one column", key.Name)
+		}
+		mappedKeys[key.ID] = true
+		column.KeyID, column.Type = key.ID, key.Type
+		if av.KeyTypeBlock == key.Type {
+			hasBlock = true
+		}
+		return nil
+	}
+
+	if nil != attrView {
+		// 先映射指定了主键或字段 ID 的列，再按字段名匹配已有字段，最后才确定主键列，这样主键列不在第一列时也能按名称匹配
+		for _, column := range ret {
+			if column.Skip {
+				continue
+			}
+
+			var key *av.Key
+			if av.KeyTypeBlock == column.Type {
+				key = attrView.GetBlockKeyValues().Key
+			} else if "" != column.KeyID {
+				if key, _ = attrView.GetKey(column.KeyID); nil == key {
+					err = fmt.Errorf("field [%s] not found", column.KeyID)
+					return
+				}
+			} else {
+				continue
+			}
+			if err = mapKey(column, key); nil != err {
+				return
+			}
+		}
+
+		for _, column := range ret {
+			if column.Skip || "" != column.KeyID {
+				continue
+			}
+
+			for _, keyValues := range attrView.KeyValues {
+				if keyValues.Key.Name == column.Name && !mappedKeys[keyValues.Key.ID] {
+					if err = mapKey(column, keyValues.Key); nil != err {
+						return
+					}
+					break
+				}
+			}
+		}
+	}
+
+	if !hasBlock {
+		// 未指定主键列时使用第一个没有映射到已有字段的列
+		for _, column := range ret {
+			if !column.Skip && "" == column.KeyID {
+				column.Type = av.KeyTypeBlock
+				if nil != attrView {
+					if err = mapKey(column, attrView.GetBlockKeyValues().Key); nil != err {
+						return
+					}
+				}
+				hasBlock = true
+				break
+			}
+		}
+	}
+	if !hasBlock {
+		err = errors.New("no column can be the primary key")
+		return
+	}
+
+	for _, column := range ret {
+		if column.Skip {
+			continue
+		}
+
+		if "" == column.Type {
+			var contents []string
+			for _, row := range rows {
+				if column.Index < len(row) {
+					contents = append(contents, strings.TrimSpace(row[column.Index]))
+				}
+			}
+			column.Type = inferAttrViewImportKeyType(contents, locale)
+		}
+		if !isAttrViewImportKeyType(column.Type) {
+			err = fmt.Errorf("field type [%s] of column [%s] is not supported for import", column.Type, column.Name)
+			return
+		}
+	}
+	return
+}
+
+func attrViewImportBlockColumn(columns []*AttrViewImportColumn) *AttrViewImportColumn {
+	for _, column := range columns {
+		if !column.Skip && av.KeyTypeBlock == column.Type {
+			return column
+		}
+	}
+	return nil
+}
+
+func isAttrViewImportKeyType(keyType av.KeyType) bool {
+	switch keyType {
+	case av.KeyTypeBlock, av.KeyTypeText, av.KeyTypeNumber, av.KeyTypeDate, av.KeyTypeSelect, av.KeyTypeMSelect,
+		av.KeyTypeURL, av.KeyTypeEmail, av.KeyTypePhone, av.KeyTypeCheckbox:
+		return true
+	}
+	return false
+}
+
+// newAttrViewForImport 新建一个只包含主键字段的数据库，主键字段名使用主键列的列名。
+func newAttrViewForImport(name string, columns []*AttrViewImportColumn) (ret *av.AttributeView, blockID string, err error) {
+	avID := ast.NewNodeID()
+	blockID = ast.NewNodeID()
+	ret = av.NewAttributeView(avID)
+	ret.Name = name
+
+	// 去掉默认的单选字段
+	blockKey := ret.GetBlockKeyValues().Key
+	ret.KeyValues = []*av.KeyValues{{Key: blockKey}}
+	for _, view := range ret.Views {
+		if nil != view.Table {
+			view.Table.Columns = []*av.ViewTableColumn{{BaseField: &av.BaseField{ID: blockKey.ID}}}
+		}
+	}
+
+	if column := attrViewImportBlockColumn(columns); nil != column {
+		blockKey.Name = column.Name
+		column.KeyID = blockKey.ID
+	}
+
+	if err = av.SaveAttributeView(ret); nil != err {
+		logging.LogErrorf("save attribute view [%s] failed: %s", avID, err)
+		return
+	}
+	av.UpsertBlockRel(avID, blockID)
+	return
+}
+
+// addAttrViewImportKeys 为没有映射到已有字段的列新建字段，新字段依次添加到最后。
+func addAttrViewImportKeys(avID string, columns []*AttrViewImportColumn) (err error) {
+	previousKeyID := ""
+	for _, column := range columns {
+		if column.Skip {
+			continue
+		}
+		if "" != column.KeyID {
+			previousKeyID = column.KeyID
+			continue
+		}
+
+		column.KeyID = ast.NewNodeID()
+		if err = AddAttributeViewKey(avID, column.KeyID, column.Name, string(column.Type), "", previousKeyID); nil != err {
+			return
+		}
+		previousKeyID = column.KeyID
+	}
+	return
+}
+
+// bindAttrViewImportItems 为导入的每一项在 hPath 下新建同名文档并绑定（不保存数据库），主键为空的项保持非绑定。
+func bindAttrViewImportItems(ctx *WorkspaceContext, attrView *av.AttributeView, itemIDs, titles []string, boxID, hPath string) (err error) {
+	hPath = "/" + strings.Trim(strings.TrimSpace(hPath), "/")
+	parentID := ""
+	if "/" != hPath {
+		if parentID, err = createDocsByHPathWithContext(ctx, boxID, hPath, "", "", ""); nil != err {
+			return
+		}
+	}
+
+	blockKeyValues := attrView.GetBlockKeyValues()
+	usedTitles := map[string]int{}
+	for i, itemID := range itemIDs {
+		title := strings.TrimSpace(strings.ReplaceAll(titles[i], "/", " "))
+		if "" == title {
+			continue
+		}
+		if n := usedTitles[title]; 0 < n {
+			usedTitles[title] = n + 1
+			title = fmt.Sprintf("%s (%d)", title, n+1)
+		} else {
+			usedTitles[title] = 1
+		}
+
+		docID, createErr := CreateWithMarkdownWithContext(ctx, "", boxID, path.Join(hPath, title), "", parentID, "", false, "")
+		if nil != createErr {
+			logging.LogErrorf("create doc [%s] for attribute view [%s] failed: %s", title, attrView.ID, createErr)
+			err = createErr
+			return
+		}
+		if err = bindBlockAvWithContext(ctx, attrView.ID, docID); nil != err {
+			logging.LogErrorf("bind doc [%s] to attribute view [%s] failed: %s", docID, attrView.ID, err)
+			return
+		}
+
+		if blockVal := blockKeyValues.GetValue(itemID); nil != blockVal {
+			blockVal.IsDetached = false
+			blockVal.Block.ID = docID
+			blockVal.Block.Content = title
+		}
+	}
+	return
+}
+
+// attrViewImportLocale 描述了解析数字和日期时使用的区域习惯。
+type attrViewImportLocale struct {
+	decimalComma bool   // 是否使用逗号作为小数点
+	dateOrder    string // 年月日顺序：ymd、mdy 或 dmy
+}
+
+func newAttrViewImportLocale(locale string) (ret *attrViewImportLocale) {
+	if "" == locale {
+		locale = Conf.Lang
+	}
+	locale = strings.ReplaceAll(locale, "-", "_")
+	lang, region, _ := strings.Cut(locale, "_")
+	lang = strings.ToLower(lang)
+
+	ret = &attrViewImportLocale{dateOrder: "dmy"}
+	switch lang {
+	case "de", "fr", "es", "it", "pt", "ru", "pl", "nl", "tr", "id", "vi", "uk", "cs", "sk", "sl", "sv", "da", "nb", "no", "fi",
+		"el", "ro", "hu", "hr", "sr", "bg", "lt", "lv", "et":
+		ret.decimalComma = true
+	}
+	switch lang {
+	case "zh", "ja", "ko", "hu", "lt", "mn":
+		ret.dateOrder = "ymd"
+	case "en":
+		if "" == region || strings.EqualFold("US", region) || strings.EqualFold("PH", region) {
+			ret.dateOrder = "mdy"
+		}
+	}
+	return
+}
+
+func (locale *attrViewImportLocale) parseNumber(content string) (ret float64, err error) {
+	s := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || '\'' == r || unicode.Is(unicode.Sc, r) {
+			// 去掉空白、千分位撇号和货币符号
+			return -1
+		}
+		return r
+	}, content)
+
+	if locale.decimalComma {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	} else {
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	if ret, err = strconv.ParseFloat(s, 64); nil != err {
+		err = fmt.Errorf("invalid number")
+	}
+	return
+}
+
+var attrViewImportTimeLayouts = []string{"", " 15:04", " 15:04:05", "T15:04", "T15:04:05", " 3:04 PM", " 3:04PM", " 3:04:05 PM"}
+
+func (locale *attrViewImportLocale) dateLayouts() (ret []string) {
+	var dateLayouts []string
+	for _, sep := range []string{"-", "/", "."} {
+		dateLayouts = append(dateLayouts, "2006"+sep+"1"+sep+"2")
+	}
+	for _, sep := range []string{"/", ".", "-"} {
+		switch locale.dateOrder {
+		case "mdy":
+			dateLayouts = append(dateLayouts, "1"+sep+"2"+sep+"2006", "1"+sep+"2"+sep+"06")
+		case "dmy":
+			dateLayouts = append(dateLayouts, "2"+sep+"1"+sep+"2006", "2"+sep+"1"+sep+"06")
+		}
+	}
+	dateLayouts = append(dateLayouts, "2006年1月2日", "Jan 2, 2006", "January 2, 2006", "2 Jan 2006", "2 January 2006")
+
+	for _, dateLayout := range dateLayouts {
+		for _, timeLayout := range attrViewImportTimeLayouts {
+			ret = append(ret, dateLayout+timeLayout)
+		}
+	}
+	return
+}
+
+func (locale *attrViewImportLocale) parseTime(content string) (ret time.Time, hasTime bool, err error) {
+	if t, parseErr := time.Parse(time.RFC3339, content); nil == parseErr {
+		return t.Local(), true, nil
+	}
+
+	for _, layout := range locale.dateLayouts() {
+		if t, parseErr := time.ParseInLocation(layout, content, time.Local); nil == parseErr {
+			return t, strings.Contains(layout, ":"), nil
+		}
+	}
+	err = fmt.Errorf("invalid date")
+	return
+}
+
+// parseDate 解析日期，支持使用 → 分隔的起止日期。
+func (locale *attrViewImportLocale) parseDate(content string) (ret *av.ValueDate, err error) {
+	start, end, hasEndDate := strings.Cut(content, "→")
+	t1, hasTime1, err := locale.parseTime(strings.TrimSpace(start))
+	if nil != err {
+		return
+	}
+
+	var content2 int64
+	hasTime2 := false
+	if hasEndDate {
+		t2, hasTime, parseErr := locale.parseTime(strings.TrimSpace(end))
+		if nil != parseErr {
+			err = parseErr
+			return
+		}
+		content2, hasTime2 = t2.UnixMilli(), hasTime
+	}
+	ret = av.NewFormattedValueDate(t1.UnixMilli(), content2, av.DateFormatNone, !hasTime1 && !hasTime2, hasEndDate)
+	return
+}
+
+func parseAttrViewImportCheckbox(content string) (checked bool, err error) {
+	switch strings.ToLower(content) {
+	case "true", "yes", "y", "1", "x", "on", "checked", av.CheckboxCheckedStr, "✓", "✔", "☑", "是":
+		return true, nil
+	case "false", "no", "n", "0", "off", "unchecked", "☐", "否":
+		return false, nil
+	}
+	err = fmt.Errorf("invalid checkbox value")
+	return
+}
+
+func splitAttrViewImportOptions(content string) (ret []string) {
+	for _, part := range strings.FieldsFunc(content, func(r rune) bool {
+		return ',' == r || '，' == r || ';' == r || '；' == r || '、' == r || '\n' == r
+	}) {
+		if part = strings.TrimSpace(part); "" != part && !gulu.Str.Contains(part, ret) {
+			ret = append(ret, part)
+		}
+	}
+	return
+}
+
+// parseAttrViewImportValue 将单元格内容转换为字段值，内容为空时返回 nil（主键除外）。
+// 单选和多选字段中不存在的选项会直接添加到字段上。
+func parseAttrViewImportValue(key *av.Key, content string, locale *attrViewImportLocale) (ret *av.Value, err error) {
+	if "" == content && av.KeyTypeBlock != key.Type {
+		return
+	}
+
+	ret = &av.Value{Type: key.Type}
+	switch key.Type {
+	case av.KeyTypeBlock:
+		ret.Block = &av.ValueBlock{Content: content}
+	case av.KeyTypeText:
+		ret.Text = &av.ValueText{Content: content}
+	case av.KeyTypeNumber:
+		n, parseErr := locale.parseNumber(content)
+		if nil != parseErr {
+			return nil, parseErr
+		}
+		ret.Number = av.NewFormattedValueNumber(n, key.NumberFormat)
+	case av.KeyTypeDate:
+		date, parseErr := locale.parseDate(content)
+		if nil != parseErr {
+			return nil, parseErr
+		}
+		ret.Date = date
+	case av.KeyTypeCheckbox:
+		checked, parseErr := parseAttrViewImportCheckbox(content)
+		if nil != parseErr {
+			return nil, parseErr
+		}
+		ret.Checkbox = &av.ValueCheckbox{Checked: checked}
+	case av.KeyTypeSelect, av.KeyTypeMSelect:
+		names := []string{content}
+		if av.KeyTypeMSelect == key.Type {
+			names = splitAttrViewImportOptions(content)
+		}
+		for _, name := range names {
+			opt := key.GetOption(name)
+			if nil == opt {
+				opt = &av.SelectOption{Name: name, Color: strconv.Itoa(len(key.Options)%14 + 1)}
+				key.Options = append(key.Options, opt)
+			}
+			ret.MSelect = append(ret.MSelect, &av.ValueSelect{Content: opt.Name, Color: opt.Color})
+		}
+	case av.KeyTypeURL:
+		ret.URL = &av.ValueURL{Content: content}
+	case av.KeyTypeEmail:
+		ret.Email = &av.ValueEmail{Content: content}
+	case av.KeyTypePhone:
+		ret.Phone = &av.ValuePhone{Content: content}
+	default:
+		return nil, fmt.Errorf("field type [%s] is not supported for import", key.Type)
+	}
+	return
+}
+
+// inferAttrViewImportKeyType 根据列中的非空内容推断字段类型，依次尝试复选框、数字、日期、链接、邮箱和单选，都不满足时为文本。
+func inferAttrViewImportKeyType(contents []string, locale *attrViewImportLocale) av.KeyType {
+	var nonEmpty []string
+	for _, content := range contents {
+		if "" != content {
+			nonEmpty = append(nonEmpty, content)
+		}
+	}
+	if 1 > len(nonEmpty) {
+		return av.KeyTypeText
+	}
+
+	all := func(fn func(string) bool) bool {
+		for _, content := range nonEmpty {
+			if !fn(content) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if all(func(s string) bool {
+		_, err := parseAttrViewImportCheckbox(s)
+		return nil == err && !unicode.IsDigit([]rune(s)[0])
+	}) {
+		return av.KeyTypeCheckbox
+	}
+	if all(func(s string) bool {
+		_, err := locale.parseNumber(s)
+		return nil == err
+	}) {
+		return av.KeyTypeNumber
+	}
+	if all(func(s string) bool {
+		_, err := locale.parseDate(s)
+		return nil == err
+	}) {
+		return av.KeyTypeDate
+	}
+	if all(func(s string) bool {
+		lower := strings.ToLower(s)
+		return (strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")) && !strings.ContainsAny(s, " \t\n")
+	}) {
+		return av.KeyTypeURL
+	}
+	if all(func(s string) bool {
+		at := strings.Index(s, "@")
+		return 0 < at && strings.Contains(s[at:], ".") && !strings.ContainsAny(s, " \t\n")
+	}) {
+		return av.KeyTypeEmail
+	}
+
+	// 取值重复较多的短文本列作为单选
+	distinct := map[string]bool{}
+	for _, content := range nonEmpty {
+		if 32 < len([]rune(content)) || strings.Contains(content, "\n") {
+			return av.KeyTypeText
+		}
+		distinct[content] = true
+	}
+	if len(distinct) <= 20 && len(distinct)*2 <= len(nonEmpty) {
+		return av.KeyTypeSelect
+	}
+	return av.KeyTypeText
+}
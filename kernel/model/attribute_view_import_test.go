@@ -0,0 +1,69 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/88250/lute/ast"
+	"github.com/siyuan-note/siyuan/kernel/av"
+)
+
+func TestResolveAttrViewImportColumns(t *testing.T) {
+	blockKey := av.NewKey(ast.NewNodeID(), "Name", "", av.KeyTypeBlock)
+	statusKey := av.NewKey(ast.NewNodeID(), "Status", "", av.KeyTypeSelect)
+	attrView := &av.AttributeView{ID: ast.NewNodeID(), KeyValues: []*av.KeyValues{{Key: blockKey}, {Key: statusKey}}}
+	locale := newAttrViewImportLocale("en_US")
+
+	// 主键列不在第一列时按名称匹配
+	columns, err := resolveAttrViewImportColumns(attrView, []string{"Status", "Name", "Note"}, [][]string{{"Todo", "A", "x"}}, nil, locale)
+	if nil != err {
+		t.Fatalf("resolve columns failed: %s", err)
+	}
+	if columns[0].KeyID != statusKey.ID || columns[1].KeyID != blockKey.ID || av.KeyTypeBlock != columns[1].Type || "" != columns[2].KeyID {
+		t.Fatalf("unexpected columns: %+v %+v %+v", columns[0], columns[1], columns[2])
+	}
+
+	// 没有和主键同名的列时使用第一个没有映射到已有字段的列
+	columns, err = resolveAttrViewImportColumns(attrView, []string{"Status", "Title"}, nil, nil, locale)
+	if nil != err {
+		t.Fatalf("resolve columns failed: %s", err)
+	}
+	if columns[0].KeyID != statusKey.ID || columns[1].KeyID != blockKey.ID || av.KeyTypeBlock != columns[1].Type {
+		t.Fatalf("unexpected columns: %+v %+v", columns[0], columns[1])
+	}
+
+	// 指定了主键列时同名列不再映射到主键
+	mapping := []*AttrViewImportColumn{{Index: 1, Type: av.KeyTypeBlock}}
+	columns, err = resolveAttrViewImportColumns(attrView, []string{"Name", "Title"}, [][]string{{"a", "b"}}, mapping, locale)
+	if nil != err {
+		t.Fatalf("resolve columns failed: %s", err)
+	}
+	if "" != columns[0].KeyID || av.KeyTypeBlock == columns[0].Type || columns[1].KeyID != blockKey.ID {
+		t.Fatalf("unexpected columns: %+v %+v", columns[0], columns[1])
+	}
+
+	// 多列映射到同一字段时报错
+	mapping = []*AttrViewImportColumn{{Index: 1, KeyID: statusKey.ID}}
+	if _, err = resolveAttrViewImportColumns(attrView, []string{"Name", "Other", "Status"}, nil, mapping, locale); nil != err {
+		t.Fatalf("resolve columns failed: %s", err)
+	}
+	mapping = []*AttrViewImportColumn{{Index: 0, KeyID: statusKey.ID}, {Index: 1, KeyID: statusKey.ID}}
+	if _, err = resolveAttrViewImportColumns(attrView, []string{"A", "B", "Name"}, nil, mapping, locale); nil == err {
+		t.Fatalf("expected duplicated mapping error")
+	}
+}
@@ -56,6 +56,111 @@ func RenderAttributeView(blockID, avID, viewID, query string, page, pageSize int
 	return
 }
 
+// RenderAttributeViewCalendar 渲染日历视图，只返回和 [rangeStart, rangeEnd] 有交集的项目并按天分组。
+func RenderAttributeViewCalendar(blockID, avID, viewID, query string, rangeStart, rangeEnd time.Time) (calendar *av.Calendar, attrView *av.AttributeView, err error) {
+	waitForSyncingStorages()
+
+	attrView, err = av.ParseAttributeView(avID)
+	if err != nil {
+		logging.LogErrorf("parse attribute view [%s] failed: %s", avID, err)
+		return
+	}
+
+	view, err := getRenderAttributeViewView(attrView, viewID, blockID)
+	if nil != err {
+		return
+	}
+	if av.LayoutTypeCalendar != view.LayoutType {
+		err = av.ErrWrongLayoutType
+		return
+	}
+
+	checkAttrView(attrView, view)
+	upgradeAttributeViewSpec(attrView)
+
+	viewable := sql.RenderView(attrView, view, query)
+	if err = renderViewableInstance(viewable, view, attrView, 1, -1); nil != err {
+		return
+	}
+
+	calendar = viewable.(*av.Calendar)
+	calendar.BucketByDay(rangeStart, rangeEnd)
+	return
+}
+
+// RenderAttributeViewTimeline 渲染时间线视图，只返回和 [rangeStart, rangeEnd] 有交集的项目并计算横条的位置。设置了分组时按分组渲染。
+func RenderAttributeViewTimeline(blockID, avID, viewID, query string, rangeStart, rangeEnd time.Time) (timeline *av.Timeline, attrView *av.AttributeView, err error) {
+	waitForSyncingStorages()
+
+	attrView, err = av.ParseAttributeView(avID)
+	if err != nil {
+		logging.LogErrorf("parse attribute view [%s] failed: %s", avID, err)
+		return
+	}
+
+	view, err := getRenderAttributeViewView(attrView, viewID, blockID)
+	if nil != err {
+		return
+	}
+	if av.LayoutTypeTimeline != view.LayoutType {
+		err = av.ErrWrongLayoutType
+		return
+	}
+
+	checkAttrView(attrView, view)
+	upgradeAttributeViewSpec(attrView)
+
+	viewable := sql.RenderView(attrView, view, query)
+	if err = renderViewableInstance(viewable, view, attrView, 1, -1); nil != err {
+		return
+	}
+	if err = renderAttributeViewGroups(viewable, attrView, view, query, 1, -1, nil); nil != err {
+		return
+	}
+
+	timeline = viewable.(*av.Timeline)
+	timeline.Layout(rangeStart, rangeEnd)
+	for _, group := range timeline.Groups {
+		group.(*av.Timeline).Layout(rangeStart, rangeEnd)
+	}
+	return
+}
+
+// RenderAttributeViewChart 渲染图表视图，以分组作为分类、以系列字段的值作为系列，返回聚合后的数据。
+func RenderAttributeViewChart(blockID, avID, viewID, query string) (chart *av.Chart, attrView *av.AttributeView, err error) {
+	waitForSyncingStorages()
+
+	attrView, err = av.ParseAttributeView(avID)
+	if err != nil {
+		logging.LogErrorf("parse attribute view [%s] failed: %s", avID, err)
+		return
+	}
+
+	view, err := getRenderAttributeViewView(attrView, viewID, blockID)
+	if nil != err {
+		return
+	}
+	if av.LayoutTypeChart != view.LayoutType {
+		err = av.ErrWrongLayoutType
+		return
+	}
+
+	checkAttrView(attrView, view)
+	upgradeAttributeViewSpec(attrView)
+
+	viewable := sql.RenderView(attrView, view, query)
+	if err = renderViewableInstance(viewable, view, attrView, 1, -1); nil != err {
+		return
+	}
+	if err = renderAttributeViewGroups(viewable, attrView, view, query, 1, -1, nil); nil != err {
+		return
+	}
+
+	chart = viewable.(*av.Chart)
+	chart.FillSeries(attrView)
+	return
+}
+
 const (
 	groupValueDefault                                        = "_@default@_"    // 默认分组值（值为空的默认分组）
 	groupValueNotInRange                                     = "_@notInRange@_" // 不再范围内的分组值（只有数字类型的分组才可能是该值）
@@ -110,7 +215,7 @@ func renderAttributeViewGroups(viewable av.Viewable, attrView *av.AttributeView,
 		}
 	}
 
-	// 如果是按模板分组则需要重新生成分组
+	// 如果是按模板或者公式分组则需要重新生成分组
 	if isGroupByTemplate(attrView, view) {
 		genAttrViewGroups(view, attrView) // 仅重新生成一个视图的分组以提升性能
 		av.SaveAttributeView(attrView)
@@ -177,6 +282,12 @@ func renderAttributeViewGroups(viewable av.Viewable, attrView *av.AttributeView,
 			groupView.Gallery.CardFields = nil
 		case av.LayoutTypeKanban:
 			groupView.Kanban.Fields = nil
+		case av.LayoutTypeCalendar:
+			groupView.Calendar.Fields = nil
+		case av.LayoutTypeTimeline:
+			groupView.Timeline.Fields = nil
+		case av.LayoutTypeChart:
+			groupView.Chart.Fields = nil
 		}
 	}
 	viewable.SetGroups(groups)
@@ -368,7 +479,7 @@ func isGroupByTemplate(attrView *av.AttributeView, view *av.View) bool {
 	if nil == groupKey {
 		return false
 	}
-	return av.KeyTypeTemplate == groupKey.Type
+	return av.KeyTypeTemplate == groupKey.Type || av.KeyTypeFormula == groupKey.Type
 }
 
 func renderViewableInstance(viewable av.Viewable, view *av.View, attrView *av.AttributeView, page, pageSize int) (err error) {
@@ -425,6 +536,18 @@ func renderViewableInstance(viewable av.Viewable, view *av.View, attrView *av.At
 			end = len(kanban.Cards)
 		}
 		kanban.Cards = kanban.Cards[start:end]
+	case av.LayoutTypeCalendar:
+		// 日历视图按日期范围渲染，不分页
+		calendar := viewable.(*av.Calendar)
+		calendar.ItemCount = len(calendar.Items)
+	case av.LayoutTypeTimeline:
+		// 时间线视图按日期范围渲染，不分页
+		timeline := viewable.(*av.Timeline)
+		timeline.ItemCount = len(timeline.Items)
+	case av.LayoutTypeChart:
+		// 图表视图需要全部项目参与聚合，不分页
+		chart := viewable.(*av.Chart)
+		chart.ItemCount = len(chart.Items)
 	}
 	return
 }
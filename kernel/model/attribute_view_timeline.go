@@ -0,0 +1,297 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/siyuan-note/siyuan/kernel/av"
+)
+
+func (tx *Transaction) doSetAttrViewTimeline(operation *Operation) (ret *TxErr) {
+	err := setAttrViewTimeline(operation)
+	if nil != err {
+		return &TxErr{code: TxErrHandleAttributeView, id: operation.AvID, msg: err.Error()}
+	}
+	return
+}
+
+// setAttrViewTimeline 设置时间线视图的日期、标题和依赖字段以及缩放级别，data 中只包含需要修改的项。
+func setAttrViewTimeline(operation *Operation) (err error) {
+	attrView, err := av.ParseAttributeView(operation.AvID)
+	if nil != err {
+		return
+	}
+
+	view, err := getAttrViewViewByBlockID(attrView, operation.BlockID)
+	if nil != err {
+		return
+	}
+
+	if av.LayoutTypeTimeline != view.LayoutType || nil == view.Timeline {
+		err = av.ErrWrongLayoutType
+		return
+	}
+
+	data, ok := operation.Data.(map[string]interface{})
+	if !ok {
+		err = errors.New("invalid timeline data")
+		return
+	}
+
+	timeline := view.Timeline
+	if v, ok := data["startKeyID"].(string); ok {
+		if key, _ := attrView.GetKey(v); !av.IsCalendarDateKey(key) {
+			err = fmt.Errorf("field [%s] can not be used as timeline start date", v)
+			return
+		}
+		timeline.StartKeyID = v
+	}
+	if v, ok := data["endKeyID"].(string); ok {
+		if "" != v {
+			if key, _ := attrView.GetKey(v); !av.IsCalendarDateKey(key) {
+				err = fmt.Errorf("field [%s] can not be used as timeline end date", v)
+				return
+			}
+		}
+		timeline.EndKeyID = v
+	}
+	if v, ok := data["titleKeyID"].(string); ok {
+		if "" != v {
+			if key, _ := attrView.GetKey(v); nil == key {
+				err = fmt.Errorf("field [%s] not found", v)
+				return
+			}
+		}
+		timeline.TitleKeyID = v
+	}
+	if v, ok := data["dependencyKeyID"].(string); ok {
+		if "" != v {
+			// 依赖字段必须关联到当前数据库，值为前置项目
+			key, _ := attrView.GetKey(v)
+			if nil == key || av.KeyTypeRelation != key.Type || nil == key.Relation || attrView.ID != key.Relation.AvID {
+				err = fmt.Errorf("field [%s] can not be used as timeline dependency", v)
+				return
+			}
+		}
+		timeline.DependencyKeyID = v
+	}
+	if v, ok := data["cascadeDependencies"].(bool); ok {
+		timeline.CascadeDependencies = v
+	}
+	if v, ok := data["zoom"].(string); ok {
+		switch av.TimelineZoom(v) {
+		case av.TimelineZoomDay, av.TimelineZoomWeek, av.TimelineZoomMonth:
+			timeline.Zoom = av.TimelineZoom(v)
+		default:
+			err = fmt.Errorf("invalid timeline zoom [%s]", v)
+			return
+		}
+	}
+
+	err = av.SaveAttributeView(attrView)
+	return
+}
+
+func (tx *Transaction) doSetAttrViewTimelineItemDate(operation *Operation) (ret *TxErr) {
+	err := setAttrViewTimelineItemDate(tx, operation)
+	if nil != err {
+		return &TxErr{code: TxErrHandleAttributeView, id: operation.AvID, msg: err.Error()}
+	}
+	return
+}
+
+// setAttrViewTimelineItemDate 在时间线视图中移动或者拉伸横条后同时更新项目的开始和结束日期。
+// operation.ID 为项目 ID，data 为 {start, end}，均为毫秒时间戳。开启了级联依赖时会推迟受影响的后续项目。
+func setAttrViewTimelineItemDate(tx *Transaction, operation *Operation) (err error) {
+	attrView, err := av.ParseAttributeView(operation.AvID)
+	if nil != err {
+		return
+	}
+
+	view, err := getAttrViewViewByBlockID(attrView, operation.BlockID)
+	if nil != err {
+		return
+	}
+
+	if av.LayoutTypeTimeline != view.LayoutType || nil == view.Timeline {
+		err = av.ErrWrongLayoutType
+		return
+	}
+
+	itemID := operation.ID
+	if nil == attrView.GetBlockValue(itemID) {
+		err = fmt.Errorf("item [%s] not found", itemID)
+		return
+	}
+
+	data, ok := operation.Data.(map[string]interface{})
+	if !ok {
+		err = errors.New("invalid timeline item date")
+		return
+	}
+	startArg, ok := data["start"].(float64)
+	if !ok {
+		err = errors.New("missing timeline item start date")
+		return
+	}
+	endArg, ok := data["end"].(float64)
+	if !ok {
+		err = errors.New("missing timeline item end date")
+		return
+	}
+	if endArg < startArg {
+		err = errors.New("timeline item end date is before start date")
+		return
+	}
+
+	// 创建时间和更新时间字段是只读的，只有日期字段才能拖动
+	timeline := view.Timeline
+	if startKey, _ := attrView.GetKey(timeline.StartKeyID); nil == startKey || av.KeyTypeDate != startKey.Type {
+		err = errors.New("timeline start date field is not editable")
+		return
+	}
+
+	schedule := getTimelineSchedule(attrView, timeline, itemID)
+	if 0 == schedule.start {
+		schedule.allDay = true
+	}
+	schedule.start, schedule.end = int64(startArg), int64(endArg)
+	schedules := map[string]*timelineSchedule{itemID: schedule}
+	if timeline.CascadeDependencies && "" != timeline.DependencyKeyID {
+		if err = cascadeTimelineDependencies(attrView, timeline, itemID, schedules); nil != err {
+			return
+		}
+	}
+
+	endKeyID := getTimelineEndKeyID(attrView, timeline)
+	dates := map[string]map[string]*av.ValueDate{}
+	for id, s := range schedules {
+		startDate := &av.ValueDate{Content: s.start, IsNotEmpty: true, IsNotTime: s.allDay}
+		dates[id] = map[string]*av.ValueDate{timeline.StartKeyID: startDate}
+		if "" == endKeyID {
+			if s.end > s.start {
+				startDate.HasEndDate, startDate.Content2, startDate.IsNotEmpty2 = true, s.end, true
+			}
+		} else {
+			dates[id][endKeyID] = &av.ValueDate{Content: s.end, IsNotEmpty: true, IsNotTime: s.allDay}
+		}
+	}
+	err = setAttrViewItemDates(tx, attrView, dates)
+	return
+}
+
+type timelineSchedule struct {
+	start, end int64 // 毫秒时间戳，start 为 0 表示没有安排日期
+	allDay     bool  // 全天项目的结束日期包含当天
+}
+
+// next 返回后续项目最早可以开始的时间。
+func (schedule *timelineSchedule) next() int64 {
+	if !schedule.allDay {
+		return schedule.end
+	}
+	end := time.UnixMilli(schedule.end)
+	return time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, time.Local).UnixMilli()
+}
+
+// getTimelineEndKeyID 返回单独的结束日期字段 ID，结束日期保存在开始日期字段中时返回空。
+func getTimelineEndKeyID(attrView *av.AttributeView, timeline *av.LayoutTimeline) string {
+	if "" == timeline.EndKeyID || timeline.EndKeyID == timeline.StartKeyID {
+		return ""
+	}
+	if key, _ := attrView.GetKey(timeline.EndKeyID); nil == key || av.KeyTypeDate != key.Type {
+		return ""
+	}
+	return timeline.EndKeyID
+}
+
+func getTimelineSchedule(attrView *av.AttributeView, timeline *av.LayoutTimeline, itemID string) (ret *timelineSchedule) {
+	ret = &timelineSchedule{}
+	startVal := attrView.GetValue(timeline.StartKeyID, itemID)
+	if nil == startVal || nil == startVal.Date || !startVal.Date.IsNotEmpty {
+		return
+	}
+
+	ret.start, ret.end, ret.allDay = startVal.Date.Content, startVal.Date.Content, startVal.Date.IsNotTime
+	if endKeyID := getTimelineEndKeyID(attrView, timeline); "" != endKeyID {
+		if v := attrView.GetValue(endKeyID, itemID); nil != v && nil != v.Date && v.Date.IsNotEmpty && v.Date.Content >= ret.start {
+			ret.end = v.Date.Content
+		}
+	} else if startVal.Date.HasEndDate && startVal.Date.IsNotEmpty2 && startVal.Date.Content2 >= ret.start {
+		ret.end = startVal.Date.Content2
+	}
+	return
+}
+
+// cascadeTimelineDependencies 推迟直接或间接依赖 rootID 的后续项目，使它们在前置项目结束之后才开始，后续项目的时长保持不变。
+// 只会推迟，不会提前。schedules 中已有 rootID 的新日期，受影响项目的新日期也会写入 schedules。
+func cascadeTimelineDependencies(attrView *av.AttributeView, timeline *av.LayoutTimeline, rootID string, schedules map[string]*timelineSchedule) (err error) {
+	dependencies, _ := attrView.GetKeyValues(timeline.DependencyKeyID)
+	if nil == dependencies {
+		return
+	}
+
+	getSchedule := func(itemID string) *timelineSchedule {
+		if s := schedules[itemID]; nil != s {
+			return s
+		}
+		return getTimelineSchedule(attrView, timeline, itemID)
+	}
+
+	// 每一轮至少会确定一个项目的位置，超过项目数还有变化说明存在循环依赖
+	for i := 0; i <= len(dependencies.Values); i++ {
+		changed := false
+		for _, v := range dependencies.Values {
+			if nil == v.Relation || rootID == v.BlockID {
+				continue
+			}
+
+			successor := getSchedule(v.BlockID)
+			if 0 == successor.start {
+				continue
+			}
+
+			var earliest int64
+			for _, predecessorID := range v.Relation.BlockIDs {
+				if predecessorID == v.BlockID || nil == schedules[predecessorID] {
+					// 只处理被推迟了的前置项目
+					continue
+				}
+				earliest = max(earliest, schedules[predecessorID].next())
+			}
+			if earliest <= successor.start {
+				continue
+			}
+
+			if successor.allDay {
+				if t := time.UnixMilli(earliest); 0 != t.Hour() || 0 != t.Minute() || 0 != t.Second() || 0 != t.Nanosecond() {
+					earliest = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.Local).UnixMilli()
+				}
+			}
+			delta := earliest - successor.start
+			schedules[v.BlockID] = &timelineSchedule{start: successor.start + delta, end: successor.end + delta, allDay: successor.allDay}
+			changed = true
+		}
+		if !changed {
+			return
+		}
+	}
+	return errors.New("circular timeline dependencies")
+}
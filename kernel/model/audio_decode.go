@@ -0,0 +1,319 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+
+	"github.com/siyuan-note/logging"
+)
+
+// audioChunk 切分后的一段 PCM 音频，Start 为相对录音开始的秒数
+type audioChunk struct {
+	Start float64
+	PCM   []byte
+}
+
+// detectAudioContainer 根据文件头识别音频容器格式
+func detectAudioContainer(data []byte) string {
+	switch {
+	case 12 <= len(data) && "RIFF" == string(data[:4]) && "WAVE" == string(data[8:12]):
+		return "wav"
+	case 4 <= len(data) && "OggS" == string(data[:4]):
+		return "ogg"
+	case 4 <= len(data) && bytes.Equal(data[:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return "webm"
+	case 3 <= len(data) && "ID3" == string(data[:3]):
+		return "mp3"
+	case isMPEGAudioFrames(data):
+		return "mp3"
+	case 4 <= len(data) && "fLaC" == string(data[:4]):
+		return "flac"
+	}
+	return ""
+}
+
+// MPEG 音频帧头中的比特率（kbps），按 [版本是否为 MPEG-1][层] 索引，层 0 为 Layer I
+var mpegAudioBitrates = [2][3][16]int{
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+}
+
+// MPEG 音频帧头中的采样率，按版本位索引：MPEG-2.5、保留、MPEG-2、MPEG-1
+var mpegAudioSampleRates = [4][3]int{{11025, 12000, 8000}, {}, {22050, 24000, 16000}, {44100, 48000, 32000}}
+
+// mpegAudioFrameLength 解析 MPEG 音频帧头并返回帧长度，不是合法的帧头时返回 0
+func mpegAudioFrameLength(header []byte) int {
+	if 4 > len(header) || 0xFF != header[0] || 0xE0 != header[1]&0xE0 {
+		return 0
+	}
+	version, layerBits := (header[1]>>3)&3, (header[1]>>1)&3
+	bitrateIndex, sampleRateIndex, padding := header[2]>>4, (header[2]>>2)&3, int(header[2]>>1)&1
+	if 1 == version || 0 == layerBits || 0 == bitrateIndex || 15 == bitrateIndex || 3 == sampleRateIndex {
+		return 0
+	}
+
+	layer := 3 - int(layerBits) // 0: Layer I, 1: Layer II, 2: Layer III
+	isMPEG1 := 0
+	if 3 == version {
+		isMPEG1 = 1
+	}
+	bitrate := mpegAudioBitrates[isMPEG1][layer][bitrateIndex] * 1000
+	sampleRate := mpegAudioSampleRates[version][sampleRateIndex]
+	switch {
+	case 0 == layer:
+		return (12*bitrate/sampleRate + padding) * 4
+	case 2 == layer && 0 == isMPEG1:
+		return 72*bitrate/sampleRate + padding
+	default:
+		return 144*bitrate/sampleRate + padding
+	}
+}
+
+// isMPEGAudioFrames 判断数据是否以连续的两个 MPEG 音频帧开头。
+// 只检查帧同步位时 16 位 PCM 中的 0xFFEx 采样很容易被误判为 MP3
+func isMPEGAudioFrames(data []byte) bool {
+	length := mpegAudioFrameLength(data)
+	if 1 > length || len(data) < length+4 {
+		return false
+	}
+	return 0 < mpegAudioFrameLength(data[length:])
+}
+
+// decodeAudio 把上传的音频解码为指定采样率和声道数的 16 位小端 PCM。
+// WAV 直接在内核中解码和重采样，MP3、OGG、WebM 等压缩格式需要安装 ffmpeg，没有安装时返回错误。
+// 无法识别的数据按已经是目标格式的 PCM 处理
+func decodeAudio(data []byte, format ASRAudioFormat) (ret []byte, err error) {
+	container := detectAudioContainer(data)
+	switch container {
+	case "wav":
+		wav, parseErr := parseWAV(data)
+		if nil == parseErr {
+			logging.LogDebugf("ASR: WAV format=%d, channels=%d, sampleRate=%d, bitsPerSample=%d", wav.format, wav.channels, wav.sampleRate, wav.bits)
+			return wav.convert(format), nil
+		}
+		// 压缩编码的 WAV 交给 ffmpeg 解码
+		logging.LogDebugf("ASR: decode WAV failed [%s], trying ffmpeg", parseErr)
+		return ffmpegDecodeAudio(data, container, format)
+	case "":
+		logging.LogDebugf("ASR: unknown audio container, sending as raw PCM")
+		return data, nil
+	default:
+		return ffmpegDecodeAudio(data, container, format)
+	}
+}
+
+// ffmpegDecodeAudio 使用 ffmpeg 解码并重采样。
+// 没有安装 ffmpeg 时不能把压缩数据当作 PCM 切分和封装，否则语音识别服务收到的是无效的 WAV
+func ffmpegDecodeAudio(data []byte, container string, format ASRAudioFormat) (ret []byte, err error) {
+	ffmpeg, lookErr := exec.LookPath("ffmpeg")
+	if nil != lookErr {
+		return nil, fmt.Errorf("ffmpeg is required to decode %s audio", container)
+	}
+
+	cmd := exec.Command(ffmpeg, "-hide_banner", "-loglevel", "error", "-i", "pipe:0",
+		"-f", "s16le", "-acodec", "pcm_s16le", "-ac", strconv.Itoa(format.Channels), "-ar", strconv.Itoa(format.SampleRate), "pipe:1")
+	cmd.Stdin = bytes.NewReader(data)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if ret, err = cmd.Output(); nil != err {
+		return nil, fmt.Errorf("ffmpeg decode audio failed: %v %s", err, stderr.String())
+	}
+	return
+}
+
+type wavAudio struct {
+	format     int // 1: PCM, 3: IEEE float
+	channels   int
+	sampleRate int
+	bits       int
+	data       []byte
+}
+
+// parseWAV 解析 WAV 文件，逐个读取 RIFF 块而不是假定头部固定为 44 字节
+func parseWAV(data []byte) (ret *wavAudio, err error) {
+	ret = &wavAudio{}
+	hasFmt := false
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		body := offset + 8
+		if size > len(data)-body || 0 > size {
+			// 边录边写的文件没有回填长度
+			size = len(data) - body
+		}
+
+		switch id {
+		case "fmt ":
+			if 16 > size {
+				return nil, errors.New("invalid WAV fmt chunk")
+			}
+			chunk := data[body : body+size]
+			ret.format = int(binary.LittleEndian.Uint16(chunk[0:2]))
+			ret.channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			ret.sampleRate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+			ret.bits = int(binary.LittleEndian.Uint16(chunk[14:16]))
+			if 0xFFFE == ret.format && 26 <= size {
+				// WAVE_FORMAT_EXTENSIBLE 的实际编码在子格式 GUID 的前两个字节
+				ret.format = int(binary.LittleEndian.Uint16(chunk[24:26]))
+			}
+			hasFmt = true
+		case "data":
+			ret.data = data[body : body+size]
+		}
+		offset = body + size + size%2
+	}
+
+	if !hasFmt || nil == ret.data {
+		return nil, errors.New("WAV fmt or data chunk not found")
+	}
+	if 1 > ret.channels || 1 > ret.sampleRate {
+		return nil, errors.New("invalid WAV channels or sample rate")
+	}
+	switch {
+	case 1 == ret.format && (8 == ret.bits || 16 == ret.bits || 24 == ret.bits || 32 == ret.bits):
+	case 3 == ret.format && (32 == ret.bits || 64 == ret.bits):
+	default:
+		return nil, fmt.Errorf("unsupported WAV encoding [format=%d, bits=%d]", ret.format, ret.bits)
+	}
+	return
+}
+
+func (wav *wavAudio) frames() int {
+	return len(wav.data) / (wav.channels * wav.bits / 8)
+}
+
+// sample 返回第 frame 帧第 channel 个声道的采样，范围 [-1, 1]
+func (wav *wavAudio) sample(frame, channel int) float64 {
+	width := wav.bits / 8
+	b := wav.data[(frame*wav.channels+channel)*width:]
+	switch {
+	case 3 == wav.format && 32 == wav.bits:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case 3 == wav.format:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case 8 == wav.bits:
+		return (float64(b[0]) - 128) / 128
+	case 16 == wav.bits:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+	case 24 == wav.bits:
+		return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / 8388608
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648
+	}
+}
+
+// mixed 返回第 frame 帧混合到 channels 个声道后第 channel 个声道的采样。混合为单声道时取所有声道的平均值
+func (wav *wavAudio) mixed(frame, channel, channels int) float64 {
+	if 1 == channels && 1 < wav.channels {
+		sum := 0.0
+		for c := 0; c < wav.channels; c++ {
+			sum += wav.sample(frame, c)
+		}
+		return sum / float64(wav.channels)
+	}
+	return wav.sample(frame, channel%wav.channels)
+}
+
+// convert 转换为目标格式的 16 位 PCM。降采样时对每个输出采样覆盖的输入采样取平均，减少混叠；升采样时线性插值
+func (wav *wavAudio) convert(format ASRAudioFormat) []byte {
+	if 1 == wav.format && 16 == wav.bits && wav.sampleRate == format.SampleRate && wav.channels == format.Channels {
+		return wav.data
+	}
+
+	srcFrames := wav.frames()
+	ratio := float64(wav.sampleRate) / float64(format.SampleRate)
+	outFrames := int(float64(srcFrames) / ratio)
+	ret := make([]byte, outFrames*format.Channels*2)
+	for i := 0; i < outFrames; i++ {
+		pos := float64(i) * ratio
+		frame := int(pos)
+		for c := 0; c < format.Channels; c++ {
+			var v float64
+			if 1 < ratio {
+				end := min(int(pos+ratio), srcFrames)
+				for j := frame; j < end; j++ {
+					v += wav.mixed(j, c, format.Channels)
+				}
+				v /= float64(max(end-frame, 1))
+			} else {
+				v = wav.mixed(frame, c, format.Channels)
+				if frac := pos - float64(frame); 0 < frac && frame+1 < srcFrames {
+					v += (wav.mixed(frame+1, c, format.Channels) - v) * frac
+				}
+			}
+			v = math.Max(-1, math.Min(1, v))
+			binary.LittleEndian.PutUint16(ret[(i*format.Channels+c)*2:], uint16(int16(math.Round(v*32767))))
+		}
+	}
+	return ret
+}
+
+// encodeWAV 为 16 位 PCM 加上 WAV 头部
+func encodeWAV(pcm []byte, format ASRAudioFormat) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 44+len(pcm)))
+	blockAlign := format.Channels * 2
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(buf, binary.LittleEndian, uint32(16))
+	binary.Write(buf, binary.LittleEndian, uint16(1))
+	binary.Write(buf, binary.LittleEndian, uint16(format.Channels))
+	binary.Write(buf, binary.LittleEndian, uint32(format.SampleRate))
+	binary.Write(buf, binary.LittleEndian, uint32(format.SampleRate*blockAlign))
+	binary.Write(buf, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
+
+// splitAudioChunks 把 PCM 切分为长度为 chunkSeconds、相邻分段重叠 overlapSeconds 的分段
+func splitAudioChunks(pcm []byte, format ASRAudioFormat, chunkSeconds, overlapSeconds int) (ret []*audioChunk) {
+	frameSize := format.Channels * 2
+	bytesPerSecond := format.SampleRate * frameSize
+	chunkSize := chunkSeconds * bytesPerSecond
+	if len(pcm) <= chunkSize {
+		return []*audioChunk{{PCM: pcm}}
+	}
+
+	step := (chunkSeconds - overlapSeconds) * bytesPerSecond
+	step -= step % frameSize
+	for start := 0; start < len(pcm); start += step {
+		end := min(start+chunkSize, len(pcm))
+		ret = append(ret, &audioChunk{Start: float64(start) / float64(bytesPerSecond), PCM: pcm[start:end]})
+		if end == len(pcm) {
+			break
+		}
+	}
+	return
+}
@@ -318,7 +318,7 @@ func getBacklinkRenderNodes(n *ast.Node, originalRefBlockIDs map[string]string)
 	return
 }
 
-func GetBacklink2(id, keyword, mentionKeyword string, sortMode, mentionSortMode int, containChildren bool) (boxID string, backlinks, backmentions []*Path, linkRefsCount, mentionsCount int) {
+func GetBacklink2(id, keyword, mentionKeyword string, sortMode, mentionSortMode int, containChildren, withRelated bool) (boxID string, backlinks, backmentions []*Path, related []*RelatedDoc, linkRefsCount, mentionsCount int) {
 	keyword = strings.TrimSpace(keyword)
 	var keywords []string
 	if "" != keyword {
@@ -417,6 +417,20 @@ func GetBacklink2(id, keyword, mentionKeyword string, sortMode, mentionSortMode
 		name := boxNames[l.Box]
 		l.HPath = name + l.HPath
 	}
+
+	// 语义相关文档，排除已经通过引用关联的文档
+	if withRelated {
+		var err error
+		if related, err = GetRelatedDocs(GetDefaultWorkspaceContext(), rootID, nil, false, 0, 0); nil != err {
+			logging.LogWarnf("get related docs of [%s] failed: %s", rootID, err)
+		}
+		if 0 < len(related) {
+			boxNames = Conf.BoxNames([]string{boxID})
+			for _, doc := range related {
+				doc.HPath = boxNames[doc.Box] + doc.HPath
+			}
+		}
+	}
 	return
 }
 
@@ -977,17 +977,25 @@ func processEmbedHTML(htmlStr string) string {
 }
 
 func GetBlockKramdown(id, mode string) (ret string) {
+	return GetBlockKramdownWithContext(GetDefaultWorkspaceContext(), id, mode)
+}
+
+// GetBlockKramdownWithContext 使用 WorkspaceContext 获取块的 Kramdown
+func GetBlockKramdownWithContext(ctx *WorkspaceContext, id, mode string) (ret string) {
 	if "" == id {
 		return
 	}
 
-	tree, err := LoadTreeByBlockID(id)
+	tree, err := LoadTreeByBlockIDWithContext(ctx, id)
 	if err != nil {
 		return
 	}
 
 	addBlockIALNodes(tree, false)
 	node := treenode.GetNodeInTree(tree, id)
+	if nil == node {
+		return
+	}
 	root := &ast.Node{Type: ast.NodeDocument}
 	root.AppendChild(node.Next) // IAL
 	root.PrependChild(node)
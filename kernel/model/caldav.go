@@ -21,6 +21,7 @@ import (
 	"context"
 	"errors"
 	"github.com/siyuan-note/siyuan/kernel/util"
+	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -28,6 +29,7 @@ import (
 
 	"github.com/88250/gulu"
 	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav"
 	"github.com/emersion/go-webdav/caldav"
 	"github.com/siyuan-note/logging"
 )
@@ -82,8 +84,9 @@ var (
 	ErrorCalDavCalendarNotFound    = errors.New("CalDAV: calendar not found")
 	ErrorCalDavCalendarPathInvalid = errors.New("CalDAV: calendar path is invalid")
 
-	ErrorCalDavCalendarObjectNotFound    = errors.New("CalDAV: calendar object not found")
-	ErrorCalDavCalendarObjectPathInvalid = errors.New("CalDAV: calendar object path is invalid")
+	ErrorCalDavCalendarObjectNotFound     = errors.New("CalDAV: calendar object not found")
+	ErrorCalDavCalendarObjectPathInvalid  = errors.New("CalDAV: calendar object path is invalid")
+	ErrorCalDavCalendarObjectETagMismatch = errors.New("CalDAV: calendar object ETag mismatch")
 )
 
 // CalendarsMetaDataFilePath returns the absolute path of the calendars' meta data file
@@ -401,11 +404,19 @@ func (c *Calendars) PutCalendarObject(objectPath string, calendarData *ical.Cale
 		return
 	}
 
-	// TODO: 处理 opts.IfNoneMatch (If-None-Match) 与 opts.IfMatch (If-Match)
-
 	var object *CalendarObject
+	etag := ""
 	if value, ok := calendar.Objects.Load(objectID); ok {
 		object = value.(*CalendarObject)
+		etag = object.Data.ETag
+	}
+
+	// 对象已经被其他客户端或者绑定的数据库修改时 ETag 不匹配，拒绝写入让客户端重新获取后再修改
+	if err = checkCalendarObjectPreconditions(etag, opts); err != nil {
+		return
+	}
+
+	if object != nil {
 		object.Data.Data = calendarData
 		object.Changed = true
 	} else {
@@ -434,6 +445,30 @@ func (c *Calendars) PutCalendarObject(objectPath string, calendarData *ical.Cale
 	return
 }
 
+// checkCalendarObjectPreconditions checks If-Match and If-None-Match against the current ETag of the object
+func checkCalendarObjectPreconditions(etag string, opts *caldav.PutCalendarObjectOptions) error {
+	if opts == nil {
+		return nil
+	}
+
+	if opts.IfMatch.IsSet() {
+		if ok, err := opts.IfMatch.MatchETag(etag); err != nil {
+			return webdav.NewHTTPError(http.StatusBadRequest, err)
+		} else if !ok {
+			return webdav.NewHTTPError(http.StatusPreconditionFailed, ErrorCalDavCalendarObjectETagMismatch)
+		}
+	}
+
+	if opts.IfNoneMatch.IsSet() {
+		if ok, err := opts.IfNoneMatch.MatchETag(etag); err != nil {
+			return webdav.NewHTTPError(http.StatusBadRequest, err)
+		} else if ok {
+			return webdav.NewHTTPError(http.StatusPreconditionFailed, ErrorCalDavCalendarObjectETagMismatch)
+		}
+	}
+	return nil
+}
+
 func (c *Calendars) ListCalendarObjects(calendarPath string, req *caldav.CalendarCompRequest) (calendarObjects []caldav.CalendarObject, err error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -724,6 +759,19 @@ func (b *CalDavBackend) PutCalendarObject(ctx context.Context, objectPath string
 
 	calendarObject, err = calendars.PutCalendarObject(objectPath, calendar, opts)
 	// logging.LogDebugf("CalDAV PutCalendarObject <- calendarObject: %#v, err: %s", calendarObject, err)
+	if err != nil {
+		return
+	}
+
+	// 同步到绑定的数据库
+	etag := calendarObject.ETag
+	syncAttrViewCalendarObject(objectPath, calendar)
+	if object, getErr := calendars.GetCalendarObject(objectPath, nil); getErr == nil && object.ETag != etag {
+		// 对象被数据库重写（比如不满足字段校验规则），和客户端提交的内容不一致，不返回 ETag 以便客户端重新获取
+		modified := *object
+		modified.ETag = ""
+		calendarObject = &modified
+	}
 	return
 }
 
@@ -774,7 +822,17 @@ func (b *CalDavBackend) DeleteCalendarObject(ctx context.Context, objectPath str
 		return
 	}
 
+	// 删除前记录事件 UID，以便清空绑定的数据库中对应项目的日期
+	object, _ := calendars.GetCalendarObject(objectPath, nil)
+
 	err = calendars.DeleteCalendarObject(objectPath)
 	// logging.LogDebugf("CalDAV DeleteCalendarObject <- err: %s", err)
+	if err != nil {
+		return
+	}
+
+	if object != nil {
+		removeAttrViewCalendarObject(objectPath, object.Data)
+	}
 	return
 }
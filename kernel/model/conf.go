@@ -512,6 +512,39 @@ func InitConf() {
 	if 1 > Conf.AI.OpenAI.APIMaxContexts || 64 < Conf.AI.OpenAI.APIMaxContexts {
 		Conf.AI.OpenAI.APIMaxContexts = 7
 	}
+	if nil != Conf.AI.Embedding {
+		if 200 > Conf.AI.Embedding.ChunkSize || 8000 < Conf.AI.Embedding.ChunkSize {
+			Conf.AI.Embedding.ChunkSize = 2000
+		}
+		if 0 > Conf.AI.Embedding.ChunkOverlap || Conf.AI.Embedding.ChunkSize/2 < Conf.AI.Embedding.ChunkOverlap {
+			Conf.AI.Embedding.ChunkOverlap = 200
+		}
+	}
+	if nil == Conf.AI.Agent {
+		Conf.AI.Agent = conf.NewAgent()
+	}
+	if 1 > Conf.AI.Agent.MaxSteps || 32 < Conf.AI.Agent.MaxSteps {
+		Conf.AI.Agent.MaxSteps = 8
+	}
+	if nil == Conf.AI.Agent.ToolPermissions {
+		Conf.AI.Agent.ToolPermissions = map[string]string{}
+	}
+	if nil == Conf.AI.Providers {
+		Conf.AI.Providers = conf.NewAIProviders()
+		importLegacyAIProviders(Conf.AI.Providers)
+	}
+	if nil == Conf.AI.Providers.Routes {
+		Conf.AI.Providers.Routes = map[string]*conf.AIRoute{}
+	}
+	if nil == Conf.AI.Usage {
+		Conf.AI.Usage = conf.NewAIUsage()
+	}
+	if nil == Conf.AI.Usage.DefaultBudget {
+		Conf.AI.Usage.DefaultBudget = &conf.AIBudget{}
+	}
+	if nil == Conf.AI.Usage.Budgets {
+		Conf.AI.Usage.Budgets = map[string]*conf.AIBudget{}
+	}
 
 	if "" != Conf.AI.OpenAI.APIKey {
 		logging.LogInfof("OpenAI API enabled\n"+
@@ -984,6 +1017,9 @@ func GetMaskedConf() (ret *AppConf, err error) {
 	}
 
 	ret.UserData = MaskedUserData
+	if nil != ret.AI {
+		ret.AI.Providers = ret.AI.Providers.Masked()
+	}
 	if "" != ret.AccessAuthCode {
 		ret.AccessAuthCode = MaskedAccessAuthCode
 	}
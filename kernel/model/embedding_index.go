@@ -0,0 +1,568 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// EmbeddingModelInfo 生成向量使用的服务商、模型和向量维度
+type EmbeddingModelInfo struct {
+	Provider  string `json:"provider"`
+	Model     string `json:"model"`
+	Dimension int    `json:"dimension,omitempty"`
+}
+
+var (
+	embeddingIndexLock = sync.Mutex{}
+	blockVectorsLock   = sync.Mutex{}
+)
+
+// currentEmbeddingIndex 根据 ctx 对应的向量化配置生成索引信息，未配置向量化服务时返回 nil。
+// 已存储的向量由默认工作空间的向量化服务生成，维护索引时传入默认工作空间
+func currentEmbeddingIndex(ctx *WorkspaceContext) *conf.EmbeddingIndex {
+	service := NewEmbeddingServiceWithContext(ctx)
+	if nil == service || !service.IsEnabled() {
+		return nil
+	}
+
+	info := service.ModelInfo()
+	ret := &conf.EmbeddingIndex{Provider: info.Provider, Model: info.Model}
+	if ep := ResolveAIEndpoint(ctx, conf.AITaskEmbedding); nil != ep {
+		ret.ProviderName = ep.Provider.Name
+	}
+	return ret
+}
+
+// getEmbeddingIndexProvider 获取索引引用的服务商，用户的服务商优先，服务商已经被删除时返回 nil
+func getEmbeddingIndexProvider(ctx *WorkspaceContext, index *conf.EmbeddingIndex) *conf.AIProvider {
+	if user := getUserAIProviders(ctx); nil != user {
+		if provider := user.GetProvider(index.ProviderName); nil != provider {
+			return provider
+		}
+	}
+	return Conf.AI.Providers.GetProvider(index.ProviderName)
+}
+
+// EnsureEmbeddingIndex 记录已存储向量所属的模型。
+// 修改向量化配置前需要调用，保证切换模型后仍能使用原来的模型检索旧向量
+func EnsureEmbeddingIndex() {
+	embeddingIndexLock.Lock()
+	defer embeddingIndexLock.Unlock()
+
+	if nil != Conf.AI.EmbeddingIndex {
+		return
+	}
+	if index := currentEmbeddingIndex(GetDefaultWorkspaceContext()); nil != index {
+		Conf.AI.EmbeddingIndex = index
+		Conf.Save()
+		invalidateDocVectorsCache()
+	}
+}
+
+// SwitchEmptyEmbeddingIndex 还没有存储任何向量时直接切换到当前配置的模型，不需要重新向量化
+func SwitchEmptyEmbeddingIndex(dataDir string) {
+	if vectors, _ := loadBlockVectors(); 0 < len(vectors) || hasAssetVectors(dataDir) {
+		return
+	}
+
+	embeddingIndexLock.Lock()
+	defer embeddingIndexLock.Unlock()
+	Conf.AI.EmbeddingIndex = currentEmbeddingIndex(GetDefaultWorkspaceContext())
+	Conf.Save()
+	invalidateDocVectorsCache()
+}
+
+// getEmbeddingIndex 获取已存储向量所属的模型，未配置向量化服务时返回 nil
+func getEmbeddingIndex() *conf.EmbeddingIndex {
+	EnsureEmbeddingIndex()
+
+	embeddingIndexLock.Lock()
+	defer embeddingIndexLock.Unlock()
+	if nil == Conf.AI.EmbeddingIndex {
+		return nil
+	}
+	index := *Conf.AI.EmbeddingIndex
+	return &index
+}
+
+// recordEmbeddingIndexDimension 记录索引的向量维度，旧版配置没有维度信息时在第一次查询后补上
+func recordEmbeddingIndexDimension(dimension int) {
+	embeddingIndexLock.Lock()
+	defer embeddingIndexLock.Unlock()
+
+	index := Conf.AI.EmbeddingIndex
+	if nil == index || 0 != index.Dimension || 1 > dimension {
+		return
+	}
+	index.Dimension = dimension
+	Conf.Save()
+}
+
+// newQueryEmbeddingService 创建用于向量化查询的服务，查询的用量计入 ctx 对应用户的预算。
+// 切换模型后重新向量化完成前，查询使用已存储向量所属的模型，否则和旧向量不在同一个向量空间
+func newQueryEmbeddingService(ctx *WorkspaceContext) EmbeddingService {
+	service := NewEmbeddingServiceWithContext(ctx)
+	if nil == service || !service.IsEnabled() {
+		return service
+	}
+
+	index := getEmbeddingIndex()
+	if nil == index {
+		return service
+	}
+	if info := service.ModelInfo(); info.Provider == index.Provider && info.Model == index.Model {
+		return service
+	}
+	if "" == index.ProviderName {
+		// 旧版向量化配置只有一套连接信息
+		if nil == Conf.AI.Embedding {
+			return service
+		}
+		return &OpenAIEmbeddingService{
+			ctx:      ctx,
+			client:   util.NewOpenAIClient(Conf.AI.Embedding.APIKey, "", Conf.AI.Embedding.APIBaseURL, util.UserAgent, "", conf.AIProviderTypeOpenAI),
+			model:    index.Model,
+			provider: index.Provider,
+		}
+	}
+
+	provider := getEmbeddingIndexProvider(ctx, index)
+	if nil == provider {
+		logging.LogWarnf("embedding index provider [%s] not found, query with current embedding model", index.ProviderName)
+		return service
+	}
+	return &OpenAIEmbeddingService{
+		ctx:      ctx,
+		client:   util.NewOpenAIClient(provider.APIKey, provider.Proxy, provider.BaseURL, util.UserAgent, provider.APIVersion, provider.Type),
+		model:    index.Model,
+		provider: index.Provider,
+	}
+}
+
+// isServingVector 判断向量是否由索引使用的模型生成。
+// 旧版向量没有记录模型，只要维度一致就认为可用，余弦相似度计算时仍会跳过维度不同的向量
+func isServingVector(index *conf.EmbeddingIndex, provider, model string, dimension int) bool {
+	if nil == index {
+		return true
+	}
+	if 0 < index.Dimension && 0 < dimension && index.Dimension != dimension {
+		return false
+	}
+	if "" == model {
+		return true
+	}
+	return provider == index.Provider && model == index.Model
+}
+
+// isTargetVector 判断向量是否已经由目标模型生成
+func isTargetVector(target EmbeddingModelInfo, provider, model string) bool {
+	return provider == target.Provider && model == target.Model
+}
+
+// loadServingBlockVectors 加载由索引使用的模型生成的块向量
+func loadServingBlockVectors() (ret map[string]*BlockVector, err error) {
+	vectors, err := loadBlockVectors()
+	if nil != err {
+		return
+	}
+
+	index := getEmbeddingIndex()
+	ret = map[string]*BlockVector{}
+	for id, vector := range vectors {
+		if isServingVector(index, vector.Provider, vector.Model, len(vector.Vector)) {
+			ret[id] = vector
+		}
+	}
+	return
+}
+
+// hasAssetVectors 判断工作空间下是否存在资源文件向量
+func hasAssetVectors(dataDir string) (ret bool) {
+	assetsDir := filepath.Join(dataDir, "assets")
+	if !gulu.File.IsDir(assetsDir) {
+		return
+	}
+
+	filepath.WalkDir(assetsDir, func(path string, d fs.DirEntry, err error) error {
+		if nil != err {
+			return nil
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".vectors.json") {
+			ret = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return
+}
+
+// ReembedProgress 重新向量化任务进度
+type ReembedProgress struct {
+	VectorizeProgress
+	Serving *EmbeddingModelInfo `json:"serving"` // 检索当前使用的模型
+	Target  *EmbeddingModelInfo `json:"target"`  // 重新向量化使用的模型
+	Phase   string              `json:"phase"`   // blocks, assets, cutover, done, canceled, failed
+	Error   string              `json:"error"`
+}
+
+const (
+	ReembedPhaseBlocks   = "blocks"
+	ReembedPhaseAssets   = "assets"
+	ReembedPhaseCutover  = "cutover"
+	ReembedPhaseDone     = "done"
+	ReembedPhaseCanceled = "canceled"
+	ReembedPhaseFailed   = "failed"
+)
+
+var (
+	reembedProgress     ReembedProgress
+	reembedProgressLock sync.RWMutex
+	reembedCanceled     atomic.Bool
+)
+
+// GetReembedProgress 获取重新向量化任务进度
+func GetReembedProgress() ReembedProgress {
+	reembedProgressLock.RLock()
+	progress := reembedProgress
+	reembedProgressLock.RUnlock()
+
+	progress.estimateTimeLeft()
+	if !progress.IsRunning {
+		if index := getEmbeddingIndex(); nil != index {
+			progress.Serving = &EmbeddingModelInfo{Provider: index.Provider, Model: index.Model, Dimension: index.Dimension}
+		}
+	}
+	return progress
+}
+
+// CancelReembedJob 取消重新向量化任务，已生成的向量会保留，再次启动任务时从中断处继续
+func CancelReembedJob() {
+	reembedCanceled.Store(true)
+}
+
+// StartReembedJob 使用当前配置的模型在后台重新生成所有块向量和资源文件向量。
+// 新向量先写入待切换文件，期间检索仍使用旧向量；全部完成后一次性切换。
+// 任务中断或部分失败时已生成的向量会保留，再次启动时跳过它们
+func StartReembedJob(dataDir string) (err error) {
+	service := NewEmbeddingService()
+	if nil == service || !service.IsEnabled() {
+		return errors.New("embedding service is not enabled")
+	}
+
+	reembedProgressLock.Lock()
+	defer reembedProgressLock.Unlock()
+	if reembedProgress.IsRunning {
+		return errors.New("re-embedding job is already running")
+	}
+
+	EnsureEmbeddingIndex()
+	target := service.ModelInfo()
+	now := time.Now()
+	reembedProgress = ReembedProgress{
+		VectorizeProgress: VectorizeProgress{IsRunning: true, StartTime: now, LastUpdateTime: now},
+		Target:            &target,
+		Phase:             ReembedPhaseBlocks,
+	}
+	if index := getEmbeddingIndex(); nil != index {
+		reembedProgress.Serving = &EmbeddingModelInfo{Provider: index.Provider, Model: index.Model, Dimension: index.Dimension}
+	}
+	reembedCanceled.Store(false)
+	go runReembedJob(dataDir, service, target)
+	return
+}
+
+func runReembedJob(dataDir string, service EmbeddingService, target EmbeddingModelInfo) {
+	blockVectorsLock.Lock()
+	blockVectors, err := loadBlockVectors()
+	blockVectorsLock.Unlock()
+	if nil != err {
+		finishReembedJob(ReembedPhaseFailed, fmt.Sprintf("load block vectors failed: %s", err))
+		return
+	}
+	pending, err := loadPendingBlockVectors()
+	if nil != err {
+		logging.LogWarnf("load pending block vectors failed, starting over: %s", err)
+		pending = map[string]*BlockVector{}
+	}
+	assets, _ := loadAllAssetVectors(dataDir)
+
+	var blockIDs []string
+	for id, vector := range blockVectors {
+		if !isTargetVector(target, vector.Provider, vector.Model) {
+			blockIDs = append(blockIDs, id)
+		}
+	}
+	var staleAssets []*AssetVector
+	for _, asset := range assets {
+		if !isTargetVector(target, asset.Provider, asset.Model) {
+			staleAssets = append(staleAssets, asset)
+		}
+	}
+	reembedProgressLock.Lock()
+	reembedProgress.TotalFiles = len(blockIDs) + len(staleAssets)
+	reembedProgressLock.Unlock()
+
+	dimension := 0
+	for i, id := range blockIDs {
+		if reembedCanceled.Load() {
+			savePendingBlockVectors(pending)
+			finishReembedJob(ReembedPhaseCanceled, "")
+			return
+		}
+
+		vector := blockVectors[id]
+		if done := pending[id]; nil != done && isTargetVector(target, done.Provider, done.Model) && done.Content == vector.Content {
+			dimension = len(done.Vector)
+			updateReembedProgress(id, true)
+			continue
+		}
+
+		embedding, vecErr := service.VectorizeText(vector.Content)
+		if nil != vecErr {
+			logging.LogErrorf("re-embed block [%s] failed: %s", id, vecErr)
+			updateReembedProgress(id, false)
+			continue
+		}
+		dimension = len(embedding)
+		pending[id] = &BlockVector{
+			ID:         id,
+			NotebookID: vector.NotebookID,
+			Content:    vector.Content,
+			Vector:     embedding,
+			UpdatedAt:  time.Now(),
+			Provider:   target.Provider,
+			Model:      target.Model,
+			Dimension:  dimension,
+		}
+		updateReembedProgress(id, true)
+		if 0 == (i+1)%50 {
+			savePendingBlockVectors(pending)
+		}
+	}
+	if err = savePendingBlockVectors(pending); nil != err {
+		finishReembedJob(ReembedPhaseFailed, fmt.Sprintf("save pending block vectors failed: %s", err))
+		return
+	}
+
+	setReembedPhase(ReembedPhaseAssets)
+	for _, asset := range staleAssets {
+		if reembedCanceled.Load() {
+			finishReembedJob(ReembedPhaseCanceled, "")
+			return
+		}
+
+		reembedded, reembedErr := reembedAsset(service, target, asset)
+		if nil != reembedErr {
+			logging.LogErrorf("re-embed asset [%s] failed: %s", asset.AssetPath, reembedErr)
+			updateReembedProgress(asset.FileName, false)
+			continue
+		}
+		dimension = reembedded.Dimension
+		updateReembedProgress(asset.FileName, true)
+	}
+
+	reembedProgressLock.RLock()
+	failed := reembedProgress.FailedCount
+	reembedProgressLock.RUnlock()
+	if 0 < failed {
+		finishReembedJob(ReembedPhaseFailed, fmt.Sprintf("%d items failed, start the job again to retry them", failed))
+		return
+	}
+
+	setReembedPhase(ReembedPhaseCutover)
+	if err = cutOverEmbeddingIndex(target, dimension, staleAssets); nil != err {
+		finishReembedJob(ReembedPhaseFailed, err.Error())
+		return
+	}
+	finishReembedJob(ReembedPhaseDone, "")
+}
+
+// reembedAsset 使用目标模型重新向量化资源文件的所有分块，结果写入待切换文件
+func reembedAsset(service EmbeddingService, target EmbeddingModelInfo, asset *AssetVector) (ret *AssetVector, err error) {
+	pendingPath := getPendingVectorFilePath(asset.AssetPath)
+	if data, readErr := os.ReadFile(pendingPath); nil == readErr {
+		done := &AssetVector{}
+		if nil == json.Unmarshal(data, done) && isTargetVector(target, done.Provider, done.Model) && !done.UpdatedAt.Before(asset.UpdatedAt) {
+			return done, nil
+		}
+	}
+
+	ret = &AssetVector{}
+	*ret = *asset
+	ret.Chunks = nil
+	for _, chunk := range asset.Chunks {
+		embedding, vecErr := service.VectorizeText(chunk.Content)
+		if nil != vecErr {
+			return nil, vecErr
+		}
+		reembedded := *chunk
+		reembedded.Vector = embedding
+		ret.Chunks = append(ret.Chunks, &reembedded)
+	}
+	if 1 > len(ret.Chunks) {
+		return nil, errors.New("asset has no chunks")
+	}
+
+	ret.Vector = ret.Chunks[0].Vector
+	ret.Provider, ret.Model, ret.Dimension = target.Provider, target.Model, len(ret.Vector)
+	ret.UpdatedAt = time.Now()
+	ret.Metadata = map[string]interface{}{}
+	for k, v := range asset.Metadata {
+		ret.Metadata[k] = v
+	}
+	ret.Metadata["vectorDim"] = ret.Dimension
+
+	data, err := json.MarshalIndent(ret, "", "  ")
+	if nil != err {
+		return
+	}
+	err = os.WriteFile(pendingPath, data, 0644)
+	return
+}
+
+// cutOverEmbeddingIndex 用待切换文件中的新向量替换旧向量，并把索引切换到目标模型
+func cutOverEmbeddingIndex(target EmbeddingModelInfo, dimension int, staleAssets []*AssetVector) (err error) {
+	index := currentEmbeddingIndex(GetDefaultWorkspaceContext())
+	if nil == index || !isTargetVector(target, index.Provider, index.Model) {
+		return errors.New("embedding config changed while re-embedding, start the job again")
+	}
+	index.Dimension = dimension
+
+	pending, err := loadPendingBlockVectors()
+	if nil != err {
+		return
+	}
+	blockVectorsLock.Lock()
+	vectors, err := loadBlockVectors()
+	if nil != err {
+		blockVectorsLock.Unlock()
+		return
+	}
+	for id, vector := range pending {
+		// 任务运行期间重新向量化过的块已经是新模型生成的，保留最新的结果
+		if current := vectors[id]; nil != current && !isTargetVector(target, current.Provider, current.Model) {
+			vectors[id] = vector
+		}
+	}
+	data, err := json.MarshalIndent(vectors, "", "  ")
+	if nil == err {
+		err = os.WriteFile(filepath.Join(util.DataDir, "block_vectors.json"), data, 0644)
+	}
+	blockVectorsLock.Unlock()
+	if nil != err {
+		return
+	}
+	os.Remove(getPendingBlockVectorsPath())
+
+	for _, asset := range staleAssets {
+		pendingPath := getPendingVectorFilePath(asset.AssetPath)
+		if current, loadErr := loadAssetVector(asset.AssetPath); nil == loadErr && isTargetVector(target, current.Provider, current.Model) {
+			os.Remove(pendingPath)
+			continue
+		}
+		if renameErr := os.Rename(pendingPath, getVectorFilePath(asset.AssetPath)); nil != renameErr {
+			logging.LogWarnf("cut over asset vectors [%s] failed: %s", asset.AssetPath, renameErr)
+		}
+	}
+
+	embeddingIndexLock.Lock()
+	Conf.AI.EmbeddingIndex = index
+	Conf.Save()
+	embeddingIndexLock.Unlock()
+	invalidateDocVectorsCache()
+	logging.LogInfof("embedding index switched to [%s/%s], dimension [%d]", index.Provider, index.Model, index.Dimension)
+	return
+}
+
+func updateReembedProgress(current string, success bool) {
+	reembedProgressLock.Lock()
+	defer reembedProgressLock.Unlock()
+
+	reembedProgress.ProcessedFiles++
+	if success {
+		reembedProgress.SuccessCount++
+	} else {
+		reembedProgress.FailedCount++
+	}
+	reembedProgress.CurrentFile = current
+	reembedProgress.LastUpdateTime = time.Now()
+}
+
+func setReembedPhase(phase string) {
+	reembedProgressLock.Lock()
+	defer reembedProgressLock.Unlock()
+	reembedProgress.Phase = phase
+	reembedProgress.LastUpdateTime = time.Now()
+}
+
+func finishReembedJob(phase, errMsg string) {
+	reembedProgressLock.Lock()
+	defer reembedProgressLock.Unlock()
+	reembedProgress.IsRunning = false
+	reembedProgress.Phase = phase
+	reembedProgress.Error = errMsg
+	reembedProgress.CurrentFile = ""
+	reembedProgress.LastUpdateTime = time.Now()
+	if "" != errMsg {
+		logging.LogErrorf("re-embedding job %s: %s", phase, errMsg)
+	}
+}
+
+func getPendingBlockVectorsPath() string {
+	return filepath.Join(util.DataDir, "block_vectors.pending.json")
+}
+
+// getPendingVectorFilePath 资源文件重新向量化结果的待切换文件，例如 doc.pdf.vectors.pending.json
+func getPendingVectorFilePath(assetPath string) string {
+	return assetPath + ".vectors.pending.json"
+}
+
+func loadPendingBlockVectors() (ret map[string]*BlockVector, err error) {
+	ret = map[string]*BlockVector{}
+	data, err := os.ReadFile(getPendingBlockVectorsPath())
+	if nil != err {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	err = json.Unmarshal(data, &ret)
+	return
+}
+
+func savePendingBlockVectors(vectors map[string]*BlockVector) error {
+	data, err := json.MarshalIndent(vectors, "", "  ")
+	if nil != err {
+		return err
+	}
+	return os.WriteFile(getPendingBlockVectorsPath(), data, 0644)
+}
@@ -0,0 +1,275 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/88250/gulu"
+	"github.com/88250/lute/ast"
+	"github.com/88250/lute/parse"
+	"github.com/sashabaranov/go-openai"
+	"github.com/siyuan-note/siyuan/kernel/sql"
+	"github.com/siyuan-note/siyuan/kernel/treenode"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// GeneratedFlashcard 由 AI 生成的问答闪卡
+type GeneratedFlashcard struct {
+	Question  string `json:"question"`
+	Answer    string `json:"answer"`
+	Duplicate bool   `json:"duplicate"`         // 和卡包中已有的闪卡或本次生成的其他闪卡重复，重复的闪卡不会创建
+	BlockID   string `json:"blockID,omitempty"` // 创建的闪卡块 ID，预览时为空
+}
+
+const (
+	defaultGenerateFlashcardLimit = 10
+	maxGenerateFlashcardLimit     = 50
+	maxFlashcardSourceLen         = 12000
+)
+
+// GenerateFlashcards 使用 AI 从文档或标题下的内容生成问答闪卡。
+// 闪卡以超级块的形式追加到 parentID 下（第一个段落为问题，第二个段落为答案），并通过 addFlashcards 事务加入卡包；
+// parentID 为空时追加到来源所在的文档末尾。preview 为 true 时只返回生成结果，不写入任何数据
+func GenerateFlashcards(ctx *WorkspaceContext, sourceID, deckID, parentID string, limit int, preview bool) (ret []*GeneratedFlashcard, err error) {
+	if !isOpenAIAPIEnabled(ctx) {
+		return nil, errors.New("AI not enabled")
+	}
+	if !ast.IsNodeIDPattern(sourceID) {
+		return nil, errors.New("invalid source id")
+	}
+	deckLock.Lock()
+	deck := Decks[deckID]
+	deckLock.Unlock()
+	if nil == deck {
+		return nil, fmt.Errorf("deck [%s] not found", deckID)
+	}
+	if 1 > limit {
+		limit = defaultGenerateFlashcardLimit
+	}
+	if maxGenerateFlashcardLimit < limit {
+		limit = maxGenerateFlashcardLimit
+	}
+
+	source, rootID, err := getFlashcardSourceMd(ctx, sourceID)
+	if nil != err {
+		return
+	}
+	if "" == strings.TrimSpace(source) {
+		return nil, errors.New("source content is empty")
+	}
+	if "" == parentID {
+		parentID = rootID
+	}
+	if !ast.IsNodeIDPattern(parentID) {
+		return nil, errors.New("invalid parent id")
+	}
+	if err = CheckAIBudget(ctx); nil != err {
+		return
+	}
+
+	existing := getDeckCardContents(deck.GetBlockIDs())
+	prompt := fmt.Sprintf("请根据以下笔记内容生成最多 %d 组用于间隔重复记忆的问答闪卡。\n"+
+		"要求：每个问题只考察一个知识点，答案简洁准确且能从笔记中找到依据，不要生成和笔记无关的问题。\n"+
+		"只输出 JSON 数组，不要输出其他内容，格式为 [{\"question\": \"问题\", \"answer\": \"答案\"}]。", limit)
+	if 0 < len(existing) {
+		// 提示模型避开已有的闪卡，后面仍会再做一次去重
+		var samples []string
+		for i, content := range existing {
+			if 30 <= i {
+				break
+			}
+			samples = append(samples, "- "+gulu.Str.SubStr(content, 64))
+		}
+		prompt += "\n卡包中已经存在以下闪卡，请不要重复：\n" + strings.Join(samples, "\n")
+	}
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: prompt},
+		{Role: openai.ChatMessageRoleUser, Content: truncateAtSentence(source, maxFlashcardSourceLen)},
+	}
+	completion, err := createChatCompletionWithFeature(ctx, AIFeatureFlashcard, messages)
+	if nil != err {
+		return
+	}
+
+	if ret, err = parseGeneratedFlashcards(completion); nil != err {
+		return
+	}
+	if len(ret) > limit {
+		ret = ret[:limit]
+	}
+	markDuplicatedFlashcards(ret, existing)
+	if preview {
+		return
+	}
+
+	// 直接构建节点而不是拼接 Markdown，避免模型输出中的 }}} 和 {: ...} 破坏超级块结构或者注入属性
+	var blockIDs []string
+	subTree := &parse.Tree{Root: &ast.Node{Type: ast.NodeDocument}}
+	for _, card := range ret {
+		if card.Duplicate {
+			continue
+		}
+		card.BlockID = ast.NewNodeID()
+		blockIDs = append(blockIDs, card.BlockID)
+		subTree.Root.AppendChild(newFlashcardNode(card))
+	}
+	if 1 > len(blockIDs) {
+		return
+	}
+
+	luteEngine := util.NewLute()
+	dom := luteEngine.Tree2BlockDOM(subTree, luteEngine.RenderOptions)
+	transactions := []*Transaction{
+		newAppendInsertTransaction(luteEngine, dom, parentID),
+		{
+			DoOperations:   []*Operation{{Action: "addFlashcards", DeckID: deckID, BlockIDs: blockIDs}},
+			UndoOperations: []*Operation{{Action: "removeFlashcards", DeckID: deckID, BlockIDs: blockIDs}},
+		},
+	}
+	PerformTransactionsWithContext(ctx, &transactions)
+	FlushTxQueue()
+
+	evt := util.NewCmdResult("transactions", 0, util.PushModeBroadcast)
+	evt.Data = transactions[:1]
+	util.PushEvent(evt)
+	return
+}
+
+// newFlashcardNode 构建闪卡的超级块，问题和答案作为纯文本段落并排放置
+func newFlashcardNode(card *GeneratedFlashcard) (ret *ast.Node) {
+	ret = &ast.Node{ID: card.BlockID, Type: ast.NodeSuperBlock}
+	ret.SetIALAttr("id", card.BlockID)
+	ret.SetIALAttr("updated", card.BlockID[:14])
+	ret.AppendChild(&ast.Node{Type: ast.NodeSuperBlockOpenMarker})
+	ret.AppendChild(&ast.Node{Type: ast.NodeSuperBlockLayoutMarker, Tokens: []byte("row")})
+	for _, text := range []string{card.Question, card.Answer} {
+		paragraph := treenode.NewParagraph("")
+		paragraph.AppendChild(&ast.Node{Type: ast.NodeText, Tokens: []byte(strings.TrimSpace(text))})
+		ret.AppendChild(paragraph)
+	}
+	ret.AppendChild(&ast.Node{Type: ast.NodeSuperBlockCloseMarker})
+	return
+}
+
+// getFlashcardSourceMd 获取生成闪卡使用的 Markdown：文档返回全文，标题返回标题及其下的所有块
+func getFlashcardSourceMd(ctx *WorkspaceContext, id string) (ret, rootID string, err error) {
+	tree, err := LoadTreeByBlockIDWithContext(ctx, id)
+	if nil != err {
+		return
+	}
+	node := treenode.GetNodeInTree(tree, id)
+	if nil == node {
+		return "", "", ErrBlockNotFound
+	}
+
+	rootID = tree.ID
+	luteEngine := util.NewLute()
+	switch node.Type {
+	case ast.NodeDocument:
+		ret = "# " + tree.Root.IALAttr("title") + "\n\n" + treenode.ExportNodeStdMd(node, luteEngine)
+	case ast.NodeHeading:
+		buf := strings.Builder{}
+		buf.WriteString(treenode.ExportNodeStdMd(node, luteEngine))
+		for _, child := range treenode.HeadingChildren(node) {
+			buf.WriteString("\n\n")
+			buf.WriteString(treenode.ExportNodeStdMd(child, luteEngine))
+		}
+		ret = buf.String()
+	default:
+		return "", "", errors.New("source must be a document or a heading")
+	}
+	return
+}
+
+// getDeckCardContents 获取卡包中已有闪卡块的文本内容
+func getDeckCardContents(blockIDs []string) (ret []string) {
+	for _, block := range sql.GetBlocks(blockIDs) {
+		if nil == block {
+			continue
+		}
+		if content := strings.TrimSpace(block.Content); "" != content {
+			ret = append(ret, content)
+		}
+	}
+	return
+}
+
+// parseGeneratedFlashcards 解析模型返回的 JSON 数组，兼容代码块包裹和前后附带说明文字的情况
+func parseGeneratedFlashcards(completion string) (ret []*GeneratedFlashcard, err error) {
+	start := strings.Index(completion, "[")
+	end := strings.LastIndex(completion, "]")
+	if 0 > start || end <= start {
+		return nil, errors.New("AI response does not contain flashcards")
+	}
+
+	var cards []*GeneratedFlashcard
+	if err = gulu.JSON.UnmarshalJSON([]byte(completion[start:end+1]), &cards); nil != err {
+		return nil, fmt.Errorf("parse AI response failed: %s", err)
+	}
+	for _, card := range cards {
+		if nil == card {
+			continue
+		}
+		card.Question = strings.TrimSpace(card.Question)
+		card.Answer = strings.TrimSpace(card.Answer)
+		card.Duplicate, card.BlockID = false, ""
+		if "" == card.Question || "" == card.Answer {
+			continue
+		}
+		ret = append(ret, card)
+	}
+	return
+}
+
+// markDuplicatedFlashcards 标记和已有闪卡或本批次前面的闪卡问题相同的闪卡。
+// 已有闪卡块的内容以问题开头，因此比较时判断已有内容是否以问题开头
+func markDuplicatedFlashcards(cards []*GeneratedFlashcard, existing []string) {
+	var existingKeys []string
+	for _, content := range existing {
+		existingKeys = append(existingKeys, flashcardDedupKey(content))
+	}
+
+	seen := map[string]bool{}
+	for _, card := range cards {
+		key := flashcardDedupKey(card.Question)
+		if "" == key || seen[key] {
+			card.Duplicate = true
+			continue
+		}
+		seen[key] = true
+		for _, existingKey := range existingKeys {
+			if strings.HasPrefix(existingKey, key) {
+				card.Duplicate = true
+				break
+			}
+		}
+	}
+}
+
+// flashcardDedupKey 去掉空白和标点并转为小写，忽略问题在格式上的差异
+func flashcardDedupKey(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, text)
+}
@@ -0,0 +1,448 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/88250/gulu"
+	"github.com/88250/lute/ast"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+	"github.com/siyuan-note/siyuan/kernel/sql"
+	"github.com/siyuan-note/siyuan/kernel/treenode"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// Model Context Protocol 服务端，将工作空间以资源和工具的形式暴露给外部 AI 客户端
+// 协议说明 https://modelcontextprotocol.io/specification
+
+const (
+	MCPProtocolVersion = "2025-03-26"
+
+	mcpErrParse          = -32700
+	mcpErrInvalidRequest = -32600
+	mcpErrMethodNotFound = -32601
+	mcpErrInvalidParams  = -32602
+	mcpErrInternal       = -32603
+)
+
+// mcpTools 通过 MCP 暴露的工具，复用智能体工具实现
+var mcpTools = []string{"search_fulltext", "query_sql", "semantic_search", "get_block_kramdown", "append_block"}
+
+// MCPRequest JSON-RPC 请求
+type MCPRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// MCPResponse JSON-RPC 响应
+type MCPResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *MCPError       `json:"error,omitempty"`
+}
+
+// MCPError JSON-RPC 错误
+type MCPError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// HandleMCPMessage 处理一条 MCP 消息（单个请求或批量请求），返回需要回写的响应，通知消息没有响应
+func HandleMCPMessage(ctx *WorkspaceContext, data []byte) (ret []byte) {
+	data = []byte(strings.TrimSpace(string(data)))
+	if 1 > len(data) {
+		return
+	}
+
+	if '[' == data[0] {
+		var reqs []*MCPRequest
+		if err := json.Unmarshal(data, &reqs); err != nil {
+			ret, _ = json.Marshal(newMCPErrResponse(nil, mcpErrParse, err.Error()))
+			return
+		}
+		var resps []*MCPResponse
+		for _, req := range reqs {
+			if resp := handleMCPRequest(ctx, req); nil != resp {
+				resps = append(resps, resp)
+			}
+		}
+		if 0 < len(resps) {
+			ret, _ = json.Marshal(resps)
+		}
+		return
+	}
+
+	req := &MCPRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		ret, _ = json.Marshal(newMCPErrResponse(nil, mcpErrParse, err.Error()))
+		return
+	}
+	if resp := handleMCPRequest(ctx, req); nil != resp {
+		ret, _ = json.Marshal(resp)
+	}
+	return
+}
+
+// IsMCPInitializeMessage 判断消息（单个请求或批量请求）中是否包含 initialize 请求
+func IsMCPInitializeMessage(data []byte) bool {
+	data = []byte(strings.TrimSpace(string(data)))
+	if 1 > len(data) {
+		return false
+	}
+
+	var reqs []*MCPRequest
+	if '[' == data[0] {
+		if err := json.Unmarshal(data, &reqs); err != nil {
+			return false
+		}
+	} else {
+		req := &MCPRequest{}
+		if err := json.Unmarshal(data, req); err != nil {
+			return false
+		}
+		reqs = append(reqs, req)
+	}
+	for _, req := range reqs {
+		if nil != req && "initialize" == req.Method {
+			return true
+		}
+	}
+	return false
+}
+
+func handleMCPRequest(ctx *WorkspaceContext, req *MCPRequest) (ret *MCPResponse) {
+	defer logging.Recover()
+
+	isNotification := 1 > len(req.ID)
+	if "2.0" != req.JSONRPC {
+		if isNotification {
+			return nil
+		}
+		return newMCPErrResponse(req.ID, mcpErrInvalidRequest, "jsonrpc must be 2.0")
+	}
+
+	var result interface{}
+	var err error
+	var code int
+	switch req.Method {
+	case "initialize":
+		result = map[string]interface{}{
+			"protocolVersion": MCPProtocolVersion,
+			"capabilities": map[string]interface{}{
+				"tools":     map[string]interface{}{"listChanged": false},
+				"resources": map[string]interface{}{"listChanged": false, "subscribe": false},
+			},
+			"serverInfo": map[string]interface{}{"name": "siyuan", "version": util.Ver},
+		}
+	case "ping":
+		result = map[string]interface{}{}
+	case "tools/list":
+		result = map[string]interface{}{"tools": mcpListTools()}
+	case "tools/call":
+		result, code, err = mcpCallTool(ctx, req.Params)
+	case "resources/list":
+		result, err = mcpListResources(ctx)
+	case "resources/templates/list":
+		result = map[string]interface{}{"resourceTemplates": []map[string]interface{}{
+			{"uriTemplate": "siyuan://notebooks/{notebook}", "name": "Notebook documents", "mimeType": "application/json"},
+			{"uriTemplate": "siyuan://blocks/{id}", "name": "Document or block", "mimeType": "text/markdown"},
+		}}
+	case "resources/read":
+		result, code, err = mcpReadResource(ctx, req.Params)
+	default:
+		if strings.HasPrefix(req.Method, "notifications/") {
+			return nil
+		}
+		code, err = mcpErrMethodNotFound, fmt.Errorf("method [%s] not found", req.Method)
+	}
+
+	if isNotification {
+		return nil
+	}
+	if nil != err {
+		if 0 == code {
+			code = mcpErrInternal
+		}
+		return newMCPErrResponse(req.ID, code, err.Error())
+	}
+	return &MCPResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+func newMCPErrResponse(id json.RawMessage, code int, msg string) *MCPResponse {
+	if 1 > len(id) {
+		id = json.RawMessage("null")
+	}
+	return &MCPResponse{JSONRPC: "2.0", ID: id, Error: &MCPError{Code: code, Message: msg}}
+}
+
+func mcpListTools() (ret []map[string]interface{}) {
+	ret = []map[string]interface{}{}
+	for _, name := range mcpTools {
+		tool := getAgentTool(name)
+		if nil == tool || conf.AgentToolPermDeny == agentToolPermission(tool) {
+			continue
+		}
+		if tool.Write && util.ReadOnly {
+			continue
+		}
+		ret = append(ret, map[string]interface{}{
+			"name":        tool.Name,
+			"description": tool.Description,
+			"inputSchema": tool.Parameters,
+			"annotations": map[string]interface{}{"readOnlyHint": !tool.Write},
+		})
+	}
+	return
+}
+
+func mcpCallTool(ctx *WorkspaceContext, params json.RawMessage) (ret interface{}, code int, err error) {
+	var arg struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	if err = json.Unmarshal(params, &arg); err != nil {
+		return nil, mcpErrInvalidParams, err
+	}
+
+	var tool *AgentTool
+	if gulu.Str.Contains(arg.Name, mcpTools) {
+		tool = getAgentTool(arg.Name)
+	}
+	if nil == tool || conf.AgentToolPermDeny == agentToolPermission(tool) || (tool.Write && util.ReadOnly) {
+		return nil, mcpErrInvalidParams, fmt.Errorf("tool [%s] not found", arg.Name)
+	}
+	if nil == arg.Arguments {
+		arg.Arguments = map[string]interface{}{}
+	}
+
+	if tool.Write && conf.AgentToolPermConfirm == agentToolPermission(tool) {
+		// 需要确认的写操作不直接执行，和智能体一样生成待确认的操作，由用户在思源中确认后执行
+		proposal := &AgentProposal{ID: ast.NewNodeID(), Tool: tool.Name, Args: arg.Arguments}
+		result := map[string]interface{}{
+			"status":   "pending_confirmation",
+			"proposal": proposal,
+			"note":     "The operation requires user confirmation in SiYuan and has not been applied.",
+		}
+		ret = map[string]interface{}{
+			"content": []map[string]interface{}{{"type": "text", "text": agentToolContent(result, nil)}},
+			"isError": false,
+		}
+		return
+	}
+
+	// 工具执行失败按 MCP 约定放在结果里返回，让客户端的模型能够看到错误并自行调整
+	result, callErr := tool.call(ctx, arg.Arguments)
+	if txs, ok := result.([]*Transaction); ok {
+		var ids []string
+		for _, tx := range txs {
+			for _, op := range tx.DoOperations {
+				ids = append(ids, op.ID)
+			}
+		}
+		result = map[string]interface{}{"status": "done", "ids": ids}
+	}
+	ret = map[string]interface{}{
+		"content": []map[string]interface{}{{"type": "text", "text": agentToolContent(result, callErr)}},
+		"isError": nil != callErr,
+	}
+	return
+}
+
+func mcpListResources(ctx *WorkspaceContext) (ret interface{}, err error) {
+	resources := []map[string]interface{}{}
+	for _, box := range Conf.GetOpenedBoxesWithContext(ctx) {
+		resources = append(resources, map[string]interface{}{
+			"uri":         "siyuan://notebooks/" + box.ID,
+			"name":        box.Name,
+			"description": "Notebook " + box.Name,
+			"mimeType":    "application/json",
+		})
+	}
+
+	// 文档数量可能很多，这里只列出最近更新的文档，其余文档通过资源模板或者工具访问
+	docs := sql.SelectBlocksRawStmtWithContext(ctx, "SELECT * FROM blocks WHERE type = 'd' ORDER BY updated DESC", 1, 128)
+	for _, doc := range docs {
+		resources = append(resources, map[string]interface{}{
+			"uri":         "siyuan://blocks/" + doc.ID,
+			"name":        doc.Content,
+			"description": doc.HPath,
+			"mimeType":    "text/markdown",
+		})
+	}
+	return map[string]interface{}{"resources": resources}, nil
+}
+
+func mcpReadResource(ctx *WorkspaceContext, params json.RawMessage) (ret interface{}, code int, err error) {
+	var arg struct {
+		URI string `json:"uri"`
+	}
+	if err = json.Unmarshal(params, &arg); err != nil {
+		return nil, mcpErrInvalidParams, err
+	}
+
+	var text, mimeType string
+	switch {
+	case strings.HasPrefix(arg.URI, "siyuan://notebooks/"):
+		boxID := strings.TrimPrefix(arg.URI, "siyuan://notebooks/")
+		p := "/"
+		if idx := strings.Index(boxID, "/"); 0 < idx {
+			boxID, p = boxID[:idx], boxID[idx:]
+		}
+		docs, listErr := agentListDocs(ctx, map[string]interface{}{"notebook": boxID, "path": p})
+		if nil != listErr {
+			return nil, mcpErrInvalidParams, listErr
+		}
+		data, _ := gulu.JSON.MarshalJSON(docs)
+		text, mimeType = string(data), "application/json"
+	case strings.HasPrefix(arg.URI, "siyuan://blocks/"):
+		id := strings.TrimPrefix(arg.URI, "siyuan://blocks/")
+		if !ast.IsNodeIDPattern(id) {
+			return nil, mcpErrInvalidParams, errors.New("invalid block id")
+		}
+		text, err = exportMCPBlockMd(ctx, id)
+		if nil != err {
+			return nil, mcpErrInvalidParams, err
+		}
+		mimeType = "text/markdown"
+	default:
+		return nil, mcpErrInvalidParams, fmt.Errorf("resource [%s] not found", arg.URI)
+	}
+
+	ret = map[string]interface{}{
+		"contents": []map[string]interface{}{{"uri": arg.URI, "mimeType": mimeType, "text": text}},
+	}
+	return
+}
+
+func exportMCPBlockMd(ctx *WorkspaceContext, id string) (ret string, err error) {
+	tree, err := LoadTreeByBlockIDWithContext(ctx, id)
+	if nil != err {
+		return
+	}
+	node := treenode.GetNodeInTree(tree, id)
+	if nil == node {
+		return "", ErrBlockNotFound
+	}
+
+	luteEngine := util.NewLute()
+	if ast.NodeDocument == node.Type {
+		ret = "# " + tree.Root.IALAttr("title") + "\n\n" + treenode.ExportNodeStdMd(node, luteEngine)
+		return
+	}
+	ret = treenode.ExportNodeStdMd(node, luteEngine)
+	return
+}
+
+var mcpStdout *os.File
+
+// PrepareMCPStdio 在设置了 SIYUAN_MCP_STDIO 环境变量时启用 stdio 传输。
+// 内核日志默认也会输出到 stdout，这里需要在内核启动前把 stdout 让给 MCP，日志改为输出到 stderr
+func PrepareMCPStdio() bool {
+	if "true" != os.Getenv("SIYUAN_MCP_STDIO") {
+		return false
+	}
+
+	mcpStdout = os.Stdout
+	os.Stdout = os.Stderr
+	return true
+}
+
+// ServeMCPStdio 通过 stdio 提供 MCP 服务，每行一条 JSON-RPC 消息
+func ServeMCPStdio() {
+	if nil == mcpStdout {
+		return
+	}
+
+	ctx, err := mcpStdioWorkspaceContext()
+	if nil != err {
+		logging.LogErrorf("start MCP stdio server failed: %s", err)
+		return
+	}
+
+	logging.LogInfof("MCP stdio server started")
+	serveMCPStream(ctx, os.Stdin, mcpStdout)
+	logging.LogInfof("MCP stdio server stopped")
+}
+
+func serveMCPStream(ctx *WorkspaceContext, in io.Reader, out io.Writer) {
+	lock := sync.Mutex{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if 1 > len(strings.TrimSpace(string(line))) {
+			continue
+		}
+
+		resp := HandleMCPMessage(ctx, line)
+		if 1 > len(resp) {
+			continue
+		}
+		lock.Lock()
+		out.Write(append(resp, '\n'))
+		lock.Unlock()
+	}
+	if err := scanner.Err(); nil != err {
+		logging.LogErrorf("read MCP stdio failed: %s", err)
+	}
+}
+
+// mcpStdioWorkspaceContext 在 Web 多用户模式下通过 SIYUAN_MCP_TOKEN 确定用户，非 Web 模式使用默认工作空间
+func mcpStdioWorkspaceContext() (ret *WorkspaceContext, err error) {
+	if "true" != os.Getenv("SIYUAN_WEB_MODE") {
+		return GetDefaultWorkspaceContext(), nil
+	}
+
+	token := os.Getenv("SIYUAN_MCP_TOKEN")
+	if "" == token {
+		return nil, errors.New("SIYUAN_MCP_TOKEN is required in web mode")
+	}
+	user, err := GetMCPUserByToken(token)
+	if nil != err {
+		return
+	}
+	return NewWorkspaceContextWithUser(user.Workspace, user.ID, user.Username), nil
+}
+
+// GetMCPUserByToken 通过 Web 用户令牌获取用户，支持本地令牌和统一认证令牌
+func GetMCPUserByToken(token string) (ret *User, err error) {
+	if authService := GetWebAuthService(); nil != authService {
+		if ret, err = authService.ValidateToken(token); nil == err && nil != ret {
+			return
+		}
+	}
+
+	if unifiedService := GetUnifiedAuthService(); nil != unifiedService {
+		unifiedUser, verifyErr := unifiedService.VerifyToken(token)
+		if nil == verifyErr && nil != unifiedUser {
+			return unifiedService.EnsureLocalUser(unifiedUser)
+		}
+	}
+	return nil, errors.New("invalid token")
+}
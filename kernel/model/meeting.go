@@ -3,95 +3,36 @@ package model
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"regexp"
 	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/sashabaranov/go-openai"
 	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/conf"
 )
 
-// ASR 配置
-var asrEndpoint = "ws://jason.cheman.top:10096/"
-
-// LLM 配置
-var llmEndpoint = "http://jason.cheman.top:8001/v1"
-var llmAPIKey = "vllm-token"
-var llmModelName = "tclf90/Qwen3-32B-GPTQ-Int4"
-var llmTemperature = 0.3
-var llmMaxTokens = 200
-
-func init() {
-	// 加载 ASR 配置
-	asrConfigPath := "/root/code/unified-settings-service/config/asr-config.json"
-	asrData, err := os.ReadFile(asrConfigPath)
-	if err == nil {
-		var asrConfig struct {
-			Endpoint string `json:"endpoint"`
-		}
-		if json.Unmarshal(asrData, &asrConfig) == nil && asrConfig.Endpoint != "" {
-			// 确保 endpoint 有正确的协议前缀
-			rawEndpoint := asrConfig.Endpoint
-			if strings.HasPrefix(rawEndpoint, "http://") {
-				asrEndpoint = "ws://" + strings.TrimPrefix(rawEndpoint, "http://")
-			} else if strings.HasPrefix(rawEndpoint, "https://") {
-				asrEndpoint = "wss://" + strings.TrimPrefix(rawEndpoint, "https://")
-			} else {
-				asrEndpoint = "ws://" + rawEndpoint
-			}
-			if !strings.HasSuffix(asrEndpoint, "/") {
-				asrEndpoint += "/"
-			}
-			logging.LogInfof("ASR 配置已加载: %s", asrEndpoint)
-		}
-	} else {
-		logging.LogWarnf("ASR 配置文件不存在，使用默认配置: %s", asrConfigPath)
-	}
-
-	// 加载 LLM 默认模型配置
-	llmConfigPath := "/root/code/unified-settings-service/config/default-models.json"
-	llmData, err := os.ReadFile(llmConfigPath)
-	if err == nil {
-		var models map[string]struct {
-			BaseURL      string  `json:"base_url"`
-			APIKey       string  `json:"api_key"`
-			ModelName    string  `json:"model_name"`
-			Temperature  float64 `json:"temperature"`
-			MaxTokens    int     `json:"max_tokens"`
-		}
-		if json.Unmarshal(llmData, &models) == nil {
-			// 优先使用 meeting 专用模型，其次是 siyuan 模型
-			var modelKey string
-			if model, ok := models["builtin_free_meeting"]; ok {
-				modelKey = "builtin_free_meeting"
-				llmEndpoint = model.BaseURL
-				llmAPIKey = model.APIKey
-				llmModelName = model.ModelName
-				llmTemperature = model.Temperature
-				llmMaxTokens = model.MaxTokens
-			} else if model, ok := models["builtin_free_siyuan"]; ok {
-				modelKey = "builtin_free_siyuan"
-				llmEndpoint = model.BaseURL
-				llmAPIKey = model.APIKey
-				llmModelName = model.ModelName
-				llmTemperature = model.Temperature
-				llmMaxTokens = model.MaxTokens
-			}
-			if modelKey != "" {
-				if !strings.HasSuffix(llmEndpoint, "/") {
-					llmEndpoint += "/"
-				}
-				logging.LogInfof("LLM 配置已加载 (%s): %s %s (temperature=%.1f, max_tokens=%d)",
-					modelKey, llmEndpoint, llmModelName, llmTemperature, llmMaxTokens)
-			}
-		}
-	} else {
-		logging.LogWarnf("LLM 配置文件不存在，使用默认配置: %s", llmConfigPath)
+const (
+	defaultMeetingLLMTemperature = 0.3
+	defaultMeetingLLMMaxTokens   = 200
+)
+
+// getMeetingLLMEndpoint 获取会议纪要使用的模型，未配置会议纪要路由时使用对话路由
+func getMeetingLLMEndpoint(ctx *WorkspaceContext) (ret *AIEndpoint, err error) {
+	if ret = ResolveAIEndpoint(ctx, conf.AITaskMeeting); nil == ret {
+		return nil, errors.New("no AI provider is routed for meeting notes")
+	}
+	if 0 >= ret.Temperature {
+		ret.Temperature = defaultMeetingLLMTemperature
 	}
+	if 0 >= ret.MaxTokens {
+		ret.MaxTokens = defaultMeetingLLMMaxTokens
+	}
+	return
 }
 
 func findIncrementalContent(oldText, newText string) string {
@@ -355,7 +296,7 @@ type TranscribeAudioResponse struct {
 }
 
 // TranscribeAudio 转录音频并生成摘要
-func (s *MeetingService) TranscribeAudio(audioData []byte) (*TranscribeAudioResponse, error) {
+func (s *MeetingService) TranscribeAudio(ctx *WorkspaceContext, audioData []byte) (*TranscribeAudioResponse, error) {
 	if len(audioData) == 0 {
 		return nil, fmt.Errorf("audio data is empty")
 	}
@@ -363,7 +304,7 @@ func (s *MeetingService) TranscribeAudio(audioData []byte) (*TranscribeAudioResp
 	logging.LogDebugf("TranscribeAudio: received audio data, size: %d bytes", len(audioData))
 
 	// 1. 调用 ASR 服务 (假设使用本地 FunASR REST API)
-	transcription, err := s.callASR(audioData)
+	transcription, err := s.callASR(ctx, audioData)
 	if err != nil {
 		logging.LogErrorf("ASR failed: %v", err)
 		return nil, err
@@ -376,7 +317,7 @@ func (s *MeetingService) TranscribeAudio(audioData []byte) (*TranscribeAudioResp
 	// 2. 调用 LLM 生成摘要
 	summary := ""
 	if transcription != "" {
-		summary, err = s.GenerateSummary(transcription)
+		summary, err = s.GenerateSummary(ctx, transcription)
 		if err != nil {
 			logging.LogWarnf("Summary generation failed: %v", err)
 		}
@@ -407,228 +348,48 @@ func cleanASRTags(text string) string {
 	return cleaned
 }
 
-// callASR 调用 ASR 服务 (WebSocket 模式)
-func (s *MeetingService) callASR(audioData []byte) (string, error) {
-	// 补全末尾斜杠，有些服务器对路径很敏感
-	asrURL := asrEndpoint
-
-	// 1. 去除 WAV 头部 (44 bytes)，只发送纯 PCM 数据
-	// 如果不去除，头部信息会被识别为刺耳噪音，导致开头乱码或识别错误
-	pcmData := audioData
-	if len(audioData) > 44 && string(audioData[:4]) == "RIFF" {
-		// 解析 WAV 头部信息用于调试
-		if len(audioData) >= 44 {
-			// WAV 头部格式：
-			// 0-3: "RIFF"
-			// 4-7: file size - 8
-			// 8-11: "WAVE"
-			// 12-15: "fmt "
-			// 16-19: fmt chunk size (通常是 16)
-			// 20-21: audio format (1 = PCM)
-			// 22-23: num channels
-			// 24-27: sample rate
-			// 28-31: byte rate
-			// 32-33: block align
-			// 34-35: bits per sample
-			audioFormat := int(audioData[20]) | int(audioData[21])<<8
-			numChannels := int(audioData[22]) | int(audioData[23])<<8
-			sampleRate := int(audioData[24]) | int(audioData[25])<<8 | int(audioData[26])<<16 | int(audioData[27])<<24
-			bitsPerSample := int(audioData[34]) | int(audioData[35])<<8
-			logging.LogInfof("ASR: WAV header - format=%d, channels=%d, sampleRate=%d, bitsPerSample=%d", 
-				audioFormat, numChannels, sampleRate, bitsPerSample)
-		}
-		logging.LogDebugf("ASR: Detected WAV header, stripping first 44 bytes.")
-		pcmData = audioData[44:]
-	}
-
-	logging.LogDebugf("ASR: Connecting to %s, PCM data size: %d bytes", asrURL, len(pcmData))
-
-	dialer := websocket.DefaultDialer
-	dialer.HandshakeTimeout = 15 * time.Second
-
-	conn, resp, err := dialer.Dial(asrURL, nil)
+// callASR 调用语音识别后端，返回完整的转录文本
+func (s *MeetingService) callASR(ctx *WorkspaceContext, audioData []byte) (string, error) {
+	segments, err := s.transcribeSegments(ctx, audioData)
 	if err != nil {
-		status := "unknown"
-		if resp != nil {
-			status = resp.Status
-			body, _ := io.ReadAll(resp.Body)
-			logging.LogErrorf("ASR Handshake failed. Status: %s, Body: %s", status, string(body))
-		}
-		return "", fmt.Errorf("ASR WebSocket connection failed: %v (Status: %s)", err, status)
+		return "", err
 	}
-	defer conn.Close()
-
-	logging.LogDebugf("ASR: WebSocket connected successfully")
+	return joinSegmentTexts(segments), nil
+}
 
-	// 2. 发送开始配置 (根据文档使用 2pass 模式)
-	startConfig := map[string]interface{}{
-		"mode":           "2pass",
-		"chunk_size":     []int{5, 10, 5},
-		"chunk_interval": 10,
-		"wav_name":       "meeting",
-		"is_speaking":    true,
+// transcribeSegments 调用语音识别后端，返回带时间的片段。音频先解码为后端要求的 PCM 格式，
+// 较长的录音切分为相互重叠的分段并行识别后拼接，每个分段对应一个片段
+func (s *MeetingService) transcribeSegments(ctx *WorkspaceContext, audioData []byte) ([]*MeetingStreamSegment, error) {
+	backend, err := newASRBackend(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if err := conn.WriteJSON(startConfig); err != nil {
-		return "", fmt.Errorf("failed to send start config: %v", err)
+	format := backend.AudioFormat()
+	pcm, err := decodeAudio(audioData, format)
+	if err != nil {
+		return nil, err
 	}
 
-	// 准备收集结果
-	var fullTranscriptBuilder bytes.Buffer
-	// 暂存最新的流式中间结果，防止最后一句因未 finalize 而丢失
-	var latestPartialText string
-
-	// 用于同步发送端状态的通道
-	sendErrChan := make(chan error, 1)
-
-	// 3. 启动协程并发发送音频数据
-	go func() {
-		defer close(sendErrChan)
-
-		logging.LogDebugf("ASR: Start sending PCM data (%d bytes)...", len(pcmData))
-
-		// 直接批量发送，无需延迟
-		// 分块大小：使用较大的 chunk 以减少网络开销，但不超过 64KB
-		const chunkSize = 64000
-
-		for i := 0; i < len(pcmData); i += chunkSize {
-			end := i + chunkSize
-			if end > len(pcmData) {
-				end = len(pcmData)
-			}
-
-			if err := conn.WriteMessage(websocket.BinaryMessage, pcmData[i:end]); err != nil {
-				sendErrChan <- fmt.Errorf("failed to send audio chunk: %v", err)
-				return
-			}
-		}
-
-		logging.LogDebugf("ASR: Audio data sent, sending end signal...")
-
-		// 4. 发送结束信号 (is_speaking 为 false)
-		endConfig := map[string]interface{}{
-			"is_speaking": false,
-		}
-		if err := conn.WriteJSON(endConfig); err != nil {
-			sendErrChan <- fmt.Errorf("failed to send end signal: %v", err)
-			return
-		}
-		logging.LogDebugf("ASR: End signal sent.")
-	}()
-
-	// 5. 主协程循环读取识别结果
-	// 读取循环会在 socket 关闭或出错时退出
-
-	messageCount := 0
-	timeout := time.After(180 * time.Second) // 延长超时到180秒
-	logging.LogDebugf("ASR: Waiting for recognition results...")
-
-	for {
-		// 检查发送端是否有错误
-		select {
-		case err := <-sendErrChan:
-			if err != nil {
-				// 发送失败直接返回目前已识别的内容+错误
-				if latestPartialText != "" {
-					fullTranscriptBuilder.WriteString(latestPartialText)
-				}
-				return fullTranscriptBuilder.String(), err
-			}
-		case <-timeout:
-			logging.LogWarnf("ASR: Timeout waiting for result.")
-			// 超时返回当前结果
-			if latestPartialText != "" {
-				fullTranscriptBuilder.WriteString(latestPartialText)
-			}
-			return fullTranscriptBuilder.String(), nil
-		default:
-		}
-
-		// 设置读取超时，防止服务端假死
-		// conn.SetReadDeadline(time.Now().Add(10 * time.Second)) // Removed as per instruction
-		_, message, err := conn.ReadMessage()
-		if err != nil {
-			if websocket.IsCloseError(err, websocket.CloseNormalClosure) || err == io.EOF {
-				logging.LogDebugf("ASR connection closed normally.")
-			} else {
-				// 忽略 ReadDeadline 产生的超时错误，这在流式传输间隙是正常的吗？
-				// 不，对于 continuous streaming，read 应该 block 直到有数据。
-				// 但如果对方一直不发数据（比如 VAD silence），可能会超时。
-				// 这里我们需要区分是网络断了还是只是静音。
-				// 简单起见，如果超时且发送端还没结束，我们继续等待?
-				// 上面使用了 SetReadDeadline，如果超时会返回 error。
-				// 为了稳妥，这里如果只是读超时，我们不退出的逻辑比较复杂。
-				// 暂时去掉 SetReadDeadline，依赖外层总 timeout 和 socket close。
-				logging.LogDebugf("ASR connection read stop: %v", err)
-			}
-
-			// 连接关闭意味着转录结束，将最后未 finalize 的内容拼上去
-			if latestPartialText != "" {
-				logging.LogDebugf("ASR: Appending unfinalized tail: '%s'", latestPartialText)
-				fullTranscriptBuilder.WriteString(latestPartialText)
-			}
-			// 清理 ASR 返回的标签
-			rawText := fullTranscriptBuilder.String()
-			cleanedText := cleanASRTags(rawText)
-			logging.LogDebugf("ASR: Raw text length: %d, Cleaned text length: %d", len(rawText), len(cleanedText))
-			return cleanedText, nil
-		}
-
-		messageCount++
-
-		// 打印原始消息用于调试
-		logging.LogDebugf("ASR: Raw message #%d: %s", messageCount, string(message))
-
-		var result struct {
-			Text    string `json:"text"`
-			IsFinal bool   `json:"is_final"`
-			Mode    string `json:"mode"`
-		}
-		if err := json.Unmarshal(message, &result); err != nil {
-			logging.LogWarnf("Failed to parse ASR result: %v. Message: %s", err, string(message))
-			continue
-		}
-
-		// 2pass 模式下，2pass-offline 的结果是最终的高质量识别结果
-		// 需要收集这些结果，而不是等待 is_final=true 的空消息
-		if result.Mode == "2pass-offline" && result.Text != "" {
-			logging.LogDebugf("ASR: 2pass-offline result: '%s'", result.Text)
-			fullTranscriptBuilder.WriteString(result.Text)
-			latestPartialText = ""
-			
-			// 如果 is_final 为 true，说明识别完成，立即返回
-			if result.IsFinal {
-				logging.LogDebugf("ASR: Recognition completed with is_final=true")
-				rawText := fullTranscriptBuilder.String()
-				cleanedText := cleanASRTags(rawText)
-				logging.LogDebugf("ASR: Raw text length: %d, Cleaned text length: %d", len(rawText), len(cleanedText))
-				return cleanedText, nil
-			}
-		} else if result.IsFinal {
-			logging.LogDebugf("ASR: Sentence finalized: '%s'", result.Text)
-			if result.Text != "" {
-				fullTranscriptBuilder.WriteString(result.Text)
-			}
-			// 此句话已经确定，清空暂存区
-			latestPartialText = ""
-			break
-		} else {
-			// 将中间结果暂存 (2pass-online 的实时结果)
-			latestPartialText = result.Text
-		}
-	}
-	
-	rawText := fullTranscriptBuilder.String()
-	cleanedText := cleanASRTags(rawText)
-	return cleanedText, nil
+	chunks := splitAudioChunks(pcm, format, asrChunkSeconds, asrChunkOverlapSeconds)
+	logging.LogDebugf("ASR: backend [%s], PCM data size: %d bytes, chunks: %d", backend.Name(), len(pcm), len(chunks))
+	return transcribeAudioChunks(backend, format, chunks)
 }
 
 // GenerateSummary 生成摘要
-func (s *MeetingService) GenerateSummary(text string) (string, error) {
+func (s *MeetingService) GenerateSummary(ctx *WorkspaceContext, text string) (string, error) {
+	if err := CheckAIBudget(ctx); nil != err {
+		return "", err
+	}
+
 	// 使用配置中的 LLM 设置
-	baseURL := strings.TrimSuffix(llmEndpoint, "/")
+	ep, err := getMeetingLLMEndpoint(ctx)
+	if err != nil {
+		return "", err
+	}
+	baseURL := strings.TrimSuffix(ep.Provider.BaseURL, "/")
 	llmURL := baseURL + "/chat/completions"
-	apiKey := llmAPIKey
-	modelName := llmModelName
+	apiKey := ep.Provider.APIKey
+	modelName := ep.Model
 
 	prompt := fmt.Sprintf(`请将以下内容整理成简洁的三点摘要。
 
@@ -653,8 +414,8 @@ func (s *MeetingService) GenerateSummary(text string) (string, error) {
 			{"role": "user", "content": prompt},
 		},
 		"stream":      false,
-		"temperature": llmTemperature,
-		"max_tokens":  llmMaxTokens,
+		"temperature": ep.Temperature,
+		"max_tokens":  ep.MaxTokens,
 	}
 
 	jsonPayload, _ := json.Marshal(payload)
@@ -667,7 +428,7 @@ func (s *MeetingService) GenerateSummary(text string) (string, error) {
 		req.Header.Set("Authorization", "Bearer "+apiKey)
 	}
 
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := ep.HTTPClient(60 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		logging.LogErrorf("Failed to call LLM service at %s: %v", llmURL, err)
@@ -687,11 +448,22 @@ func (s *MeetingService) GenerateSummary(text string) (string, error) {
 				Content string `json:"content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage *openai.Usage `json:"usage"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", err
 	}
 
+	var completion string
+	if len(result.Choices) > 0 {
+		completion = result.Choices[0].Message.Content
+	}
+	var messages []openai.ChatCompletionMessage
+	for _, m := range payload["messages"].([]map[string]string) {
+		messages = append(messages, openai.ChatCompletionMessage{Role: m["role"], Content: m["content"]})
+	}
+	recordAIChatUsage(ctx, AIFeatureMeeting, ep.Provider.Name, modelName, result.Usage, messages, completion)
+
 	if len(result.Choices) > 0 {
 		summary := result.Choices[0].Message.Content
 		// 严格过滤思考过程标签（包括未闭合的标签）
@@ -0,0 +1,476 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/88250/lute/ast"
+	"github.com/sashabaranov/go-openai"
+	"github.com/siyuan-note/filelock"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/av"
+	"github.com/siyuan-note/siyuan/kernel/filesys"
+	"github.com/siyuan-note/siyuan/kernel/sql"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// MeetingActionItem 会议中提取的行动项
+type MeetingActionItem struct {
+	Task    string `json:"task"`
+	Owner   string `json:"owner"`
+	Due     string `json:"due"` // YYYY-MM-DD
+	Status  string `json:"status"`
+	BlockID string `json:"blockID"`          // 文档中行动项所在的块
+	ItemID  string `json:"itemID,omitempty"` // 添加到数据库后的项目 ID
+}
+
+// MeetingFinalizeOptions 生成会议纪要文档的参数
+type MeetingFinalizeOptions struct {
+	BoxID         string
+	HPath         string                  // 文档路径，为空时使用 /会议纪要/{标题}
+	Title         string                  // 为空时使用会议时间
+	Template      string                  // 模板路径，相对于 data/templates/，为空时使用内置模板
+	Transcription string                  // 已有的转录文本，为空时识别 Audio
+	Segments      []*MeetingStreamSegment // 流式转录得到的带时间的片段，优先于 Transcription
+	Summary       string                  // 已有的摘要，为空时由 AI 生成
+	Audio         []byte                  // 录音，保存为资源文件并在文档中引用
+	AudioName     string
+
+	// 行动项写入的数据库，为空时不写入。负责人、截止日期和状态字段未指定时按字段名称匹配
+	AvID        string
+	OwnerKeyID  string
+	DueKeyID    string
+	StatusKeyID string
+}
+
+// MeetingFinalizeResult 生成会议纪要文档的结果
+type MeetingFinalizeResult struct {
+	DocID       string               `json:"docID"`
+	HPath       string               `json:"hPath"`
+	Summary     string               `json:"summary"`
+	Decisions   []string             `json:"decisions"`
+	ActionItems []*MeetingActionItem `json:"actionItems"`
+	AudioPath   string               `json:"audioPath,omitempty"`
+	AvError     string               `json:"avError,omitempty"` // 文档已经创建，但行动项写入数据库失败
+}
+
+const (
+	meetingNotesMaxTokens       = 2048
+	meetingTranscriptMaxChars   = 12000 // 提取决策和行动项时使用的转录文本长度
+	meetingTranscriptParaRunes  = 200   // 转录文本按句子合并为段落的长度
+	defaultMeetingActionStatus  = "待办"
+	meetingTranscriptStartAttr  = "custom-meeting-start"
+	meetingActionItemAttr       = "custom-meeting-action"
+	defaultMeetingNotesTemplate = `## 摘要
+
+.action{if .summary}.action{.summary}.action{else}无.action{end}
+
+## 决策
+
+.action{if .decisions}.action{.decisions}.action{else}无.action{end}
+
+## 行动项
+
+.action{if .actionItems}.action{.actionItems}.action{else}无.action{end}
+.action{if .audio}
+## 录音
+
+.action{.audio}
+.action{end}
+## 转录
+
+.action{.transcript}
+`
+)
+
+// 按字段名称匹配行动项写入的数据库字段
+var (
+	meetingOwnerKeyNames  = []string{"负责人", "责任人", "执行人", "owner", "assignee"}
+	meetingDueKeyNames    = []string{"截止日期", "截止时间", "截止", "到期日", "due", "due date", "deadline"}
+	meetingStatusKeyNames = []string{"状态", "status"}
+)
+
+// Finalize 把一次会议整理为文档：按模板生成包含摘要、决策、行动项和带时间标记的转录文本的文档，
+// 录音保存为资源文件并在文档中引用，行动项绑定到指定的数据库并填写负责人、截止日期和状态
+func (s *MeetingService) Finalize(ctx *WorkspaceContext, opts *MeetingFinalizeOptions) (ret *MeetingFinalizeResult, err error) {
+	box := Conf.BoxWithContext(ctx, opts.BoxID)
+	if nil == box {
+		return nil, ErrBoxNotFound
+	}
+	if "" != opts.AvID && !ast.IsNodeIDPattern(opts.AvID) {
+		return nil, errors.New("invalid attribute view id")
+	}
+
+	segments := opts.Segments
+	if 1 > len(segments) && "" != strings.TrimSpace(opts.Transcription) {
+		segments = []*MeetingStreamSegment{{Text: strings.TrimSpace(opts.Transcription)}}
+	}
+	if 1 > len(segments) {
+		if 1 > len(opts.Audio) {
+			return nil, errors.New("transcription or audio is required")
+		}
+		if segments, err = s.transcribeSegments(ctx, opts.Audio); nil != err {
+			return
+		}
+	}
+	transcript := strings.TrimSpace(joinSegmentTexts(segments))
+	if "" == transcript {
+		return nil, errors.New("transcription is empty")
+	}
+
+	now := time.Now()
+	ret = &MeetingFinalizeResult{Summary: opts.Summary}
+	if ret.Decisions, ret.ActionItems, ret.Summary, err = extractMeetingNotes(ctx, transcript, opts.Summary, now); nil != err {
+		return
+	}
+
+	if 0 < len(opts.Audio) {
+		if ret.AudioPath, err = saveMeetingAudio(ctx, opts.Audio, opts.AudioName); nil != err {
+			return
+		}
+		defer func() {
+			if nil == err {
+				return
+			}
+			// 文档没有创建成功时删除录音，避免留下没有被引用的资源文件
+			if removeErr := os.Remove(filepath.Join(ctx.GetDataDir(), ret.AudioPath)); nil != removeErr {
+				logging.LogWarnf("remove meeting audio [%s] failed: %s", ret.AudioPath, removeErr)
+			}
+		}()
+	}
+
+	title := strings.TrimSpace(opts.Title)
+	if "" == title {
+		title = "会议纪要 " + now.Format("2006-01-02 15:04")
+	}
+	ret.HPath = strings.TrimSpace(opts.HPath)
+	if "" == ret.HPath {
+		ret.HPath = "/会议纪要/" + title
+	}
+	ret.HPath = util.TrimSpaceInPath(ret.HPath)
+
+	md, err := renderMeetingNotes(ctx, opts.Template, title, now, segments, ret)
+	if nil != err {
+		return
+	}
+	if ret.DocID, err = CreateWithMarkdownWithContext(ctx, "", box.ID, ret.HPath, md, "", "", false, ""); nil != err {
+		return
+	}
+
+	if "" != opts.AvID && 0 < len(ret.ActionItems) {
+		if avErr := appendMeetingActionItems(ctx, opts, ret.ActionItems); nil != avErr {
+			logging.LogErrorf("append meeting action items to attribute view [%s] failed: %s", opts.AvID, avErr)
+			ret.AvError = avErr.Error()
+		}
+	}
+	return
+}
+
+// extractMeetingNotes 让 AI 从转录文本中提取决策和行动项，summary 为空时同时生成摘要
+func extractMeetingNotes(ctx *WorkspaceContext, transcript, summary string, now time.Time) (decisions []string, actionItems []*MeetingActionItem, retSummary string, err error) {
+	if err = CheckAIBudget(ctx); nil != err {
+		return
+	}
+
+	ep, err := getMeetingLLMEndpoint(ctx)
+	if nil != err {
+		return
+	}
+	prompt := "你是一个会议纪要助手。请根据会议的转录文本提取会议中做出的决策和需要跟进的行动项。今天是 " + now.Format("2006-01-02") + "。\n" +
+		"只输出一个 JSON 对象：{\"summary\": \"摘要\", \"decisions\": [\"决策\"], \"actionItems\": [{\"task\": \"要做的事\", \"owner\": \"负责人\", \"due\": \"YYYY-MM-DD\", \"status\": \"状态\"}]}。\n" +
+		"summary 为三行摘要，格式为 \"> **主题**：...\\n> **要点**：...\\n> **后续**：...\"；" +
+		"转录中没有明确提到的负责人、截止日期和状态请输出空字符串，相对日期（例如下周五）请换算为具体日期，不要编造。不要输出思考过程和其他内容。"
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: prompt},
+		{Role: openai.ChatMessageRoleUser, Content: truncateAtSentence(transcript, meetingTranscriptMaxChars)},
+	}
+	resp, err := ep.OpenAIClient().CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:       ep.Model,
+		Messages:    messages,
+		MaxTokens:   max(ep.MaxTokens, meetingNotesMaxTokens),
+		Temperature: float32(ep.Temperature),
+	})
+	if nil != err {
+		return nil, nil, "", fmt.Errorf("extract meeting notes failed: %s", err)
+	}
+	var completion string
+	if 0 < len(resp.Choices) {
+		completion = resp.Choices[0].Message.Content
+	}
+	recordAIChatUsage(ctx, AIFeatureMeeting, ep.Provider.Name, ep.Model, &resp.Usage, messages, completion)
+
+	completion = filterThinkTags(completion)
+	start := strings.Index(completion, "{")
+	end := strings.LastIndex(completion, "}")
+	if 0 > start || end <= start {
+		return nil, nil, "", errors.New("AI response does not contain JSON")
+	}
+	var notes struct {
+		Summary     string               `json:"summary"`
+		Decisions   []string             `json:"decisions"`
+		ActionItems []*MeetingActionItem `json:"actionItems"`
+	}
+	if err = gulu.JSON.UnmarshalJSON([]byte(completion[start:end+1]), &notes); nil != err {
+		return nil, nil, "", fmt.Errorf("parse AI response failed: %s", err)
+	}
+
+	retSummary = strings.TrimSpace(summary)
+	if "" == retSummary {
+		retSummary = strings.TrimSpace(notes.Summary)
+	}
+	decisions = []string{}
+	for _, decision := range notes.Decisions {
+		if decision = strings.TrimSpace(decision); "" != decision {
+			decisions = append(decisions, decision)
+		}
+	}
+	actionItems = []*MeetingActionItem{}
+	for _, item := range notes.ActionItems {
+		if nil == item || "" == strings.TrimSpace(item.Task) {
+			continue
+		}
+		item.Task, item.Owner, item.Due, item.Status = strings.TrimSpace(item.Task), strings.TrimSpace(item.Owner), strings.TrimSpace(item.Due), strings.TrimSpace(item.Status)
+		if "" == item.Status {
+			item.Status = defaultMeetingActionStatus
+		}
+		item.BlockID, item.ItemID = ast.NewNodeID(), ""
+		actionItems = append(actionItems, item)
+	}
+	return
+}
+
+// saveMeetingAudio 把录音保存到 data/assets/ 下，返回资源路径
+func saveMeetingAudio(ctx *WorkspaceContext, data []byte, name string) (ret string, err error) {
+	name = util.FilterUploadFileName(filepath.Base(name))
+	if "" == name || "." == name {
+		name = "meeting"
+	}
+	if "" == filepath.Ext(name) {
+		if container := detectAudioContainer(data); "" != container {
+			name += "." + container
+		}
+	}
+	name = util.AssetName(name, ast.NewNodeID())
+
+	assetsDir := filepath.Join(ctx.GetDataDir(), "assets")
+	if err = os.MkdirAll(assetsDir, 0755); nil != err {
+		return
+	}
+	if err = filelock.WriteFile(filepath.Join(assetsDir, name), data); nil != err {
+		return
+	}
+	IncSync()
+	return "assets/" + name, nil
+}
+
+// renderMeetingNotes 使用模板生成会议纪要的 Markdown。模板的语法和文档模板相同，可以使用以下变量：
+// title、date、summary、decisions、actionItems、audio、transcript，以及结构化的 decisionList、actionItemList、segments
+func renderMeetingNotes(ctx *WorkspaceContext, templatePath, title string, now time.Time, segments []*MeetingStreamSegment, notes *MeetingFinalizeResult) (ret string, err error) {
+	tplContent := defaultMeetingNotesTemplate
+	if "" != templatePath {
+		templatesDir := filepath.Join(ctx.GetDataDir(), "templates")
+		absPath := filepath.Join(templatesDir, templatePath)
+		if !util.IsSubPath(templatesDir, absPath) {
+			return "", errors.New("invalid template path")
+		}
+		data, readErr := os.ReadFile(absPath)
+		if nil != readErr {
+			return "", readErr
+		}
+		tplContent = string(data)
+	}
+
+	var decisions, actionItems []string
+	for _, decision := range notes.Decisions {
+		decisions = append(decisions, "* "+decision)
+	}
+	for i, item := range notes.ActionItems {
+		var meta []string
+		if "" != item.Owner {
+			meta = append(meta, "@"+item.Owner)
+		}
+		if "" != item.Due {
+			meta = append(meta, "截止 "+item.Due)
+		}
+		text := item.Task
+		if 0 < len(meta) {
+			text += "（" + strings.Join(meta, "，") + "）"
+		}
+		actionItems = append(actionItems, "* [ ] "+text+"\n  {: id=\""+item.BlockID+"\" "+meetingActionItemAttr+"=\""+strconv.Itoa(i+1)+"\"}")
+	}
+	audio := ""
+	if "" != notes.AudioPath {
+		audio = "<audio controls=\"controls\" src=\"" + notes.AudioPath + "\"></audio>"
+	}
+
+	dataModel := map[string]interface{}{
+		"title":          title,
+		"date":           now.Format("2006-01-02 15:04"),
+		"summary":        notes.Summary,
+		"decisions":      strings.Join(decisions, "\n"),
+		"actionItems":    strings.Join(actionItems, "\n"),
+		"audio":          audio,
+		"transcript":     meetingTranscriptMarkdown(segments),
+		"decisionList":   notes.Decisions,
+		"actionItemList": notes.ActionItems,
+		"segments":       segments,
+	}
+
+	goTpl := template.New("").Delims(".action{", "}")
+	tplFuncMap := filesys.BuiltInTemplateFuncs()
+	sql.SQLTemplateFuncs(&tplFuncMap)
+	tpl, err := goTpl.Funcs(tplFuncMap).Parse(tplContent)
+	if nil != err {
+		return "", errors.New(fmt.Sprintf(Conf.Language(44), err.Error()))
+	}
+	buf := &bytes.Buffer{}
+	if err = tpl.Execute(buf, dataModel); nil != err {
+		return "", errors.New(fmt.Sprintf(Conf.Language(44), err.Error()))
+	}
+	return buf.String(), nil
+}
+
+// meetingTranscriptMarkdown 生成转录文本，每段以时间标记开头，段落块上记录开始的秒数，便于定位到录音中的位置
+func meetingTranscriptMarkdown(segments []*MeetingStreamSegment) string {
+	timed := false
+	for _, segment := range segments {
+		if 0 < segment.End {
+			timed = true
+			break
+		}
+	}
+	if !timed {
+		return joinSegmentTexts(segments)
+	}
+
+	buf := strings.Builder{}
+	for _, para := range meetingTranscriptParagraphs(segments) {
+		text := strings.TrimSpace(para.Text)
+		if "" == text {
+			continue
+		}
+		start := int(para.Start)
+		buf.WriteString("`[" + formatMeetingTimestamp(para.Start) + "]` " + text + "\n")
+		buf.WriteString("{: id=\"" + ast.NewNodeID() + "\" " + meetingTranscriptStartAttr + "=\"" + strconv.Itoa(start) + "\"}\n\n")
+	}
+	return buf.String()
+}
+
+// meetingTranscriptParagraphs 把片段按句子切开，再合并为长度适中的段落。
+// 分段识别得到的片段较长且没有句子级的时间，句子的时间按字数在片段内线性估算
+func meetingTranscriptParagraphs(segments []*MeetingStreamSegment) (ret []*MeetingStreamSegment) {
+	var sentences []*MeetingStreamSegment
+	for _, segment := range segments {
+		runes := []rune(segment.Text)
+		if 1 > len(runes) {
+			continue
+		}
+		duration := segment.End - segment.Start
+		begin := 0
+		for i, r := range runes {
+			if i != len(runes)-1 && !strings.ContainsRune("。！？!?；;\n", r) {
+				continue
+			}
+			sentences = append(sentences, &MeetingStreamSegment{
+				Text:  string(runes[begin : i+1]),
+				Start: segment.Start + duration*float64(begin)/float64(len(runes)),
+				End:   segment.Start + duration*float64(i+1)/float64(len(runes)),
+			})
+			begin = i + 1
+		}
+	}
+
+	var para *MeetingStreamSegment
+	for _, sentence := range sentences {
+		if nil == para || meetingTranscriptParaRunes <= len([]rune(para.Text)) {
+			para = &MeetingStreamSegment{Start: sentence.Start}
+			ret = append(ret, para)
+		}
+		para.Text += sentence.Text
+		para.End = sentence.End
+	}
+	return
+}
+
+// appendMeetingActionItems 把行动项所在的块绑定到数据库，并填写负责人、截止日期和状态
+func appendMeetingActionItems(ctx *WorkspaceContext, opts *MeetingFinalizeOptions, items []*MeetingActionItem) (err error) {
+	attrView, err := av.ParseAttributeView(opts.AvID)
+	if nil != err {
+		return
+	}
+
+	ownerKey := findMeetingActionKey(attrView, opts.OwnerKeyID, meetingOwnerKeyNames)
+	dueKey := findMeetingActionKey(attrView, opts.DueKeyID, meetingDueKeyNames)
+	statusKey := findMeetingActionKey(attrView, opts.StatusKeyID, meetingStatusKeyNames)
+
+	var doOps, undoOps []*Operation
+	for _, item := range items {
+		item.ItemID = ast.NewNodeID()
+		doOps = append(doOps, &Operation{Action: "insertAttrViewBlock", AvID: opts.AvID, Srcs: []map[string]interface{}{{"id": item.BlockID, "isDetached": false, "itemID": item.ItemID}}})
+		undoOps = append(undoOps, &Operation{Action: "removeAttrViewBlock", AvID: opts.AvID, SrcIDs: []string{item.ItemID}})
+
+		cells := []struct {
+			key *av.Key
+			raw string
+		}{{ownerKey, item.Owner}, {dueKey, item.Due}, {statusKey, item.Status}}
+		for _, cell := range cells {
+			key, raw := cell.key, cell.raw
+			if nil == key || "" == raw {
+				continue
+			}
+			val, _, convErr := newExtractedAttrViewValue(key, raw)
+			if nil != convErr || nil == val {
+				logging.LogWarnf("convert meeting action item value [%s] for key [%s] failed: %v", raw, key.Name, convErr)
+				continue
+			}
+			doOps = append(doOps, &Operation{Action: "updateAttrViewCell", AvID: opts.AvID, KeyID: key.ID, RowID: item.ItemID, Data: val})
+		}
+	}
+
+	transactions := []*Transaction{{DoOperations: doOps, UndoOperations: undoOps}}
+	if err = performAgentTransactions(ctx, transactions); nil != err {
+		for _, item := range items {
+			item.ItemID = ""
+		}
+	}
+	return
+}
+
+// findMeetingActionKey 按字段 ID 查找字段，未指定时按名称匹配
+func findMeetingActionKey(attrView *av.AttributeView, keyID string, names []string) *av.Key {
+	if "" != keyID {
+		key, _ := attrView.GetKey(keyID)
+		return key
+	}
+	for _, kv := range attrView.KeyValues {
+		if gulu.Str.Contains(strings.ToLower(strings.TrimSpace(kv.Key.Name)), names) && gulu.Str.Contains(string(kv.Key.Type), attrViewExtractableKeyTypes) {
+			return kv.Key
+		}
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/88250/lute/ast"
+	"github.com/gorilla/websocket"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+// 流式转录推送给客户端的事件类型
+const (
+	MeetingStreamEventPartial = "partial" // 正在识别的句子，后续会被修正
+	MeetingStreamEventFinal   = "final"   // 已经确定的句子
+	MeetingStreamEventSummary = "summary" // 结束时生成的摘要
+	MeetingStreamEventError   = "error"
+	MeetingStreamEventDone    = "done"
+)
+
+// MeetingStreamSegment 流式转录的片段，时间为相对录音开始的秒数
+type MeetingStreamSegment struct {
+	Text    string  `json:"text"`
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	BlockID string  `json:"blockID,omitempty"` // 写入目标文档后的块 ID
+}
+
+// MeetingStreamEvent 推送给客户端的事件
+type MeetingStreamEvent struct {
+	Type    string                `json:"type"`
+	Segment *MeetingStreamSegment `json:"segment,omitempty"`
+	Summary string                `json:"summary,omitempty"`
+	Result  *MeetingStreamResult  `json:"result,omitempty"`
+	Msg     string                `json:"msg,omitempty"`
+}
+
+// MeetingStreamOptions 流式转录参数
+type MeetingStreamOptions struct {
+	DocID      string // 已确定的句子追加到该文档（或容器块）末尾，为空时不写入
+	Format     string // 音频帧格式：pcm（16 位小端单声道）或 opus
+	SampleRate int    // 采样率，默认 16000
+}
+
+// MeetingStreamResult 流式转录结束后的结果
+type MeetingStreamResult struct {
+	Transcription string                  `json:"transcription"`
+	Summary       string                  `json:"summary"`
+	Segments      []*MeetingStreamSegment `json:"segments"`
+}
+
+// MeetingStream 一次流式转录会话，把客户端的音频帧转发给语音识别服务，并把识别结果推送给客户端
+type MeetingStream struct {
+	ctx     *WorkspaceContext
+	opts    *MeetingStreamOptions
+	asr     *websocket.Conn
+	onEvent func(evt *MeetingStreamEvent)
+
+	lock      sync.Mutex
+	startTime time.Time
+	pcmBytes  int64                   // 已转发的 PCM 字节数，用于计算时间
+	segStart  float64                 // 当前句子的开始时间
+	partial   string                  // 当前句子的中间结果
+	segments  []*MeetingStreamSegment // 已确定的句子
+	done      chan struct{}
+	stopped   bool
+}
+
+// StartStream 连接语音识别服务并开始流式转录。onEvent 在读取识别结果的协程中调用
+func (s *MeetingService) StartStream(ctx *WorkspaceContext, opts *MeetingStreamOptions, onEvent func(evt *MeetingStreamEvent)) (ret *MeetingStream, err error) {
+	if "" == opts.Format {
+		opts.Format = "pcm"
+	}
+	if "pcm" != opts.Format && "opus" != opts.Format {
+		return nil, fmt.Errorf("unsupported audio format [%s]", opts.Format)
+	}
+	if 1 > opts.SampleRate {
+		opts.SampleRate = 16000
+	}
+	if "" != opts.DocID && !ast.IsNodeIDPattern(opts.DocID) {
+		return nil, errors.New("invalid doc id")
+	}
+	if err = CheckAIBudget(ctx); nil != err {
+		return
+	}
+
+	// 只有 WebSocket 语音识别服务支持边录边识别
+	asrBackend, err := newASRBackend(ctx)
+	if nil != err {
+		return
+	}
+	backend, ok := asrBackend.(*FunASRBackend)
+	if !ok {
+		return nil, errors.New("streaming transcription requires a WebSocket ASR backend")
+	}
+	dialer := &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 15 * time.Second}
+	conn, _, err := dialer.Dial(backend.url, nil)
+	if nil != err {
+		return nil, fmt.Errorf("ASR WebSocket connection failed: %v", err)
+	}
+
+	startConfig := map[string]interface{}{
+		"mode":           "2pass",
+		"chunk_size":     []int{5, 10, 5},
+		"chunk_interval": 10,
+		"wav_name":       "meeting",
+		"wav_format":     opts.Format,
+		"audio_fs":       opts.SampleRate,
+		"is_speaking":    true,
+	}
+	if err = conn.WriteJSON(startConfig); nil != err {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send start config: %v", err)
+	}
+
+	ret = &MeetingStream{ctx: ctx, opts: opts, asr: conn, onEvent: onEvent, startTime: time.Now(), done: make(chan struct{})}
+	go ret.readResults()
+	return
+}
+
+// Write 转发一帧音频
+func (stream *MeetingStream) Write(frame []byte) (err error) {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	if stream.stopped {
+		return errors.New("stream stopped")
+	}
+
+	if err = stream.asr.WriteMessage(websocket.BinaryMessage, frame); nil != err {
+		return
+	}
+	stream.pcmBytes += int64(len(frame))
+	return
+}
+
+// Stop 通知语音识别服务音频结束，等待剩余的识别结果后生成摘要。
+// 摘要会追加到目标文档末尾
+func (stream *MeetingStream) Stop() (ret *MeetingStreamResult) {
+	stream.lock.Lock()
+	if !stream.stopped {
+		stream.stopped = true
+		if err := stream.asr.WriteJSON(map[string]interface{}{"is_speaking": false}); nil != err {
+			logging.LogWarnf("send ASR end signal failed: %s", err)
+		}
+	}
+	stream.lock.Unlock()
+
+	select {
+	case <-stream.done:
+	case <-time.After(60 * time.Second):
+		logging.LogWarnf("wait for ASR results timeout")
+	}
+	stream.asr.Close()
+	<-stream.done
+
+	stream.lock.Lock()
+	// 连接关闭时还没有确定的句子也保留下来
+	var last *MeetingStreamSegment
+	if "" != stream.partial {
+		last = stream.finalizeSegment(stream.partial)
+	}
+	var texts []string
+	ret = &MeetingStreamResult{Segments: stream.segments}
+	for _, segment := range stream.segments {
+		texts = append(texts, segment.Text)
+	}
+	stream.lock.Unlock()
+	if nil != last {
+		stream.publishSegment(last)
+	}
+
+	ret.Transcription = strings.Join(texts, "")
+	if "" == ret.Transcription {
+		return
+	}
+
+	summary, err := Meeting.GenerateSummary(stream.ctx, ret.Transcription)
+	if nil != err {
+		logging.LogWarnf("generate meeting summary failed: %s", err)
+		stream.onEvent(&MeetingStreamEvent{Type: MeetingStreamEventError, Msg: err.Error()})
+		return
+	}
+	ret.Summary = summary
+	stream.appendToDoc(summary, "")
+	stream.onEvent(&MeetingStreamEvent{Type: MeetingStreamEventSummary, Summary: summary})
+	return
+}
+
+func (stream *MeetingStream) readResults() {
+	defer close(stream.done)
+
+	for {
+		_, message, err := stream.asr.ReadMessage()
+		if nil != err {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				logging.LogDebugf("ASR stream read stop: %v", err)
+			}
+			return
+		}
+
+		var result struct {
+			Text    string `json:"text"`
+			IsFinal bool   `json:"is_final"`
+			Mode    string `json:"mode"`
+		}
+		if err = json.Unmarshal(message, &result); nil != err {
+			logging.LogWarnf("parse ASR result failed: %v, message: %s", err, message)
+			continue
+		}
+		text := cleanASRTags(result.Text)
+
+		// 只在锁内更新状态，写入文档和推送事件在释放锁后进行，避免阻塞音频帧的转发
+		var final *MeetingStreamSegment
+		var partial *MeetingStreamEvent
+		stream.lock.Lock()
+		switch {
+		case "2pass-offline" == result.Mode || "offline" == result.Mode:
+			// 离线识别的结果是最终的高质量结果，替换掉这句话的中间结果
+			if "" != text {
+				final = stream.finalizeSegment(text)
+			} else {
+				stream.partial = ""
+			}
+		case "2pass-online" == result.Mode || "online" == result.Mode:
+			// 在线识别的结果是增量的
+			if "" != text {
+				stream.partial += text
+				partial = &MeetingStreamEvent{Type: MeetingStreamEventPartial, Segment: &MeetingStreamSegment{Text: stream.partial, Start: stream.segStart, End: stream.position()}}
+			}
+		default:
+			if result.IsFinal && "" != text {
+				final = stream.finalizeSegment(text)
+			} else if "" != text {
+				stream.partial = text
+				partial = &MeetingStreamEvent{Type: MeetingStreamEventPartial, Segment: &MeetingStreamSegment{Text: text, Start: stream.segStart, End: stream.position()}}
+			}
+		}
+		finished := result.IsFinal && stream.stopped
+		stream.lock.Unlock()
+
+		if nil != final {
+			stream.publishSegment(final)
+		}
+		if nil != partial {
+			stream.onEvent(partial)
+		}
+		if finished {
+			return
+		}
+	}
+}
+
+// finalizeSegment 确定一句话并返回该句，调用方需要持有锁，释放锁后再调用 publishSegment 写入文档和推送
+func (stream *MeetingStream) finalizeSegment(text string) (ret *MeetingStreamSegment) {
+	ret = &MeetingStreamSegment{Text: text, Start: stream.segStart, End: stream.position()}
+	if "" != stream.opts.DocID {
+		ret.BlockID = ast.NewNodeID()
+	}
+	stream.segments = append(stream.segments, ret)
+	stream.segStart = ret.End
+	stream.partial = ""
+	return
+}
+
+// publishSegment 把确定的句子写入目标文档并推送给客户端，调用方不能持有锁
+func (stream *MeetingStream) publishSegment(segment *MeetingStreamSegment) {
+	if "" != segment.BlockID {
+		stream.appendToDoc("`["+formatMeetingTimestamp(segment.Start)+"]` "+segment.Text, segment.BlockID)
+	}
+	stream.onEvent(&MeetingStreamEvent{Type: MeetingStreamEventFinal, Segment: segment})
+}
+
+// position 返回当前已转发音频的时长。PCM 根据字节数计算，Opus 是变长编码，使用经过的时间
+func (stream *MeetingStream) position() float64 {
+	if "pcm" == stream.opts.Format {
+		return float64(stream.pcmBytes) / float64(2*stream.opts.SampleRate)
+	}
+	return time.Since(stream.startTime).Seconds()
+}
+
+// appendToDoc 把 Markdown 追加到目标文档末尾，id 不为空时作为新块的 ID。没有目标文档时返回 false
+func (stream *MeetingStream) appendToDoc(md, id string) bool {
+	if "" == stream.opts.DocID {
+		return false
+	}
+
+	if "" != id {
+		md += "\n{: id=\"" + id + "\"}"
+	}
+	luteEngine := util.NewLute()
+	luteEngine.SetHTMLTag2TextMark(true)
+	dom := luteEngine.Md2BlockDOM(md, true)
+	performAgentTransactions(stream.ctx, []*Transaction{
+		{DoOperations: []*Operation{{Action: "appendInsert", Data: dom, ParentID: stream.opts.DocID}}},
+	})
+	return true
+}
+
+func formatMeetingTimestamp(seconds float64) string {
+	total := int(seconds)
+	if 3600 <= total {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total%3600/60, total%60)
+	}
+	return fmt.Sprintf("%02d:%02d", total/60, total%60)
+}
@@ -0,0 +1,157 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+)
+
+// newFakeFunASRServer 模拟 FunASR 的 2pass 模式：收到第一帧音频后返回一句在线和离线结果，收到结束信号后返回最后一句
+func newFakeFunASRServer(t *testing.T, frames *int) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if nil != err {
+			t.Errorf("upgrade failed: %s", err)
+			return
+		}
+		defer conn.Close()
+
+		var startConfig map[string]interface{}
+		if err = conn.ReadJSON(&startConfig); nil != err || true != startConfig["is_speaking"] {
+			t.Errorf("invalid start config [%v]: %v", startConfig, err)
+			return
+		}
+
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if nil != err {
+				return
+			}
+			if websocket.BinaryMessage == msgType {
+				*frames++
+				if 1 == *frames {
+					conn.WriteJSON(map[string]interface{}{"mode": "2pass-online", "text": "大家"})
+					conn.WriteJSON(map[string]interface{}{"mode": "2pass-online", "text": "好"})
+					conn.WriteJSON(map[string]interface{}{"mode": "2pass-offline", "text": "大家好。"})
+				}
+				continue
+			}
+
+			var signal map[string]interface{}
+			if err = json.Unmarshal(data, &signal); nil == err && false == signal["is_speaking"] {
+				conn.WriteJSON(map[string]interface{}{"mode": "2pass-offline", "text": "散会。", "is_final": true})
+				return
+			}
+		}
+	}))
+}
+
+// newFakeMeetingLLMServer 模拟 OpenAI 兼容的对话接口，返回固定的摘要
+func newFakeMeetingLLMServer(summary string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": summary}}},
+		})
+	}))
+}
+
+func TestMeetingStream(t *testing.T) {
+	frames := 0
+	asrServer := newFakeFunASRServer(t, &frames)
+	defer asrServer.Close()
+	summary := "> **主题**：测试"
+	llmServer := newFakeMeetingLLMServer(summary)
+	defer llmServer.Close()
+
+	savedConf := Conf
+	defer func() { Conf = savedConf }()
+	Conf = &AppConf{AI: &conf.AI{
+		Providers: &conf.AIProviders{
+			Providers: []*conf.AIProvider{
+				{Name: "asr", Type: conf.AIProviderTypeFunASR, BaseURL: asrServer.URL},
+				{Name: "llm", Type: conf.AIProviderTypeOpenAI, BaseURL: llmServer.URL, Models: []string{"test"}},
+			},
+			Routes: map[string]*conf.AIRoute{
+				conf.AITaskASR:     {Provider: "asr"},
+				conf.AITaskMeeting: {Provider: "llm"},
+			},
+		},
+		Usage: conf.NewAIUsage(),
+	}}
+
+	var events []*MeetingStreamEvent
+	eventsLock := sync.Mutex{}
+	ctx := NewWorkspaceContext(t.TempDir())
+	stream, err := Meeting.StartStream(ctx, &MeetingStreamOptions{}, func(evt *MeetingStreamEvent) {
+		eventsLock.Lock()
+		defer eventsLock.Unlock()
+		events = append(events, evt)
+	})
+	if nil != err {
+		t.Fatalf("start stream failed: %s", err)
+	}
+
+	// 16kHz 16 位单声道，一帧 100ms
+	frame := make([]byte, 3200)
+	for i := 0; i < 5; i++ {
+		if err = stream.Write(frame); nil != err {
+			t.Fatalf("write frame failed: %s", err)
+		}
+	}
+	result := stream.Stop()
+
+	if 5 != frames {
+		t.Errorf("expected 5 frames relayed, got %d", frames)
+	}
+	if "大家好。散会。" != result.Transcription {
+		t.Errorf("unexpected transcription [%s]", result.Transcription)
+	}
+	if 2 != len(result.Segments) || result.Segments[0].End != result.Segments[1].Start || 0.5 != result.Segments[1].End {
+		t.Errorf("unexpected segments %+v", result.Segments)
+	}
+	if summary != result.Summary {
+		t.Errorf("unexpected summary [%s]", result.Summary)
+	}
+
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	var types []string
+	for _, evt := range events {
+		types = append(types, evt.Type)
+	}
+	expected := []string{MeetingStreamEventPartial, MeetingStreamEventPartial, MeetingStreamEventFinal, MeetingStreamEventFinal, MeetingStreamEventSummary}
+	if strings.Join(expected, ",") != strings.Join(types, ",") {
+		t.Errorf("unexpected events [%s]", strings.Join(types, ","))
+	}
+	if "大家好" != events[1].Segment.Text {
+		t.Errorf("unexpected partial segment [%s]", events[1].Segment.Text)
+	}
+}
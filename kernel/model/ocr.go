@@ -1,83 +1,328 @@
-// [OCR 功能已禁用] 本文件中的 OCR 相关功能已被注释
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
 package model
 
-/*
 import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/88250/gulu"
+	"github.com/88250/lute/ast"
+	"github.com/siyuan-note/filelock"
 	"github.com/siyuan-note/logging"
-	"github.com/siyuan-note/siyuan/kernel/cache"
+	"github.com/siyuan-note/siyuan/kernel/conf"
 	"github.com/siyuan-note/siyuan/kernel/sql"
-	"github.com/siyuan-note/siyuan/kernel/task"
 	"github.com/siyuan-note/siyuan/kernel/util"
 )
 
-func OCRAssetsJob() {
-	util.WaitForTesseractInit()
+// OCRTextBlock 识别出的一行文字
+type OCRTextBlock struct {
+	Text       string      `json:"text"`
+	Confidence float64     `json:"score"`
+	Position   [][]float64 `json:"box"` // 文字区域的顶点坐标
+}
 
-	if !util.TesseractEnabled {
-		return
+// OCREngine 文字识别引擎
+type OCREngine interface {
+	// Name 返回引擎名称
+	Name() string
+
+	// Available 检查引擎是否可用，不可用时返回原因
+	Available() (bool, string)
+
+	// Supports 检查是否支持识别该扩展名的图片
+	Supports(ext string) bool
+
+	// Recognize 识别图片中的文字
+	Recognize(imgAbsPath string) ([]*OCRTextBlock, error)
+}
+
+// ocrImageExts 文字识别队列处理的图片格式，具体能否识别还取决于引擎
+var ocrImageExts = []string{".png", ".jpg", ".jpeg", ".bmp", ".gif", ".webp", ".tif", ".tiff"}
+
+func isOCRImage(p string) bool {
+	return gulu.Str.Contains(strings.ToLower(filepath.Ext(p)), ocrImageExts)
+}
+
+// newOCREngine 根据工作空间的文字识别路由创建引擎，未配置时使用本机默认地址的 PaddleOCR 服务
+func newOCREngine(ctx *WorkspaceContext) OCREngine {
+	ep := ResolveAIEndpoint(ctx, conf.AITaskOCR)
+	if nil == ep {
+		return &PaddleOCREngine{baseURL: DefaultPaddleOCRBaseURL, timeout: PaddleOCRTimeout}
+	}
+	if conf.AIProviderTypeTesseract == ep.Provider.Type {
+		return &TesseractOCREngine{}
 	}
+	return &PaddleOCREngine{baseURL: strings.TrimSuffix(ep.Provider.BaseURL, "/"), timeout: ep.Timeout(PaddleOCRTimeout)}
+}
+
+// OCRHealthCheck 检查默认工作空间的文字识别引擎是否可用
+func OCRHealthCheck() (bool, string) {
+	return OCRHealthCheckWithContext(GetDefaultWorkspaceContext())
+}
 
-	task.AppendTaskWithTimeout(task.OCRImage, 30*time.Second, autoOCRAssets)
+// OCRHealthCheckWithContext 检查工作空间的文字识别引擎是否可用
+func OCRHealthCheckWithContext(ctx *WorkspaceContext) (bool, string) {
+	return newOCREngine(ctx).Available()
 }
 
-func autoOCRAssets() {
+// TesseractOCREngine 本机 Tesseract 命令行
+type TesseractOCREngine struct{}
+
+func (engine *TesseractOCREngine) Name() string {
+	return "tesseract"
+}
+
+func (engine *TesseractOCREngine) Available() (bool, string) {
+	util.WaitForTesseractInit()
 	if !util.TesseractEnabled {
-		return
+		return false, "Tesseract 未安装或已通过环境变量关闭"
 	}
+	return true, "Tesseract " + strings.Join(util.TesseractLangs, "+")
+}
+
+func (engine *TesseractOCREngine) Supports(ext string) bool {
+	return util.IsTesseractExtractable(ext)
+}
 
-	defer logging.Recover()
+// Recognize 调用 Tesseract 识别图片，按行合并 TSV 输出中的单词
+func (engine *TesseractOCREngine) Recognize(imgAbsPath string) (ret []*OCRTextBlock, err error) {
+	if ok, msg := engine.Available(); !ok {
+		return nil, errors.New(msg)
+	}
 
-	assetsPath := util.GetDataAssetsAbsPath()
-	assets := getUnOCRAssetsAbsPaths()
-	if 0 < len(assets) {
-		for i, assetAbsPath := range assets {
-			text := util.GetOcrJsonText(util.Tesseract(assetAbsPath))
-			p := strings.TrimPrefix(assetAbsPath, assetsPath)
-			p = "assets" + filepath.ToSlash(p)
-			util.SetAssetText(p, text)
-			if 7 <= i { // 一次任务中最多处理 7 张图片，防止长时间占用系统资源
-				break
-			}
+	rows := util.Tesseract(imgAbsPath)
+	if nil == rows {
+		return nil, fmt.Errorf("tesseract recognize [%s] failed", filepath.Base(imgAbsPath))
+	}
+
+	type line struct {
+		words                    []string
+		confSum                  float64
+		confCount                int
+		left, top, right, bottom float64
+	}
+	lines := map[string]*line{}
+	var lineKeys []string
+	for _, row := range rows {
+		text := strings.TrimSpace(fmt.Sprint(row["text"]))
+		if "" == text {
+			continue
+		}
+		key := fmt.Sprint(row["page_num"], "-", row["block_num"], "-", row["par_num"], "-", row["line_num"])
+		l := lines[key]
+		left, top := tesseractNumber(row["left"]), tesseractNumber(row["top"])
+		right, bottom := left+tesseractNumber(row["width"]), top+tesseractNumber(row["height"])
+		if nil == l {
+			l = &line{left: left, top: top, right: right, bottom: bottom}
+			lines[key] = l
+			lineKeys = append(lineKeys, key)
+		}
+		l.words = append(l.words, text)
+		if conf := tesseractNumber(row["conf"]); 0 <= conf {
+			l.confSum += conf
+			l.confCount++
 		}
+		l.left, l.top = min(l.left, left), min(l.top, top)
+		l.right, l.bottom = max(l.right, right), max(l.bottom, bottom)
 	}
 
-	util.CleanNotExistAssetsTexts()
+	ret = []*OCRTextBlock{}
+	for _, key := range lineKeys {
+		l := lines[key]
+		block := &OCRTextBlock{
+			Text:     util.RemoveRedundantSpace(strings.Join(l.words, " ")),
+			Position: [][]float64{{l.left, l.top}, {l.right, l.top}, {l.right, l.bottom}, {l.left, l.bottom}},
+		}
+		if 0 < l.confCount {
+			block.Confidence = l.confSum / float64(l.confCount) / 100
+		}
+		ret = append(ret, block)
+	}
+	return
+}
 
-	// 刷新 OCR 结果到数据库
-	util.NodeOCRQueueLock.Lock()
-	defer util.NodeOCRQueueLock.Unlock()
-	for _, id := range util.NodeOCRQueue {
-		sql.IndexNodeQueue(id)
+func tesseractNumber(v interface{}) float64 {
+	ret, err := strconv.ParseFloat(strings.TrimSpace(fmt.Sprint(v)), 64)
+	if nil != err {
+		return -1
 	}
-	util.NodeOCRQueue = nil
+	return ret
 }
 
-func getUnOCRAssetsAbsPaths() (ret []string) {
-	var assetsPaths []string
-	assets := cache.GetAssets()
-	for _, asset := range assets {
-		if !util.IsTesseractExtractable(asset.Path) {
-			continue
-		}
-		assetsPaths = append(assetsPaths, asset.Path)
+// ocrAssetFile 使用引擎识别图片或 PDF。PDF 先按页转换为图片再逐页识别
+func ocrAssetFile(engine OCREngine, absPath string) (ret *OCRAssetResult, err error) {
+	if !gulu.File.IsExist(absPath) {
+		return nil, fmt.Errorf("文件不存在: %s", absPath)
 	}
 
-	assetsPath := util.GetDataAssetsAbsPath()
-	for _, assetPath := range assetsPaths {
-		if util.ExistsAssetText(assetPath) {
-			continue
+	ext := strings.ToLower(filepath.Ext(absPath))
+	ret = &OCRAssetResult{
+		ID:        gulu.Rand.String(16),
+		AssetPath: absPath,
+		FileName:  filepath.Base(absPath),
+		FileType:  ext,
+		Engine:    engine.Name(),
+		PageCount: 1,
+	}
+
+	switch {
+	case ".pdf" == ext:
+		var text string
+		if ret.OCRResults, text, ret.PageCount, err = ocrPDFFile(engine, absPath); nil != err {
+			return nil, err
 		}
-		absPath := filepath.Join(assetsPath, strings.TrimPrefix(assetPath, "assets"))
-		ret = append(ret, absPath)
+		ret.FullText = strings.TrimSpace(text)
+	case isOCRImage(absPath):
+		if !engine.Supports(ext) {
+			return nil, fmt.Errorf("OCR 引擎 [%s] 不支持的文件格式: %s", engine.Name(), ext)
+		}
+		if ret.OCRResults, err = engine.Recognize(absPath); nil != err {
+			return nil, err
+		}
+		buf := strings.Builder{}
+		for _, block := range ret.OCRResults {
+			buf.WriteString(block.Text)
+			buf.WriteString("\n")
+		}
+		ret.FullText = strings.TrimSpace(buf.String())
+	default:
+		return nil, fmt.Errorf("不支持的文件格式: %s", ext)
 	}
+	ret.UpdatedAt = time.Now()
+	logging.LogInfof("OCR [%s] 完成: %s, 识别 %d 个文本块", engine.Name(), ret.FileName, len(ret.OCRResults))
 	return
 }
 
-func FlushAssetsTextsJob() {
-	util.SaveAssetsTexts()
+// OCR 结果保存在工作空间的 storage/ocr/results/ 下，文件名为资源路径的 MD5，资源内容索引和向量化时从这里读取识别的文字
+
+func ocrStorageDir(dataDir string) string {
+	return filepath.Join(dataDir, "storage", "ocr")
+}
+
+func ocrResultPath(dataDir, assetPath string) string {
+	return filepath.Join(ocrStorageDir(dataDir), "results", fmt.Sprintf("%x.json", md5.Sum([]byte(assetPath))))
+}
+
+// toOCRAssetPath 把资源文件的绝对路径转换为相对于数据目录的路径，例如 assets/xxx.png
+func toOCRAssetPath(dataDir, absPath string) string {
+	rel, err := filepath.Rel(dataDir, absPath)
+	if nil != err {
+		return filepath.ToSlash(absPath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func saveOCRResult(dataDir, assetPath string, result *OCRAssetResult) error {
+	stored := *result
+	stored.AssetPath = assetPath
+	data, err := json.Marshal(&stored)
+	if nil != err {
+		return err
+	}
+	p := ocrResultPath(dataDir, assetPath)
+	if err = os.MkdirAll(filepath.Dir(p), 0755); nil != err {
+		return err
+	}
+	return filelock.WriteFile(p, data)
+}
+
+func loadStoredOCRResult(dataDir, assetPath string) (ret *OCRAssetResult) {
+	data, err := filelock.ReadFile(ocrResultPath(dataDir, assetPath))
+	if nil != err {
+		return nil
+	}
+	ret = &OCRAssetResult{}
+	if err = json.Unmarshal(data, ret); nil != err {
+		logging.LogWarnf("parse OCR result [%s] failed: %s", assetPath, err)
+		return nil
+	}
+	return
+}
+
+func removeOCRResult(dataDir, assetPath string) {
+	if p := ocrResultPath(dataDir, assetPath); gulu.File.IsExist(p) {
+		if err := filelock.Remove(p); nil != err {
+			logging.LogWarnf("remove OCR result [%s] failed: %s", assetPath, err)
+		}
+	}
+}
+
+func renameOCRResult(dataDir, oldAssetPath, newAssetPath string) {
+	result := loadStoredOCRResult(dataDir, oldAssetPath)
+	if nil == result {
+		return
+	}
+	if err := saveOCRResult(dataDir, newAssetPath, result); nil != err {
+		logging.LogWarnf("rename OCR result [%s] to [%s] failed: %s", oldAssetPath, newAssetPath, err)
+		return
+	}
+	removeOCRResult(dataDir, oldAssetPath)
+}
+
+// getAssetOCRText 获取资源文件识别出的文字，absPath 需要位于默认数据目录或者已经加载了文字识别队列的工作空间下
+func getAssetOCRText(absPath string) string {
+	dataDir := util.DataDir
+	ocrQueuesLock.Lock()
+	for dir := range ocrQueues {
+		if len(dir) > len(dataDir) && util.IsSubPath(dir, absPath) {
+			dataDir = dir
+		}
+	}
+	ocrQueuesLock.Unlock()
+
+	if result := loadStoredOCRResult(dataDir, toOCRAssetPath(dataDir, absPath)); nil != result {
+		return result.FullText
+	}
+	return ""
+}
+
+// indexOCRAssetContent 把识别出的文字写入工作空间的资源内容数据库，替换该资源原有的内容
+func indexOCRAssetContent(ctx *WorkspaceContext, absPath, assetPath, text string) {
+	info, err := os.Stat(absPath)
+	if nil != err {
+		logging.LogErrorf("stat [%s] failed: %s", absPath, err)
+		return
+	}
+
+	if !ctx.IsDefaultWorkspace() {
+		if err = sql.SetAssetContentDBForContext(ctx); nil != err {
+			logging.LogErrorf("[用户: %s] 设置附件内容数据库连接失败: %s", ctx.Username, err)
+			return
+		}
+		defer sql.RestoreAssetContentDB()
+	}
+
+	sql.DeleteAssetContentsByPathQueue(assetPath)
+	sql.IndexAssetContentsQueue([]*sql.AssetContent{{
+		ID:      ast.NewNodeID(),
+		Name:    util.RemoveID(filepath.Base(assetPath)),
+		Ext:     strings.ToLower(filepath.Ext(assetPath)),
+		Path:    assetPath,
+		Size:    info.Size(),
+		Updated: info.ModTime().Unix(),
+		Content: text,
+	}})
+	sql.FlushAssetContentQueue()
 }
-*/
@@ -0,0 +1,535 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/siyuan-note/filelock"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+const (
+	OCRJobPending = "pending"
+	OCRJobRunning = "running"
+	OCRJobDone    = "done"
+	OCRJobSkipped = "skipped" // 有文字层的 PDF 或引擎不支持的格式
+	OCRJobFailed  = "failed"
+
+	ocrJobMaxAttempts  = 3
+	ocrJobRetryBackoff = 30 * time.Second
+	ocrJobKeepFinished = 500 // 队列文件中最多保留的已结束任务数
+	ocrJobKeepFailed   = 200 // 队列文件中最多保留的失败任务数，超出时丢弃最早失败的任务
+)
+
+// OCRJob 文字识别任务
+type OCRJob struct {
+	Path     string `json:"path"` // 相对于数据目录的资源路径，例如 assets/xxx.png
+	Status   string `json:"status"`
+	Priority int    `json:"priority"` // 数值越大越先处理
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+	Engine   string `json:"engine"`
+	Created  int64  `json:"created"`
+	Updated  int64  `json:"updated"`
+	NextRun  int64  `json:"nextRun"` // 失败重试或引擎不可用时的下次执行时间
+}
+
+// ocrQueue 工作空间的文字识别队列，持久化在 storage/ocr/queue.json，内核重启后继续处理
+type ocrQueue struct {
+	ctx  *WorkspaceContext
+	jobs map[string]*OCRJob
+	lock sync.Mutex
+	wake chan bool
+}
+
+var (
+	ocrQueues     = map[string]*ocrQueue{} // 数据目录 -> 队列
+	ocrQueuesLock = sync.Mutex{}
+)
+
+// OCRQueueJob 加载默认工作空间和 Web 模式下所有用户工作空间的文字识别队列，启动时恢复未完成的任务
+func OCRQueueJob() {
+	if !util.IsBooted() {
+		return
+	}
+	getOCRQueue(GetDefaultWorkspaceContext())
+
+	if "true" != os.Getenv("SIYUAN_WEB_MODE") || nil == GetUserStore() {
+		return
+	}
+	users, err := GetUserStore().List()
+	if nil != err {
+		logging.LogErrorf("list users for OCR queues failed: %s", err)
+		return
+	}
+	for _, user := range users {
+		if !user.IsActive || "" == user.Workspace {
+			continue
+		}
+		ctx := NewWorkspaceContextWithUser(user.Workspace, user.ID, user.Username)
+		// 只加载有持久化队列的工作空间，其他工作空间在第一次加入任务时再创建队列
+		if gulu.File.IsExist(filepath.Join(ocrStorageDir(ctx.GetDataDir()), "queue.json")) {
+			getOCRQueue(ctx)
+		}
+	}
+}
+
+func getOCRQueue(ctx *WorkspaceContext) *ocrQueue {
+	dataDir := ctx.GetDataDir()
+	ocrQueuesLock.Lock()
+	defer ocrQueuesLock.Unlock()
+	if q := ocrQueues[dataDir]; nil != q {
+		return q
+	}
+
+	q := &ocrQueue{ctx: ctx, jobs: map[string]*OCRJob{}, wake: make(chan bool, 1)}
+	q.load()
+	ocrQueues[dataDir] = q
+	go q.run()
+	return q
+}
+
+func (q *ocrQueue) queuePath() string {
+	return filepath.Join(ocrStorageDir(q.ctx.GetDataDir()), "queue.json")
+}
+
+func (q *ocrQueue) load() {
+	p := q.queuePath()
+	if !gulu.File.IsExist(p) {
+		return
+	}
+	data, err := filelock.ReadFile(p)
+	if nil != err {
+		logging.LogErrorf("read OCR queue [%s] failed: %s", p, err)
+		return
+	}
+	var jobs []*OCRJob
+	if err = json.Unmarshal(data, &jobs); nil != err {
+		logging.LogErrorf("parse OCR queue [%s] failed: %s", p, err)
+		return
+	}
+	for _, job := range jobs {
+		if OCRJobRunning == job.Status {
+			// 上次退出时正在处理的任务重新排队
+			job.Status = OCRJobPending
+		}
+		q.jobs[job.Path] = job
+	}
+}
+
+// save 持久化队列，调用方需要持有 q.lock
+func (q *ocrQueue) save() {
+	var active, finished, failed []*OCRJob
+	for _, job := range q.jobs {
+		switch job.Status {
+		case OCRJobDone, OCRJobSkipped:
+			finished = append(finished, job)
+		case OCRJobFailed:
+			failed = append(failed, job)
+		default:
+			active = append(active, job)
+		}
+	}
+	finished = q.trimJobs(finished, ocrJobKeepFinished)
+	failed = q.trimJobs(failed, ocrJobKeepFailed)
+	jobs := append(append(active, finished...), failed...)
+	sortOCRJobs(jobs)
+
+	data, err := json.Marshal(jobs)
+	if nil != err {
+		logging.LogErrorf("marshal OCR queue failed: %s", err)
+		return
+	}
+	p := q.queuePath()
+	if err = os.MkdirAll(filepath.Dir(p), 0755); nil != err {
+		logging.LogErrorf("create OCR storage dir failed: %s", err)
+		return
+	}
+	if err = filelock.WriteFile(p, data); nil != err {
+		logging.LogErrorf("write OCR queue [%s] failed: %s", p, err)
+	}
+}
+
+// trimJobs 只保留最近更新的 keep 个任务，其他任务从队列中删除，调用方需要持有 q.lock
+func (q *ocrQueue) trimJobs(jobs []*OCRJob, keep int) []*OCRJob {
+	if keep >= len(jobs) {
+		return jobs
+	}
+	sortOCRJobsByUpdated(jobs)
+	for _, job := range jobs[keep:] {
+		delete(q.jobs, job.Path)
+	}
+	return jobs[:keep]
+}
+
+func (q *ocrQueue) notify() {
+	select {
+	case q.wake <- true:
+	default:
+	}
+}
+
+// enqueue 加入任务，已经在排队的任务只提高优先级，已结束的任务重新排队
+func (q *ocrQueue) enqueue(assetPaths []string, priority int) (ret []*OCRJob) {
+	q.lock.Lock()
+	now := time.Now().UnixMilli()
+	for _, p := range assetPaths {
+		job := q.jobs[p]
+		if nil != job && (OCRJobPending == job.Status || OCRJobRunning == job.Status) {
+			job.Priority = max(job.Priority, priority)
+			job.Updated = now
+		} else {
+			job = &OCRJob{Path: p, Status: OCRJobPending, Priority: priority, Created: now, Updated: now}
+			q.jobs[p] = job
+		}
+		ret = append(ret, job)
+	}
+	q.save()
+	q.lock.Unlock()
+	q.notify()
+	return
+}
+
+// next 取出下一个可以执行的任务并标记为执行中，没有时返回距离最近一次重试的等待时间
+func (q *ocrQueue) next() (ret *OCRJob, wait time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := time.Now().UnixMilli()
+	wait = time.Minute
+	var ready []*OCRJob
+	for _, job := range q.jobs {
+		if OCRJobPending != job.Status {
+			continue
+		}
+		if job.NextRun > now {
+			wait = min(wait, time.Duration(job.NextRun-now)*time.Millisecond)
+			continue
+		}
+		ready = append(ready, job)
+	}
+	if 1 > len(ready) {
+		return
+	}
+
+	sortOCRJobs(ready)
+	ret = ready[0]
+	ret.Status = OCRJobRunning
+	ret.Updated = now
+	q.save()
+	return
+}
+
+func (q *ocrQueue) run() {
+	defer logging.Recover()
+
+	for {
+		job, wait := q.next()
+		if nil == job {
+			select {
+			case <-q.wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+		q.process(job)
+	}
+}
+
+func (q *ocrQueue) process(job *OCRJob) {
+	absPath := filepath.Join(q.ctx.GetDataDir(), filepath.FromSlash(job.Path))
+	status, engineName, err := q.recognize(absPath, job.Path)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now().UnixMilli()
+	job.Updated, job.Engine, job.Error = now, engineName, ""
+	if errors.Is(err, errOCREngineUnavailable) {
+		// 引擎不可用时不计入重试次数，等待服务恢复
+		job.Status, job.Error, job.NextRun = OCRJobPending, err.Error(), now+time.Minute.Milliseconds()
+	} else if errors.Is(err, errOCRAssetNotFound) {
+		job.Status, job.Error = OCRJobFailed, err.Error()
+	} else if nil != err {
+		job.Attempts++
+		job.Error = err.Error()
+		if ocrJobMaxAttempts <= job.Attempts {
+			job.Status = OCRJobFailed
+			logging.LogErrorf("OCR [%s] failed after %d attempts: %s", job.Path, job.Attempts, err)
+		} else {
+			job.Status = OCRJobPending
+			job.NextRun = now + (ocrJobRetryBackoff << (job.Attempts - 1)).Milliseconds()
+			logging.LogWarnf("OCR [%s] failed, retry later: %s", job.Path, err)
+		}
+	} else {
+		job.Status = status
+	}
+	if _, exists := q.jobs[job.Path]; exists {
+		q.save()
+	}
+}
+
+var (
+	errOCREngineUnavailable = errors.New("OCR engine unavailable")
+	errOCRAssetNotFound     = errors.New("asset not found")
+)
+
+// recognize 识别资源文件并保存结果、写入资源内容数据库、加入向量化队列
+func (q *ocrQueue) recognize(absPath, assetPath string) (status, engineName string, err error) {
+	if !gulu.File.IsExist(absPath) {
+		return "", "", errOCRAssetNotFound
+	}
+
+	ext := strings.ToLower(filepath.Ext(absPath))
+	if ".pdf" == ext && !NeedOCR(absPath) {
+		return OCRJobSkipped, "", nil
+	}
+
+	engine := newOCREngine(q.ctx)
+	engineName = engine.Name()
+	if ok, msg := engine.Available(); !ok {
+		return "", engineName, fmt.Errorf("%w: %s", errOCREngineUnavailable, msg)
+	}
+	if ".pdf" != ext && !engine.Supports(ext) {
+		return OCRJobSkipped, engineName, nil
+	}
+
+	result, err := ocrAssetFile(engine, absPath)
+	if nil != err {
+		return "", engineName, err
+	}
+	dataDir := q.ctx.GetDataDir()
+	if err = saveOCRResult(dataDir, assetPath, result); nil != err {
+		return "", engineName, err
+	}
+	if "" != result.FullText {
+		indexOCRAssetContent(q.ctx, absPath, assetPath, result.FullText)
+		EnqueueAssetVectorize(absPath)
+	}
+	return OCRJobDone, engineName, nil
+}
+
+// isOCRQueueAsset 检查资源文件是否需要加入文字识别队列：图片和 PDF
+func isOCRQueueAsset(p string) bool {
+	return ".pdf" == strings.ToLower(filepath.Ext(p)) || isOCRImage(p)
+}
+
+// normalizeOCRAssetPath 把 API 传入的路径统一为 assets/ 开头的相对路径，路径不在工作空间的资源文件夹下时返回错误
+func normalizeOCRAssetPath(ctx *WorkspaceContext, p string) (ret string, err error) {
+	dataDir := ctx.GetDataDir()
+	p = filepath.ToSlash(p)
+	if slashDataDir := filepath.ToSlash(dataDir); strings.HasPrefix(p, slashDataDir+"/") {
+		p = strings.TrimPrefix(p, slashDataDir+"/")
+	}
+	p = strings.TrimPrefix(p, "/")
+	if !strings.HasPrefix(p, "assets/") {
+		p = path.Join("assets", p)
+	}
+
+	absPath, err := filepath.Abs(filepath.Join(dataDir, filepath.FromSlash(p)))
+	if nil != err {
+		return
+	}
+	absDataDir, err := filepath.Abs(dataDir)
+	if nil != err {
+		return
+	}
+	if !util.IsSubPath(filepath.Join(absDataDir, "assets"), absPath) {
+		return "", errors.New("invalid asset path: " + p)
+	}
+	ret = toOCRAssetPath(absDataDir, absPath)
+	return
+}
+
+// enqueueUploadedAssetOCR 上传的图片和 PDF 自动加入文字识别队列
+func enqueueUploadedAssetOCR(ctx *WorkspaceContext, absPath string) {
+	if !isOCRQueueAsset(absPath) {
+		return
+	}
+	if nil == ctx {
+		ctx = GetDefaultWorkspaceContext()
+	}
+	getOCRQueue(ctx).enqueue([]string{toOCRAssetPath(ctx.GetDataDir(), absPath)}, 0)
+}
+
+// EnqueueOCRAssetsWithContext 把资源文件加入文字识别队列
+func EnqueueOCRAssetsWithContext(ctx *WorkspaceContext, assetPaths []string, priority int) (ret []*OCRJob, err error) {
+	var paths []string
+	for _, p := range assetPaths {
+		if p, err = normalizeOCRAssetPath(ctx, p); nil != err {
+			return
+		}
+		if !isOCRQueueAsset(p) {
+			return nil, errors.New("only images and PDF files can be recognized: " + p)
+		}
+		if !gulu.File.IsExist(filepath.Join(ctx.GetDataDir(), filepath.FromSlash(p))) {
+			return nil, errors.New("asset not found: " + p)
+		}
+		paths = append(paths, p)
+	}
+	if 1 > len(paths) {
+		return nil, errors.New("no assets to recognize")
+	}
+
+	jobs := getOCRQueue(ctx).enqueue(paths, priority)
+	for _, job := range jobs {
+		copied := *job
+		ret = append(ret, &copied)
+	}
+	return
+}
+
+// RetryOCRJobsWithContext 立即重试失败的任务，assetPaths 为空时重试所有失败的任务
+func RetryOCRJobsWithContext(ctx *WorkspaceContext, assetPaths []string) (count int) {
+	q := getOCRQueue(ctx)
+	q.lock.Lock()
+	targets := map[string]bool{}
+	for _, p := range assetPaths {
+		if assetPath, err := normalizeOCRAssetPath(ctx, p); nil == err {
+			targets[assetPath] = true
+		}
+	}
+	if 0 < len(assetPaths) && 1 > len(targets) {
+		q.lock.Unlock()
+		return
+	}
+	now := time.Now().UnixMilli()
+	for _, job := range q.jobs {
+		if 0 < len(targets) && !targets[job.Path] {
+			continue
+		}
+		if OCRJobFailed != job.Status && (OCRJobPending != job.Status || 0 == job.NextRun) {
+			continue
+		}
+		job.Status, job.Attempts, job.NextRun, job.Updated = OCRJobPending, 0, 0, now
+		count++
+	}
+	if 0 < count {
+		q.save()
+	}
+	q.lock.Unlock()
+	q.notify()
+	return
+}
+
+// SetOCRJobPriorityWithContext 调整排队中任务的优先级
+func SetOCRJobPriorityWithContext(ctx *WorkspaceContext, assetPath string, priority int) error {
+	assetPath, err := normalizeOCRAssetPath(ctx, assetPath)
+	if nil != err {
+		return err
+	}
+
+	q := getOCRQueue(ctx)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	job := q.jobs[assetPath]
+	if nil == job {
+		return errors.New("OCR job not found")
+	}
+	if OCRJobPending != job.Status {
+		return errors.New("only pending OCR jobs can be reprioritized")
+	}
+	job.Priority = priority
+	job.Updated = time.Now().UnixMilli()
+	q.save()
+	return nil
+}
+
+// OCRQueueStatus 文字识别队列状态
+type OCRQueueStatus struct {
+	Engine    string         `json:"engine"`
+	Available bool           `json:"available"`
+	Message   string         `json:"message"`
+	Counts    map[string]int `json:"counts"`
+	Jobs      []*OCRJob      `json:"jobs"`
+}
+
+// GetOCRQueueStatusWithContext 获取文字识别队列状态，status 不为空时只返回该状态的任务
+func GetOCRQueueStatusWithContext(ctx *WorkspaceContext, status string) (ret *OCRQueueStatus) {
+	engine := newOCREngine(ctx)
+	ret = &OCRQueueStatus{Engine: engine.Name(), Counts: map[string]int{}, Jobs: []*OCRJob{}}
+	ret.Available, ret.Message = engine.Available()
+
+	q := getOCRQueue(ctx)
+	q.lock.Lock()
+	for _, job := range q.jobs {
+		ret.Counts[job.Status]++
+		if "" == status || status == job.Status {
+			copied := *job
+			ret.Jobs = append(ret.Jobs, &copied)
+		}
+	}
+	q.lock.Unlock()
+	sortOCRJobs(ret.Jobs)
+	return
+}
+
+// sortOCRJobs 按优先级从高到低、创建时间从早到晚排序
+func sortOCRJobs(jobs []*OCRJob) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if jobs[i].Priority != jobs[j].Priority {
+			return jobs[i].Priority > jobs[j].Priority
+		}
+		return jobs[i].Created < jobs[j].Created
+	})
+}
+
+func sortOCRJobsByUpdated(jobs []*OCRJob) {
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Updated > jobs[j].Updated })
+}
+
+// getAssetDataDir 返回资源文件所在工作空间的数据目录，不在已加载队列的工作空间中时使用默认数据目录
+func getAssetDataDir(assetAbsPath string) string {
+	ocrQueuesLock.Lock()
+	defer ocrQueuesLock.Unlock()
+	for dataDir := range ocrQueues {
+		if util.IsSubPath(filepath.Join(dataDir, "assets"), assetAbsPath) {
+			return dataDir
+		}
+	}
+	return util.DataDir
+}
+
+// removeOCRAsset 资源文件删除后移除识别结果和队列中的任务
+func removeOCRAsset(dataDir, assetPath string) {
+	removeOCRResult(dataDir, assetPath)
+
+	ocrQueuesLock.Lock()
+	q := ocrQueues[dataDir]
+	ocrQueuesLock.Unlock()
+	if nil == q {
+		return
+	}
+	q.lock.Lock()
+	if _, ok := q.jobs[assetPath]; ok {
+		delete(q.jobs, assetPath)
+		q.save()
+	}
+	q.lock.Unlock()
+}
@@ -0,0 +1,251 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/siyuan-note/siyuan/kernel/sql"
+)
+
+// OpenAI 兼容接口，模型名称作为别名用于选择 RAG 检索范围：
+//
+//	siyuan                     检索所有笔记
+//	siyuan-none                不使用 RAG，直接转发到上游模型
+//	siyuan-notebook-{boxID}    仅检索指定笔记本
+const (
+	OpenAICompatModelAll          = "siyuan"
+	OpenAICompatModelNone         = "siyuan-none"
+	OpenAICompatModelNotebookPref = "siyuan-notebook-"
+)
+
+var ErrOpenAICompatModelNotFound = errors.New("model not found")
+
+// OpenAICompatScope 别名解析后的 RAG 范围
+type OpenAICompatScope struct {
+	Model    string // 客户端请求的模型别名
+	RAG      bool   // 是否使用 RAG
+	Notebook string // 为空时检索所有笔记
+
+	provider string // 上游服务商，用于记录用量
+}
+
+// ParseOpenAICompatModel 解析模型别名
+func ParseOpenAICompatModel(ctx *WorkspaceContext, model string) (ret *OpenAICompatScope, err error) {
+	ret = &OpenAICompatScope{Model: model}
+	switch {
+	case "" == model || OpenAICompatModelAll == model:
+		ret.Model = OpenAICompatModelAll
+		ret.RAG = true
+	case OpenAICompatModelNone == model:
+	case strings.HasPrefix(model, OpenAICompatModelNotebookPref):
+		boxID := strings.TrimPrefix(model, OpenAICompatModelNotebookPref)
+		if nil == Conf.BoxWithContext(ctx, boxID) {
+			return nil, ErrOpenAICompatModelNotFound
+		}
+		ret.RAG = true
+		ret.Notebook = boxID
+	default:
+		return nil, ErrOpenAICompatModelNotFound
+	}
+	return
+}
+
+// ListOpenAICompatModels 列出可用的模型别名，每个打开的笔记本对应一个别名
+func ListOpenAICompatModels(ctx *WorkspaceContext) (ret []openai.Model) {
+	created := time.Now().Unix()
+	ret = append(ret,
+		openai.Model{ID: OpenAICompatModelAll, Object: "model", CreatedAt: created, OwnedBy: "siyuan"},
+		openai.Model{ID: OpenAICompatModelNone, Object: "model", CreatedAt: created, OwnedBy: "siyuan"})
+	for _, box := range Conf.GetOpenedBoxesWithContext(ctx) {
+		ret = append(ret, openai.Model{ID: OpenAICompatModelNotebookPref + box.ID, Object: "model", CreatedAt: created, OwnedBy: "siyuan"})
+	}
+	return
+}
+
+// prepareOpenAICompatRequest 按别名注入 RAG 上下文，并将请求改写为上游模型的请求
+func prepareOpenAICompatRequest(ctx *WorkspaceContext, req *openai.ChatCompletionRequest) (client *openai.Client, scope *OpenAICompatScope, err error) {
+	if !isOpenAIAPIEnabled(ctx) {
+		return nil, nil, errors.New("AI not enabled")
+	}
+
+	scope, err = ParseOpenAICompatModel(ctx, req.Model)
+	if nil != err {
+		return
+	}
+	if err = CheckAIBudget(ctx); nil != err {
+		return
+	}
+
+	for i, msg := range req.Messages {
+		// RAG 只使用纯文本内容检索，多段内容中的文本在这里合并
+		if "" == msg.Content && 0 < len(msg.MultiContent) && "user" == msg.Role {
+			var texts []string
+			for _, part := range msg.MultiContent {
+				if openai.ChatMessagePartTypeText == part.Type {
+					texts = append(texts, part.Text)
+				}
+			}
+			if len(texts) == len(msg.MultiContent) {
+				req.Messages[i].Content = strings.Join(texts, "\n")
+				req.Messages[i].MultiContent = nil
+			}
+		}
+	}
+
+	if scope.RAG {
+		var allowedAssets []string
+		if "" != scope.Notebook {
+			allowedAssets = getNotebookAssetPaths(ctx, scope.Notebook)
+		}
+		// 笔记本中没有资源时不做检索，避免空过滤条件退化为检索所有笔记
+		if "" == scope.Notebook || 0 < len(allowedAssets) {
+			req.Messages = EnhanceMessagesWithRAGContext(ctx, req.Messages, allowedAssets)
+		}
+	}
+
+	client, provider, apiModel, maxTokens, temperature := getEffectiveAIConfig(ctx)
+	scope.provider = provider
+	req.Model = apiModel
+	if 1 > req.MaxTokens && 1 > req.MaxCompletionTokens && 0 < maxTokens {
+		req.MaxTokens = maxTokens
+	}
+	if 0 == req.Temperature {
+		req.Temperature = float32(temperature)
+	}
+	return
+}
+
+func getNotebookAssetPaths(ctx *WorkspaceContext, boxID string) (ret []string) {
+	stmt := fmt.Sprintf("SELECT DISTINCT path FROM assets WHERE box = '%s'", strings.ReplaceAll(boxID, "'", "''"))
+	rows, err := sql.QueryWithContext(ctx, stmt, 4096)
+	if nil != err {
+		return
+	}
+	for _, row := range rows {
+		if p, ok := row["path"].(string); ok && "" != p {
+			ret = append(ret, p)
+		}
+	}
+	return
+}
+
+// OpenAICompatChatCompletion 非流式的 OpenAI 兼容对话
+func OpenAICompatChatCompletion(ctx *WorkspaceContext, req openai.ChatCompletionRequest) (ret openai.ChatCompletionResponse, err error) {
+	req.Stream = false
+	req.StreamOptions = nil
+	client, scope, err := prepareOpenAICompatRequest(ctx, &req)
+	if nil != err {
+		return
+	}
+
+	ret, err = client.CreateChatCompletion(context.Background(), req)
+	if nil != err {
+		return
+	}
+	var completion string
+	if 0 < len(ret.Choices) {
+		completion = ret.Choices[0].Message.Content
+	}
+	recordAIChatUsage(ctx, AIFeatureOpenAI, scope.provider, req.Model, &ret.Usage, req.Messages, completion)
+	ret.Model = scope.Model
+	return
+}
+
+// OpenAICompatChatCompletionStream 流式的 OpenAI 兼容对话，每收到一个上游分片调用一次 onChunk
+func OpenAICompatChatCompletionStream(ctx *WorkspaceContext, req openai.ChatCompletionRequest, onChunk func(chunk *openai.ChatCompletionStreamResponse) error) (err error) {
+	req.Stream = true
+	// 上游总是返回用量用于计量，客户端没有要求时不转发用量分片
+	clientWantsUsage := nil != req.StreamOptions && req.StreamOptions.IncludeUsage
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	client, scope, err := prepareOpenAICompatRequest(ctx, &req)
+	if nil != err {
+		return
+	}
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), req)
+	if nil != err {
+		return
+	}
+	defer stream.Close()
+
+	var usage *openai.Usage
+	completion := &strings.Builder{}
+	defer func() {
+		recordAIChatUsage(ctx, AIFeatureOpenAI, scope.provider, req.Model, usage, req.Messages, completion.String())
+	}()
+
+	for {
+		chunk, recvErr := stream.Recv()
+		if errors.Is(recvErr, io.EOF) {
+			return nil
+		}
+		if nil != recvErr {
+			return recvErr
+		}
+
+		if nil != chunk.Usage {
+			usage = chunk.Usage
+			if !clientWantsUsage {
+				if 1 > len(chunk.Choices) {
+					continue
+				}
+				chunk.Usage = nil
+			}
+		}
+		for _, choice := range chunk.Choices {
+			completion.WriteString(choice.Delta.Content)
+		}
+
+		chunk.Model = scope.Model
+		if err = onChunk(&chunk); nil != err {
+			return
+		}
+	}
+}
+
+// OpenAICompatEmbeddings 使用已配置的向量化服务生成嵌入
+func OpenAICompatEmbeddings(ctx *WorkspaceContext, inputs []string) (ret []openai.Embedding, err error) {
+	if err = CheckAIBudget(ctx); nil != err {
+		return
+	}
+
+	embeddingService := NewEmbeddingServiceWithContext(ctx)
+	if nil == embeddingService || !embeddingService.IsEnabled() {
+		return nil, errors.New("embedding service is not enabled")
+	}
+
+	for i, input := range inputs {
+		vector, vecErr := embeddingService.VectorizeText(input)
+		if nil != vecErr {
+			return nil, vecErr
+		}
+
+		embedding := make([]float32, len(vector))
+		for j, v := range vector {
+			embedding[j] = float32(v)
+		}
+		ret = append(ret, openai.Embedding{Object: "embedding", Index: i, Embedding: embedding})
+	}
+	return
+}
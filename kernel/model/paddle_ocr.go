@@ -18,6 +18,8 @@ import (
 
 	"github.com/88250/gulu"
 	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/conf"
+	"github.com/siyuan-note/siyuan/kernel/util"
 )
 
 // PaddleOCR 配置
@@ -42,41 +44,32 @@ type PaddleOCRURLRequest struct {
 	URL string `json:"url"`
 }
 
-// PaddleOCRResult OCR 识别结果 (适配 Umi-OCR)
-type PaddleOCRResult struct {
-	Text       string      `json:"text"`
-	Confidence float64     `json:"score"`
-	Position   [][]float64 `json:"box"`
-}
-
 // PaddleOCRResponse OCR 响应结构 (适配 Umi-OCR)
 type PaddleOCRResponse struct {
-	Code    int               `json:"code"`
-	Data    []PaddleOCRResult `json:"data"`
-	Message string            `json:"msg"`
+	Code    int             `json:"code"`
+	Data    []*OCRTextBlock `json:"data"`
+	Message string          `json:"msg"`
 }
 
-// OCRAssetResult OCR 资源文件结果（保存到 .ocr.json）
+// OCRAssetResult 资源文件的 OCR 结果，保存在工作空间的 storage/ocr/results/ 下
 type OCRAssetResult struct {
-	ID         string            `json:"id"`
-	AssetPath  string            `json:"assetPath"`
-	FileName   string            `json:"fileName"`
-	FileType   string            `json:"fileType"`
-	OCRResults []PaddleOCRResult `json:"ocrResults"`
-	FullText   string            `json:"fullText"`
-	PageCount  int               `json:"pageCount"`
-	UpdatedAt  time.Time         `json:"updatedAt"`
+	ID         string          `json:"id"`
+	AssetPath  string          `json:"assetPath"`
+	FileName   string          `json:"fileName"`
+	FileType   string          `json:"fileType"`
+	Engine     string          `json:"engine"`
+	OCRResults []*OCRTextBlock `json:"ocrResults"`
+	FullText   string          `json:"fullText"`
+	PageCount  int             `json:"pageCount"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
 }
 
-// getPaddleOCRConfig 获取 PaddleOCR 配置
-func getPaddleOCRConfig() *PaddleOCRConfig {
-	// 从配置文件读取，如果不存在则使用默认配置
-	configPath := "/root/code/neu-siyuan-note/config/ocr-config.json"
-	data, err := os.ReadFile(configPath)
-	if err == nil {
-		var config PaddleOCRConfig
-		if err := json.Unmarshal(data, &config); err == nil && config.BaseURL != "" {
-			return &config
+// getPaddleOCRConfig 获取 PaddleOCR 配置，使用用户的 OCR 路由
+func getPaddleOCRConfig(ctx *WorkspaceContext) *PaddleOCRConfig {
+	if ep := ResolveAIEndpoint(ctx, conf.AITaskOCR); nil != ep && conf.AIProviderTypeTesseract != ep.Provider.Type {
+		return &PaddleOCRConfig{
+			BaseURL: strings.TrimSuffix(ep.Provider.BaseURL, "/"),
+			Enabled: true,
 		}
 	}
 
@@ -87,17 +80,22 @@ func getPaddleOCRConfig() *PaddleOCRConfig {
 	}
 }
 
-// PaddleOCRHealthCheck 检查 PaddleOCR 服务状态
-func PaddleOCRHealthCheck() (bool, string) {
-	config := getPaddleOCRConfig()
-	if !config.Enabled {
-		return false, "OCR 服务未启用"
-	}
+// PaddleOCREngine PaddleOCR / Umi-OCR HTTP 服务
+type PaddleOCREngine struct {
+	baseURL string
+	timeout time.Duration
+}
+
+func (engine *PaddleOCREngine) Name() string {
+	return "paddleocr"
+}
 
+// Available 检查 PaddleOCR 服务状态
+func (engine *PaddleOCREngine) Available() (bool, string) {
 	// Umi-OCR 没有 /health 端点，改为检查根路径
 	// 增加超时时间到 30 秒，因为 OCR 服务可能正在处理其他请求
 	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get(config.BaseURL + "/")
+	resp, err := client.Get(engine.baseURL + "/")
 	if err != nil {
 		return false, fmt.Sprintf("OCR 服务连接失败: %v", err)
 	}
@@ -111,13 +109,24 @@ func PaddleOCRHealthCheck() (bool, string) {
 	return false, fmt.Sprintf("OCR 服务状态异常: %d", resp.StatusCode)
 }
 
-// PaddleOCRFromBase64 使用 base64 图片进行 OCR
-func PaddleOCRFromBase64(base64Image string) (*PaddleOCRResponse, error) {
-	config := getPaddleOCRConfig()
-	if !config.Enabled {
-		return nil, fmt.Errorf("OCR 服务未启用")
+func (engine *PaddleOCREngine) Supports(ext string) bool {
+	return gulu.Str.Contains(strings.ToLower(ext), ocrImageExts)
+}
+
+func (engine *PaddleOCREngine) Recognize(imgAbsPath string) ([]*OCRTextBlock, error) {
+	data, err := os.ReadFile(imgAbsPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %v", err)
+	}
+
+	resp, err := engine.recognizeBase64(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		return nil, err
 	}
+	return resp.Data, nil
+}
 
+func (engine *PaddleOCREngine) recognizeBase64(base64Image string) (*PaddleOCRResponse, error) {
 	// 构建 JSON 请求
 	reqBody := PaddleOCRRequest{Base64: base64Image}
 	jsonData, err := json.Marshal(reqBody)
@@ -125,9 +134,9 @@ func PaddleOCRFromBase64(base64Image string) (*PaddleOCRResponse, error) {
 		return nil, fmt.Errorf("序列化请求失败: %v", err)
 	}
 
-	client := &http.Client{Timeout: PaddleOCRTimeout}
+	client := &http.Client{Timeout: engine.timeout}
 	resp, err := client.Post(
-		config.BaseURL+"/api/ocr",
+		engine.baseURL+"/api/ocr",
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -158,181 +167,43 @@ func PaddleOCRFromBase64(base64Image string) (*PaddleOCRResponse, error) {
 	return &ocrResp, nil
 }
 
+// PaddleOCRHealthCheck 检查 PaddleOCR 服务状态
+func PaddleOCRHealthCheck(ctx *WorkspaceContext) (bool, string) {
+	config := getPaddleOCRConfig(ctx)
+	return (&PaddleOCREngine{baseURL: config.BaseURL, timeout: PaddleOCRTimeout}).Available()
+}
+
+// PaddleOCRFromBase64 使用 base64 图片进行 OCR
+func PaddleOCRFromBase64(ctx *WorkspaceContext, base64Image string) (*PaddleOCRResponse, error) {
+	config := getPaddleOCRConfig(ctx)
+	return (&PaddleOCREngine{baseURL: config.BaseURL, timeout: PaddleOCRTimeout}).recognizeBase64(base64Image)
+}
+
 // PaddleOCRFromFile 从文件进行 OCR
-func PaddleOCRFromFile(filePath string) (*PaddleOCRResponse, error) {
+func PaddleOCRFromFile(ctx *WorkspaceContext, filePath string) (*PaddleOCRResponse, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("读取文件失败: %v", err)
 	}
 
 	base64Image := base64.StdEncoding.EncodeToString(data)
-	return PaddleOCRFromBase64(base64Image)
+	return PaddleOCRFromBase64(ctx, base64Image)
 }
 
-// getOCRFilePath 获取 OCR 结果文件路径
-func getOCRFilePath(assetPath string) string {
-	return assetPath + ".ocr.json"
-}
-
-// saveOCRResult 保存 OCR 结果
-// 优化后的流程: 只保存 Markdown,不保存 JSON
-// 这样可以避免索引混乱,减少存储空间
-func saveOCRResult(result *OCRAssetResult) error {
-	// 直接生成并保存 Markdown 文档
-	if err := saveOCRAsMarkdown(result); err != nil {
-		return fmt.Errorf("生成 Markdown 文档失败: %v", err)
-	}
-	
-	logging.LogInfof("已保存 OCR 结果为 Markdown: %s.md", result.AssetPath)
-	return nil
-}
-
-// saveOCRAsMarkdown 将 OCR 结果保存为 Markdown 格式
-func saveOCRAsMarkdown(result *OCRAssetResult) error {
-	// 生成 Markdown 文件路径：源文件名 + .md
-	mdPath := result.AssetPath + ".md"
-	
-	// 清理文件名用于标题
-	cleanName := result.FileName
-	cleanName = strings.ReplaceAll(cleanName, "amp;40;", "(")
-	cleanName = strings.ReplaceAll(cleanName, "amp;41;", ")")
-	
-	var content strings.Builder
-	
-	// 添加文档头部
-	content.WriteString(fmt.Sprintf("# %s\n\n", cleanName))
-	content.WriteString("> 本文档由 OCR 识别结果自动生成\n\n")
-	content.WriteString("---\n\n")
-	
-	// 处理文本内容
-	texts := make([]string, 0, len(result.OCRResults))
-	for _, item := range result.OCRResults {
-		text := strings.TrimSpace(item.Text)
-		if text != "" {
-			texts = append(texts, text)
-		}
-	}
-	
-	// 格式化文本为 Markdown
-	currentParagraph := make([]string, 0)
-	
-	for i, text := range texts {
-		// 判断是否为标题
-		isTitle := false
-		titleLevel := 0
-		
-		if len(text) < 50 {
-			// 检查章节标题（如 1.1、1.2.3 等）
-			if len(text) > 0 && text[0] >= '0' && text[0] <= '9' {
-				if len(text) < 3 || text[1] == ' ' || text[1] == '.' {
-					isTitle = true
-					titleLevel = 1
-				} else if len(text) >= 3 {
-					// 安全地检查前几个字符中是否包含点号
-					checkLen := len(text)
-					if checkLen > 5 {
-						checkLen = 5
-					}
-					if strings.Contains(text[:checkLen], ".") {
-						dotCount := strings.Count(text[:checkLen], ".")
-						if dotCount == 1 {
-							titleLevel = 2
-						} else if dotCount >= 2 {
-							titleLevel = 3
-						}
-						isTitle = true
-					}
-				}
-			}
-			
-			// 关键词标题
-			keywords := []string{"项目", "编制", "评价", "概况", "分析", "措施", "结论", "依据", "目的", "等级", "范围", "保护", "因子"}
-			for _, keyword := range keywords {
-				if strings.Contains(text, keyword) && !strings.Contains(text, "。") && len(text) < 30 {
-					isTitle = true
-					if titleLevel == 0 {
-						titleLevel = 2
-					}
-					break
-				}
-			}
-		}
-		
-		// 检查是否为列表项
-		isListItem := false
-		if strings.HasPrefix(text, "(") || strings.HasPrefix(text, "（") ||
-			strings.HasPrefix(text, "①") || strings.HasPrefix(text, "②") ||
-			strings.HasPrefix(text, "③") || strings.HasPrefix(text, "④") ||
-			strings.HasPrefix(text, "⑤") || strings.HasPrefix(text, "⑥") ||
-			strings.HasPrefix(text, "⑦") || strings.HasPrefix(text, "⑧") ||
-			strings.HasPrefix(text, "⑨") || strings.HasPrefix(text, "⑩") {
-			isListItem = true
-		}
-		
-		if isTitle {
-			// 输出之前的段落
-			if len(currentParagraph) > 0 {
-				content.WriteString(strings.Join(currentParagraph, ""))
-				content.WriteString("\n\n")
-				currentParagraph = currentParagraph[:0]
-			}
-			
-			// 添加标题
-			switch titleLevel {
-			case 1:
-				content.WriteString(fmt.Sprintf("\n## %s\n\n", text))
-			case 2:
-				content.WriteString(fmt.Sprintf("\n### %s\n\n", text))
-			case 3:
-				content.WriteString(fmt.Sprintf("\n#### %s\n\n", text))
-			default:
-				content.WriteString(fmt.Sprintf("\n**%s**\n\n", text))
-			}
-		} else if isListItem {
-			// 输出之前的段落
-			if len(currentParagraph) > 0 {
-				content.WriteString(strings.Join(currentParagraph, ""))
-				content.WriteString("\n\n")
-				currentParagraph = currentParagraph[:0]
-			}
-			
-			// 添加列表项
-			content.WriteString(fmt.Sprintf("- %s\n", text))
-		} else {
-			// 普通文本
-			currentParagraph = append(currentParagraph, text)
-			
-			// 如果文本以句号、分号结尾，或者下一个是标题，则结束段落
-			if strings.HasSuffix(text, "。") || strings.HasSuffix(text, "；") ||
-				(i < len(texts)-1 && len(texts[i+1]) < 30) {
-				content.WriteString(strings.Join(currentParagraph, ""))
-				content.WriteString("\n\n")
-				currentParagraph = currentParagraph[:0]
-			}
-		}
-	}
-	
-	// 输出最后的段落
-	if len(currentParagraph) > 0 {
-		content.WriteString(strings.Join(currentParagraph, ""))
-		content.WriteString("\n")
+// loadOCRResult 加载 OCR 结果，兼容旧版本在资源文件旁边生成的 Markdown 文件
+func loadOCRResult(absPath string) (*OCRAssetResult, error) {
+	if text := getAssetOCRText(absPath); "" != text {
+		return &OCRAssetResult{
+			AssetPath: absPath,
+			FileName:  filepath.Base(absPath),
+			FileType:  filepath.Ext(absPath),
+			FullText:  text,
+		}, nil
 	}
-	
-	// 写入 Markdown 文件
-	if err := os.WriteFile(mdPath, []byte(content.String()), 0644); err != nil {
-		return fmt.Errorf("写入 Markdown 文件失败: %v", err)
-	}
-	
-	logging.LogInfof("已生成 Markdown 文档: %s", mdPath)
-	return nil
-}
 
-// loadOCRResult 加载 OCR 结果
-// 优化后: 从 Markdown 文件读取,而不是 JSON
-func loadOCRResult(assetPath string) (*OCRAssetResult, error) {
-	mdPath := assetPath + ".md"
+	mdPath := absPath + ".md"
 	if !gulu.File.IsExist(mdPath) {
-		return nil, fmt.Errorf("Markdown 文件不存在")
+		return nil, fmt.Errorf("OCR 结果不存在")
 	}
 
 	data, err := os.ReadFile(mdPath)
@@ -340,17 +211,14 @@ func loadOCRResult(assetPath string) (*OCRAssetResult, error) {
 		return nil, err
 	}
 
-	// 从 Markdown 提取文本内容
-	content := string(data)
-	
 	// 移除 Markdown 标记,提取纯文本
-	lines := strings.Split(content, "\n")
+	lines := strings.Split(string(data), "\n")
 	var textLines []string
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		// 跳过标题行、分隔线、引用等
-		if line == "" || strings.HasPrefix(line, "#") || 
-		   strings.HasPrefix(line, ">") || strings.HasPrefix(line, "---") {
+		if line == "" || strings.HasPrefix(line, "#") ||
+			strings.HasPrefix(line, ">") || strings.HasPrefix(line, "---") {
 			continue
 		}
 		// 移除列表标记
@@ -359,90 +227,49 @@ func loadOCRResult(assetPath string) (*OCRAssetResult, error) {
 			textLines = append(textLines, line)
 		}
 	}
-	
-	fullText := strings.Join(textLines, "\n")
-	fileName := filepath.Base(assetPath)
-	
+
 	result := &OCRAssetResult{
-		ID:        gulu.Rand.String(16),
-		AssetPath: assetPath,
-		FileName:  fileName,
-		FileType:  filepath.Ext(assetPath),
-		FullText:  fullText,
-		UpdatedAt: time.Now(),
+		AssetPath: absPath,
+		FileName:  filepath.Base(absPath),
+		FileType:  filepath.Ext(absPath),
+		FullText:  strings.Join(textLines, "\n"),
 	}
-
 	return result, nil
 }
 
-// OCRAsset 对资源文件进行 OCR 识别
-// 支持图片格式：png, jpg, jpeg, bmp, gif
+// OCRAsset 对默认工作空间的资源文件进行 OCR 识别
+// 支持图片格式：png, jpg, jpeg, bmp, gif, webp, tif
 // 支持 PDF（会转换为图片后 OCR）
 func OCRAsset(assetPath string) (*OCRAssetResult, error) {
-	if !gulu.File.IsExist(assetPath) {
-		return nil, fmt.Errorf("文件不存在: %s", assetPath)
-	}
-
-	ext := strings.ToLower(filepath.Ext(assetPath))
-	fileName := filepath.Base(assetPath)
-
-	var allResults []PaddleOCRResult
-	var fullTextBuilder strings.Builder
-	pageCount := 1
-
-	switch ext {
-	case ".png", ".jpg", ".jpeg", ".bmp", ".gif", ".webp":
-		// 直接 OCR 图片
-		resp, err := PaddleOCRFromFile(assetPath)
-		if err != nil {
-			return nil, err
-		}
-		allResults = resp.Data
-		for _, r := range resp.Data {
-			fullTextBuilder.WriteString(r.Text)
-			fullTextBuilder.WriteString("\n")
-		}
-
-	case ".pdf":
-		// PDF 需要先转换为图片再 OCR
-		results, text, pages, err := ocrPDFFile(assetPath)
-		if err != nil {
-			return nil, err
-		}
-		allResults = results
-		fullTextBuilder.WriteString(text)
-		pageCount = pages
+	return OCRAssetWithContext(GetDefaultWorkspaceContext(), assetPath)
+}
 
-	default:
-		return nil, fmt.Errorf("不支持的文件格式: %s", ext)
+// OCRAssetWithContext 使用当前的 OCR 引擎识别工作空间中的资源文件，保存结果并写入资源内容数据库
+func OCRAssetWithContext(ctx *WorkspaceContext, absPath string) (*OCRAssetResult, error) {
+	engine := newOCREngine(ctx)
+	if ok, msg := engine.Available(); !ok {
+		return nil, fmt.Errorf("OCR 服务不可用: %s", msg)
 	}
 
-	// 生成资源 ID
-	h := gulu.Rand.String(16)
+	result, err := ocrAssetFile(engine, absPath)
+	if err != nil {
+		return nil, err
+	}
 
-	result := &OCRAssetResult{
-		ID:         h,
-		AssetPath:  assetPath,
-		FileName:   fileName,
-		FileType:   ext,
-		OCRResults: allResults,
-		FullText:   strings.TrimSpace(fullTextBuilder.String()),
-		PageCount:  pageCount,
-		UpdatedAt:  time.Now(),
-	}
-
-	// 保存结果
-	if err := saveOCRResult(result); err != nil {
+	dataDir := ctx.GetDataDir()
+	assetPath := toOCRAssetPath(dataDir, absPath)
+	if err := saveOCRResult(dataDir, assetPath, result); err != nil {
 		logging.LogWarnf("保存 OCR 结果失败: %v", err)
 	}
-
-	logging.LogInfof("OCR 完成: %s, 识别 %d 个文本块", fileName, len(allResults))
+	if util.IsSubPath(dataDir, absPath) && "" != result.FullText {
+		indexOCRAssetContent(ctx, absPath, assetPath, result.FullText)
+	}
 	return result, nil
 }
 
 // ocrPDFFile 对 PDF 文件进行 OCR
 // 使用 pdftoppm 将 PDF 转换为图片，然后逐页 OCR
-func ocrPDFFile(pdfPath string) ([]PaddleOCRResult, string, int, error) {
+func ocrPDFFile(engine OCREngine, pdfPath string) ([]*OCRTextBlock, string, int, error) {
 	// 创建临时目录
 	tmpDir := filepath.Join(os.TempDir(), "paddle_ocr_pdf", gulu.Rand.String(8))
 	if err := os.MkdirAll(tmpDir, 0755); err != nil {
@@ -484,7 +311,7 @@ func ocrPDFFile(pdfPath string) ([]PaddleOCRResult, string, int, error) {
 	logging.LogInfof("PDF 转换完成，共 %d 页", len(files))
 
 	// 对每页图片进行 OCR
-	var allResults []PaddleOCRResult
+	var allResults []*OCRTextBlock
 	var fullTextBuilder strings.Builder
 	pageCount := len(files)
 
@@ -503,7 +330,7 @@ func ocrPDFFile(pdfPath string) ([]PaddleOCRResult, string, int, error) {
 	for i, imgFile := range sortedFiles {
 		logging.LogInfof("OCR 第 %d/%d 页: %s", i+1, pageCount, filepath.Base(imgFile))
 
-		resp, err := PaddleOCRFromFile(imgFile)
+		blocks, err := engine.Recognize(imgFile)
 		if err != nil {
 			logging.LogWarnf("第 %d 页 OCR 失败: %v", i+1, err)
 			continue
@@ -512,7 +339,7 @@ func ocrPDFFile(pdfPath string) ([]PaddleOCRResult, string, int, error) {
 		// 添加页码标记
 		fullTextBuilder.WriteString(fmt.Sprintf("\n--- 第 %d 页 ---\n", i+1))
 
-		for _, r := range resp.Data {
+		for _, r := range blocks {
 			allResults = append(allResults, r)
 			fullTextBuilder.WriteString(r.Text)
 			fullTextBuilder.WriteString("\n")
@@ -567,7 +394,7 @@ func ParsePDFWithOCR(pdfPath string) (string, error) {
 	}
 
 	// 检查 OCR 服务是否可用
-	healthy, msg := PaddleOCRHealthCheck()
+	healthy, msg := OCRHealthCheck()
 	if !healthy {
 		logging.LogWarnf("OCR 服务不可用: %s", msg)
 		// 返回原始提取结果（可能为空）
@@ -597,8 +424,7 @@ func GetOCRText(assetPath string) (string, error) {
 }
 
 // HasOCRResult 检查是否已有 OCR 结果
-// 优化后: 检查 Markdown 文件而不是 JSON
 func HasOCRResult(assetPath string) bool {
-	mdPath := assetPath + ".md"
-	return gulu.File.IsExist(mdPath)
+	_, err := loadOCRResult(assetPath)
+	return err == nil
 }
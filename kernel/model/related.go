@@ -0,0 +1,440 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/88250/gulu"
+	"github.com/siyuan-note/logging"
+	"github.com/siyuan-note/siyuan/kernel/sql"
+	"github.com/siyuan-note/siyuan/kernel/treenode"
+)
+
+// RelatedDoc 语义相关的文档
+type RelatedDoc struct {
+	ID         string  `json:"id"`
+	Box        string  `json:"box"`
+	HPath      string  `json:"hPath"`
+	Title      string  `json:"title"`
+	Similarity float64 `json:"similarity"`
+}
+
+const (
+	defaultRelatedDocLimit     = 10
+	defaultRelatedDocThreshold = 0.75
+)
+
+// docVector 文档向量，由文档内已向量化的块的向量取平均得到
+type docVector struct {
+	rootID string
+	box    string
+	hPath  string
+	vector []float64
+}
+
+// GetRelatedDocs 获取和指定文档语义最相关的文档，已经通过引用（正向或反向）关联的文档会被排除。
+// boxIDs 为空时只在文档所在的笔记本中查找，allBoxes 为 true 时在所有打开的笔记本中查找
+func GetRelatedDocs(ctx *WorkspaceContext, rootID string, boxIDs []string, allBoxes bool, limit int, threshold float64) (ret []*RelatedDoc, err error) {
+	ret = []*RelatedDoc{}
+	bt := getBlockTreeWithContext(ctx, rootID)
+	if nil == bt {
+		return nil, ErrBlockNotFound
+	}
+	rootID = bt.RootID
+	if 1 > limit {
+		limit = defaultRelatedDocLimit
+	}
+	if 0 >= threshold || 1 < threshold {
+		threshold = defaultRelatedDocThreshold
+	}
+	boxIDs = getSemanticScopeBoxIDs(ctx, bt.BoxID, boxIDs, allBoxes)
+
+	docVectors, err := getDocVectors(ctx)
+	if nil != err {
+		return
+	}
+	target := docVectors[rootID]
+	if nil == target {
+		return
+	}
+
+	excluded := map[string]bool{rootID: true}
+	for _, b := range sql.QueryDefRootBlocksByRefRootIDWithContext(ctx, rootID) {
+		excluded[b.ID] = true
+	}
+	for _, b := range sql.QueryRefRootBlocksByDefRootIDWithContext(ctx, rootID) {
+		excluded[b.ID] = true
+	}
+
+	for id, doc := range docVectors {
+		if excluded[id] || !gulu.Str.Contains(doc.box, boxIDs) || len(doc.vector) != len(target.vector) {
+			continue
+		}
+		similarity := dotProduct(target.vector, doc.vector)
+		if similarity < threshold {
+			continue
+		}
+		ret = append(ret, &RelatedDoc{ID: id, Box: doc.box, HPath: doc.hPath, Similarity: similarity})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Similarity > ret[j].Similarity })
+	if len(ret) > limit {
+		ret = ret[:limit]
+	}
+
+	for _, doc := range ret {
+		if b := sql.GetBlockWithContext(ctx, doc.ID); nil != b {
+			doc.Title = b.Content
+		}
+	}
+	return
+}
+
+// getSemanticScopeBoxIDs 计算语义检索的笔记本范围，只保留打开的笔记本
+func getSemanticScopeBoxIDs(ctx *WorkspaceContext, currentBoxID string, boxIDs []string, allBoxes bool) (ret []string) {
+	var opened []string
+	for _, box := range Conf.GetOpenedBoxesWithContext(ctx) {
+		opened = append(opened, box.ID)
+	}
+	if allBoxes {
+		return opened
+	}
+	if 1 > len(boxIDs) && "" != currentBoxID {
+		boxIDs = []string{currentBoxID}
+	}
+	for _, boxID := range boxIDs {
+		if gulu.Str.Contains(boxID, opened) {
+			ret = append(ret, boxID)
+		}
+	}
+	return
+}
+
+// getBlockTreeWithContext 从工作空间的块树库中获取块树，Web 模式下每个用户使用自己的块树库
+func getBlockTreeWithContext(ctx *WorkspaceContext, id string) *treenode.BlockTree {
+	if ctx.IsWebMode() {
+		return treenode.GetBlockTreeWithDBPath(id, ctx.BlockTreeDBPath)
+	}
+	return treenode.GetBlockTree(id)
+}
+
+func getBlockTreesWithContext(ctx *WorkspaceContext, ids []string) map[string]*treenode.BlockTree {
+	if ctx.IsWebMode() {
+		return treenode.GetBlockTreesWithDBPath(ids, ctx.BlockTreeDBPath)
+	}
+	return treenode.GetBlockTrees(ids)
+}
+
+// docVectorsCache 缓存的工作空间文档向量
+type docVectorsCache struct {
+	version int64
+	builtAt time.Time
+	docs    map[string]*docVector
+}
+
+// 文档移动或者重命名后块树会变化，缓存过期后按最新的块树重新聚合
+const docVectorsCacheTTL = 10 * time.Minute
+
+var (
+	docVectorsCaches    = map[string]*docVectorsCache{} // 数据目录 -> 文档向量缓存
+	docVectorsVersion   int64                           // 块向量或向量索引每次变化时递增
+	docVectorsCacheLock sync.Mutex
+)
+
+// invalidateDocVectorsCache 块向量重新生成或者向量索引切换后清空文档向量缓存
+func invalidateDocVectorsCache() {
+	docVectorsCacheLock.Lock()
+	defer docVectorsCacheLock.Unlock()
+	docVectorsVersion++
+	docVectorsCaches = map[string]*docVectorsCache{}
+}
+
+// getDocVectors 获取工作空间的文档向量，只在缓存失效后重新加载块向量并聚合
+func getDocVectors(ctx *WorkspaceContext) (ret map[string]*docVector, err error) {
+	dataDir := ctx.GetDataDir()
+	docVectorsCacheLock.Lock()
+	version := docVectorsVersion
+	if cache := docVectorsCaches[dataDir]; nil != cache && cache.version == version && docVectorsCacheTTL > time.Since(cache.builtAt) {
+		docVectorsCacheLock.Unlock()
+		return cache.docs, nil
+	}
+	docVectorsCacheLock.Unlock()
+
+	vectors, err := loadServingBlockVectors()
+	if nil != err {
+		return
+	}
+	var ids []string
+	for id := range vectors {
+		ids = append(ids, id)
+	}
+	ret = buildDocVectors(vectors, getBlockTreesWithContext(ctx, ids))
+
+	docVectorsCacheLock.Lock()
+	if version == docVectorsVersion {
+		docVectorsCaches[dataDir] = &docVectorsCache{version: version, builtAt: time.Now(), docs: ret}
+	}
+	docVectorsCacheLock.Unlock()
+	return
+}
+
+// buildDocVectors 按文档聚合块向量，结果已归一化，文档之间的余弦相似度即向量点积
+func buildDocVectors(vectors map[string]*BlockVector, blockTrees map[string]*treenode.BlockTree) (ret map[string]*docVector) {
+	ret = map[string]*docVector{}
+	for id, vector := range vectors {
+		bt := blockTrees[id]
+		if nil == bt || 1 > len(vector.Vector) {
+			continue
+		}
+		doc := ret[bt.RootID]
+		if nil == doc {
+			doc = &docVector{rootID: bt.RootID, box: bt.BoxID, hPath: bt.HPath, vector: make([]float64, len(vector.Vector))}
+			ret[bt.RootID] = doc
+		}
+		if len(doc.vector) != len(vector.Vector) {
+			// 向量维度不一致（比如切换过向量模型）时忽略该块
+			continue
+		}
+		for i, v := range vector.Vector {
+			doc.vector[i] += v
+		}
+	}
+	for rootID, doc := range ret {
+		if doc.vector = normalizeVector(doc.vector); nil == doc.vector {
+			delete(ret, rootID)
+		}
+	}
+	return
+}
+
+// normalizeVector 返回单位向量，零向量返回 nil
+func normalizeVector(vector []float64) (ret []float64) {
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	if 0 == norm {
+		return
+	}
+	norm = math.Sqrt(norm)
+	ret = make([]float64, len(vector))
+	for i, v := range vector {
+		ret[i] = v / norm
+	}
+	return
+}
+
+func dotProduct(a, b []float64) (ret float64) {
+	for i := range a {
+		ret += a[i] * b[i]
+	}
+	return
+}
+
+// DuplicateBlock 近似重复的块
+type DuplicateBlock struct {
+	ID      string `json:"id"`
+	RootID  string `json:"rootID"`
+	Box     string `json:"box"`
+	HPath   string `json:"hPath"`
+	Content string `json:"content"`
+}
+
+// DuplicateBlockCluster 一组互相近似重复的块，可以作为合并候选
+type DuplicateBlockCluster struct {
+	Blocks     []*DuplicateBlock `json:"blocks"`
+	Similarity float64           `json:"similarity"` // 组内相似度最高的一对块的相似度
+}
+
+// DuplicateBlocksJob 近似重复块聚类任务的状态和结果
+type DuplicateBlocksJob struct {
+	IsRunning bool                     `json:"isRunning"`
+	BoxIDs    []string                 `json:"boxIDs"`
+	Threshold float64                  `json:"threshold"`
+	Total     int                      `json:"total"`
+	Processed int                      `json:"processed"`
+	StartTime time.Time                `json:"startTime"`
+	EndTime   time.Time                `json:"endTime"`
+	Clusters  []*DuplicateBlockCluster `json:"clusters"`
+	Error     string                   `json:"error"`
+}
+
+const (
+	defaultDuplicateBlockThreshold = 0.92
+	minDuplicateBlockContentLen    = 16 // 过短的块（比如只有一个词的段落）不参与聚类
+)
+
+var (
+	duplicateBlocksJobs    = map[string]*DuplicateBlocksJob{} // 数据目录 -> 该工作空间的聚类任务
+	duplicateBlocksJobLock sync.RWMutex
+)
+
+// GetDuplicateBlocksJob 获取工作空间近似重复块聚类任务的进度和最近一次的结果
+func GetDuplicateBlocksJob(ctx *WorkspaceContext) DuplicateBlocksJob {
+	duplicateBlocksJobLock.RLock()
+	defer duplicateBlocksJobLock.RUnlock()
+	if job := duplicateBlocksJobs[ctx.GetDataDir()]; nil != job {
+		return *job
+	}
+	return DuplicateBlocksJob{Clusters: []*DuplicateBlockCluster{}}
+}
+
+// StartDuplicateBlocksJob 在后台对工作空间范围内已向量化的块做近似重复聚类，相似度不低于 threshold 的块归为一组
+func StartDuplicateBlocksJob(ctx *WorkspaceContext, boxIDs []string, allBoxes bool, threshold float64) (err error) {
+	if 0 >= threshold || 1 < threshold {
+		threshold = defaultDuplicateBlockThreshold
+	}
+	boxIDs = getSemanticScopeBoxIDs(ctx, "", boxIDs, allBoxes)
+	if 1 > len(boxIDs) {
+		return errors.New("no opened notebook in scope")
+	}
+
+	dataDir := ctx.GetDataDir()
+	duplicateBlocksJobLock.Lock()
+	if job := duplicateBlocksJobs[dataDir]; nil != job && job.IsRunning {
+		duplicateBlocksJobLock.Unlock()
+		return errors.New("duplicate blocks job is running")
+	}
+	job := &DuplicateBlocksJob{IsRunning: true, BoxIDs: boxIDs, Threshold: threshold, StartTime: time.Now(), Clusters: []*DuplicateBlockCluster{}}
+	duplicateBlocksJobs[dataDir] = job
+	duplicateBlocksJobLock.Unlock()
+
+	go func() {
+		clusters, clusterErr := clusterDuplicateBlocks(ctx, job, boxIDs, threshold)
+
+		duplicateBlocksJobLock.Lock()
+		defer duplicateBlocksJobLock.Unlock()
+		job.IsRunning = false
+		job.EndTime = time.Now()
+		if nil != clusterErr {
+			job.Error = clusterErr.Error()
+			logging.LogErrorf("cluster duplicate blocks failed: %s", clusterErr)
+			return
+		}
+		job.Clusters = clusters
+		logging.LogInfof("clustered [%d] duplicate block groups in [%d] notebooks, elapsed [%s]", len(clusters), len(boxIDs), time.Since(job.StartTime))
+	}()
+	return
+}
+
+func clusterDuplicateBlocks(ctx *WorkspaceContext, job *DuplicateBlocksJob, boxIDs []string, threshold float64) (ret []*DuplicateBlockCluster, err error) {
+	vectors, err := loadServingBlockVectors()
+	if nil != err {
+		return
+	}
+
+	var ids []string
+	for id := range vectors {
+		ids = append(ids, id)
+	}
+	blockTrees := getBlockTreesWithContext(ctx, ids)
+
+	var candidates []*BlockVector
+	for _, id := range ids {
+		vector, bt := vectors[id], blockTrees[id]
+		if nil == bt || "d" == bt.Type || !gulu.Str.Contains(bt.BoxID, boxIDs) {
+			continue
+		}
+		if minDuplicateBlockContentLen > len([]rune(vector.Content)) || 1 > len(vector.Vector) {
+			continue
+		}
+		candidates = append(candidates, vector)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+
+	// 预先归一化，两两比较时只需要计算点积
+	normalized := make([][]float64, len(candidates))
+	for i, candidate := range candidates {
+		normalized[i] = normalizeVector(candidate.Vector)
+	}
+
+	duplicateBlocksJobLock.Lock()
+	job.Total = len(candidates)
+	duplicateBlocksJobLock.Unlock()
+
+	// 两两比较后用并查集合并相似的块
+	parents := make([]int, len(candidates))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	maxSimilarity := map[int]float64{}
+	for i := 0; i < len(candidates); i++ {
+		if nil == normalized[i] {
+			continue
+		}
+		for j := i + 1; j < len(candidates); j++ {
+			if len(normalized[i]) != len(normalized[j]) {
+				continue
+			}
+			similarity := dotProduct(normalized[i], normalized[j])
+			if similarity < threshold {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			if ri != rj {
+				parents[rj] = ri
+				maxSimilarity[ri] = max(maxSimilarity[ri], maxSimilarity[rj])
+			}
+			maxSimilarity[ri] = max(maxSimilarity[ri], similarity)
+		}
+
+		if 0 == i%100 {
+			duplicateBlocksJobLock.Lock()
+			job.Processed = i
+			duplicateBlocksJobLock.Unlock()
+		}
+	}
+
+	groups := map[int]*DuplicateBlockCluster{}
+	for i, vector := range candidates {
+		root := find(i)
+		group := groups[root]
+		if nil == group {
+			group = &DuplicateBlockCluster{Similarity: maxSimilarity[root]}
+			groups[root] = group
+		}
+		bt := blockTrees[vector.ID]
+		group.Blocks = append(group.Blocks, &DuplicateBlock{ID: vector.ID, RootID: bt.RootID, Box: bt.BoxID, HPath: bt.HPath, Content: vector.Content})
+	}
+	for _, group := range groups {
+		if 1 < len(group.Blocks) {
+			ret = append(ret, group)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if len(ret[i].Blocks) != len(ret[j].Blocks) {
+			return len(ret[i].Blocks) > len(ret[j].Blocks)
+		}
+		return ret[i].Similarity > ret[j].Similarity
+	})
+
+	duplicateBlocksJobLock.Lock()
+	job.Processed = len(candidates)
+	duplicateBlocksJobLock.Unlock()
+	return
+}
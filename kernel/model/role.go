@@ -16,7 +16,13 @@
 
 package model
 
-import "github.com/gin-gonic/gin"
+import (
+	"os"
+	"strings"
+
+	"github.com/88250/gulu"
+	"github.com/gin-gonic/gin"
+)
 
 type Role uint
 
@@ -58,3 +64,26 @@ func GetGinContextRole(c *gin.Context) Role {
 func IsAdminRoleContext(c *gin.Context) bool {
 	return GetGinContextRole(c) == RoleAdministrator
 }
+
+// IsWorkspaceOwnerContext 判断请求是否来自工作空间所有者，只有所有者才能修改全局配置（AI 服务商、用量配额等）。
+// Web 模式下每个用户都是自己工作空间的管理员，只有 SIYUAN_WEB_ADMIN_USERS（逗号分隔的用户名）中的用户才是所有者。
+func IsWorkspaceOwnerContext(c *gin.Context) bool {
+	if !IsAdminRoleContext(c) {
+		return false
+	}
+	if "true" != os.Getenv("SIYUAN_WEB_MODE") {
+		return true
+	}
+
+	username := c.GetString("web_username")
+	if "" == username {
+		return false
+	}
+	var admins []string
+	for _, admin := range strings.Split(os.Getenv("SIYUAN_WEB_ADMIN_USERS"), ",") {
+		if admin = strings.TrimSpace(admin); "" != admin {
+			admins = append(admins, admin)
+		}
+	}
+	return gulu.Str.Contains(username, admins)
+}
@@ -379,6 +379,14 @@ func CheckAdminRole(c *gin.Context) {
 	}
 }
 
+func CheckWorkspaceOwner(c *gin.Context) {
+	if IsWorkspaceOwnerContext(c) {
+		c.Next()
+	} else {
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
+
 func CheckEditRole(c *gin.Context) {
 	if IsValidRole(GetGinContextRole(c), []Role{
 		RoleAdministrator,
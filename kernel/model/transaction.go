@@ -93,6 +93,7 @@ func flushTx(tx *Transaction) {
 
 	start := time.Now()
 	if txErr := performTx(tx); nil != txErr {
+		tx.txErr = txErr
 		switch txErr.code {
 		case TxErrCodeBlockNotFound:
 			util.PushTxErr("Transaction failed", txErr.code, nil)
@@ -102,6 +103,8 @@ func flushTx(tx *Transaction) {
 		case TxErrHandleAttributeView:
 			util.PushMsg(Conf.language(258), 5000)
 			logging.LogErrorf("handle attribute view failed: %s", txErr.msg)
+		case TxErrAttributeViewConstraint:
+			util.PushErrMsg(fmt.Sprintf(Conf.language(275), txErr.msg), 7000)
 		default:
 			txData, _ := gulu.JSON.MarshalJSON(tx)
 			logging.LogFatalf(logging.ExitCodeFatal, "transaction failed [%d]: %s\n  tx [%s]", txErr.code, txErr.msg, txData)
@@ -134,10 +137,11 @@ func PerformTransactionsWithContext(ctx *WorkspaceContext, transactions *[]*Tran
 }
 
 const (
-	TxErrCodeBlockNotFound   = 0
-	TxErrCodeDataIsSyncing   = 1
-	TxErrCodeWriteTree       = 2
-	TxErrHandleAttributeView = 3
+	TxErrCodeBlockNotFound       = 0
+	TxErrCodeDataIsSyncing       = 1
+	TxErrCodeWriteTree           = 2
+	TxErrHandleAttributeView     = 3
+	TxErrAttributeViewConstraint = 4
 )
 
 type TxErr struct {
@@ -267,6 +271,10 @@ func performTx(tx *Transaction) (ret *TxErr) {
 				ret = tx.doReplaceAttrViewBlock(op)
 			case "updateAttrViewColTemplate":
 				ret = tx.doUpdateAttrViewColTemplate(op)
+			case "setAttrViewColConstraint":
+				ret = tx.doSetAttrViewColConstraint(op)
+			case "updateAttrViewColFormula":
+				ret = tx.doUpdateAttrViewColFormula(op)
 			case "addAttrViewView":
 				ret = tx.doAddAttrViewView(op)
 			case "removeAttrViewView":
@@ -315,6 +323,16 @@ func performTx(tx *Transaction) (ret *TxErr) {
 				ret = tx.doSetAttrViewWrapField(op)
 			case "changeAttrViewLayout":
 				ret = tx.doChangeAttrViewLayout(op)
+			case "setAttrViewCalendar":
+				ret = tx.doSetAttrViewCalendar(op)
+			case "setAttrViewCalendarItemDate":
+				ret = tx.doSetAttrViewCalendarItemDate(op)
+			case "setAttrViewTimeline":
+				ret = tx.doSetAttrViewTimeline(op)
+			case "setAttrViewTimelineItemDate":
+				ret = tx.doSetAttrViewTimelineItemDate(op)
+			case "setAttrViewChart":
+				ret = tx.doSetAttrViewChart(op)
 			case "setAttrViewBlockView":
 				ret = tx.doSetAttrViewBlockView(op)
 			case "setAttrViewCardAspectRatio":
@@ -346,6 +364,20 @@ func performTx(tx *Transaction) (ret *TxErr) {
 		logging.LogErrorf("commit tx failed: %s", cr)
 		return &TxErr{msg: cr.Error()}
 	}
+
+	if 0 < len(tx.avAutomationEvents) {
+		go execAttrViewAutomations(tx.avAutomationEvents)
+	}
+
+	var avIDs []string
+	for _, op := range tx.DoOperations {
+		if "" != op.AvID && !gulu.Str.Contains(op.AvID, avIDs) {
+			avIDs = append(avIDs, op.AvID)
+		}
+	}
+	if 0 < len(avIDs) {
+		go syncAttrViewCalendars(avIDs) // 同步到绑定的 CalDAV 日历
+	}
 	return
 }
 
@@ -1962,6 +1994,9 @@ type Transaction struct {
 	trees map[string]*parse.Tree // 事务中变更的树
 	nodes map[string]*ast.Node   // 事务中变更的节点
 
+	avAutomationEvents []*attrViewAutomationEvent // 事务提交后需要执行数据库自动化规则的事件
+	txErr              *TxErr                     // 事务执行失败的原因
+
 	isGlobalAssetsInit bool   // 是否初始化过全局资源判断
 	isGlobalAssets     bool   // 是否属于全局资源
 	assetsDir          string // 资源目录路径
@@ -1973,6 +2008,14 @@ type Transaction struct {
 	ctx        *WorkspaceContext // workspace 上下文，用于多用户隔离
 }
 
+// Err 返回事务执行失败的原因，事务执行成功或者尚未执行时返回 nil。
+func (tx *Transaction) Err() error {
+	if nil == tx.txErr {
+		return nil
+	}
+	return errors.New(tx.txErr.msg)
+}
+
 func (tx *Transaction) WaitForCommit() {
 	for {
 		if 1 == tx.state.Load() {
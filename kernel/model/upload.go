@@ -136,6 +136,7 @@ func InsertLocalAssetsWithContext(ctx *WorkspaceContext, id string, assetAbsPath
 
 			// 自动向量化
 			EnqueueAssetVectorize(writePath)
+			enqueueUploadedAssetOCR(ctx, writePath)
 		}
 	}
 	IncSync()
@@ -369,18 +370,8 @@ func Upload(c *gin.Context) {
 			if !needUnzip2Dir {
 				EnqueueAssetVectorize(writePath)
 
-				// 自动 OCR 识别（针对 PDF 文件）
-				if ext == ".pdf" {
-					go func(pdfPath string) {
-						logging.LogInfof("开始自动 OCR 识别: %s", filepath.Base(pdfPath))
-						_, err := OCRAsset(pdfPath)
-						if err != nil {
-							logging.LogWarnf("自动 OCR 识别失败: %v", err)
-						} else {
-							logging.LogInfof("自动 OCR 识别完成: %s", filepath.Base(pdfPath))
-						}
-					}(writePath)
-				}
+				// 图片和扫描版 PDF 加入文字识别队列
+				enqueueUploadedAssetOCR(ctx, writePath)
 			}
 		}
 	}
@@ -65,6 +65,35 @@ func RenderGroupView(attrView *av.AttributeView, view, groupView *av.View, query
 		groupView.Kanban.FitImage = view.Kanban.FitImage
 		groupView.Kanban.DisplayFieldName = view.Kanban.DisplayFieldName
 		groupView.Kanban.FillColBackgroundColor = view.Kanban.FillColBackgroundColor
+	case av.LayoutTypeCalendar:
+		err = copier.CopyWithOption(&groupView.Calendar.Fields, &view.Calendar.Fields, copier.Option{DeepCopy: true})
+		groupView.Calendar.ShowIcon = view.Calendar.ShowIcon
+		groupView.Calendar.WrapField = view.Calendar.WrapField
+
+		groupView.Calendar.StartKeyID = view.Calendar.StartKeyID
+		groupView.Calendar.EndKeyID = view.Calendar.EndKeyID
+		groupView.Calendar.TitleKeyID = view.Calendar.TitleKeyID
+		groupView.Calendar.Mode = view.Calendar.Mode
+		groupView.Calendar.FirstDayOfWeek = view.Calendar.FirstDayOfWeek
+	case av.LayoutTypeTimeline:
+		err = copier.CopyWithOption(&groupView.Timeline.Fields, &view.Timeline.Fields, copier.Option{DeepCopy: true})
+		groupView.Timeline.ShowIcon = view.Timeline.ShowIcon
+		groupView.Timeline.WrapField = view.Timeline.WrapField
+
+		groupView.Timeline.StartKeyID = view.Timeline.StartKeyID
+		groupView.Timeline.EndKeyID = view.Timeline.EndKeyID
+		groupView.Timeline.TitleKeyID = view.Timeline.TitleKeyID
+		groupView.Timeline.DependencyKeyID = view.Timeline.DependencyKeyID
+		groupView.Timeline.CascadeDependencies = view.Timeline.CascadeDependencies
+		groupView.Timeline.Zoom = view.Timeline.Zoom
+	case av.LayoutTypeChart:
+		err = copier.CopyWithOption(&groupView.Chart.Fields, &view.Chart.Fields, copier.Option{DeepCopy: true})
+		groupView.Chart.ShowIcon = view.Chart.ShowIcon
+		groupView.Chart.WrapField = view.Chart.WrapField
+
+		groupView.Chart.ChartType = view.Chart.ChartType
+		groupView.Chart.SeriesKeyID = view.Chart.SeriesKeyID
+		groupView.Chart.Aggregate = view.Chart.Aggregate
 	}
 	if nil != err {
 		logging.LogErrorf("copy view fields [%s] to group [%s] failed: %s", view.ID, groupView.ID, err)
@@ -75,6 +104,12 @@ func RenderGroupView(attrView *av.AttributeView, view, groupView *av.View, query
 			groupView.Gallery.CardFields = view.Gallery.CardFields
 		case av.LayoutTypeKanban:
 			groupView.Kanban.Fields = view.Kanban.Fields
+		case av.LayoutTypeCalendar:
+			groupView.Calendar.Fields = view.Calendar.Fields
+		case av.LayoutTypeTimeline:
+			groupView.Timeline.Fields = view.Timeline.Fields
+		case av.LayoutTypeChart:
+			groupView.Chart.Fields = view.Chart.Fields
 		}
 	}
 
@@ -115,6 +150,12 @@ func renderViewWithDataDir(dataDir string, attrView *av.AttributeView, view *av.
 		ret = RenderAttributeViewGalleryWithDataDir(dataDir, attrView, view, query, depth, cachedAttrViews)
 	case av.LayoutTypeKanban:
 		ret = RenderAttributeViewKanbanWithDataDir(dataDir, attrView, view, query, depth, cachedAttrViews)
+	case av.LayoutTypeCalendar:
+		ret = RenderAttributeViewCalendarWithDataDir(dataDir, attrView, view, query, depth, cachedAttrViews)
+	case av.LayoutTypeTimeline:
+		ret = RenderAttributeViewTimelineWithDataDir(dataDir, attrView, view, query, depth, cachedAttrViews)
+	case av.LayoutTypeChart:
+		ret = RenderAttributeViewChartWithDataDir(dataDir, attrView, view, query, depth, cachedAttrViews)
 	}
 	return
 }
@@ -352,6 +393,8 @@ func fillAttributeViewBaseValue(baseValue *av.BaseValue, fieldID, itemID string,
 		}
 	case av.KeyTypeTemplate: // 渲染模板字段
 		baseValue.Value = &av.Value{ID: baseValue.ID, KeyID: fieldID, BlockID: itemID, Type: av.KeyTypeTemplate, Template: &av.ValueTemplate{Content: fieldTemplate}}
+	case av.KeyTypeFormula: // 公式字段的值不保存，后面再计算
+		baseValue.Value = &av.Value{ID: baseValue.ID, KeyID: fieldID, BlockID: itemID, Type: av.KeyTypeFormula, Formula: &av.ValueFormula{}}
 	case av.KeyTypeCreated: // 填充创建时间字段值，后面再渲染
 		baseValue.Value = &av.Value{ID: baseValue.ID, KeyID: fieldID, BlockID: itemID, Type: av.KeyTypeCreated}
 	case av.KeyTypeUpdated: // 填充更新时间字段值，后面再渲染
@@ -511,7 +554,7 @@ func fillAttributeViewAutoGeneratedValues(attrView *av.AttributeView, collection
 
 		isSameAv := destAv.ID == attrView.ID
 		var furtherCollection av.Collection
-		if av.KeyTypeTemplate == destKey.Type || (!isSameAv && (av.KeyTypeUpdated == destKey.Type || av.KeyTypeCreated == destKey.Type || av.KeyTypeRelation == destKey.Type)) {
+		if av.KeyTypeTemplate == destKey.Type || av.KeyTypeFormula == destKey.Type || (!isSameAv && (av.KeyTypeUpdated == destKey.Type || av.KeyTypeCreated == destKey.Type || av.KeyTypeRelation == destKey.Type)) {
 			viewable := renderView(destAv, destAv.Views[0], "", depth, cachedAttrViews)
 			if nil != viewable {
 				furtherCollection = viewable.(av.Collection)
@@ -594,7 +637,7 @@ func GetFurtherCollections(attrView *av.AttributeView, cachedAttrViews map[strin
 		isSameAv := destAv.ID == attrView.ID
 
 		var furtherCollection av.Collection
-		if av.KeyTypeTemplate == destKey.Type || (!isSameAv && (av.KeyTypeUpdated == destKey.Type || av.KeyTypeCreated == destKey.Type || av.KeyTypeRelation == destKey.Type)) {
+		if av.KeyTypeTemplate == destKey.Type || av.KeyTypeFormula == destKey.Type || (!isSameAv && (av.KeyTypeUpdated == destKey.Type || av.KeyTypeCreated == destKey.Type || av.KeyTypeRelation == destKey.Type)) {
 			viewable := RenderView(destAv, destAv.Views[0], "")
 			if nil != viewable {
 				furtherCollection = viewable.(av.Collection)
@@ -653,6 +696,44 @@ func fillAttributeViewTemplateValues(attrView *av.AttributeView, view *av.View,
 	return
 }
 
+// fillAttributeViewFormulaValues 按依赖顺序计算公式字段的值，公式可以引用模板、汇总以及其他公式字段的值。
+func fillAttributeViewFormulaValues(attrView *av.AttributeView, collection av.Collection) {
+	formulas, errs := av.CompileFormulas(attrView)
+	if 1 > len(formulas) && 1 > len(errs) {
+		return
+	}
+
+	for _, formula := range formulas {
+		formula.Key.Formula.ResultType = formula.ResultType
+		for _, item := range collection.GetItems() {
+			value := item.GetValue(formula.Key.ID)
+			if nil == value {
+				continue
+			}
+
+			value.Formula = formula.Eval(func(keyID string) *av.Value {
+				if ret := item.GetValue(keyID); nil != ret {
+					return ret
+				}
+				return attrView.GetValue(keyID, item.GetID())
+			})
+		}
+	}
+
+	for keyID, err := range errs {
+		key, _ := attrView.GetKey(keyID)
+		for _, item := range collection.GetItems() {
+			if value := item.GetValue(keyID); nil != value {
+				value.Formula = &av.ValueFormula{Type: key.Formula.ResultType, Error: err.Error()}
+				if result := value.GetFormulaResult(); nil != result {
+					// 保持结果类型对应的空值，这样过滤、排序和计算时不会出错
+					value.Formula.Number, value.Formula.Text, value.Formula.Date, value.Formula.Checkbox = result.Number, result.Text, result.Date, result.Checkbox
+				}
+			}
+		}
+	}
+}
+
 func fillAttributeViewKeyValues(attrView *av.AttributeView, collection av.Collection) {
 	fieldValues := map[string][]*av.Value{}
 	for _, item := range collection.GetItems() {
@@ -742,6 +823,10 @@ func FillAttributeViewNilValue(value *av.Value, typ av.KeyType) {
 		if nil == value.Rollup {
 			value.Rollup = &av.ValueRollup{}
 		}
+	case av.KeyTypeFormula:
+		if nil == value.Formula {
+			value.Formula = &av.ValueFormula{}
+		}
 	}
 }
 
@@ -831,6 +916,64 @@ func removeMissingField(attrView *av.AttributeView, view *av.View, missingKeyID
 		}
 	}
 
+	if nil != view.Calendar {
+		for i, calendarField := range view.Calendar.Fields {
+			if calendarField.ID == missingKeyID {
+				view.Calendar.Fields = append(view.Calendar.Fields[:i], view.Calendar.Fields[i+1:]...)
+				changed = true
+				break
+			}
+		}
+		if view.Calendar.EndKeyID == missingKeyID {
+			view.Calendar.EndKeyID = ""
+			changed = true
+		}
+		if view.Calendar.TitleKeyID == missingKeyID {
+			view.Calendar.TitleKeyID = ""
+			changed = true
+		}
+	}
+
+	if nil != view.Timeline {
+		for i, timelineField := range view.Timeline.Fields {
+			if timelineField.ID == missingKeyID {
+				view.Timeline.Fields = append(view.Timeline.Fields[:i], view.Timeline.Fields[i+1:]...)
+				changed = true
+				break
+			}
+		}
+		if view.Timeline.EndKeyID == missingKeyID {
+			view.Timeline.EndKeyID = ""
+			changed = true
+		}
+		if view.Timeline.TitleKeyID == missingKeyID {
+			view.Timeline.TitleKeyID = ""
+			changed = true
+		}
+		if view.Timeline.DependencyKeyID == missingKeyID {
+			view.Timeline.DependencyKeyID = ""
+			changed = true
+		}
+	}
+
+	if nil != view.Chart {
+		for i, chartField := range view.Chart.Fields {
+			if chartField.ID == missingKeyID {
+				view.Chart.Fields = append(view.Chart.Fields[:i], view.Chart.Fields[i+1:]...)
+				changed = true
+				break
+			}
+		}
+		if view.Chart.SeriesKeyID == missingKeyID {
+			view.Chart.SeriesKeyID = ""
+			changed = true
+		}
+		if nil != view.Chart.Aggregate && view.Chart.Aggregate.KeyID == missingKeyID {
+			view.Chart.Aggregate = &av.ChartAggregate{Operator: av.CalcOperatorCountAll}
+			changed = true
+		}
+	}
+
 	if changed {
 		av.SaveAttributeView(attrView)
 	}
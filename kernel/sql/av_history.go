@@ -0,0 +1,131 @@
+// SiYuan - Refactor your thinking
+// Copyright (c) 2020-present, b3log.org
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/siyuan-note/logging"
+)
+
+// AttrViewCellHistory 描述了数据库单元格的一次修改。
+type AttrViewCellHistory struct {
+	ID       string `json:"id"`       // 记录 ID
+	AvID     string `json:"avID"`     // 数据库 ID
+	ItemID   string `json:"itemID"`   // 项目 ID
+	KeyID    string `json:"keyID"`    // 字段 ID
+	OldValue string `json:"oldValue"` // 修改前的值（JSON），单元格原来不存在时为空
+	NewValue string `json:"newValue"` // 修改后的值（JSON）
+	User     string `json:"user"`     // 修改人
+	Created  int64  `json:"created"`  // 修改时间（毫秒）
+}
+
+const (
+	AttrViewCellHistoriesInsert      = "INSERT INTO av_cell_histories (id, av_id, item_id, key_id, old_value, new_value, user, created) VALUES %s"
+	AttrViewCellHistoriesPlaceholder = "(?, ?, ?, ?, ?, ?, ?, ?)"
+)
+
+// initAttrViewCellHistoryTable 创建单元格历史表，历史库重建或清空时单元格历史会一并清空。
+func initAttrViewCellHistoryTable() {
+	if _, err := historyDB.Exec("CREATE TABLE IF NOT EXISTS av_cell_histories (id TEXT, av_id TEXT, item_id TEXT, key_id TEXT, old_value TEXT, new_value TEXT, user TEXT, created INTEGER)"); nil != err {
+		logging.LogErrorf("create table [av_cell_histories] failed: %s", err)
+		return
+	}
+	if _, err := historyDB.Exec("CREATE INDEX IF NOT EXISTS idx_av_cell_histories_item ON av_cell_histories(av_id, item_id, created)"); nil != err {
+		logging.LogErrorf("create index [idx_av_cell_histories_item] failed: %s", err)
+	}
+}
+
+// SelectAttrViewCellHistories 按时间倒序查询项目的单元格历史，keyID 为空时查询所有字段，since 大于 0 时只查询该时间及之后的修改。
+func SelectAttrViewCellHistories(avID, itemID, keyID string, since int64, limit int) (ret []*AttrViewCellHistory) {
+	ret = []*AttrViewCellHistory{}
+	if nil == historyDB {
+		return
+	}
+
+	stmt := "SELECT id, av_id, item_id, key_id, old_value, new_value, user, created FROM av_cell_histories WHERE av_id = ? AND item_id = ?"
+	args := []interface{}{avID, itemID}
+	if "" != keyID {
+		stmt += " AND key_id = ?"
+		args = append(args, keyID)
+	}
+	if 0 < since {
+		stmt += " AND created >= ?"
+		args = append(args, since)
+	}
+	stmt += " ORDER BY created DESC, rowid DESC"
+	if 0 < limit {
+		stmt += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := historyDB.Query(stmt, args...)
+	if nil != err {
+		logging.LogWarnf("sql query [%s] failed: %s", stmt, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if history := scanAttrViewCellHistoryRows(rows); nil != history {
+			ret = append(ret, history)
+		}
+	}
+	return
+}
+
+func scanAttrViewCellHistoryRows(rows *sql.Rows) (ret *AttrViewCellHistory) {
+	var history AttrViewCellHistory
+	if err := rows.Scan(&history.ID, &history.AvID, &history.ItemID, &history.KeyID, &history.OldValue, &history.NewValue, &history.User, &history.Created); nil != err {
+		logging.LogErrorf("query scan field failed: %s\n%s", err, logging.ShortStack())
+		return
+	}
+	ret = &history
+	return
+}
+
+func deleteOutdatedAttrViewCellHistories(tx *sql.Tx, before int64) (err error) {
+	stmt := "DELETE FROM av_cell_histories WHERE created < ?"
+	err = execStmtTx(tx, stmt, before*1000)
+	return
+}
+
+func insertAttrViewCellHistories(tx *sql.Tx, histories []*AttrViewCellHistory) (err error) {
+	for i := 0; i < len(histories); i += 512 {
+		end := i + 512
+		if end > len(histories) {
+			end = len(histories)
+		}
+		if err = insertAttrViewCellHistories0(tx, histories[i:end]); nil != err {
+			return
+		}
+	}
+	return
+}
+
+func insertAttrViewCellHistories0(tx *sql.Tx, bulk []*AttrViewCellHistory) (err error) {
+	valueStrings := make([]string, 0, len(bulk))
+	valueArgs := make([]interface{}, 0, len(bulk)*strings.Count(AttrViewCellHistoriesPlaceholder, "?"))
+	for _, b := range bulk {
+		valueStrings = append(valueStrings, AttrViewCellHistoriesPlaceholder)
+		valueArgs = append(valueArgs, b.ID, b.AvID, b.ItemID, b.KeyID, b.OldValue, b.NewValue, b.User, b.Created)
+	}
+
+	stmt := fmt.Sprintf(AttrViewCellHistoriesInsert, strings.Join(valueStrings, ","))
+	err = prepareExecInsertTx(tx, stmt, valueArgs)
+	return
+}
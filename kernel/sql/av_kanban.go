@@ -63,6 +63,7 @@ func RenderAttributeViewKanbanWithDataDir(dataDir string, attrView *av.Attribute
 				Date:         key.Date,
 				Created:      key.Created,
 				Updated:      key.Updated,
+				Formula:      key.Formula,
 			},
 		})
 	}
@@ -136,6 +137,9 @@ func RenderAttributeViewKanbanWithDataDir(dataDir string, attrView *av.Attribute
 		util.PushErrMsg(fmt.Sprintf(util.Langs[util.Lang][44], util.EscapeHTML(renderTemplateErr.Error())), 30000)
 	}
 
+	// 公式字段在模板字段之后计算，这样公式就可以使用模板字段的值了
+	fillAttributeViewFormulaValues(attrView, ret)
+
 	filterByQuery(query, ret)
 	manualSort(view, ret)
 	return
@@ -66,6 +66,7 @@ func RenderAttributeViewTableWithDataDir(dataDir string, attrView *av.AttributeV
 				Date:         key.Date,
 				Created:      key.Created,
 				Updated:      key.Updated,
+				Formula:      key.Formula,
 			},
 			Width: col.Width,
 			Pin:   col.Pin,
@@ -134,6 +135,9 @@ func RenderAttributeViewTableWithDataDir(dataDir string, attrView *av.AttributeV
 		util.PushErrMsg(fmt.Sprintf(util.Langs[util.Lang][44], util.EscapeHTML(renderTemplateErr.Error())), 30000)
 	}
 
+	// 公式字段在模板字段之后计算，这样公式就可以使用模板字段的值了
+	fillAttributeViewFormulaValues(attrView, ret)
+
 	filterByQuery(query, ret)
 	manualSort(view, ret)
 	return
@@ -0,0 +1,159 @@
+package sql
+
+import (
+	"fmt"
+
+	"github.com/88250/lute/ast"
+	"github.com/siyuan-note/siyuan/kernel/av"
+	"github.com/siyuan-note/siyuan/kernel/filesys"
+	"github.com/siyuan-note/siyuan/kernel/util"
+)
+
+func RenderAttributeViewTimeline(attrView *av.AttributeView, view *av.View, query string, depth *int, cachedAttrViews map[string]*av.AttributeView) (ret *av.Timeline) {
+	return RenderAttributeViewTimelineWithDataDir(util.DataDir, attrView, view, query, depth, cachedAttrViews)
+}
+
+func RenderAttributeViewTimelineWithDataDir(dataDir string, attrView *av.AttributeView, view *av.View, query string, depth *int, cachedAttrViews map[string]*av.AttributeView) (ret *av.Timeline) {
+	viewable := attrView.RenderedViewables[view.ID]
+	if nil != viewable {
+		ret = viewable.(*av.Timeline)
+		return
+	}
+
+	ret = &av.Timeline{
+		BaseInstance:        av.NewViewBaseInstance(view),
+		StartKeyID:          view.Timeline.StartKeyID,
+		EndKeyID:            view.Timeline.EndKeyID,
+		TitleKeyID:          view.Timeline.TitleKeyID,
+		DependencyKeyID:     view.Timeline.DependencyKeyID,
+		CascadeDependencies: view.Timeline.CascadeDependencies,
+		Zoom:                view.Timeline.Zoom,
+		Fields:              []*av.TimelineField{},
+		Items:               []*av.TimelineItem{},
+	}
+
+	// 组装字段，开始日期、结束日期、标题和依赖字段即使没有显示也需要渲染值
+	fields := append([]*av.ViewTimelineField{}, view.Timeline.Fields...)
+	for _, keyID := range []string{view.Timeline.StartKeyID, view.Timeline.EndKeyID, view.Timeline.TitleKeyID, view.Timeline.DependencyKeyID} {
+		if "" == keyID {
+			continue
+		}
+		exists := false
+		for _, field := range fields {
+			if field.ID == keyID {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			fields = append(fields, &av.ViewTimelineField{BaseField: &av.BaseField{ID: keyID, Hidden: true}})
+		}
+	}
+	for _, field := range fields {
+		key, getErr := attrView.GetKey(field.ID)
+		if nil != getErr {
+			// 找不到字段则在视图中删除
+			removeMissingField(attrView, view, field.ID)
+			continue
+		}
+
+		ret.Fields = append(ret.Fields, &av.TimelineField{
+			BaseInstanceField: &av.BaseInstanceField{
+				ID:           key.ID,
+				Name:         key.Name,
+				Type:         key.Type,
+				Icon:         key.Icon,
+				Wrap:         field.Wrap,
+				Hidden:       field.Hidden,
+				Desc:         key.Desc,
+				Calc:         field.Calc,
+				Options:      key.Options,
+				NumberFormat: key.NumberFormat,
+				Template:     key.Template,
+				Relation:     key.Relation,
+				Rollup:       key.Rollup,
+				Date:         key.Date,
+				Created:      key.Created,
+				Updated:      key.Updated,
+				Formula:      key.Formula,
+			},
+		})
+	}
+
+	itemsValues := generateAttrViewItems(attrView, view) // 生成项目
+	filterNotFoundAttrViewItems(itemsValues)             // 过滤掉不存在的项目
+
+	// 批量加载绑定块对应的树
+	var ialIDs []string
+	for _, keyValues := range itemsValues {
+		for _, kValues := range keyValues {
+			blockVal := kValues.GetBlockValue()
+			if nil != blockVal && !blockVal.IsDetached {
+				ialIDs = append(ialIDs, blockVal.Block.ID)
+			}
+		}
+	}
+	boundTrees := filesys.LoadTreesWithDataDir(dataDir, ialIDs)
+
+	// 生成项目字段值
+	for itemID, itemValues := range itemsValues {
+		timelineItem := &av.TimelineItem{ID: itemID}
+		for _, field := range ret.Fields {
+			var fieldValue *av.TimelineFieldValue
+			for _, keyValues := range itemValues {
+				if keyValues.Key.ID == field.ID {
+					fieldValue = &av.TimelineFieldValue{
+						BaseValue: &av.BaseValue{
+							ID:        keyValues.Values[0].ID,
+							Value:     keyValues.Values[0],
+							ValueType: field.Type,
+						},
+					}
+					break
+				}
+			}
+			if nil == fieldValue {
+				fieldValue = &av.TimelineFieldValue{
+					BaseValue: &av.BaseValue{
+						ID:        itemID[:14] + ast.NewNodeID()[14:],
+						ValueType: field.Type,
+					},
+				}
+			}
+
+			filedDateIsTime := false
+			if nil != field.Date {
+				filedDateIsTime = field.Date.FillSpecificTime
+			}
+			fillAttributeViewBaseValue(fieldValue.BaseValue, field.ID, itemID, field.NumberFormat, field.Template, filedDateIsTime)
+			timelineItem.Values = append(timelineItem.Values, fieldValue)
+		}
+		ret.Items = append(ret.Items, timelineItem)
+	}
+
+	// 回填补全数据
+	fillAttributeViewKeyValues(attrView, ret)
+
+	// 批量获取块属性以提升性能
+	ials := BatchGetBlockAttrsWitTrees(ialIDs, boundTrees)
+
+	// 渲染自动生成的字段值，比如关联、汇总、创建时间和更新时间
+	fillAttributeViewAutoGeneratedValues(attrView, ret, ials, depth, cachedAttrViews)
+
+	// 最后渲染模板字段，这样模板就可以使用汇总、关联、创建时间和更新时间的值了
+	renderTemplateErr := fillAttributeViewTemplateValues(attrView, view, ret, ials)
+	if nil != renderTemplateErr {
+		util.PushErrMsg(fmt.Sprintf(util.Langs[util.Lang][44], util.EscapeHTML(renderTemplateErr.Error())), 30000)
+	}
+
+	// 公式字段在模板字段之后计算，这样公式就可以使用模板字段的值了
+	fillAttributeViewFormulaValues(attrView, ret)
+
+	for _, item := range ret.Items {
+		item.FillSchedule(ret.StartKeyID, ret.EndKeyID, ret.TitleKeyID, ret.DependencyKeyID)
+	}
+
+	filterByQuery(query, ret)
+	manualSort(view, ret)
+	return
+}
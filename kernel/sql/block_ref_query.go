@@ -201,6 +201,38 @@ func QueryRefRootBlocksByDefRootIDs(defRootIDs []string) (ret map[string][]*Bloc
 	return
 }
 
+// QueryDefRootBlocksByRefRootIDWithContext 使用 WorkspaceContext 查询文档引用的定义块所在的文档块
+func QueryDefRootBlocksByRefRootIDWithContext(ctx WorkspaceContext, refRootID string) (ret []*Block) {
+	rows, err := queryWithContext(ctx, "SELECT * FROM blocks WHERE id IN (SELECT DISTINCT def_block_root_id FROM refs WHERE root_id = ?)", refRootID)
+	if err != nil {
+		logging.LogErrorf("sql query failed: %s", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if block := scanBlockRows(rows); nil != block {
+			ret = append(ret, block)
+		}
+	}
+	return
+}
+
+// QueryRefRootBlocksByDefRootIDWithContext 使用 WorkspaceContext 查询引用了指定文档的文档块
+func QueryRefRootBlocksByDefRootIDWithContext(ctx WorkspaceContext, defRootID string) (ret []*Block) {
+	rows, err := queryWithContext(ctx, "SELECT * FROM blocks WHERE id IN (SELECT DISTINCT root_id FROM refs WHERE def_block_root_id = ?)", defRootID)
+	if err != nil {
+		logging.LogErrorf("sql query failed: %s", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if block := scanBlockRows(rows); nil != block {
+			ret = append(ret, block)
+		}
+	}
+	return
+}
+
 func GetRefText(defBlockID string) (ret string) {
 	ret = getRefText(defBlockID)
 	ret = strings.ReplaceAll(ret, search.SearchMarkLeft, "")
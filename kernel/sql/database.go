@@ -279,6 +279,7 @@ func InitHistoryDatabase(forceRebuild bool) {
 	initHistoryDBConnection()
 
 	if !forceRebuild && gulu.File.IsExist(util.HistoryDBPath) {
+		initAttrViewCellHistoryTable()
 		return
 	}
 
@@ -332,6 +333,8 @@ func initHistoryDBTables() {
 	// 创建一个替代的普通表用于基础历史记录功能
 	historyDB.Exec("CREATE TABLE IF NOT EXISTS histories_fts_backup (id TEXT, type TEXT, op TEXT, title TEXT, content TEXT, path TEXT, created TEXT)")
 	logging.LogInfo("FTS5功能已暂时禁用，使用基础历史记录功能")
+
+	initAttrViewCellHistoryTable()
 }
 
 var initAssetContentDatabaseLock = sync.Mutex{}
@@ -38,10 +38,11 @@ var (
 
 type historyDBQueueOperation struct {
 	inQueueTime time.Time
-	action      string // index/deleteOutdated
+	action      string // index/indexAvCell/deleteOutdated
 
-	histories []*History // index
-	before    int64      // deleteOutdated
+	histories       []*History             // index
+	avCellHistories []*AttrViewCellHistory // indexAvCell
+	before          int64                  // deleteOutdated
 }
 
 func FlushHistoryTxJob() {
@@ -111,8 +112,13 @@ func execHistoryOp(op *historyDBQueueOperation, tx *sql.Tx, context map[string]i
 	switch op.action {
 	case "index":
 		err = insertHistories(tx, op.histories, context)
+	case "indexAvCell":
+		err = insertAttrViewCellHistories(tx, op.avCellHistories)
 	case "deleteOutdated":
-		err = deleteOutdatedHistories(tx, op.before, context)
+		if err = deleteOutdatedHistories(tx, op.before, context); err != nil {
+			return
+		}
+		err = deleteOutdatedAttrViewCellHistories(tx, op.before)
 	default:
 		msg := fmt.Sprintf("unknown history operation [%s]", op.action)
 		logging.LogErrorf(msg)
@@ -141,6 +147,18 @@ func IndexHistoriesQueue(histories []*History) {
 	historyOperationQueue = append(historyOperationQueue, newOp)
 }
 
+func IndexAttrViewCellHistoriesQueue(histories []*AttrViewCellHistory) {
+	if 1 > len(histories) {
+		return
+	}
+
+	historyDBQueueLock.Lock()
+	defer historyDBQueueLock.Unlock()
+
+	newOp := &historyDBQueueOperation{inQueueTime: time.Now(), action: "indexAvCell", avCellHistories: histories}
+	historyOperationQueue = append(historyOperationQueue, newOp)
+}
+
 func getHistoryOperations() (ops []*historyDBQueueOperation) {
 	historyDBQueueLock.Lock()
 	defer historyDBQueueLock.Unlock()
@@ -470,8 +470,23 @@ func ExistBlockTrees(ids []string) (ret map[string]bool) {
 }
 
 func GetBlockTrees(ids []string) (ret map[string]*BlockTree) {
+	return GetBlockTreesWithDB(ids, db)
+}
+
+// GetBlockTreesWithDBPath 使用指定路径的数据库批量获取 BlockTree，用于多用户数据隔离。
+func GetBlockTreesWithDBPath(ids []string, dbPath string) (ret map[string]*BlockTree) {
+	database, err := btManager.GetOrCreateDB(dbPath)
+	if err != nil {
+		logging.LogErrorf("get or create database [%s] failed: %s", dbPath, err)
+		return map[string]*BlockTree{}
+	}
+	return GetBlockTreesWithDB(ids, database)
+}
+
+// GetBlockTreesWithDB 使用指定的数据库连接批量获取 BlockTree。
+func GetBlockTreesWithDB(ids []string, database *sql.DB) (ret map[string]*BlockTree) {
 	ret = map[string]*BlockTree{}
-	if 1 > len(ids) {
+	if 1 > len(ids) || nil == database {
 		return
 	}
 
@@ -490,7 +505,7 @@ func GetBlockTrees(ids []string) (ret map[string]*BlockTree) {
 		args = append(args, id)
 	}
 	stmt := stmtBuf.String()
-	rows, err := db.Query(stmt, args...)
+	rows, err := database.Query(stmt, args...)
 	if err != nil {
 		logging.LogErrorf("sql query [%s] failed: %s", stmt, err)
 		return
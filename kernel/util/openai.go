@@ -18,6 +18,8 @@ package util
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
@@ -28,6 +30,12 @@ import (
 )
 
 func ChatGPT(msg string, contextMsgs []string, c *openai.Client, model string, maxTokens int, temperature float64, timeout int) (ret string, stop bool, err error) {
+	ret, stop, _, err = ChatGPTWithUsage(msg, contextMsgs, c, model, maxTokens, temperature, timeout)
+	return
+}
+
+// ChatGPTWithUsage 和 ChatGPT 一样，同时返回上游接口报告的 Token 用量
+func ChatGPTWithUsage(msg string, contextMsgs []string, c *openai.Client, model string, maxTokens int, temperature float64, timeout int) (ret string, stop bool, usage openai.Usage, err error) {
 	var reqMsgs []openai.ChatCompletionMessage
 
 	for _, ctxMsg := range contextMsgs {
@@ -69,6 +77,7 @@ func ChatGPT(msg string, contextMsgs []string, c *openai.Client, model string, m
 		return
 	}
 
+	usage = resp.Usage
 	if 1 > len(resp.Choices) {
 		stop = true
 		return
@@ -89,24 +98,97 @@ func ChatGPT(msg string, contextMsgs []string, c *openai.Client, model string, m
 }
 
 func NewOpenAIClient(apiKey, apiProxy, apiBaseURL, apiUserAgent, apiVersion, apiProvider string) *openai.Client {
+	return NewOpenAIClientWithRetry(apiKey, apiProxy, apiBaseURL, apiUserAgent, apiVersion, apiProvider, 0, 1, 0)
+}
+
+// NewOpenAIClientWithRetry 创建带超时和重试的客户端。
+// 流式响应可能持续很久，所以 timeout 只限制等待响应头的时间，不限制读取响应体的时间
+func NewOpenAIClientWithRetry(apiKey, apiProxy, apiBaseURL, apiUserAgent, apiVersion, apiProvider string, timeout time.Duration, maxAttempts int, backoff time.Duration) *openai.Client {
 	config := openai.DefaultConfig(apiKey)
-	if "Azure" == apiProvider {
+	if "Azure" == apiProvider || "azure" == apiProvider {
 		config = openai.DefaultAzureConfig(apiKey, apiBaseURL)
 		config.APIVersion = apiVersion
 	}
 
+	transport := newProxyTransport(apiProxy)
+	transport.ResponseHeaderTimeout = timeout
+	config.HTTPClient = &http.Client{Transport: newAddHeaderTransport(NewRetryTransport(transport, maxAttempts, backoff), apiUserAgent, apiBaseURL)}
+	config.BaseURL = apiBaseURL
+	return openai.NewClientWithConfig(config)
+}
+
+// NewRetryHTTPClient 创建带超时和重试的 HTTP 客户端
+func NewRetryHTTPClient(proxy string, timeout time.Duration, maxAttempts int, backoff time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: NewRetryTransport(newProxyTransport(proxy), maxAttempts, backoff)}
+}
+
+func newProxyTransport(proxy string) *http.Transport {
 	transport := &http.Transport{}
-	if "" != apiProxy {
-		proxyUrl, err := url.Parse(apiProxy)
+	if "" != proxy {
+		proxyUrl, err := url.Parse(proxy)
 		if err != nil {
 			logging.LogErrorf("OpenAI API proxy failed: %v", err)
 		} else {
 			transport.Proxy = http.ProxyURL(proxyUrl)
 		}
 	}
-	config.HTTPClient = &http.Client{Transport: newAddHeaderTransport(transport, apiUserAgent, apiBaseURL)}
-	config.BaseURL = apiBaseURL
-	return openai.NewClientWithConfig(config)
+	return transport
+}
+
+// RetryTransport 在网络错误、429 和 5xx 时按指数退避重试请求
+type RetryTransport struct {
+	RoundTripper http.RoundTripper
+	MaxAttempts  int
+	Backoff      time.Duration
+}
+
+func NewRetryTransport(roundTripper http.RoundTripper, maxAttempts int, backoff time.Duration) *RetryTransport {
+	if 1 > maxAttempts {
+		maxAttempts = 1
+	}
+	return &RetryTransport{RoundTripper: roundTripper, MaxAttempts: maxAttempts, Backoff: backoff}
+}
+
+func (rt *RetryTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	for attempt := 1; ; attempt++ {
+		resp, err = rt.RoundTripper.RoundTrip(req)
+		if attempt >= rt.MaxAttempts || !isRetryableResponse(resp, err) {
+			return
+		}
+
+		// 请求体无法重放时不重试
+		if nil != req.Body && http.NoBody != req.Body && nil == req.GetBody {
+			return
+		}
+
+		if nil != resp {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		wait := rt.Backoff << (attempt - 1)
+		logging.LogWarnf("request [%s] failed, retry after %s (attempt %d/%d)", req.URL.Redacted(), wait, attempt+1, rt.MaxAttempts)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+
+		if nil != req.GetBody {
+			body, bodyErr := req.GetBody()
+			if nil != bodyErr {
+				return nil, bodyErr
+			}
+			req.Body = body
+		}
+	}
+}
+
+func isRetryableResponse(resp *http.Response, err error) bool {
+	if nil != err {
+		return !errors.Is(err, context.Canceled)
+	}
+	return http.StatusTooManyRequests == resp.StatusCode || 500 <= resp.StatusCode
 }
 
 type AddHeaderTransport struct {
@@ -125,6 +207,6 @@ func (adt *AddHeaderTransport) RoundTrip(req *http.Request) (*http.Response, err
 	return adt.RoundTripper.RoundTrip(req)
 }
 
-func newAddHeaderTransport(transport *http.Transport, userAgent, baseURL string) *AddHeaderTransport {
+func newAddHeaderTransport(transport http.RoundTripper, userAgent, baseURL string) *AddHeaderTransport {
 	return &AddHeaderTransport{RoundTripper: transport, UserAgent: userAgent, BaseURL: baseURL}
 }